@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tenantical/router/internal/config"
+	"github.com/tenantical/router/internal/database"
+)
+
+const apikeyUsage = `Usage: tenant-router apikey <command>
+
+Commands:
+  create -name NAME [-scopes read,write,admin] [-expires DURATION]
+                Create an admin API key and print it (shown only once)
+  list          List API keys
+  revoke ID     Revoke an API key
+
+The database is selected with DB_DRIVER, DB_PATH and DATABASE_URL.
+`
+
+// runAPIKey implements the apikey subcommand, used among others to create
+// the first admin key, and returns the exit code.
+func runAPIKey(cfg *config.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, apikeyUsage)
+		return 2
+	}
+
+	store, err := database.OpenStore(cfg.Database.Driver, cfg.Database.DSN(), cfg.Database.AutoMigrate)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "apikey: %v\n", err)
+		return 1
+	}
+	tm, err := database.NewTenantManager(store, false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "apikey: %v\n", err)
+		return 1
+	}
+	defer tm.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	ctx = database.WithActor(ctx, "cli")
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("apikey create", flag.ContinueOnError)
+		name := fs.String("name", "", "Name of the key, recorded as the actor of its changes")
+		scopes := fs.String("scopes", database.ScopeAdmin, "Comma-separated scopes: read, write, admin")
+		expires := fs.Duration("expires", 0, "Lifetime of the key, e.g. 720h (default: never expires)")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+		if *name == "" {
+			fmt.Fprintln(os.Stderr, "apikey create: -name is required")
+			return 2
+		}
+
+		var expiresAt *time.Time
+		if *expires > 0 {
+			t := time.Now().Add(*expires)
+			expiresAt = &t
+		}
+
+		key, k, err := tm.CreateAPIKey(ctx, *name, strings.Split(*scopes, ","), expiresAt)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "apikey create: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "Created API key %s (%s) with scopes %s; it can't be shown again:\n", k.Name, k.ID, strings.Join(k.Scopes, ","))
+		fmt.Println(key)
+		return 0
+
+	case "list":
+		keys, err := tm.ListAPIKeys()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "apikey list: %v\n", err)
+			return 1
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tPREFIX\tSCOPES\tEXPIRES\tLAST USED\tSTATUS")
+		for _, k := range keys {
+			status := "active"
+			switch {
+			case k.RevokedAt != nil:
+				status = "revoked"
+			case k.ExpiresAt != nil && !time.Now().Before(*k.ExpiresAt):
+				status = "expired"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", k.ID, k.Name, k.Prefix, strings.Join(k.Scopes, ","),
+				formatTime(k.ExpiresAt, "never"), formatTime(k.LastUsedAt, "-"), status)
+		}
+		tw.Flush()
+		return 0
+
+	case "revoke":
+		if len(args) != 2 {
+			fmt.Fprint(os.Stderr, apikeyUsage)
+			return 2
+		}
+		if err := tm.RevokeAPIKey(ctx, args[1]); err != nil {
+			fmt.Fprintf(os.Stderr, "apikey revoke: %v\n", err)
+			return 1
+		}
+		fmt.Printf("API key %s revoked\n", args[1])
+		return 0
+
+	default:
+		fmt.Fprint(os.Stderr, apikeyUsage)
+		return 2
+	}
+}
+
+func formatTime(t *time.Time, none string) string {
+	if t == nil {
+		return none
+	}
+	return t.Format(time.RFC3339)
+}
@@ -4,18 +4,28 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/tenantical/router/internal/certs"
 	"github.com/tenantical/router/internal/config"
 	"github.com/tenantical/router/internal/database"
 	"github.com/tenantical/router/internal/handler"
+	"github.com/tenantical/router/internal/oidc"
+	"github.com/tenantical/router/internal/proxyproto"
+	"github.com/tenantical/router/internal/verify"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 func main() {
@@ -25,12 +35,82 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	// Initialize tenant manager
-	tm, err := database.NewTenantManager(cfg.Database.Path, true)
+	// Schema management: tenant-router migrate status|up|down
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(cfg, os.Args[2:]))
+	}
+
+	// Admin API keys: tenant-router apikey create|list|revoke
+	if len(os.Args) > 1 && os.Args[1] == "apikey" {
+		os.Exit(runAPIKey(cfg, os.Args[2:]))
+	}
+
+	// Admin panel operators: tenant-router operator create|list|passwd|delete
+	if len(os.Args) > 1 && os.Args[1] == "operator" {
+		os.Exit(runOperator(cfg, os.Args[2:]))
+	}
+
+	// Validate trusted PROXY protocol sources and reverse proxies before opening any listener
+	proxyProtocolTrusted, err := database.ParseIPList(cfg.Server.ProxyProtocolTrusted)
+	if err != nil {
+		log.Fatalf("Invalid PROXY_PROTOCOL_TRUSTED: %v", err)
+	}
+	trustedProxies, err := database.ParseIPList(cfg.Server.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+
+	// Open tenant store and initialize tenant manager
+	store, err := database.OpenStore(cfg.Database.Driver, cfg.Database.DSN(), cfg.Database.AutoMigrate)
+	if err != nil {
+		log.Fatalf("Failed to open tenant store: %v", err)
+	}
+	// Other instances sharing the SQLite file are seen by polling
+	if s, ok := store.(*database.SQLiteStore); ok {
+		s.SetPollInterval(cfg.Database.PollInterval)
+	}
+	if cfg.Database.TenantsFile != "" {
+		fs, err := database.NewFileStore(store, cfg.Database.TenantsFile, cfg.Database.TenantsFileMode)
+		if err != nil {
+			log.Fatalf("Failed to load tenants file: %v", err)
+		}
+		fs.SetPollInterval(cfg.Database.PollInterval)
+		store = fs
+	}
+
+	tm, err := database.NewTenantManager(store, true)
 	if err != nil {
 		log.Fatalf("Failed to initialize tenant manager: %v", err)
 	}
 	defer tm.Close()
+	tm.SetCachePolicy(database.CachePolicy{
+		TTL:         cfg.Cache.TTL,
+		NegativeTTL: cfg.Cache.NegativeTTL,
+		MaxEntries:  cfg.Cache.MaxEntries,
+	})
+
+	// Domains claimed through the tenant API are only routed once verified
+	var resolver verify.Resolver = verify.NewResolver(cfg.Verify.DNSServer)
+	if cfg.Verify.RecordsFile != "" {
+		resolver = &verify.FileResolver{Path: cfg.Verify.RecordsFile}
+		log.Printf("Domain verification lookups answered from %s", cfg.Verify.RecordsFile)
+	}
+	tm.StartDomainVerification(verify.NewChecker(resolver, cfg.Verify.HTTPPort, cfg.Verify.AllowPrivate), database.VerificationPolicy{
+		ClaimTTL: cfg.Verify.ClaimTTL,
+		Interval: cfg.Verify.Interval,
+		Reverify: cfg.Verify.Reverify,
+		Grace:    cfg.Verify.Grace,
+	})
+
+	statusPages, err := handler.NewStatusPages(
+		cfg.Proxy.SuspendedStatusCode,
+		cfg.Proxy.SuspendedPageFile,
+		cfg.Proxy.MaintenancePageFile,
+		cfg.Proxy.MaintenanceRetryAfter,
+	)
+	if err != nil {
+		log.Fatalf("Failed to load status pages: %v", err)
+	}
 
 	// Initialize handlers
 	proxyHandler := handler.NewProxyHandler(
@@ -40,93 +120,191 @@ func main() {
 		cfg.Proxy.MaxIdleConns,
 		cfg.Proxy.IdleConnTimeout,
 		cfg.Proxy.DisableKeepAlive,
+		cfg.Proxy.UpstreamProtocol,
+		cfg.Proxy.UpstreamTLSSkipVerify,
+		statusPages,
 	)
 
-	adminHandler := handler.NewAdminHandler(tm)
-	adminUIHandler := handler.NewAdminUIHandler()
-
-	// Setup router
-	r := chi.NewRouter()
-
-	// Middleware
-	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(60 * time.Second))
+	adminAuth := handler.AuthSettings{
+		SessionTTL:    cfg.Server.AdminSessionTTL,
+		SecureCookies: !cfg.Server.AdminInsecureCookies,
+		PasswordLogin: cfg.Server.AdminPasswordLogin,
+	}
+	if cfg.OIDC.Enabled() {
+		adminAuth.SSO = newSSO(cfg.OIDC)
+		log.Printf("Admin single sign-on with %s", cfg.OIDC.Issuer)
+	}
 
-	// CORS configuration
-	r.Use(cors.Handler(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"},
-		AllowedHeaders:   []string{"*"},
-		ExposedHeaders:   []string{"*"},
-		AllowCredentials: false,
-		MaxAge:           300,
-	}))
+	adminHandler := handler.NewAdminHandler(tm, adminAuth)
+	adminHandler.SetProxyHandler(proxyHandler)
+	adminUIHandler := handler.NewAdminUIHandler()
+	tenantAPIHandler := handler.NewTenantAPIHandler(tm, cfg.Server.TenantMaxDomains)
 
-	// Middleware to handle admin domain root path
-	adminDomain := cfg.Server.AdminDomain
-	r.Use(func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Only handle root path "/"
-			if r.URL.Path == "/" {
-				host := r.Host
-				if host == "" {
-					host = r.Header.Get("X-Forwarded-Host")
-				}
-				if host == "" {
-					host = r.Header.Get("X-Original-Host")
-				}
-				
-				// If request is from admin domain, redirect to /admin
-				if host == adminDomain {
-					http.Redirect(w, r, "/admin", http.StatusFound)
-					return
-				}
-			}
-			
-			// Continue to next handler
-			next.ServeHTTP(w, r)
-		})
-	})
+	// Admin and public routes are served by separate routers, so that tenant
+	// domains can't reach the admin panel and keep their own /admin paths
+	adminRouter := newRouter(trustedProxies)
 
-	// Health check endpoint
-	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, `{"status":"ok","service":"tenant-router"}`)
+	// Redirect the admin host's root to the panel
+	adminRouter.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/admin", http.StatusFound)
 	})
 
 	// Admin UI route
-	adminUIHandler.RegisterRoutes(r)
+	adminUIHandler.RegisterRoutes(adminRouter)
+
+	// Admin API routes, authenticated with API keys (see "tenant-router apikey")
+	// or the session cookie of an operator signed in to the panel
+	adminHandler.RegisterRoutes(adminRouter)
 
-	// Admin API routes (optional, can be protected with auth)
-	adminHandler.RegisterRoutes(r)
+	// Tenant API routes, authenticated with tenant tokens; also served on
+	// the public listener for TENANT_API_DOMAIN, so customers don't need
+	// access to the admin hosts
+	tenantAPIHandler.RegisterRoutes(adminRouter)
+
+	r := newRouter(trustedProxies)
 
 	// Proxy routes (catch-all)
 	proxyHandler.RegisterRoutes(r)
 
+	var publicHandler http.Handler = r
+	if len(cfg.Server.TenantAPIDomains) > 0 {
+		tenantAPIRouter := newRouter(trustedProxies)
+		tenantAPIHandler.RegisterRoutes(tenantAPIRouter)
+		publicHandler = handler.HostRouter(cfg.Server.TenantAPIDomains, tenantAPIRouter, r)
+		log.Printf("Tenant API served on hosts: %s", strings.Join(cfg.Server.TenantAPIDomains, ", "))
+	}
+
+	// Without a separate admin listener the admin hosts share the public one
+	var appHandler http.Handler = publicHandler
+	var adminSrv *http.Server
+	if cfg.Server.AdminListen != "" {
+		adminSrv = &http.Server{
+			Addr:         cfg.Server.AdminListen,
+			Handler:      adminRouter,
+			ReadTimeout:  cfg.Server.ReadTimeout,
+			WriteTimeout: cfg.Server.WriteTimeout,
+			IdleTimeout:  cfg.Server.IdleTimeout,
+		}
+	} else {
+		appHandler = handler.AdminHostRouter(cfg.Server.AdminDomains, adminRouter, publicHandler)
+	}
+
+	// Accept cleartext HTTP/2 (h2c) so plaintext gRPC clients can reach tenant backends
+	var rootHandler http.Handler = appHandler
+	if cfg.Server.EnableH2C {
+		rootHandler = h2c.NewHandler(appHandler, &http2.Server{IdleTimeout: cfg.Server.IdleTimeout})
+	}
+
 	// Setup HTTP server
 	srv := &http.Server{
 		Addr:         cfg.ServerAddress(),
-		Handler:      r,
+		Handler:      rootHandler,
 		ReadTimeout:  cfg.Server.ReadTimeout,
 		WriteTimeout: cfg.Server.WriteTimeout,
 		IdleTimeout:  cfg.Server.IdleTimeout,
 	}
 
+	// Optional TLS listener with SNI certificate selection, and HTTP/3 (QUIC) alongside it.
+	// Both share the same certificate store and the same router/proxy pipeline.
+	var certStore *certs.Store
+	var tlsSrv *http.Server
+	var h3Srv *http3.Server
+	if cfg.TLS.Enabled {
+		certStore, err = certs.NewStore(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.CertDir)
+		if err != nil {
+			log.Fatalf("Failed to load TLS certificates: %v", err)
+		}
+
+		var tlsHandler http.Handler = appHandler
+		if cfg.TLS.EnableHTTP3 {
+			h3Srv = &http3.Server{
+				Addr:      cfg.TLSAddress(),
+				Handler:   appHandler,
+				TLSConfig: http3.ConfigureTLSConfig(certStore.TLSConfig()),
+			}
+
+			// Advertise HTTP/3 on every response served over TCP
+			tlsHandler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				_ = h3Srv.SetQUICHeaders(w.Header())
+				appHandler.ServeHTTP(w, req)
+			})
+		}
+
+		tlsSrv = &http.Server{
+			Addr:         cfg.TLSAddress(),
+			Handler:      tlsHandler,
+			TLSConfig:    certStore.TLSConfig(),
+			ReadTimeout:  cfg.Server.ReadTimeout,
+			WriteTimeout: cfg.Server.WriteTimeout,
+			IdleTimeout:  cfg.Server.IdleTimeout,
+		}
+	}
+
 	// Start server in goroutine
 	go func() {
 		log.Printf("Starting tenant router server on %s", cfg.ServerAddress())
 		log.Printf("Backend URL: %s", cfg.Proxy.BackendURL)
-		log.Printf("Database path: %s", cfg.Database.Path)
+		if cfg.Database.Driver == "postgres" {
+			log.Printf("Database: postgres")
+		} else {
+			log.Printf("Database path: %s", cfg.Database.Path)
+		}
 		
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		ln, err := listen(cfg.ServerAddress(), cfg, proxyProtocolTrusted)
+		if err != nil {
+			log.Fatalf("Server failed to start: %v", err)
+		}
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server failed to start: %v", err)
 		}
 	}()
 
+	if tlsSrv != nil {
+		go func() {
+			log.Printf("Starting TLS listener on %s", cfg.TLSAddress())
+			ln, err := listen(cfg.TLSAddress(), cfg, proxyProtocolTrusted)
+			if err != nil {
+				log.Fatalf("TLS server failed to start: %v", err)
+			}
+			if err := tlsSrv.ServeTLS(ln, "", ""); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("TLS server failed to start: %v", err)
+			}
+		}()
+	}
+
+	if adminSrv != nil {
+		go func() {
+			log.Printf("Starting admin listener on %s", cfg.Server.AdminListen)
+			if err := adminSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Admin server failed to start: %v", err)
+			}
+		}()
+	} else {
+		log.Printf("Admin panel served on hosts: %s", strings.Join(cfg.Server.AdminDomains, ", "))
+	}
+
+	if h3Srv != nil {
+		go func() {
+			log.Printf("Starting HTTP/3 (QUIC) listener on udp %s", cfg.TLSAddress())
+			if err := h3Srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("HTTP/3 server stopped: %v", err)
+			}
+		}()
+	}
+
+	// Reload certificates on SIGHUP (e.g. from a certbot deploy hook)
+	if certStore != nil {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := certStore.Reload(); err != nil {
+					log.Printf("[TLS] ERROR: certificate reload failed: %v", err)
+				}
+			}
+		}()
+	}
+
 	// Wait for interrupt signal for graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -137,9 +315,109 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	// HTTP/3 clients are sent GOAWAY and their requests drain alongside
+	// the TCP listeners'
+	h3Done := make(chan struct{})
+	go func() {
+		defer close(h3Done)
+		if h3Srv == nil {
+			return
+		}
+		if err := h3Srv.Shutdown(ctx); err != nil {
+			log.Printf("HTTP/3 server forced to shutdown: %v", err)
+		}
+	}()
+
+	if adminSrv != nil {
+		if err := adminSrv.Shutdown(ctx); err != nil {
+			log.Printf("Admin server forced to shutdown: %v", err)
+		}
+	}
+
+	if tlsSrv != nil {
+		if err := tlsSrv.Shutdown(ctx); err != nil {
+			log.Printf("TLS server forced to shutdown: %v", err)
+		}
+	}
+
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
+	<-h3Done
 
 	log.Println("Server exited")
 }
+
+// newRouter returns a router with the middleware shared by the admin and
+// public routes. Client addresses reported by the trusted reverse proxies
+// replace the connection's.
+func newRouter(trustedProxies []*net.IPNet) chi.Router {
+	r := chi.NewRouter()
+
+	// Middleware
+	r.Use(middleware.RequestID)
+	r.Use(handler.RealIP(trustedProxies))
+	r.Use(middleware.Logger)
+	r.Use(middleware.Recoverer)
+	r.Use(handler.Timeout(60 * time.Second))
+
+	// CORS configuration
+	r.Use(cors.Handler(cors.Options{
+		AllowedOrigins:   []string{"*"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"},
+		AllowedHeaders:   []string{"*"},
+		ExposedHeaders:   []string{"*"},
+		AllowCredentials: false,
+		MaxAge:           300,
+	}))
+
+	// Health check endpoint
+	r.Get("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintf(w, `{"status":"ok","service":"tenant-router"}`)
+	})
+
+	return r
+}
+
+// newSSO returns the single sign-on settings of the admin panel. Users
+// return to the panel after signing out at the provider.
+func newSSO(cfg config.OIDCConfig) *handler.SSO {
+	postLogout := ""
+	if u, err := url.Parse(cfg.RedirectURL); err == nil {
+		postLogout = (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: "/admin"}).String()
+	}
+
+	return &handler.SSO{
+		Provider: oidc.NewProvider(oidc.Config{
+			Issuer:       cfg.Issuer,
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Scopes:       cfg.Scopes,
+		}),
+		UsernameClaim: cfg.UsernameClaim,
+		GroupsClaim:   cfg.GroupsClaim,
+		TenantsClaim:  cfg.TenantsClaim,
+		RoleMap:       cfg.RoleMap,
+		DefaultRole:   cfg.DefaultRole,
+		PostLogoutURL: postLogout,
+	}
+}
+
+// listen opens a TCP listener on addr, decoding PROXY protocol headers from
+// the trusted load balancers when enabled.
+func listen(addr string, cfg *config.Config, trusted []*net.IPNet) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if !cfg.Server.ProxyProtocol {
+		return ln, nil
+	}
+
+	log.Printf("PROXY protocol enabled on %s (trusted: %s)", addr, strings.Join(cfg.Server.ProxyProtocolTrusted, ", "))
+	return proxyproto.NewListener(ln, trusted, cfg.Server.ProxyProtocolTimeout), nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tenantical/router/internal/config"
+	"github.com/tenantical/router/internal/database"
+)
+
+const migrateUsage = `Usage: tenant-router migrate <command>
+
+Commands:
+  status        Show applied and pending migrations
+  up [version]  Apply pending migrations (up to version, default latest)
+  down [steps]  Revert the last applied migrations (default 1)
+
+The database is selected with DB_DRIVER, DB_PATH and DATABASE_URL.
+`
+
+// runMigrate implements the migrate subcommand and returns the exit code.
+func runMigrate(cfg *config.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	n := 0
+	if len(args) > 1 {
+		var err error
+		n, err = strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			fmt.Fprintf(os.Stderr, "invalid argument %q: must be a non-negative number\n", args[1])
+			return 2
+		}
+	}
+
+	migrator, db, err := database.OpenMigrator(cfg.Database.Driver, cfg.Database.DSN())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	switch args[0] {
+	case "status":
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+			return 1
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+		for _, s := range status {
+			state := "pending"
+			switch {
+			case s.Unknown:
+				state = "applied (unknown to this binary)"
+			case s.Applied:
+				state = "applied"
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", s.Version, s.Name, state, s.AppliedAt)
+		}
+		tw.Flush()
+
+		if err := migrator.Check(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "\n%v\n", err)
+			return 1
+		}
+		return 0
+
+	case "up":
+		err = migrator.Up(ctx, n)
+
+	case "down":
+		if n == 0 {
+			n = 1
+		}
+		err = migrator.Down(ctx, n)
+
+	default:
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+		return 1
+	}
+
+	current, err := migrator.Current(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Database schema at version %d (latest known: %d)\n", current, migrator.Latest())
+	return 0
+}
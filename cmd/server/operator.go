@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tenantical/router/internal/config"
+	"github.com/tenantical/router/internal/database"
+)
+
+const operatorUsage = `Usage: tenant-router operator <command>
+
+Commands:
+  create -username NAME [-role viewer|editor|owner] [-tenants ID,...]
+                Create an admin panel operator; the password is read from stdin
+  list          List operators
+  passwd NAME   Set an operator's password, read from stdin, and end their sessions
+  delete NAME   Delete an operator
+
+The database is selected with DB_DRIVER, DB_PATH and DATABASE_URL.
+`
+
+// runOperator implements the operator subcommand, used among others to
+// create the first owner, and returns the exit code.
+func runOperator(cfg *config.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, operatorUsage)
+		return 2
+	}
+
+	store, err := database.OpenStore(cfg.Database.Driver, cfg.Database.DSN(), cfg.Database.AutoMigrate)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "operator: %v\n", err)
+		return 1
+	}
+	tm, err := database.NewTenantManager(store, false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "operator: %v\n", err)
+		return 1
+	}
+	defer tm.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	ctx = database.WithActor(ctx, "cli")
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("operator create", flag.ContinueOnError)
+		username := fs.String("username", "", "Username to sign in with")
+		role := fs.String("role", database.RoleOwner, "Role: viewer, editor or owner")
+		tenants := fs.String("tenants", "", "Comma-separated tenant IDs the operator is restricted to (default: all)")
+		if err := fs.Parse(args[1:]); err != nil {
+			return 2
+		}
+		if *username == "" {
+			fmt.Fprintln(os.Stderr, "operator create: -username is required")
+			return 2
+		}
+
+		password, err := readPassword(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "operator create: %v\n", err)
+			return 1
+		}
+
+		o := database.Operator{Username: *username, Role: *role}
+		if *tenants != "" {
+			o.Tenants = strings.Split(*tenants, ",")
+		}
+		created, err := tm.CreateOperator(ctx, o, password)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "operator create: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Created operator %s (%s) with role %s\n", created.Username, created.ID, created.Role)
+		return 0
+
+	case "list":
+		operators, err := tm.ListOperators()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "operator list: %v\n", err)
+			return 1
+		}
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tUSERNAME\tROLE\tTENANTS\tLAST LOGIN\tSTATUS")
+		for _, o := range operators {
+			tenants := "all"
+			if len(o.Tenants) > 0 {
+				tenants = strings.Join(o.Tenants, ",")
+			}
+			status := "active"
+			if o.Disabled {
+				status = "disabled"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", o.ID, o.Username, o.Role, tenants, formatTime(o.LastLoginAt, "never"), status)
+		}
+		tw.Flush()
+		return 0
+
+	case "passwd", "delete":
+		if len(args) != 2 {
+			fmt.Fprint(os.Stderr, operatorUsage)
+			return 2
+		}
+		o, err := tm.GetOperatorByUsername(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "operator %s: %s: %v\n", args[0], args[1], err)
+			return 1
+		}
+
+		if args[0] == "delete" {
+			if err := tm.DeleteOperator(ctx, o.ID); err != nil {
+				fmt.Fprintf(os.Stderr, "operator delete: %v\n", err)
+				return 1
+			}
+			fmt.Printf("Operator %s deleted\n", o.Username)
+			return 0
+		}
+
+		password, err := readPassword(os.Stdin)
+		if err == nil && password == "" {
+			err = errors.New("password is required")
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "operator passwd: %v\n", err)
+			return 1
+		}
+		if _, err := tm.UpdateOperator(ctx, *o, password); err != nil {
+			fmt.Fprintf(os.Stderr, "operator passwd: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Password of operator %s changed\n", o.Username)
+		return 0
+
+	default:
+		fmt.Fprint(os.Stderr, operatorUsage)
+		return 2
+	}
+}
+
+// readPassword reads a password from the first line of r, prompting for it
+// on stderr.
+func readPassword(r io.Reader) (string, error) {
+	fmt.Fprint(os.Stderr, "Password: ")
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
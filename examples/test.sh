@@ -5,6 +5,11 @@
 
 BASE_URL="http://localhost:8080"
 BACKEND_URL="${BACKEND_URL:-http://localhost:3000}"
+ADMIN_DOMAIN="${ADMIN_DOMAIN:-tenantical.iranservat.com}"
+ADMIN_API_KEY="${ADMIN_API_KEY:?ADMIN_API_KEY must be set}"
+
+# Admin routes are only served on ADMIN_DOMAIN hosts and need an API key
+ADMIN=(-H "Host: $ADMIN_DOMAIN" -H "Authorization: Bearer $ADMIN_API_KEY")
 
 echo "🧪 Testing Tenant Router"
 echo "========================="
@@ -28,11 +33,11 @@ echo ""
 
 # Test 2: Add Tenant
 echo -e "${YELLOW}Test 2: Add Tenant${NC}"
-response=$(curl -s -o /dev/null -w "%{http_code}" -X POST "$BASE_URL/admin/tenants" \
+response=$(curl -s -o /dev/null -w "%{http_code}" "${ADMIN[@]}" -X PUT "$BASE_URL/admin/domains/test.example.com" \
   -H "Content-Type: application/json" \
-  -d '{"domain": "test.example.com", "tenant_id": "test-tenant-123"}')
+  -d '{"tenant_id": "test-tenant-123"}')
 
-if [ "$response" = "200" ]; then
+if [ "$response" = "201" ] || [ "$response" = "200" ]; then
     echo -e "${GREEN}✓ Tenant added successfully${NC}"
 else
     echo -e "${RED}✗ Failed to add tenant (HTTP $response)${NC}"
@@ -41,7 +46,7 @@ echo ""
 
 # Test 3: List Tenants
 echo -e "${YELLOW}Test 3: List Tenants${NC}"
-tenants=$(curl -s "$BASE_URL/admin/tenants")
+tenants=$(curl -s "${ADMIN[@]}" "$BASE_URL/admin/tenants")
 echo "$tenants" | grep -q "test-tenant-123"
 if [ $? -eq 0 ]; then
     echo -e "${GREEN}✓ Tenant listed successfully${NC}"
@@ -61,11 +66,11 @@ echo ""
 
 # Test 5: Wildcard Domain
 echo -e "${YELLOW}Test 5: Add Wildcard Domain${NC}"
-response=$(curl -s -o /dev/null -w "%{http_code}" -X POST "$BASE_URL/admin/tenants" \
+response=$(curl -s -o /dev/null -w "%{http_code}" "${ADMIN[@]}" -X PUT "$BASE_URL/admin/domains/*.test.com" \
   -H "Content-Type: application/json" \
-  -d '{"domain": "*.test.com", "tenant_id": "wildcard-tenant"}')
+  -d '{"tenant_id": "wildcard-tenant"}')
 
-if [ "$response" = "200" ]; then
+if [ "$response" = "201" ] || [ "$response" = "200" ]; then
     echo -e "${GREEN}✓ Wildcard domain added successfully${NC}"
 else
     echo -e "${RED}✗ Failed to add wildcard domain (HTTP $response)${NC}"
@@ -74,7 +79,9 @@ echo ""
 
 # Test 6: Delete Tenant
 echo -e "${YELLOW}Test 6: Delete Tenant${NC}"
-response=$(curl -s -o /dev/null -w "%{http_code}" -X DELETE "$BASE_URL/admin/tenants/test.example.com")
+# Deletes must name the revision they delete: send the domain's ETag
+etag=$(curl -s -o /dev/null -D - "${ADMIN[@]}" "$BASE_URL/admin/domains/test.example.com" | tr -d '\r' | awk 'tolower($1) == "etag:" {print $2}')
+response=$(curl -s -o /dev/null -w "%{http_code}" "${ADMIN[@]}" -H "If-Match: $etag" -X DELETE "$BASE_URL/admin/domains/test.example.com")
 if [ "$response" = "200" ]; then
     echo -e "${GREEN}✓ Tenant deleted successfully${NC}"
 else
@@ -0,0 +1,167 @@
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Store selects a TLS certificate per connection based on SNI.
+// Certificates are indexed by every DNS name they cover (including
+// wildcard names such as *.example.com), so the same store serves
+// both the TLS (TCP) listener and the HTTP/3 (QUIC) listener.
+type Store struct {
+	certFile string
+	keyFile  string
+	dir      string
+
+	mu          sync.RWMutex
+	byName      map[string]*tls.Certificate
+	defaultCert *tls.Certificate
+}
+
+// NewStore loads the default certificate (certFile/keyFile) and every
+// certificate found in dir. Either source may be empty, but not both.
+//
+// dir is scanned recursively for certbot-style pairs (fullchain.pem +
+// privkey.pem in the same directory) and for <name>.crt + <name>.key pairs.
+func NewStore(certFile, keyFile, dir string) (*Store, error) {
+	if certFile == "" && dir == "" {
+		return nil, fmt.Errorf("no certificate configured: set TLS_CERT_FILE/TLS_KEY_FILE or TLS_CERT_DIR")
+	}
+
+	s := &Store{
+		certFile: certFile,
+		keyFile:  keyFile,
+		dir:      dir,
+	}
+
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Reload re-reads all certificates from disk and atomically replaces the
+// current set. On error the previous set is kept.
+func (s *Store) Reload() error {
+	byName := make(map[string]*tls.Certificate)
+	var defaultCert *tls.Certificate
+
+	if s.certFile != "" {
+		cert, err := loadPair(s.certFile, s.keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load default certificate: %w", err)
+		}
+		defaultCert = cert
+		index(byName, cert)
+	}
+
+	if s.dir != "" {
+		err := filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+
+			var keyPath string
+			switch {
+			case info.Name() == "fullchain.pem":
+				keyPath = filepath.Join(filepath.Dir(path), "privkey.pem")
+			case strings.HasSuffix(info.Name(), ".crt"):
+				keyPath = strings.TrimSuffix(path, ".crt") + ".key"
+			default:
+				return nil
+			}
+
+			cert, err := loadPair(path, keyPath)
+			if err != nil {
+				log.Printf("[TLS] WARNING: skipping certificate %s: %v", path, err)
+				return nil
+			}
+			index(byName, cert)
+			if defaultCert == nil {
+				defaultCert = cert
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to scan certificate directory: %w", err)
+		}
+	}
+
+	if defaultCert == nil {
+		return fmt.Errorf("no certificates found")
+	}
+
+	s.mu.Lock()
+	s.byName = byName
+	s.defaultCert = defaultCert
+	s.mu.Unlock()
+
+	log.Printf("[TLS] Loaded certificates for %d names", len(byName))
+	return nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate. It prefers an exact
+// name match, then a wildcard covering the name, then the default certificate.
+func (s *Store) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if cert, ok := s.byName[name]; ok {
+		return cert, nil
+	}
+
+	if i := strings.Index(name, "."); i > 0 {
+		if cert, ok := s.byName["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+
+	return s.defaultCert, nil
+}
+
+// TLSConfig returns a server TLS configuration backed by this store.
+func (s *Store) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: s.GetCertificate,
+	}
+}
+
+func loadPair(certPath, keyPath string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	cert.Leaf = leaf
+
+	return &cert, nil
+}
+
+func index(byName map[string]*tls.Certificate, cert *tls.Certificate) {
+	names := cert.Leaf.DNSNames
+	if len(names) == 0 && cert.Leaf.Subject.CommonName != "" {
+		names = []string{cert.Leaf.Subject.CommonName}
+	}
+
+	for _, name := range names {
+		byName[strings.ToLower(name)] = cert
+	}
+}
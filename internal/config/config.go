@@ -4,13 +4,18 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
 	Server   ServerConfig
+	TLS      TLSConfig
 	Database DatabaseConfig
 	Proxy    ProxyConfig
+	OIDC     OIDCConfig
+	Verify   VerifyConfig
+	Cache    CacheConfig
 }
 
 type ServerConfig struct {
@@ -19,11 +24,92 @@ type ServerConfig struct {
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
 	IdleTimeout  time.Duration
-	AdminDomain  string // Domain for admin panel access
+	AdminDomains []string // Hosts serving the admin panel and API (ADMIN_DOMAIN, comma-separated)
+	AdminListen  string   // Separate address for the admin panel and API; when set, no public host serves them
+	EnableH2C    bool     // Accept cleartext HTTP/2 (h2c) on the listener, needed for plaintext gRPC clients
+
+	AdminSessionTTL      time.Duration // Lifetime of admin panel sign-in sessions
+	AdminInsecureCookies bool          // Allow the session cookie over plain HTTP (local development only)
+	AdminPasswordLogin   bool          // Allow operators to sign in with a username and password
+
+	TenantAPIDomains []string // Public hosts serving the tenant API, besides the admin hosts (TENANT_API_DOMAIN, comma-separated)
+	TenantMaxDomains int      // Domains a tenant may add through the tenant API unless it has its own max_domains
+
+	ProxyProtocol        bool          // Decode PROXY protocol v1/v2 headers on inbound TCP connections
+	ProxyProtocolTrusted []string      // CIDRs/IPs allowed to send PROXY protocol headers (PROXY_PROTOCOL_TRUSTED, comma-separated)
+	ProxyProtocolTimeout time.Duration // Max time to wait for the PROXY protocol header
+
+	TrustedProxies []string // CIDRs/IPs whose True-Client-IP, X-Real-IP and X-Forwarded-For headers are believed (TRUSTED_PROXIES, comma-separated)
+}
+
+type TLSConfig struct {
+	Enabled     bool
+	Port        int
+	CertFile    string // Default certificate (used when no SNI match is found)
+	KeyFile     string
+	CertDir     string // Directory with per-domain certificates, selected by SNI
+	EnableHTTP3 bool   // Serve HTTP/3 (QUIC) on the same port over UDP and advertise it with Alt-Svc
 }
 
 type DatabaseConfig struct {
-	Path string
+	Driver string // sqlite or postgres
+	Path   string // SQLite database file
+	URL    string // PostgreSQL connection URL
+
+	AutoMigrate  bool          // Apply pending schema migrations on startup
+	PollInterval time.Duration // How often SQLite and the tenants file are polled for changes made elsewhere
+
+	TenantsFile     string // YAML or JSON file of tenants and domains, routed along with the database's
+	TenantsFileMode string // authoritative, override or fallback: how the file combines with the database
+}
+
+// OIDCConfig enables single sign-on to the admin panel with an OpenID
+// Connect provider.
+type OIDCConfig struct {
+	Issuer       string   // Provider issuer URL; empty disables single sign-on
+	ClientID     string
+	ClientSecret string   // Optional for public clients, which rely on PKCE
+	RedirectURL  string   // https://<admin host>/admin/oidc/callback, as registered with the provider
+	Scopes       []string // Scopes requested besides openid
+
+	UsernameClaim string            // Claim operators are named after
+	GroupsClaim   string            // Claim listing the user's groups
+	TenantsClaim  string            // Optional claim restricting operators to tenants ("*" for all)
+	RoleMap       map[string]string // Group → role (viewer, editor, owner); the highest matching role wins
+	DefaultRole   string            // Role of users in no mapped group; empty denies them
+}
+
+// VerifyConfig sets how ownership of domains claimed through the tenant API
+// is verified.
+type VerifyConfig struct {
+	ClaimTTL     time.Duration // Unverified claims are dropped after this long
+	Interval     time.Duration // How often pending claims are checked
+	Reverify     time.Duration // How often verified domains are checked again; 0 never
+	Grace        time.Duration // How long a verified domain may fail re-verification before it's pending again
+	DNSServer    string        // DNS server (host:port) for lookups; empty uses the system resolver
+	RecordsFile  string        // JSON file answering lookups instead of DNS, for local testing
+	HTTPPort     int           // Port the well-known file is fetched from
+	AllowPrivate bool          // Fetch the well-known file from private and loopback addresses too
+}
+
+// CacheConfig bounds the cache of resolved hosts.
+type CacheConfig struct {
+	TTL         time.Duration // How long a resolved host is cached; busy hosts are refreshed in the background
+	NegativeTTL time.Duration // How long an unknown host is cached; 0 never
+	MaxEntries  int           // Least recently used hosts are evicted beyond this
+}
+
+// Enabled reports whether single sign-on is configured.
+func (o OIDCConfig) Enabled() bool {
+	return o.Issuer != ""
+}
+
+// DSN returns the data source for the configured driver.
+func (d DatabaseConfig) DSN() string {
+	if d.Driver == "postgres" {
+		return d.URL
+	}
+	return d.Path
 }
 
 type ProxyConfig struct {
@@ -32,6 +118,13 @@ type ProxyConfig struct {
 	MaxIdleConns     int
 	IdleConnTimeout  time.Duration
 	DisableKeepAlive bool
+	UpstreamProtocol string // Default upstream protocol for tenants without one: http1, h2, h2c
+	UpstreamTLSSkipVerify bool // Skip certificate verification for HTTPS/h2 backends
+
+	SuspendedStatusCode   int           // Status for suspended tenants: 402 or 403
+	SuspendedPageFile     string        // Optional HTML template replacing the built-in suspended page
+	MaintenancePageFile   string        // Optional HTML template replacing the built-in maintenance page
+	MaintenanceRetryAfter time.Duration // Retry-After sent with maintenance responses
 }
 
 func Load() (*Config, error) {
@@ -44,6 +137,22 @@ func Load() (*Config, error) {
 	maxIdleConns, _ := strconv.Atoi(getEnv("PROXY_MAX_IDLE_CONNS", "100"))
 	idleConnTimeout, _ := strconv.Atoi(getEnv("PROXY_IDLE_CONN_TIMEOUT", "90"))
 
+	tlsPort, _ := strconv.Atoi(getEnv("TLS_PORT", "8443"))
+	proxyProtocolTimeout, _ := strconv.Atoi(getEnv("PROXY_PROTOCOL_HEADER_TIMEOUT", "5"))
+	suspendedStatusCode, _ := strconv.Atoi(getEnv("SUSPENDED_STATUS_CODE", "403"))
+	maintenanceRetryAfter, _ := strconv.Atoi(getEnv("MAINTENANCE_RETRY_AFTER", "300"))
+	adminSessionTTL, _ := strconv.Atoi(getEnv("ADMIN_SESSION_TTL", "43200"))
+	tenantMaxDomains, _ := strconv.Atoi(getEnv("TENANT_API_MAX_DOMAINS", "10"))
+	verifyTTL, _ := strconv.Atoi(getEnv("DOMAIN_VERIFY_TTL", "259200"))
+	verifyInterval, _ := strconv.Atoi(getEnv("DOMAIN_VERIFY_INTERVAL", "60"))
+	reverifyInterval, _ := strconv.Atoi(getEnv("DOMAIN_REVERIFY_INTERVAL", "86400"))
+	reverifyGrace, _ := strconv.Atoi(getEnv("DOMAIN_REVERIFY_GRACE", "259200"))
+	verifyHTTPPort, _ := strconv.Atoi(getEnv("DOMAIN_VERIFY_HTTP_PORT", "80"))
+	dbPollInterval, _ := strconv.Atoi(getEnv("DB_POLL_INTERVAL", "1"))
+	cacheTTL, _ := strconv.Atoi(getEnv("CACHE_TTL", "300"))
+	cacheNegativeTTL, _ := strconv.Atoi(getEnv("CACHE_NEGATIVE_TTL", "10"))
+	cacheMaxEntries, _ := strconv.Atoi(getEnv("CACHE_MAX_ENTRIES", "10000"))
+
 	cfg := &Config{
 		Server: ServerConfig{
 			Host:         getEnv("HOST", "0.0.0.0"),
@@ -51,10 +160,41 @@ func Load() (*Config, error) {
 			ReadTimeout:  time.Duration(readTimeout) * time.Second,
 			WriteTimeout: time.Duration(writeTimeout) * time.Second,
 			IdleTimeout:  time.Duration(idleTimeout) * time.Second,
-			AdminDomain:  getEnv("ADMIN_DOMAIN", "tenantical.iranservat.com"),
+			AdminDomains: splitList(getEnv("ADMIN_DOMAIN", "tenantical.iranservat.com")),
+			AdminListen:  getEnv("ADMIN_LISTEN_ADDR", ""),
+			EnableH2C:    getEnv("ENABLE_H2C", "false") == "true",
+
+			AdminSessionTTL:      time.Duration(adminSessionTTL) * time.Second,
+			AdminInsecureCookies: getEnv("ADMIN_INSECURE_COOKIES", "false") == "true",
+			AdminPasswordLogin:   getEnv("ADMIN_PASSWORD_LOGIN", "true") == "true",
+
+			TenantAPIDomains: splitList(getEnv("TENANT_API_DOMAIN", "")),
+			TenantMaxDomains: tenantMaxDomains,
+
+			ProxyProtocol:        getEnv("PROXY_PROTOCOL_ENABLED", "false") == "true",
+			ProxyProtocolTrusted: splitList(getEnv("PROXY_PROTOCOL_TRUSTED", "")),
+			ProxyProtocolTimeout: time.Duration(proxyProtocolTimeout) * time.Second,
+
+			TrustedProxies: splitList(getEnv("TRUSTED_PROXIES", "127.0.0.1,::1")),
+		},
+		TLS: TLSConfig{
+			Enabled:     getEnv("TLS_ENABLED", "false") == "true",
+			Port:        tlsPort,
+			CertFile:    getEnv("TLS_CERT_FILE", ""),
+			KeyFile:     getEnv("TLS_KEY_FILE", ""),
+			CertDir:     getEnv("TLS_CERT_DIR", ""),
+			EnableHTTP3: getEnv("ENABLE_HTTP3", "false") == "true",
 		},
 		Database: DatabaseConfig{
-			Path: getEnv("DB_PATH", "./tenants.db"),
+			Driver: getEnv("DB_DRIVER", "sqlite"),
+			Path:   getEnv("DB_PATH", "./tenants.db"),
+			URL:    getEnv("DATABASE_URL", ""),
+
+			AutoMigrate:  getEnv("DB_AUTO_MIGRATE", "true") == "true",
+			PollInterval: time.Duration(dbPollInterval) * time.Second,
+
+			TenantsFile:     getEnv("TENANTS_FILE", ""),
+			TenantsFileMode: getEnv("TENANTS_FILE_MODE", "override"),
 		},
 		Proxy: ProxyConfig{
 			BackendURL:       getEnv("BACKEND_URL", "http://localhost:3000"),
@@ -62,12 +202,159 @@ func Load() (*Config, error) {
 			MaxIdleConns:     maxIdleConns,
 			IdleConnTimeout:  time.Duration(idleConnTimeout) * time.Second,
 			DisableKeepAlive: getEnv("PROXY_DISABLE_KEEPALIVE", "false") == "true",
+			UpstreamProtocol: getEnv("PROXY_UPSTREAM_PROTOCOL", "http1"),
+			UpstreamTLSSkipVerify: getEnv("PROXY_UPSTREAM_TLS_SKIP_VERIFY", "false") == "true",
+
+			SuspendedStatusCode:   suspendedStatusCode,
+			SuspendedPageFile:     getEnv("SUSPENDED_PAGE_FILE", ""),
+			MaintenancePageFile:   getEnv("MAINTENANCE_PAGE_FILE", ""),
+			MaintenanceRetryAfter: time.Duration(maintenanceRetryAfter) * time.Second,
 		},
 	}
 
+	cfg.Verify = VerifyConfig{
+		ClaimTTL:     time.Duration(verifyTTL) * time.Second,
+		Interval:     time.Duration(verifyInterval) * time.Second,
+		Reverify:     time.Duration(reverifyInterval) * time.Second,
+		Grace:        time.Duration(reverifyGrace) * time.Second,
+		DNSServer:    getEnv("DOMAIN_VERIFY_DNS_SERVER", ""),
+		RecordsFile:  getEnv("DOMAIN_VERIFY_RECORDS_FILE", ""),
+		HTTPPort:     verifyHTTPPort,
+		AllowPrivate: getEnv("DOMAIN_VERIFY_ALLOW_PRIVATE", "false") == "true",
+	}
+
+	cfg.Cache = CacheConfig{
+		TTL:         time.Duration(cacheTTL) * time.Second,
+		NegativeTTL: time.Duration(cacheNegativeTTL) * time.Second,
+		MaxEntries:  cacheMaxEntries,
+	}
+
+	cfg.OIDC = OIDCConfig{
+		Issuer:       getEnv("OIDC_ISSUER", ""),
+		ClientID:     getEnv("OIDC_CLIENT_ID", ""),
+		ClientSecret: getEnv("OIDC_CLIENT_SECRET", ""),
+		RedirectURL:  getEnv("OIDC_REDIRECT_URL", ""),
+		Scopes:       splitList(getEnv("OIDC_SCOPES", "profile,email")),
+
+		UsernameClaim: getEnv("OIDC_USERNAME_CLAIM", "email"),
+		GroupsClaim:   getEnv("OIDC_GROUPS_CLAIM", "groups"),
+		TenantsClaim:  getEnv("OIDC_TENANTS_CLAIM", ""),
+		DefaultRole:   getEnv("OIDC_DEFAULT_ROLE", ""),
+	}
+	roleMap, err := parseRoleMap(getEnv("OIDC_ROLE_MAP", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid OIDC_ROLE_MAP: %w", err)
+	}
+	cfg.OIDC.RoleMap = roleMap
+
+	switch cfg.Proxy.UpstreamProtocol {
+	case "http1", "h2", "h2c":
+	default:
+		return nil, fmt.Errorf("invalid PROXY_UPSTREAM_PROTOCOL %q (must be http1, h2 or h2c)", cfg.Proxy.UpstreamProtocol)
+	}
+
+	if cfg.Proxy.SuspendedStatusCode != 402 && cfg.Proxy.SuspendedStatusCode != 403 {
+		return nil, fmt.Errorf("invalid SUSPENDED_STATUS_CODE %d (must be 402 or 403)", cfg.Proxy.SuspendedStatusCode)
+	}
+
+	switch cfg.Database.Driver {
+	case "sqlite":
+	case "postgres":
+		if cfg.Database.URL == "" {
+			return nil, fmt.Errorf("DB_DRIVER=postgres requires DATABASE_URL")
+		}
+	default:
+		return nil, fmt.Errorf("invalid DB_DRIVER %q (must be sqlite or postgres)", cfg.Database.Driver)
+	}
+
+	if cfg.Server.ProxyProtocol && len(cfg.Server.ProxyProtocolTrusted) == 0 {
+		return nil, fmt.Errorf("PROXY_PROTOCOL_ENABLED requires PROXY_PROTOCOL_TRUSTED (CIDRs of the load balancers)")
+	}
+
+	if cfg.Server.AdminSessionTTL <= 0 {
+		return nil, fmt.Errorf("invalid ADMIN_SESSION_TTL %q (must be a positive number of seconds)", getEnv("ADMIN_SESSION_TTL", ""))
+	}
+
+	if cfg.Server.TenantMaxDomains < 0 {
+		return nil, fmt.Errorf("invalid TENANT_API_MAX_DOMAINS %q (must be 0 or more)", getEnv("TENANT_API_MAX_DOMAINS", ""))
+	}
+
+	if cfg.Verify.ClaimTTL <= 0 || cfg.Verify.Interval <= 0 {
+		return nil, fmt.Errorf("DOMAIN_VERIFY_TTL and DOMAIN_VERIFY_INTERVAL must be positive numbers of seconds")
+	}
+	if cfg.Verify.Reverify < 0 || cfg.Verify.Grace < 0 {
+		return nil, fmt.Errorf("DOMAIN_REVERIFY_INTERVAL and DOMAIN_REVERIFY_GRACE must be 0 or more seconds")
+	}
+	if cfg.Database.PollInterval <= 0 {
+		return nil, fmt.Errorf("invalid DB_POLL_INTERVAL %q (must be a positive number of seconds)", getEnv("DB_POLL_INTERVAL", ""))
+	}
+	switch cfg.Database.TenantsFileMode {
+	case "authoritative", "override", "fallback":
+	default:
+		return nil, fmt.Errorf("invalid TENANTS_FILE_MODE %q (must be authoritative, override or fallback)", cfg.Database.TenantsFileMode)
+	}
+	if cfg.Cache.TTL <= 0 || cfg.Cache.NegativeTTL < 0 || cfg.Cache.MaxEntries <= 0 {
+		return nil, fmt.Errorf("CACHE_TTL and CACHE_MAX_ENTRIES must be positive, CACHE_NEGATIVE_TTL 0 or more")
+	}
+	if cfg.Verify.HTTPPort <= 0 || cfg.Verify.HTTPPort > 65535 {
+		return nil, fmt.Errorf("invalid DOMAIN_VERIFY_HTTP_PORT %q", getEnv("DOMAIN_VERIFY_HTTP_PORT", ""))
+	}
+
+	if cfg.OIDC.Enabled() {
+		if cfg.OIDC.ClientID == "" || cfg.OIDC.RedirectURL == "" {
+			return nil, fmt.Errorf("OIDC_ISSUER requires OIDC_CLIENT_ID and OIDC_REDIRECT_URL")
+		}
+		if len(cfg.OIDC.RoleMap) == 0 && cfg.OIDC.DefaultRole == "" {
+			return nil, fmt.Errorf("OIDC_ISSUER requires OIDC_ROLE_MAP or OIDC_DEFAULT_ROLE")
+		}
+	}
+	if cfg.OIDC.DefaultRole != "" && !validRole(cfg.OIDC.DefaultRole) {
+		return nil, fmt.Errorf("invalid OIDC_DEFAULT_ROLE %q (must be viewer, editor or owner)", cfg.OIDC.DefaultRole)
+	}
+
+	if !cfg.Server.AdminPasswordLogin && !cfg.OIDC.Enabled() {
+		return nil, fmt.Errorf("ADMIN_PASSWORD_LOGIN=false requires single sign-on (OIDC_ISSUER)")
+	}
+
+	if cfg.TLS.EnableHTTP3 && !cfg.TLS.Enabled {
+		return nil, fmt.Errorf("ENABLE_HTTP3 requires TLS_ENABLED=true")
+	}
+
 	return cfg, nil
 }
 
+// splitList splits a comma-separated value, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// parseRoleMap parses "group=role,group=role" into a map.
+func parseRoleMap(value string) (map[string]string, error) {
+	roles := make(map[string]string)
+	for _, item := range splitList(value) {
+		group, role, ok := strings.Cut(item, "=")
+		group, role = strings.TrimSpace(group), strings.TrimSpace(role)
+		if !ok || group == "" {
+			return nil, fmt.Errorf("%q is not group=role", item)
+		}
+		if !validRole(role) {
+			return nil, fmt.Errorf("group %s: invalid role %q (must be viewer, editor or owner)", group, role)
+		}
+		roles[group] = role
+	}
+	return roles, nil
+}
+
+func validRole(role string) bool {
+	return role == "viewer" || role == "editor" || role == "owner"
+}
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -79,3 +366,6 @@ func (c *Config) ServerAddress() string {
 	return fmt.Sprintf("%s:%d", c.Server.Host, c.Server.Port)
 }
 
+func (c *Config) TLSAddress() string {
+	return fmt.Sprintf("%s:%d", c.Server.Host, c.TLS.Port)
+}
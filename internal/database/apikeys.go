@@ -0,0 +1,297 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// API key scopes. Each scope includes the ones before it: write keys can
+// also read, admin keys can do anything.
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+	ScopeAdmin = "admin"
+)
+
+var scopeLevels = map[string]int{ScopeRead: 1, ScopeWrite: 2, ScopeAdmin: 3}
+
+// APIKeyPrefix starts every API key, so leaked keys are easy to recognize.
+const APIKeyPrefix = "trk_"
+
+// apiKeyTouchInterval limits how often last_used_at is written for a key.
+const apiKeyTouchInterval = time.Minute
+
+// Errors returned by AuthenticateAPIKey
+var (
+	ErrInvalidAPIKey = errors.New("invalid API key")
+	ErrAPIKeyExpired = errors.New("API key expired")
+	ErrAPIKeyRevoked = errors.New("API key revoked")
+)
+
+// APIKey is a credential for the admin API. Only a hash of the key is
+// stored; the key itself is shown once, when it is created.
+type APIKey struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Prefix is the start of the key, to tell keys apart
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+	CreatedAt  string     `json:"created_at"`
+	// Hash is the SHA-256 of the key, set when it is created; never listed
+	Hash string `json:"-"`
+}
+
+// ValidScope reports whether scope is a known API key scope.
+func ValidScope(scope string) bool {
+	return scopeLevels[scope] > 0
+}
+
+// Allows reports whether the key grants scope.
+func (k *APIKey) Allows(scope string) bool {
+	for _, s := range k.Scopes {
+		if scopeLevels[s] >= scopeLevels[scope] {
+			return true
+		}
+	}
+	return false
+}
+
+// Actor is how the key's requests are recorded in the history and audit log.
+func (k *APIKey) Actor() string {
+	return "key:" + k.Name
+}
+
+// hashAPIKey returns the stored form of a key. Keys are random 256-bit
+// secrets, so a fast unsalted hash is enough to make a leaked table useless.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// API keys
+
+func (s *sqlStore) apiKeyColumns() string {
+	return "id, name, prefix, scopes, expires_at, last_used_at, revoked_at, created_by, " + s.ts("created_at")
+}
+
+func (s *sqlStore) CreateAPIKey(ctx context.Context, k APIKey) error {
+	var expires interface{}
+	if k.ExpiresAt != nil {
+		expires = k.ExpiresAt.UTC().Truncate(time.Second)
+	}
+	_, err := s.db.ExecContext(ctx, s.rebind(
+		"INSERT INTO api_keys (id, name, prefix, key_hash, scopes, expires_at, created_by, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"),
+		k.ID, k.Name, k.Prefix, k.Hash, strings.Join(k.Scopes, ","), expires, nullIfEmpty(k.CreatedBy),
+		time.Now().UTC().Truncate(time.Second),
+	)
+	if s.isUniqueViolation(err) {
+		return fmt.Errorf("API key %s: %w", k.Name, ErrConflict)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create API key: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) GetAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	return s.getAPIKey(ctx, "id", id)
+}
+
+func (s *sqlStore) GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error) {
+	return s.getAPIKey(ctx, "key_hash", hash)
+}
+
+func (s *sqlStore) getAPIKey(ctx context.Context, column, value string) (*APIKey, error) {
+	row := s.db.QueryRowContext(ctx, s.rebind("SELECT "+s.apiKeyColumns()+" FROM api_keys WHERE "+column+" = ?"), value)
+	k, err := scanAPIKey(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return k, nil
+}
+
+func (s *sqlStore) ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+s.apiKeyColumns()+" FROM api_keys ORDER BY created_at, id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %w", err)
+		}
+		keys = append(keys, *k)
+	}
+	return keys, rows.Err()
+}
+
+func (s *sqlStore) RevokeAPIKey(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, s.rebind("UPDATE api_keys SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ?"),
+		time.Now().UTC().Truncate(time.Second), id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("API key %s: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+func (s *sqlStore) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.rebind("UPDATE api_keys SET last_used_at = ? WHERE id = ?"), at.UTC().Truncate(time.Second), id)
+	if err != nil {
+		return fmt.Errorf("failed to update API key: %w", err)
+	}
+	return nil
+}
+
+// scanAPIKey reads a row selected with apiKeyColumns.
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var k APIKey
+	var scopes string
+	var expires, lastUsed, revoked sql.NullTime
+	var createdBy, createdAt sql.NullString
+
+	if err := row.Scan(&k.ID, &k.Name, &k.Prefix, &scopes, &expires, &lastUsed, &revoked, &createdBy, &createdAt); err != nil {
+		return nil, err
+	}
+
+	k.Scopes = strings.Split(scopes, ",")
+	k.ExpiresAt = timePtr(expires)
+	k.LastUsedAt = timePtr(lastUsed)
+	k.RevokedAt = timePtr(revoked)
+	k.CreatedBy = createdBy.String
+	k.CreatedAt = createdAt.String
+
+	return &k, nil
+}
+
+func timePtr(v sql.NullTime) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+	t := v.Time.UTC()
+	return &t
+}
+
+// CreateAPIKey generates a key with the given scopes, stores its hash and
+// returns the key, which can't be retrieved again. A nil expiresAt never
+// expires.
+func (tm *TenantManager) CreateAPIKey(ctx context.Context, name string, scopes []string, expiresAt *time.Time) (string, *APIKey, error) {
+	if name == "" {
+		return "", nil, fmt.Errorf("API key name is required")
+	}
+	if len(scopes) == 0 {
+		return "", nil, fmt.Errorf("API key needs at least one scope")
+	}
+	for _, scope := range scopes {
+		if !ValidScope(scope) {
+			return "", nil, fmt.Errorf("unknown API key scope %q", scope)
+		}
+	}
+
+	id, err := randomString(9)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate API key: %w", err)
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate API key: %w", err)
+	}
+	key := APIKeyPrefix + secret
+
+	k := APIKey{
+		ID:        id,
+		Name:      name,
+		Prefix:    key[:len(APIKeyPrefix)+6],
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		CreatedBy: ActorFromContext(ctx),
+		Hash:      hashAPIKey(key),
+	}
+	if err := tm.store.CreateAPIKey(ctx, k); err != nil {
+		return "", nil, err
+	}
+
+	created, err := tm.store.GetAPIKey(ctx, id)
+	if err != nil {
+		return "", nil, err
+	}
+	return key, created, nil
+}
+
+// AuthenticateAPIKey returns the stored key matching key, or
+// ErrInvalidAPIKey, ErrAPIKeyExpired or ErrAPIKeyRevoked. The key's
+// last-used time is updated at most once a minute.
+func (tm *TenantManager) AuthenticateAPIKey(ctx context.Context, key string) (*APIKey, error) {
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	k, err := tm.store.GetAPIKeyByHash(ctx, hashAPIKey(key))
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if k.RevokedAt != nil {
+		return nil, ErrAPIKeyRevoked
+	}
+	if k.ExpiresAt != nil && !now.Before(*k.ExpiresAt) {
+		return nil, ErrAPIKeyExpired
+	}
+
+	if k.LastUsedAt == nil || now.Sub(*k.LastUsedAt) >= apiKeyTouchInterval {
+		if err := tm.store.TouchAPIKey(ctx, k.ID, now); err != nil {
+			return nil, err
+		}
+		k.LastUsedAt = &now
+	}
+
+	return k, nil
+}
+
+// ListAPIKeys returns all API keys, revoked ones included, oldest first.
+func (tm *TenantManager) ListAPIKeys() ([]APIKey, error) {
+	return tm.store.ListAPIKeys(context.Background())
+}
+
+// GetAPIKey returns one API key by its ID.
+func (tm *TenantManager) GetAPIKey(id string) (*APIKey, error) {
+	return tm.store.GetAPIKey(context.Background(), id)
+}
+
+// RevokeAPIKey disables a key for good. Revoked keys stay listed, so the
+// actors recorded in the history can still be identified.
+func (tm *TenantManager) RevokeAPIKey(ctx context.Context, id string) error {
+	return tm.store.RevokeAPIKey(ctx, id)
+}
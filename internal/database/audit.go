@@ -0,0 +1,184 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Audit results
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+)
+
+// AuditEntry records one admin API call that mutates state.
+type AuditEntry struct {
+	ID        int64  `json:"id"`
+	Actor     string `json:"actor"`
+	SourceIP  string `json:"source_ip"`
+	RequestID string `json:"request_id,omitempty"`
+	// Action is the method and route, e.g. "PUT /admin/domains/{domain}"
+	Action string `json:"action"`
+	// Target is the record acted on, e.g. "domain:a.example.com"
+	Target string `json:"target,omitempty"`
+	// Payload is the JSON request body, if any
+	Payload json.RawMessage `json:"payload,omitempty"`
+	// Changes are the history entries the call produced, with their diffs
+	Changes   json.RawMessage `json:"changes,omitempty"`
+	Result    string          `json:"result"`
+	Status    int             `json:"status"`
+	Error     string          `json:"error,omitempty"`
+	CreatedAt string          `json:"created_at"`
+}
+
+// AuditFilter selects audit entries. Zero fields don't filter.
+type AuditFilter struct {
+	Actor     string
+	SourceIP  string
+	RequestID string
+	// Action matches entries whose action contains it, e.g. "DELETE" or "/domains"
+	Action string
+	// Target matches exactly, or a prefix ending in ':' (e.g. "domain:")
+	Target string
+	Result string
+	Since  time.Time
+	Until  time.Time
+	// BeforeID/AfterID page through entries older/newer than an ID
+	BeforeID int64
+	AfterID  int64
+	Limit    int
+	// Ascending lists oldest first instead of newest first
+	Ascending bool
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a context whose writes are recorded in the history
+// with this request ID, linking them to the request's audit entry.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func (s *sqlStore) AppendAudit(ctx context.Context, e AuditEntry) error {
+	_, err := s.db.ExecContext(ctx, s.rebind(
+		"INSERT INTO audit_log (actor, source_ip, request_id, action, target, payload, changes, result, status, error, created_at) "+
+			"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+		e.Actor, e.SourceIP, nullIfEmpty(e.RequestID), e.Action, nullIfEmpty(e.Target),
+		nullIfEmpty(string(e.Payload)), nullIfEmpty(string(e.Changes)), e.Result, e.Status, nullIfEmpty(e.Error),
+		time.Now().UTC().Truncate(time.Second),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to append audit entry: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) ListAudit(ctx context.Context, f AuditFilter) ([]AuditEntry, error) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		where = append(where, cond)
+		args = append(args, arg)
+	}
+	if f.Actor != "" {
+		add("actor = ?", f.Actor)
+	}
+	if f.SourceIP != "" {
+		add("source_ip = ?", f.SourceIP)
+	}
+	if f.RequestID != "" {
+		add("request_id = ?", f.RequestID)
+	}
+	if f.Action != "" {
+		add("action LIKE ?"+likeEscape, "%"+escapeLike(f.Action)+"%")
+	}
+	if strings.HasSuffix(f.Target, ":") {
+		add("target LIKE ?"+likeEscape, escapeLike(f.Target)+"%")
+	} else if f.Target != "" {
+		add("target = ?", f.Target)
+	}
+	if f.Result != "" {
+		add("result = ?", f.Result)
+	}
+	if !f.Since.IsZero() {
+		add("created_at >= ?", f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		add("created_at < ?", f.Until.UTC())
+	}
+	if f.BeforeID != 0 {
+		add("id < ?", f.BeforeID)
+	}
+	if f.AfterID != 0 {
+		add("id > ?", f.AfterID)
+	}
+
+	query := "SELECT id, actor, source_ip, request_id, action, target, payload, changes, result, status, error, " + s.ts("created_at") + " FROM audit_log"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	if f.Ascending {
+		query += " ORDER BY id"
+	} else {
+		query += " ORDER BY id DESC"
+	}
+	if f.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		var requestID, target, payload, changes, errMsg, createdAt sql.NullString
+		if err := rows.Scan(&e.ID, &e.Actor, &e.SourceIP, &requestID, &e.Action, &target, &payload, &changes, &e.Result, &e.Status, &errMsg, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		e.RequestID = requestID.String
+		e.Target = target.String
+		if payload.Valid {
+			e.Payload = json.RawMessage(payload.String)
+		}
+		if changes.Valid {
+			e.Changes = json.RawMessage(changes.String)
+		}
+		e.Error = errMsg.String
+		e.CreatedAt = createdAt.String
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// escapeLike escapes the LIKE wildcards in s, for use with likeEscape.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// likeEscape is the ESCAPE clause matching escapeLike (same in SQLite and
+// PostgreSQL).
+const likeEscape = ` ESCAPE '\'`
+
+// AppendAudit adds an entry to the audit log.
+func (tm *TenantManager) AppendAudit(ctx context.Context, e AuditEntry) error {
+	return tm.store.AppendAudit(ctx, e)
+}
+
+// ListAudit returns audit entries matching f.
+func (tm *TenantManager) ListAudit(f AuditFilter) ([]AuditEntry, error) {
+	return tm.store.ListAudit(context.Background(), f)
+}
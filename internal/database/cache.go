@@ -0,0 +1,244 @@
+package database
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrUnknownHost is returned for hosts no domain routes, including those
+// of archived tenants. These answers are cached for CachePolicy.NegativeTTL.
+var ErrUnknownHost = errors.New("tenant not found for domain")
+
+// CachePolicy sets how long resolved hosts are cached and how many.
+type CachePolicy struct {
+	// TTL is how long a resolved host is used. A host requested in the
+	// last quarter of its TTL is resolved again in the background, so
+	// busy hosts never wait for the store.
+	TTL time.Duration
+	// NegativeTTL is how long an unknown host is answered from the cache;
+	// 0 doesn't cache unknown hosts
+	NegativeTTL time.Duration
+	// MaxEntries bounds the cache; the least recently used hosts are
+	// evicted first
+	MaxEntries int
+}
+
+// DefaultCachePolicy is used until SetCachePolicy is called.
+var DefaultCachePolicy = CachePolicy{
+	TTL:         5 * time.Minute,
+	NegativeTTL: 10 * time.Second,
+	MaxEntries:  10000,
+}
+
+// CacheStats counts what the cache of resolved hosts did since the manager
+// was created.
+type CacheStats struct {
+	Enabled      bool   `json:"enabled"`
+	Entries      int    `json:"entries"`
+	MaxEntries   int    `json:"max_entries"`
+	Hits         uint64 `json:"hits"`
+	NegativeHits uint64 `json:"negative_hits"` // Unknown hosts answered from the cache
+	Misses       uint64 `json:"misses"`
+	Evictions    uint64 `json:"evictions"`
+	Refreshes    uint64 `json:"refreshes"` // Background refreshes started
+}
+
+// hostCache is an LRU cache of resolved hosts, known or unknown.
+type hostCache struct {
+	mu      sync.Mutex
+	policy  CachePolicy
+	entries map[string]*list.Element // Of *cacheEntry
+	lru     *list.List               // Most recently used first
+	// gen changes whenever entries are invalidated, so resolutions that
+	// started before aren't cached
+	gen uint64
+
+	hits, negativeHits, misses, evictions, refreshes atomic.Uint64
+}
+
+type cacheEntry struct {
+	host       string
+	info       *TenantInfo // nil for unknown hosts
+	err        error
+	refreshAt  time.Time
+	expires    time.Time
+	refreshing bool
+}
+
+func newHostCache(p CachePolicy) *hostCache {
+	return &hostCache{
+		policy:  p,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// get returns the cached answer for host, and whether the caller should
+// refresh it in the background.
+func (c *hostCache) get(host string, now time.Time) (e cacheEntry, found, refresh bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[host]
+	if !ok {
+		c.misses.Add(1)
+		return e, false, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if !now.Before(entry.expires) {
+		c.remove(el)
+		c.misses.Add(1)
+		return e, false, false
+	}
+
+	c.lru.MoveToFront(el)
+	if entry.info == nil {
+		c.negativeHits.Add(1)
+		return *entry, true, false
+	}
+	c.hits.Add(1)
+	if !entry.refreshing && !now.Before(entry.refreshAt) {
+		entry.refreshing = true
+		c.refreshes.Add(1)
+		refresh = true
+	}
+	return *entry, true, refresh
+}
+
+// peek returns the cached answer for host without counting or reordering.
+func (c *hostCache) peek(host string, now time.Time) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[host]
+	if !ok || !now.Before(el.Value.(*cacheEntry).expires) {
+		return cacheEntry{}, false
+	}
+	return *el.Value.(*cacheEntry), true
+}
+
+// generation returns the current generation, to pass to put.
+func (c *hostCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// put caches how host resolved, unless entries were invalidated since gen
+// was read. Errors other than ErrUnknownHost aren't cached; a failed
+// refresh keeps the previous answer until it expires.
+func (c *hostCache) put(host string, gen uint64, info *TenantInfo, err error, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, exists := c.entries[host]
+	if exists {
+		el.Value.(*cacheEntry).refreshing = false
+	}
+	if gen != c.gen {
+		return
+	}
+
+	entry := &cacheEntry{host: host, info: info, err: err}
+	switch {
+	case err == nil:
+		entry.expires = now.Add(c.policy.TTL)
+		entry.refreshAt = now.Add(c.policy.TTL * 3 / 4)
+	case errors.Is(err, ErrUnknownHost) && c.policy.NegativeTTL > 0:
+		entry.expires = now.Add(c.policy.NegativeTTL)
+	default:
+		return
+	}
+
+	if exists {
+		el.Value = entry
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[host] = c.lru.PushFront(entry)
+	c.evict()
+}
+
+// evict removes the least recently used entries beyond MaxEntries.
+func (c *hostCache) evict() {
+	for c.lru.Len() > c.policy.MaxEntries {
+		c.remove(c.lru.Back())
+		c.evictions.Add(1)
+	}
+}
+
+func (c *hostCache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).host)
+}
+
+// delete drops host's answer.
+func (c *hostCache) delete(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	if el, ok := c.entries[host]; ok {
+		c.remove(el)
+	}
+}
+
+// clear drops every answer.
+func (c *hostCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gen++
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+func (c *hostCache) setPolicy(p CachePolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.policy = p
+	c.evict()
+}
+
+func (c *hostCache) stats() CacheStats {
+	c.mu.Lock()
+	entries, maxEntries := c.lru.Len(), c.policy.MaxEntries
+	c.mu.Unlock()
+
+	return CacheStats{
+		Entries:      entries,
+		MaxEntries:   maxEntries,
+		Hits:         c.hits.Load(),
+		NegativeHits: c.negativeHits.Load(),
+		Misses:       c.misses.Load(),
+		Evictions:    c.evictions.Load(),
+		Refreshes:    c.refreshes.Load(),
+	}
+}
+
+// SetCachePolicy replaces DefaultCachePolicy. Entries already cached keep
+// their expiry.
+func (tm *TenantManager) SetCachePolicy(p CachePolicy) {
+	tm.cache.setPolicy(p)
+}
+
+// CacheStats returns the cache's counters.
+func (tm *TenantManager) CacheStats() CacheStats {
+	stats := tm.cache.stats()
+	stats.Enabled = tm.cacheEnabled
+	return stats
+}
+
+// refreshHost resolves a cached host again, replacing its entry.
+func (tm *TenantManager) refreshHost(host string) {
+	gen := tm.cache.generation()
+	result, err, _ := tm.sf.Do(host, func() (interface{}, error) {
+		return tm.resolveTenantInfo(host)
+	})
+	info, _ := result.(*TenantInfo)
+	tm.cache.put(host, gen, info, err, time.Now())
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"strings"
+	"time"
+)
+
+// Resolution explains how a host resolves to a tenant, for debugging
+// routing.
+type Resolution struct {
+	Host string `json:"host"`
+	// Cached is true if the proxy currently answers the host from the
+	// cache, which may remember it as unknown
+	Cached bool `json:"cached"`
+	// Exact is the domain row named exactly like the host, even if it
+	// wasn't used because it's pending verification
+	Exact *Domain `json:"exact,omitempty"`
+	// Candidates are the wildcard patterns considered, most specific first
+	Candidates []WildcardCandidate `json:"candidates"`
+	Matched    *Domain             `json:"matched,omitempty"`
+	// Captures are what the matched pattern's {name} captures matched
+	Captures map[string]string `json:"captures,omitempty"`
+	Reason   string            `json:"reason,omitempty"`
+	Error    string            `json:"error,omitempty"`
+	// Info is what the proxy routes with: the cached entry if there is one
+	// (nil for a cached unknown host), otherwise what the store resolves to
+	// now
+	Info *TenantInfo `json:"-"`
+}
+
+// WildcardCandidate is a wildcard pattern considered for a host.
+type WildcardCandidate struct {
+	Pattern  string `json:"pattern"`
+	TenantID string `json:"tenant_id"`
+	Matches  bool   `json:"matches"`
+	Selected bool   `json:"selected"`
+}
+
+// ExplainHost resolves host like GetTenantInfo, but bypassing the cache of
+// resolved hosts and without caching the result, and reports how the match
+// was found. With caching enabled the host is resolved from the routing
+// table, otherwise from the store.
+func (tm *TenantManager) ExplainHost(host string) *Resolution {
+	host = strings.ToLower(strings.Split(host, ":")[0])
+	res := &Resolution{Host: host, Candidates: []WildcardCandidate{}}
+
+	var cached cacheEntry
+	if tm.cacheEnabled {
+		cached, res.Cached = tm.cache.peek(host, time.Now())
+	}
+
+	info, err := tm.explainTenantInfo(host, res)
+	if err != nil {
+		res.Error = err.Error()
+	}
+	switch {
+	case !res.Cached:
+		res.Info = info
+	case cached.info != nil:
+		res.Info = cached.info
+	default:
+		// Unknown to the proxy until the cached answer expires
+		res.Error = cached.err.Error() + " (cached)"
+	}
+	return res
+}
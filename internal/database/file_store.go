@@ -0,0 +1,720 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tenantical/router/internal/proxyproto"
+	"gopkg.in/yaml.v3"
+)
+
+// Tenants file modes (TENANTS_FILE_MODE): how the tenants and domains
+// defined in the file combine with those in the database.
+const (
+	// FileAuthoritative routes only the file's tenants and domains, and the
+	// API can't change any
+	FileAuthoritative = "authoritative"
+	// FileOverride routes the database's too; where both define a tenant
+	// or domain, the file's is used
+	FileOverride = "override"
+	// FileFallback routes the database's too; where both define a tenant
+	// or domain, the database's is used
+	FileFallback = "fallback"
+)
+
+// SourceFile is the Source of tenants and domains defined in the tenants
+// file.
+const SourceFile = "file"
+
+// ErrReadOnly is returned for changes to tenants and domains defined in the
+// tenants file.
+var ErrReadOnly = errors.New("managed in the tenants file, read-only through the API")
+
+// errAllReadOnly is returned for every change while the file is
+// authoritative.
+var errAllReadOnly = fmt.Errorf("tenants and domains are %w", ErrReadOnly)
+
+// ValidFileMode reports whether m is one of the tenants file modes.
+func ValidFileMode(m string) bool {
+	switch m {
+	case FileAuthoritative, FileOverride, FileFallback:
+		return true
+	}
+	return false
+}
+
+// tenantsFile is the schema of the tenants file, YAML or (with a .json
+// extension) JSON. Settings have the names they have in the API, and a
+// tenant's domains are listed under it:
+//
+//	tenants:
+//	  - id: acme
+//	    name: Acme Inc.
+//	    project_route: /projects/acme
+//	    domains:
+//	      - domain: shop.acme.com
+//	      - domain: "{slug}.apps.acme.com"
+//	        backend_domain: "{slug}.internal"
+//
+// Unknown settings are rejected, so typos don't go unnoticed.
+type tenantsFile struct {
+	Tenants []fileTenant `json:"tenants" yaml:"tenants"`
+}
+
+type fileTenant struct {
+	ID                   string            `json:"id" yaml:"id"`
+	Name                 string            `json:"name" yaml:"name"`
+	Status               string            `json:"status" yaml:"status"`
+	ProjectRoute         string            `json:"project_route" yaml:"project_route"`
+	ProjectPort          *int              `json:"project_port" yaml:"project_port"`
+	BackendDomain        *string           `json:"backend_domain" yaml:"backend_domain"`
+	UpstreamProtocol     string            `json:"upstream_protocol" yaml:"upstream_protocol"`
+	ProxyProtocol        string            `json:"proxy_protocol" yaml:"proxy_protocol"`
+	Metadata             map[string]string `json:"metadata" yaml:"metadata"`
+	MaintenanceAllowlist []string          `json:"maintenance_allowlist" yaml:"maintenance_allowlist"`
+	MaxDomains           *int              `json:"max_domains" yaml:"max_domains"`
+	Domains              []fileDomain      `json:"domains" yaml:"domains"`
+}
+
+type fileDomain struct {
+	Domain           string            `json:"domain" yaml:"domain"`
+	ProjectRoute     string            `json:"project_route" yaml:"project_route"`
+	ProjectPort      *int              `json:"project_port" yaml:"project_port"`
+	BackendDomain    *string           `json:"backend_domain" yaml:"backend_domain"`
+	UpstreamProtocol string            `json:"upstream_protocol" yaml:"upstream_protocol"`
+	ProxyProtocol    string            `json:"proxy_protocol" yaml:"proxy_protocol"`
+	RequestHeaders   map[string]string `json:"request_headers" yaml:"request_headers"`
+}
+
+// fileContents is a loaded tenants file. It isn't modified once loaded; a
+// reload replaces it.
+type fileContents struct {
+	tenants map[string]*Tenant
+	domains map[string]*Domain
+	sum     [sha256.Size]byte
+}
+
+// FileStore is a TenantStore that adds the tenants and domains defined in
+// a YAML or JSON file to those of a database store, which keeps everything
+// else: API keys, operators, the audit log and history. The file is checked
+// for changes every poll interval and reloaded; a file that fails to load
+// is logged and the previous contents are kept.
+//
+// Tenants and domains the file defines are read-only: changing them returns
+// ErrReadOnly, as does every tenant and domain change while the file is
+// authoritative. Domains in the file belong to the tenant they're listed
+// under, and domains in the database to tenants in the database.
+type FileStore struct {
+	TenantStore // The database
+
+	path         string
+	mode         string
+	pollInterval time.Duration
+	stop         context.CancelFunc
+
+	mu       sync.RWMutex
+	file     *fileContents
+	stamp    os.FileInfo // Of the last load attempt, to skip unchanged files
+	lastErr  string      // Of the last load attempt, logged once
+	watchers watchers
+}
+
+// NewFileStore loads the tenants file at path on top of store, combining
+// them as mode says, and watches the file for changes until closed.
+// Closing the FileStore closes store.
+func NewFileStore(store TenantStore, path, mode string) (*FileStore, error) {
+	if !ValidFileMode(mode) {
+		return nil, fmt.Errorf("invalid tenants file mode %q (must be authoritative, override or fallback)", mode)
+	}
+
+	s := &FileStore{
+		TenantStore:  store,
+		path:         path,
+		mode:         mode,
+		pollInterval: DefaultPollInterval,
+	}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stop = cancel
+	go s.poll(ctx)
+	return s, nil
+}
+
+// SetPollInterval sets how often the file is checked for changes.
+func (s *FileStore) SetPollInterval(d time.Duration) {
+	s.mu.Lock()
+	s.pollInterval = d
+	s.mu.Unlock()
+}
+
+// Mode returns how the file combines with the database.
+func (s *FileStore) Mode() string {
+	return s.mode
+}
+
+func (s *FileStore) poll(ctx context.Context) {
+	for {
+		s.mu.RLock()
+		interval := s.pollInterval
+		s.mu.RUnlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		if err := s.reload(); err != nil {
+			s.mu.Lock()
+			logged := s.lastErr == err.Error()
+			s.lastErr = err.Error()
+			s.mu.Unlock()
+			if !logged {
+				log.Printf("[TENANTS] WARNING: keeping the previous tenants: %v", err)
+			}
+		}
+	}
+}
+
+// reload loads the file if it changed since the last attempt, and reports
+// the change to watchers as a reset.
+func (s *FileStore) reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read tenants file: %w", err)
+	}
+	s.mu.RLock()
+	unchanged := s.stamp != nil && info.ModTime().Equal(s.stamp.ModTime()) && info.Size() == s.stamp.Size()
+	s.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read tenants file: %w", err)
+	}
+	s.mu.Lock()
+	s.stamp = info
+	same := s.file != nil && s.file.sum == sha256.Sum256(data)
+	s.mu.Unlock()
+	if same {
+		return nil
+	}
+
+	file, err := parseTenantsFile(data, filepath.Ext(s.path), info.ModTime())
+	if err != nil {
+		return fmt.Errorf("invalid tenants file %s: %w", s.path, err)
+	}
+
+	s.mu.Lock()
+	first := s.file == nil
+	s.file = file
+	s.lastErr = ""
+	s.watchers.notify(ChangeEvent{})
+	s.mu.Unlock()
+
+	verb := "Reloaded"
+	if first {
+		verb = "Loaded"
+	}
+	log.Printf("[TENANTS] %s %d tenants and %d domains from %s (%s)", verb, len(file.tenants), len(file.domains), s.path, s.mode)
+	return nil
+}
+
+// parseTenantsFile decodes and validates a tenants file. modTime becomes
+// the creation and update time of its entries.
+func parseTenantsFile(data []byte, ext string, modTime time.Time) (*fileContents, error) {
+	var f tenantsFile
+	if strings.EqualFold(ext, ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&f); err != nil {
+			return nil, err
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&f); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+	}
+
+	file := &fileContents{
+		tenants: make(map[string]*Tenant),
+		domains: make(map[string]*Domain),
+		sum:     sha256.Sum256(data),
+	}
+	stamp := modTime.UTC().Truncate(time.Second).Format(time.RFC3339)
+	for i, ft := range f.Tenants {
+		t, err := ft.tenant()
+		if err != nil {
+			return nil, fmt.Errorf("tenants[%d]: %w", i, err)
+		}
+		if _, ok := file.tenants[t.ID]; ok {
+			return nil, fmt.Errorf("tenants[%d]: tenant %s is defined twice", i, t.ID)
+		}
+		t.CreatedAt, t.UpdatedAt = stamp, stamp
+		file.tenants[t.ID] = t
+
+		for j, fd := range ft.Domains {
+			d, err := fd.domain(t.ID)
+			if err != nil {
+				return nil, fmt.Errorf("tenants[%d].domains[%d]: %w", i, j, err)
+			}
+			if other, ok := file.domains[d.Name]; ok {
+				return nil, fmt.Errorf("tenants[%d].domains[%d]: domain %s is already listed under tenant %s", i, j, d.Name, other.TenantID)
+			}
+			d.CreatedAt = stamp
+			file.domains[d.Name] = d
+		}
+	}
+	return file, nil
+}
+
+// tenant validates the tenant and fills in the defaults the database uses.
+func (ft *fileTenant) tenant() (*Tenant, error) {
+	if ft.ID == "" {
+		return nil, errors.New("id is required")
+	}
+	if !ValidTenantStatus(ft.Status) {
+		return nil, fmt.Errorf("tenant %s: status must be one of: active, suspended, maintenance, archived", ft.ID)
+	}
+	if err := validateRouting(ft.ProjectPort, ft.UpstreamProtocol, ft.ProxyProtocol); err != nil {
+		return nil, fmt.Errorf("tenant %s: %w", ft.ID, err)
+	}
+	if _, err := ParseIPList(ft.MaintenanceAllowlist); err != nil {
+		return nil, fmt.Errorf("tenant %s: maintenance_allowlist: %w", ft.ID, err)
+	}
+	if ft.MaxDomains != nil && *ft.MaxDomains < 0 {
+		return nil, fmt.Errorf("tenant %s: max_domains must be 0 or more", ft.ID)
+	}
+
+	t := &Tenant{
+		ID:                   ft.ID,
+		Name:                 ft.Name,
+		Status:               ft.Status,
+		ProjectRoute:         ft.ProjectRoute,
+		ProjectPort:          ft.ProjectPort,
+		BackendDomain:        ft.BackendDomain,
+		UpstreamProtocol:     ft.UpstreamProtocol,
+		ProxyProtocol:        ft.ProxyProtocol,
+		Metadata:             ft.Metadata,
+		MaintenanceAllowlist: ft.MaintenanceAllowlist,
+		MaxDomains:           ft.MaxDomains,
+		Source:               SourceFile,
+		Revision:             1,
+	}
+	if t.Name == "" {
+		t.Name = t.ID
+	}
+	if t.Status == "" {
+		t.Status = TenantActive
+	}
+	if t.ProjectRoute == "" {
+		t.ProjectRoute = "/projects/backend"
+	}
+	return t, nil
+}
+
+// domain validates the domain as the admin API does.
+func (fd *fileDomain) domain(tenantID string) (*Domain, error) {
+	name := strings.TrimSuffix(normalizeDomain(fd.Domain), ".")
+	if name == "" {
+		return nil, errors.New("domain is required")
+	}
+	if err := ValidateDomainPattern(name); err != nil {
+		return nil, err
+	}
+	if err := validateRouting(StripNone(fd.ProjectPort, fd.UpstreamProtocol, fd.ProxyProtocol)); err != nil {
+		return nil, fmt.Errorf("domain %s: %w", name, err)
+	}
+
+	// Templates may only use what the domain's pattern captures
+	templates := map[string]string{"project_route": fd.ProjectRoute}
+	if fd.BackendDomain != nil {
+		templates["backend_domain"] = *fd.BackendDomain
+	}
+	for header, value := range fd.RequestHeaders {
+		if err := ValidateRequestHeader(header, value); err != nil {
+			return nil, fmt.Errorf("domain %s: request_headers: %w", name, err)
+		}
+		templates["request_headers."+header] = value
+	}
+	fields := make([]string, 0, len(templates))
+	for field := range templates {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		if err := ValidateTemplate(name, templates[field]); err != nil {
+			return nil, fmt.Errorf("domain %s: %s: %w", name, field, err)
+		}
+	}
+
+	return &Domain{
+		Name:             name,
+		TenantID:         tenantID,
+		ProjectRoute:     fd.ProjectRoute,
+		ProjectPort:      fd.ProjectPort,
+		BackendDomain:    fd.BackendDomain,
+		UpstreamProtocol: fd.UpstreamProtocol,
+		ProxyProtocol:    fd.ProxyProtocol,
+		RequestHeaders:   fd.RequestHeaders,
+		Status:           DomainActive,
+		Source:           SourceFile,
+		Revision:         1,
+	}, nil
+}
+
+func validateRouting(port *int, upstream, proxyProtocol string) error {
+	if port != nil && (*port <= 0 || *port > 65535) {
+		return errors.New("project_port must be between 1 and 65535")
+	}
+	if !ValidUpstreamProtocol(upstream) {
+		return errors.New("upstream_protocol must be one of: http1, h2, h2c")
+	}
+	if !proxyproto.ValidVersion(proxyProtocol) {
+		return errors.New("proxy_protocol must be one of: v1, v2")
+	}
+	if proxyProtocol != "" && upstream != "" && upstream != UpstreamHTTP1 {
+		return errors.New("proxy_protocol is only supported with upstream_protocol http1")
+	}
+	return nil
+}
+
+func (s *FileStore) contents() *fileContents {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.file
+}
+
+// Reads combine the file and the database as the mode says.
+
+func (s *FileStore) GetTenant(ctx context.Context, id string) (*Tenant, error) {
+	t, ok := s.contents().tenants[id]
+	return pick(s, ok, t, func() (*Tenant, error) { return s.TenantStore.GetTenant(ctx, id) })
+}
+
+func (s *FileStore) ListTenants(ctx context.Context) ([]Tenant, error) {
+	return merge(s, s.contents().tenants, func() ([]Tenant, error) { return s.TenantStore.ListTenants(ctx) },
+		func(t Tenant) string { return t.ID })
+}
+
+func (s *FileStore) GetDomain(ctx context.Context, domain string) (*Domain, error) {
+	d, ok := s.contents().domains[domain]
+	return pick(s, ok, d, func() (*Domain, error) { return s.TenantStore.GetDomain(ctx, domain) })
+}
+
+// ListDomains combines all domains before picking the tenant's, as the
+// same domain can belong to different tenants in the file and database.
+func (s *FileStore) ListDomains(ctx context.Context, tenantID string) ([]Domain, error) {
+	all, err := merge(s, s.contents().domains, func() ([]Domain, error) { return s.TenantStore.ListDomains(ctx, "") },
+		func(d Domain) string { return d.Name })
+	if err != nil || tenantID == "" {
+		return all, err
+	}
+	var domains []Domain
+	for _, d := range all {
+		if d.TenantID == tenantID {
+			domains = append(domains, d)
+		}
+	}
+	return domains, nil
+}
+
+func (s *FileStore) ListWildcardDomains(ctx context.Context) ([]Domain, error) {
+	wildcards := make(map[string]*Domain)
+	for name, d := range s.contents().domains {
+		if isWildcard(name) {
+			wildcards[name] = d
+		}
+	}
+	return merge(s, wildcards, func() ([]Domain, error) { return s.TenantStore.ListWildcardDomains(ctx) },
+		func(d Domain) string { return d.Name })
+}
+
+// pick returns the file's entry or the database's, whichever the mode
+// prefers among those that exist.
+func pick[T any](s *FileStore, inFile bool, file *T, database func() (*T, error)) (*T, error) {
+	switch {
+	case s.mode == FileAuthoritative && !inFile:
+		return nil, ErrNotFound
+	case inFile && s.mode != FileFallback:
+		entry := *file
+		return &entry, nil
+	}
+	entry, err := database()
+	if errors.Is(err, ErrNotFound) && inFile {
+		copied := *file
+		return &copied, nil
+	}
+	return entry, err
+}
+
+// merge lists the file's entries and the database's, keeping the one the
+// mode prefers where both have the same key, ordered by key.
+func merge[T any](s *FileStore, file map[string]*T, database func() ([]T, error), key func(T) string) ([]T, error) {
+	byKey := make(map[string]T, len(file))
+	if s.mode != FileAuthoritative {
+		entries, err := database()
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range entries {
+			byKey[key(v)] = v
+		}
+	}
+	for k, v := range file {
+		if _, ok := byKey[k]; !ok || s.mode != FileFallback {
+			byKey[k] = *v
+		}
+	}
+
+	keys := make([]string, 0, len(byKey))
+	for k := range byKey {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	list := make([]T, 0, len(keys))
+	for _, k := range keys {
+		list = append(list, byKey[k])
+	}
+	return list, nil
+}
+
+// Changes are refused for what the file defines.
+
+// writableTenant returns an error wrapping ErrReadOnly if the tenant can't
+// be changed: while the file is authoritative, or when the tenant used is
+// the file's.
+func (s *FileStore) writableTenant(ctx context.Context, id string) error {
+	if s.mode == FileAuthoritative {
+		return errAllReadOnly
+	}
+	if _, ok := s.contents().tenants[id]; !ok {
+		return nil
+	}
+	if s.mode == FileFallback {
+		_, err := s.TenantStore.GetTenant(ctx, id)
+		if err == nil || !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return fmt.Errorf("tenant %s is %w", id, ErrReadOnly)
+}
+
+// writableDomain is writableTenant for domains.
+func (s *FileStore) writableDomain(ctx context.Context, name string) error {
+	if s.mode == FileAuthoritative {
+		return errAllReadOnly
+	}
+	if _, ok := s.contents().domains[name]; !ok {
+		return nil
+	}
+	if s.mode == FileFallback {
+		_, err := s.TenantStore.GetDomain(ctx, name)
+		if err == nil || !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return fmt.Errorf("domain %s is %w", name, ErrReadOnly)
+}
+
+// AddTenant returns ErrConflict for tenants the file defines.
+func (s *FileStore) AddTenant(ctx context.Context, t Tenant) error {
+	if s.mode == FileAuthoritative {
+		return errAllReadOnly
+	}
+	if _, ok := s.contents().tenants[t.ID]; ok {
+		return ErrConflict
+	}
+	return s.TenantStore.AddTenant(ctx, t)
+}
+
+func (s *FileStore) UpdateTenant(ctx context.Context, t Tenant) error {
+	if err := s.writableTenant(ctx, t.ID); err != nil {
+		return err
+	}
+	return s.TenantStore.UpdateTenant(ctx, t)
+}
+
+func (s *FileStore) DeleteTenant(ctx context.Context, id string, revision int64) error {
+	if err := s.writableTenant(ctx, id); err != nil {
+		return err
+	}
+	return s.TenantStore.DeleteTenant(ctx, id, revision)
+}
+
+// addableDomain checks that d can be added to the database: the file
+// doesn't define it (ErrConflict), and its tenant is in the database.
+func (s *FileStore) addableDomain(ctx context.Context, d Domain) error {
+	if s.mode == FileAuthoritative {
+		return errAllReadOnly
+	}
+	if _, ok := s.contents().domains[d.Name]; ok {
+		return ErrConflict
+	}
+	return s.writableTenant(ctx, d.TenantID)
+}
+
+func (s *FileStore) AddDomain(ctx context.Context, d Domain) error {
+	if err := s.addableDomain(ctx, d); err != nil {
+		return err
+	}
+	return s.TenantStore.AddDomain(ctx, d)
+}
+
+func (s *FileStore) AddDomainWithinQuota(ctx context.Context, d Domain, v *DomainVerification, defaultQuota int) error {
+	if err := s.addableDomain(ctx, d); err != nil {
+		return err
+	}
+	return s.TenantStore.AddDomainWithinQuota(ctx, d, v, defaultQuota)
+}
+
+func (s *FileStore) UpdateDomain(ctx context.Context, d Domain) error {
+	if err := s.writableDomain(ctx, d.Name); err != nil {
+		return err
+	}
+	if err := s.writableTenant(ctx, d.TenantID); err != nil {
+		return err
+	}
+	return s.TenantStore.UpdateDomain(ctx, d)
+}
+
+func (s *FileStore) DeleteDomain(ctx context.Context, domain string, revision int64) error {
+	if err := s.writableDomain(ctx, domain); err != nil {
+		return err
+	}
+	return s.TenantStore.DeleteDomain(ctx, domain, revision)
+}
+
+// ApplyRollback refuses rollbacks that write what the file defines.
+func (s *FileStore) ApplyRollback(ctx context.Context, w RollbackWrites) error {
+	if s.mode == FileAuthoritative {
+		return errAllReadOnly
+	}
+	for _, t := range w.Tenants {
+		if t.Revision != 0 {
+			if err := s.writableTenant(ctx, t.ID); err != nil {
+				return err
+			}
+		} else if _, ok := s.contents().tenants[t.ID]; ok {
+			return ErrConflict
+		}
+	}
+	for _, d := range w.Domains {
+		if d.Revision == 0 {
+			if err := s.addableDomain(ctx, d); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.writableDomain(ctx, d.Name); err != nil {
+			return err
+		}
+		if err := s.writableTenant(ctx, d.TenantID); err != nil {
+			return err
+		}
+	}
+	for _, d := range w.Deleted {
+		if err := s.writableDomain(ctx, d.Name); err != nil {
+			return err
+		}
+	}
+	return s.TenantStore.ApplyRollback(ctx, w)
+}
+
+func (s *FileStore) AddMaintenanceWindow(ctx context.Context, w MaintenanceWindow) (*MaintenanceWindow, error) {
+	if err := s.writableTenant(ctx, w.TenantID); err != nil {
+		return nil, err
+	}
+	return s.TenantStore.AddMaintenanceWindow(ctx, w)
+}
+
+func (s *FileStore) DeleteMaintenanceWindow(ctx context.Context, tenantID string, id int64) error {
+	if err := s.writableTenant(ctx, tenantID); err != nil {
+		return err
+	}
+	return s.TenantStore.DeleteMaintenanceWindow(ctx, tenantID, id)
+}
+
+// CreateTenantToken refuses tokens for the file's tenants, which only
+// exist in the database.
+func (s *FileStore) CreateTenantToken(ctx context.Context, t TenantToken) error {
+	if err := s.writableTenant(ctx, t.TenantID); err != nil {
+		return err
+	}
+	return s.TenantStore.CreateTenantToken(ctx, t)
+}
+
+// Watch reports the database's changes, and a reset whenever the file is
+// reloaded.
+func (s *FileStore) Watch(ctx context.Context) (<-chan ChangeEvent, error) {
+	events, err := s.TenantStore.Watch(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ch := make(chan ChangeEvent, 64)
+
+	s.mu.Lock()
+	s.watchers.add(ch)
+	s.mu.Unlock()
+
+	go func() {
+		// The database's channel is closed once ctx ends
+		for ev := range events {
+			s.mu.Lock()
+			deliver(ch, ev)
+			s.mu.Unlock()
+		}
+		s.mu.Lock()
+		s.watchers.remove(ch)
+		s.mu.Unlock()
+		close(ch)
+	}()
+
+	return ch, nil
+}
+
+// Close stops watching the file and closes the database.
+func (s *FileStore) Close() error {
+	s.stop()
+	return s.TenantStore.Close()
+}
+
+// TenantWritable returns an error wrapping ErrReadOnly if the tenant can't
+// be changed through the API, as it's defined in the tenants file. With an
+// empty id it checks whether any tenant or domain can be.
+func (tm *TenantManager) TenantWritable(id string) error {
+	fs, ok := tm.store.(*FileStore)
+	if !ok {
+		return nil
+	}
+	return fs.writableTenant(context.Background(), id)
+}
+
+// DomainWritable is TenantWritable for domains.
+func (tm *TenantManager) DomainWritable(name string) error {
+	fs, ok := tm.store.(*FileStore)
+	if !ok {
+		return nil
+	}
+	return fs.writableDomain(context.Background(), normalizeDomain(name))
+}
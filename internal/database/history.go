@@ -0,0 +1,276 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Change actions
+const (
+	ChangeCreate = "create"
+	ChangeUpdate = "update"
+	ChangeDelete = "delete"
+)
+
+// Kinds of records a change applies to
+const (
+	KindTenant = "tenant"
+	KindDomain = "domain"
+)
+
+// Change is one entry of the history: a tenant or domain record as it was
+// before and after a create, update or delete. Before is null for creates
+// and After is null for deletes.
+type Change struct {
+	ID       int64  `json:"id"`
+	TenantID string `json:"tenant_id"`
+	// PreviousTenantID is set when a domain was moved to TenantID
+	PreviousTenantID string `json:"previous_tenant_id,omitempty"`
+	Kind             string `json:"kind"`
+	Target           string `json:"target"`
+	Action           string `json:"action"`
+	// Revision is the record's revision after the change, or the deleted one
+	Revision  int64           `json:"revision"`
+	Actor     string          `json:"actor,omitempty"`
+	RequestID string          `json:"request_id,omitempty"`
+	Note      string          `json:"note,omitempty"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	CreatedAt string          `json:"created_at"`
+}
+
+// ChangeFilter selects history entries. Zero fields don't filter.
+type ChangeFilter struct {
+	// TenantID matches changes to the tenant and to domains it owned before
+	// or after the change
+	TenantID  string
+	Kind      string
+	Target    string
+	RequestID string
+	// BeforeID only returns changes older than this ID, for pagination
+	BeforeID int64
+	Limit    int
+}
+
+type actorKey struct{}
+type changeNoteKey struct{}
+
+// WithActor returns a context whose writes are recorded in the history as
+// made by actor.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor set with WithActor, or "".
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+func withChangeNote(ctx context.Context, note string) context.Context {
+	return context.WithValue(ctx, changeNoteKey{}, note)
+}
+
+// FieldDiff is the old and new value of one changed field.
+type FieldDiff struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// diffIgnored are bookkeeping fields left out of diffs.
+var diffIgnored = map[string]bool{"revision": true, "created_at": true, "updated_at": true}
+
+// Diff compares two JSON objects field by field (either may be null) and
+// returns the fields that differ.
+func Diff(before, after json.RawMessage) (map[string]FieldDiff, error) {
+	var from, to map[string]interface{}
+	if len(before) > 0 {
+		if err := json.Unmarshal(before, &from); err != nil {
+			return nil, fmt.Errorf("invalid before state: %w", err)
+		}
+	}
+	if len(after) > 0 {
+		if err := json.Unmarshal(after, &to); err != nil {
+			return nil, fmt.Errorf("invalid after state: %w", err)
+		}
+	}
+
+	diff := make(map[string]FieldDiff)
+	for key, old := range from {
+		if !diffIgnored[key] && !reflect.DeepEqual(old, to[key]) {
+			diff[key] = FieldDiff{From: old, To: to[key]}
+		}
+	}
+	for key, value := range to {
+		if _, ok := from[key]; !ok && !diffIgnored[key] {
+			diff[key] = FieldDiff{To: value}
+		}
+	}
+	return diff, nil
+}
+
+// Touches reports whether the change concerns tenantID.
+func (c *Change) Touches(tenantID string) bool {
+	return c.TenantID == tenantID || c.PreviousTenantID == tenantID
+}
+
+func (s *sqlStore) recordTenantChange(ctx context.Context, tx *sql.Tx, before, after *Tenant) error {
+	c := Change{Kind: KindTenant}
+	switch {
+	case before == nil:
+		c.Action, c.TenantID, c.Target, c.Revision = ChangeCreate, after.ID, after.ID, after.Revision
+	case after == nil:
+		c.Action, c.TenantID, c.Target, c.Revision = ChangeDelete, before.ID, before.ID, before.Revision
+	default:
+		c.Action, c.TenantID, c.Target, c.Revision = ChangeUpdate, after.ID, after.ID, after.Revision
+	}
+	return s.recordChange(ctx, tx, c, before, after)
+}
+
+func (s *sqlStore) recordDomainChange(ctx context.Context, tx *sql.Tx, before, after *Domain) error {
+	c := Change{Kind: KindDomain}
+	switch {
+	case before == nil:
+		c.Action, c.TenantID, c.Target, c.Revision = ChangeCreate, after.TenantID, after.Name, after.Revision
+	case after == nil:
+		c.Action, c.TenantID, c.Target, c.Revision = ChangeDelete, before.TenantID, before.Name, before.Revision
+	default:
+		c.Action, c.TenantID, c.Target, c.Revision = ChangeUpdate, after.TenantID, after.Name, after.Revision
+		if before.TenantID != after.TenantID {
+			c.PreviousTenantID = before.TenantID
+		}
+	}
+	return s.recordChange(ctx, tx, c, before, after)
+}
+
+// recordChange appends c to the history in the transaction of the write it
+// describes, so the history can't miss or invent a change.
+func (s *sqlStore) recordChange(ctx context.Context, tx *sql.Tx, c Change, before, after interface{}) error {
+	state := func(v interface{}) (interface{}, error) {
+		if reflect.ValueOf(v).IsNil() {
+			return nil, nil
+		}
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return string(raw), nil
+	}
+	beforeState, err := state(before)
+	if err != nil {
+		return fmt.Errorf("failed to record change: %w", err)
+	}
+	afterState, err := state(after)
+	if err != nil {
+		return fmt.Errorf("failed to record change: %w", err)
+	}
+	note, _ := ctx.Value(changeNoteKey{}).(string)
+
+	_, err = tx.ExecContext(ctx, s.rebind(
+		"INSERT INTO changes (tenant_id, previous_tenant_id, kind, target, action, revision, actor, request_id, note, before_state, after_state, created_at) "+
+			"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+		c.TenantID, nullIfEmpty(c.PreviousTenantID), c.Kind, c.Target, c.Action, c.Revision,
+		nullIfEmpty(ActorFromContext(ctx)), nullIfEmpty(requestIDFromContext(ctx)), nullIfEmpty(note), beforeState, afterState,
+		time.Now().UTC().Truncate(time.Second),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record change: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) changeColumns() string {
+	return "id, tenant_id, previous_tenant_id, kind, target, action, revision, actor, request_id, note, before_state, after_state, " + s.ts("created_at")
+}
+
+func (s *sqlStore) ListChanges(ctx context.Context, f ChangeFilter) ([]Change, error) {
+	var where []string
+	var args []interface{}
+	if f.TenantID != "" {
+		where = append(where, "(tenant_id = ? OR previous_tenant_id = ?)")
+		args = append(args, f.TenantID, f.TenantID)
+	}
+	if f.Kind != "" {
+		where = append(where, "kind = ?")
+		args = append(args, f.Kind)
+	}
+	if f.Target != "" {
+		where = append(where, "target = ?")
+		args = append(args, f.Target)
+	}
+	if f.RequestID != "" {
+		where = append(where, "request_id = ?")
+		args = append(args, f.RequestID)
+	}
+	if f.BeforeID != 0 {
+		where = append(where, "id < ?")
+		args = append(args, f.BeforeID)
+	}
+
+	query := "SELECT " + s.changeColumns() + " FROM changes"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if f.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, f.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list changes: %w", err)
+	}
+	defer rows.Close()
+
+	var changes []Change
+	for rows.Next() {
+		c, err := scanChange(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan change: %w", err)
+		}
+		changes = append(changes, *c)
+	}
+	return changes, rows.Err()
+}
+
+func (s *sqlStore) GetChange(ctx context.Context, id int64) (*Change, error) {
+	row := s.db.QueryRowContext(ctx, s.rebind("SELECT "+s.changeColumns()+" FROM changes WHERE id = ?"), id)
+	c, err := scanChange(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return c, nil
+}
+
+// scanChange reads a row selected with changeColumns.
+func scanChange(row rowScanner) (*Change, error) {
+	var c Change
+	var previousTenant, actor, requestID, note, before, after, createdAt sql.NullString
+
+	if err := row.Scan(&c.ID, &c.TenantID, &previousTenant, &c.Kind, &c.Target, &c.Action, &c.Revision, &actor, &requestID, &note, &before, &after, &createdAt); err != nil {
+		return nil, err
+	}
+
+	c.PreviousTenantID = previousTenant.String
+	c.Actor = actor.String
+	c.RequestID = requestID.String
+	c.Note = note.String
+	if before.Valid {
+		c.Before = json.RawMessage(before.String)
+	}
+	if after.Valid {
+		c.After = json.RawMessage(after.String)
+	}
+	c.CreatedAt = createdAt.String
+
+	return &c, nil
+}
@@ -0,0 +1,1103 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrSchemaTooNew is returned when the database has migrations applied that
+// this binary doesn't know about (it was migrated by a newer release).
+var ErrSchemaTooNew = errors.New("database schema is newer than this binary")
+
+// ErrSchemaOutdated is returned when migrations are pending and automatic
+// migration is disabled.
+var ErrSchemaOutdated = errors.New("database schema is out of date")
+
+// Migration is one versioned schema change. Up and Down run inside the same
+// transaction that records the change in schema_migrations.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(tx *sql.Tx) error
+	Down    func(tx *sql.Tx) error
+}
+
+// MigrationStatus describes one known or applied migration.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt string
+	Unknown   bool // Applied in the database but not known to this binary
+}
+
+// Migrator applies an ordered list of migrations and tracks them in the
+// schema_migrations table.
+type Migrator struct {
+	db         *sql.DB
+	postgres   bool
+	migrations []Migration
+}
+
+// NewMigrator returns the migrator for the given driver's migrations.
+func NewMigrator(db *sql.DB, driver string) *Migrator {
+	m := &Migrator{db: db, postgres: driver == DriverPostgres}
+	if m.postgres {
+		m.migrations = postgresMigrations
+	} else {
+		m.migrations = sqliteMigrations
+	}
+	return m
+}
+
+// OpenMigrator opens the database for driver/dsn without touching its schema,
+// for the migrate command. The caller must close the returned *sql.DB.
+func OpenMigrator(driver, dsn string) (*Migrator, *sql.DB, error) {
+	var db *sql.DB
+	var err error
+	switch driver {
+	case "", DriverSQLite:
+		db, err = sql.Open("sqlite3", sqliteDSN(dsn))
+	case DriverPostgres:
+		db, err = sql.Open("postgres", dsn)
+	default:
+		return nil, nil, fmt.Errorf("unsupported database driver: %s", driver)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open database: %w", err)
+	}
+	return NewMigrator(db, driver), db, nil
+}
+
+// Latest returns the highest migration version known to this binary.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// rebind converts ? placeholders to $n for PostgreSQL.
+func (m *Migrator) rebind(query string) string {
+	if !m.postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+	return nil
+}
+
+// applied returns applied versions mapped to their name and applied_at.
+func (m *Migrator) applied(ctx context.Context) (map[int][2]string, error) {
+	rows, err := m.db.QueryContext(ctx, "SELECT version, name, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int][2]string)
+	for rows.Next() {
+		var version int
+		var name, appliedAt string
+		if err := rows.Scan(&version, &name, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = [2]string{name, appliedAt}
+	}
+	return applied, rows.Err()
+}
+
+// Current returns the highest applied migration version (0 for a new database).
+func (m *Migrator) Current(ctx context.Context) (int, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return 0, err
+	}
+	var version sql.NullInt64
+	if err := m.db.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+// Status lists every known migration plus any unknown applied ones.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var status []MigrationStatus
+	known := make(map[int]bool)
+	for _, mig := range m.migrations {
+		known[mig.Version] = true
+		s := MigrationStatus{Version: mig.Version, Name: mig.Name}
+		if a, ok := applied[mig.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = a[1]
+		}
+		status = append(status, s)
+	}
+	for version, a := range applied {
+		if !known[version] {
+			status = append(status, MigrationStatus{Version: version, Name: a[0], Applied: true, AppliedAt: a[1], Unknown: true})
+		}
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Version < status[j].Version })
+	return status, nil
+}
+
+// Check returns ErrSchemaTooNew if the database is ahead of this binary, or
+// ErrSchemaOutdated if known migrations are still pending.
+func (m *Migrator) Check(ctx context.Context) error {
+	current, err := m.Current(ctx)
+	if err != nil {
+		return err
+	}
+	if current > m.Latest() {
+		return fmt.Errorf("%w (database at version %d, binary supports up to %d)", ErrSchemaTooNew, current, m.Latest())
+	}
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; !ok {
+			return fmt.Errorf("%w: migration %d (%s) is pending, run \"migrate up\"", ErrSchemaOutdated, mig.Version, mig.Name)
+		}
+	}
+	return nil
+}
+
+// Up applies pending migrations up to and including target (0 means latest).
+// Each migration runs in its own transaction; on failure earlier migrations
+// stay applied and the error names the one that failed.
+func (m *Migrator) Up(ctx context.Context, target int) error {
+	current, err := m.Current(ctx)
+	if err != nil {
+		return err
+	}
+	if current > m.Latest() {
+		return fmt.Errorf("%w (database at version %d, binary supports up to %d)", ErrSchemaTooNew, current, m.Latest())
+	}
+	if target == 0 {
+		target = m.Latest()
+	}
+
+	for _, mig := range m.migrations {
+		if mig.Version > target {
+			break
+		}
+		if err := m.apply(ctx, mig, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Down reverts the most recent steps applied migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	current, err := m.Current(ctx)
+	if err != nil {
+		return err
+	}
+	if current > m.Latest() {
+		return fmt.Errorf("%w (database at version %d, binary supports up to %d)", ErrSchemaTooNew, current, m.Latest())
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+		mig := m.migrations[i]
+		if _, ok := applied[mig.Version]; !ok {
+			continue
+		}
+		if err := m.apply(ctx, mig, false); err != nil {
+			return err
+		}
+		steps--
+	}
+	return nil
+}
+
+func (m *Migrator) apply(ctx context.Context, mig Migration, up bool) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Serialize concurrent replicas migrating the same PostgreSQL database
+	if m.postgres {
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(7451320)"); err != nil {
+			return fmt.Errorf("failed to lock schema_migrations: %w", err)
+		}
+	}
+
+	var exists int
+	err = tx.QueryRowContext(ctx, m.rebind("SELECT COUNT(*) FROM schema_migrations WHERE version = ?"), mig.Version).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if up {
+		if exists > 0 {
+			return nil
+		}
+		if err := mig.Up(tx); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", mig.Version, mig.Name, err)
+		}
+		_, err = tx.ExecContext(ctx, m.rebind("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)"),
+			mig.Version, mig.Name, time.Now().UTC().Format(time.RFC3339))
+	} else {
+		if exists == 0 {
+			return nil
+		}
+		if mig.Down == nil {
+			return fmt.Errorf("migration %d (%s) is irreversible", mig.Version, mig.Name)
+		}
+		if err := mig.Down(tx); err != nil {
+			return fmt.Errorf("reverting migration %d (%s) failed: %w", mig.Version, mig.Name, err)
+		}
+		_, err = tx.ExecContext(ctx, m.rebind("DELETE FROM schema_migrations WHERE version = ?"), mig.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	direction := "Applied"
+	if !up {
+		direction = "Reverted"
+	}
+	log.Printf("[DB] %s migration %d: %s", direction, mig.Version, mig.Name)
+	return nil
+}
+
+// prepareSchema brings a store's database up to date (if autoMigrate) and
+// refuses to continue when the schema doesn't match this binary.
+func prepareSchema(db *sql.DB, driver string, autoMigrate bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	m := NewMigrator(db, driver)
+	if autoMigrate {
+		if err := m.Up(ctx, 0); err != nil {
+			return err
+		}
+	}
+	return m.Check(ctx)
+}
+
+// execAll returns a migration step that executes each statement in order.
+func execAll(statements ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, stmt := range statements {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// sqliteAddColumn adds a column unless it already exists, for databases
+// created before migrations were versioned (when columns were added ad hoc).
+func sqliteAddColumn(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+var sqliteMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create tenants table",
+		Up: func(tx *sql.Tx) error {
+			err := execAll(`
+			CREATE TABLE IF NOT EXISTS tenants (
+				domain TEXT PRIMARY KEY,
+				tenant_id TEXT NOT NULL,
+				project_route TEXT NOT NULL DEFAULT '/projects/backend',
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+				"CREATE INDEX IF NOT EXISTS idx_domain ON tenants(domain)",
+				"CREATE INDEX IF NOT EXISTS idx_tenant_id ON tenants(tenant_id)",
+			)(tx)
+			if err != nil {
+				return err
+			}
+			// Databases from before project routing existed
+			return sqliteAddColumn(tx, "tenants", "project_route", "TEXT DEFAULT '/projects/backend'")
+		},
+		Down: execAll("DROP TABLE tenants"),
+	},
+	{
+		Version: 2,
+		Name:    "add tenants.project_port",
+		Up: func(tx *sql.Tx) error {
+			return sqliteAddColumn(tx, "tenants", "project_port", "INTEGER")
+		},
+		Down: execAll("ALTER TABLE tenants DROP COLUMN project_port"),
+	},
+	{
+		Version: 3,
+		Name:    "add tenants.backend_domain",
+		Up: func(tx *sql.Tx) error {
+			return sqliteAddColumn(tx, "tenants", "backend_domain", "TEXT")
+		},
+		Down: execAll("ALTER TABLE tenants DROP COLUMN backend_domain"),
+	},
+	{
+		Version: 4,
+		Name:    "add tenants.upstream_protocol",
+		Up: func(tx *sql.Tx) error {
+			return sqliteAddColumn(tx, "tenants", "upstream_protocol", "TEXT")
+		},
+		Down: execAll("ALTER TABLE tenants DROP COLUMN upstream_protocol"),
+	},
+	{
+		Version: 5,
+		Name:    "add tenants.proxy_protocol",
+		Up: func(tx *sql.Tx) error {
+			return sqliteAddColumn(tx, "tenants", "proxy_protocol", "TEXT")
+		},
+		Down: execAll("ALTER TABLE tenants DROP COLUMN proxy_protocol"),
+	},
+	{
+		Version: 6,
+		Name:    "split tenants into tenants and domains",
+		Up: execAll(
+			"ALTER TABLE tenants RENAME TO tenants_legacy",
+			`CREATE TABLE tenants (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				status TEXT NOT NULL DEFAULT 'active',
+				project_route TEXT NOT NULL DEFAULT '/projects/backend',
+				project_port INTEGER,
+				backend_domain TEXT,
+				upstream_protocol TEXT,
+				proxy_protocol TEXT,
+				metadata TEXT,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			// A tenant only takes a setting as its default when all of its
+			// domains agree on it (unset included); otherwise the domains keep
+			// their own values below, and those without one stay unset
+			`INSERT INTO tenants (id, name, project_route, project_port, backend_domain, upstream_protocol, proxy_protocol, created_at, updated_at)
+			SELECT tenant_id, tenant_id,
+				CASE WHEN COUNT(DISTINCT COALESCE(project_route, '/projects/backend')) = 1
+					THEN MIN(COALESCE(project_route, '/projects/backend')) ELSE '/projects/backend' END,
+				CASE WHEN COUNT(DISTINCT project_port) = 1 AND COUNT(project_port) = COUNT(*) THEN MIN(project_port) END,
+				CASE WHEN COUNT(DISTINCT backend_domain) = 1 AND COUNT(backend_domain) = COUNT(*) THEN MIN(backend_domain) END,
+				CASE WHEN COUNT(DISTINCT upstream_protocol) = 1 AND COUNT(upstream_protocol) = COUNT(*) THEN MIN(upstream_protocol) END,
+				CASE WHEN COUNT(DISTINCT proxy_protocol) = 1 AND COUNT(proxy_protocol) = COUNT(*) THEN MIN(proxy_protocol) END,
+				MIN(created_at), MIN(created_at)
+			FROM tenants_legacy GROUP BY tenant_id`,
+			`CREATE TABLE domains (
+				domain TEXT PRIMARY KEY,
+				tenant_id TEXT NOT NULL REFERENCES tenants(id),
+				project_route TEXT,
+				project_port INTEGER,
+				backend_domain TEXT,
+				upstream_protocol TEXT,
+				proxy_protocol TEXT,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			// Domains only keep the settings that differ from their tenant's
+			`INSERT INTO domains (domain, tenant_id, project_route, project_port, backend_domain, upstream_protocol, proxy_protocol, created_at)
+			SELECT l.domain, l.tenant_id,
+				CASE WHEN COALESCE(l.project_route, '/projects/backend') = t.project_route THEN NULL ELSE l.project_route END,
+				CASE WHEN l.project_port IS t.project_port THEN NULL ELSE l.project_port END,
+				CASE WHEN l.backend_domain IS t.backend_domain THEN NULL ELSE l.backend_domain END,
+				CASE WHEN l.upstream_protocol IS t.upstream_protocol THEN NULL ELSE l.upstream_protocol END,
+				CASE WHEN l.proxy_protocol IS t.proxy_protocol THEN NULL ELSE l.proxy_protocol END,
+				l.created_at
+			FROM tenants_legacy l JOIN tenants t ON t.id = l.tenant_id`,
+			"DROP TABLE tenants_legacy",
+			"CREATE INDEX idx_domains_tenant_id ON domains(tenant_id)",
+		),
+		Down: execAll(
+			`CREATE TABLE tenants_legacy (
+				domain TEXT PRIMARY KEY,
+				tenant_id TEXT NOT NULL,
+				project_route TEXT NOT NULL DEFAULT '/projects/backend',
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+				project_port INTEGER,
+				backend_domain TEXT,
+				upstream_protocol TEXT,
+				proxy_protocol TEXT
+			)`,
+			`INSERT INTO tenants_legacy (domain, tenant_id, project_route, project_port, backend_domain, upstream_protocol, proxy_protocol, created_at)
+			SELECT d.domain, d.tenant_id, COALESCE(d.project_route, t.project_route), COALESCE(d.project_port, t.project_port),
+				COALESCE(d.backend_domain, t.backend_domain), COALESCE(d.upstream_protocol, t.upstream_protocol),
+				COALESCE(d.proxy_protocol, t.proxy_protocol), d.created_at
+			FROM domains d JOIN tenants t ON t.id = d.tenant_id`,
+			"DROP TABLE domains",
+			"DROP TABLE tenants",
+			"ALTER TABLE tenants_legacy RENAME TO tenants",
+			"CREATE INDEX IF NOT EXISTS idx_domain ON tenants(domain)",
+			"CREATE INDEX IF NOT EXISTS idx_tenant_id ON tenants(tenant_id)",
+		),
+	},
+	{
+		Version: 7,
+		Name:    "add tenants.maintenance_allowlist",
+		Up: func(tx *sql.Tx) error {
+			return sqliteAddColumn(tx, "tenants", "maintenance_allowlist", "TEXT")
+		},
+		Down: execAll("ALTER TABLE tenants DROP COLUMN maintenance_allowlist"),
+	},
+	{
+		Version: 8,
+		Name:    "create maintenance_windows table",
+		Up: execAll(`
+			CREATE TABLE maintenance_windows (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				tenant_id TEXT NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+				starts_at DATETIME NOT NULL,
+				ends_at DATETIME NOT NULL,
+				message TEXT,
+				bypass_ips TEXT,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			)`,
+			"CREATE INDEX idx_maintenance_windows_tenant_id ON maintenance_windows(tenant_id)",
+		),
+		Down: execAll("DROP TABLE maintenance_windows"),
+	},
+	{
+		Version: 9,
+		Name:    "add tenants.revision and domains.revision",
+		Up: func(tx *sql.Tx) error {
+			if err := sqliteAddColumn(tx, "tenants", "revision", "INTEGER NOT NULL DEFAULT 1"); err != nil {
+				return err
+			}
+			return sqliteAddColumn(tx, "domains", "revision", "INTEGER NOT NULL DEFAULT 1")
+		},
+		Down: execAll(
+			"ALTER TABLE tenants DROP COLUMN revision",
+			"ALTER TABLE domains DROP COLUMN revision",
+		),
+	},
+	{
+		Version: 10,
+		Name:    "create changes table",
+		// History outlives the records it describes: no foreign keys
+		Up: execAll(`
+			CREATE TABLE changes (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				tenant_id TEXT NOT NULL,
+				previous_tenant_id TEXT,
+				kind TEXT NOT NULL,
+				target TEXT NOT NULL,
+				action TEXT NOT NULL,
+				revision INTEGER NOT NULL,
+				actor TEXT,
+				note TEXT,
+				before_state TEXT,
+				after_state TEXT,
+				created_at DATETIME NOT NULL
+			)`,
+			"CREATE INDEX idx_changes_tenant_id ON changes(tenant_id)",
+			"CREATE INDEX idx_changes_previous_tenant_id ON changes(previous_tenant_id)",
+			"CREATE INDEX idx_changes_target ON changes(kind, target)",
+		),
+		Down: execAll("DROP TABLE changes"),
+	},
+	{
+		Version: 11,
+		Name:    "create audit_log table",
+		Up: func(tx *sql.Tx) error {
+			if err := sqliteAddColumn(tx, "changes", "request_id", "TEXT"); err != nil {
+				return err
+			}
+			return execAll(
+				"CREATE INDEX idx_changes_request_id ON changes(request_id)",
+				`CREATE TABLE audit_log (
+					id INTEGER PRIMARY KEY AUTOINCREMENT,
+					actor TEXT NOT NULL,
+					source_ip TEXT NOT NULL,
+					request_id TEXT,
+					action TEXT NOT NULL,
+					target TEXT,
+					payload TEXT,
+					changes TEXT,
+					result TEXT NOT NULL,
+					status INTEGER NOT NULL,
+					error TEXT,
+					created_at DATETIME NOT NULL
+				)`,
+				"CREATE INDEX idx_audit_log_actor ON audit_log(actor)",
+				"CREATE INDEX idx_audit_log_target ON audit_log(target)",
+				"CREATE INDEX idx_audit_log_created_at ON audit_log(created_at)",
+				// Append-only, even for tools that bypass the router
+				`CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+				BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END`,
+				`CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+				BEGIN SELECT RAISE(ABORT, 'audit_log is append-only'); END`,
+			)(tx)
+		},
+		Down: execAll(
+			"DROP TABLE audit_log",
+			"DROP INDEX idx_changes_request_id",
+			"ALTER TABLE changes DROP COLUMN request_id",
+		),
+	},
+	{
+		Version: 12,
+		Name:    "create api_keys table",
+		Up: execAll(`
+			CREATE TABLE api_keys (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL UNIQUE,
+				prefix TEXT NOT NULL,
+				key_hash TEXT NOT NULL UNIQUE,
+				scopes TEXT NOT NULL,
+				expires_at DATETIME,
+				last_used_at DATETIME,
+				revoked_at DATETIME,
+				created_by TEXT,
+				created_at DATETIME NOT NULL
+			)`,
+		),
+		Down: execAll("DROP TABLE api_keys"),
+	},
+	{
+		Version: 13,
+		Name:    "create operators and sessions tables",
+		Up: execAll(`
+			CREATE TABLE operators (
+				id TEXT PRIMARY KEY,
+				username TEXT NOT NULL UNIQUE,
+				role TEXT NOT NULL,
+				tenants TEXT,
+				disabled BOOLEAN NOT NULL DEFAULT 0,
+				password_hash TEXT,
+				last_login_at DATETIME,
+				created_at DATETIME NOT NULL,
+				updated_at DATETIME NOT NULL
+			)`,
+			`CREATE TABLE sessions (
+				id TEXT PRIMARY KEY,
+				operator_id TEXT NOT NULL REFERENCES operators(id) ON DELETE CASCADE,
+				csrf_token TEXT NOT NULL,
+				source_ip TEXT,
+				user_agent TEXT,
+				expires_at DATETIME NOT NULL,
+				created_at DATETIME NOT NULL
+			)`,
+			"CREATE INDEX idx_sessions_operator_id ON sessions(operator_id)",
+		),
+		Down: execAll("DROP TABLE sessions", "DROP TABLE operators"),
+	},
+	{
+		Version: 14,
+		Name:    "add tenants.max_domains and create tenant_tokens table",
+		Up: func(tx *sql.Tx) error {
+			if err := sqliteAddColumn(tx, "tenants", "max_domains", "INTEGER"); err != nil {
+				return err
+			}
+			return execAll(`
+				CREATE TABLE tenant_tokens (
+					id TEXT PRIMARY KEY,
+					tenant_id TEXT NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+					name TEXT NOT NULL,
+					prefix TEXT NOT NULL,
+					key_hash TEXT NOT NULL UNIQUE,
+					allow_routing BOOLEAN NOT NULL DEFAULT 0,
+					expires_at DATETIME,
+					last_used_at DATETIME,
+					revoked_at DATETIME,
+					created_by TEXT,
+					created_at DATETIME NOT NULL,
+					UNIQUE (tenant_id, name)
+				)`,
+			)(tx)
+		},
+		Down: execAll("DROP TABLE tenant_tokens", "ALTER TABLE tenants DROP COLUMN max_domains"),
+	},
+	{
+		Version: 15,
+		Name:    "add domains.status and create domain_verifications table",
+		Up: func(tx *sql.Tx) error {
+			if err := sqliteAddColumn(tx, "domains", "status", "TEXT NOT NULL DEFAULT 'active'"); err != nil {
+				return err
+			}
+			return execAll(`
+				CREATE TABLE domain_verifications (
+					domain TEXT PRIMARY KEY REFERENCES domains(domain) ON DELETE CASCADE,
+					token TEXT NOT NULL,
+					method TEXT,
+					expires_at DATETIME NOT NULL,
+					verified_at DATETIME,
+					checked_at DATETIME,
+					failing_since DATETIME,
+					error TEXT,
+					created_at DATETIME NOT NULL
+				)`,
+			)(tx)
+		},
+		Down: execAll("DROP TABLE domain_verifications", "ALTER TABLE domains DROP COLUMN status"),
+	},
+	{
+		Version: 16,
+		Name:    "add domains.request_headers",
+		Up: func(tx *sql.Tx) error {
+			return sqliteAddColumn(tx, "domains", "request_headers", "TEXT")
+		},
+		Down: execAll("ALTER TABLE domains DROP COLUMN request_headers"),
+	},
+	{
+		Version: 17,
+		Name:    "create change_feed table",
+		// SQLite has no NOTIFY: triggers append changed domains to the feed,
+		// which every process sharing the file polls (see SQLiteStore.Watch).
+		// Like the postgres triggers, tenant-wide changes append "" (reset).
+		Up: execAll(`
+			CREATE TABLE change_feed (
+				seq INTEGER PRIMARY KEY AUTOINCREMENT,
+				domain TEXT NOT NULL
+			)`,
+			`CREATE TRIGGER domains_insert_feed AFTER INSERT ON domains
+			BEGIN INSERT INTO change_feed (domain) VALUES (NEW.domain); END`,
+			`CREATE TRIGGER domains_update_feed AFTER UPDATE ON domains
+			BEGIN
+				INSERT INTO change_feed (domain) VALUES (OLD.domain);
+				INSERT INTO change_feed (domain) SELECT NEW.domain WHERE NEW.domain <> OLD.domain;
+			END`,
+			`CREATE TRIGGER domains_delete_feed AFTER DELETE ON domains
+			BEGIN INSERT INTO change_feed (domain) VALUES (OLD.domain); END`,
+			`CREATE TRIGGER tenants_insert_feed AFTER INSERT ON tenants
+			BEGIN INSERT INTO change_feed (domain) VALUES (''); END`,
+			`CREATE TRIGGER tenants_update_feed AFTER UPDATE ON tenants
+			BEGIN INSERT INTO change_feed (domain) VALUES (''); END`,
+			`CREATE TRIGGER tenants_delete_feed AFTER DELETE ON tenants
+			BEGIN INSERT INTO change_feed (domain) VALUES (''); END`,
+			`CREATE TRIGGER maintenance_windows_insert_feed AFTER INSERT ON maintenance_windows
+			BEGIN INSERT INTO change_feed (domain) VALUES (''); END`,
+			`CREATE TRIGGER maintenance_windows_update_feed AFTER UPDATE ON maintenance_windows
+			BEGIN INSERT INTO change_feed (domain) VALUES (''); END`,
+			`CREATE TRIGGER maintenance_windows_delete_feed AFTER DELETE ON maintenance_windows
+			BEGIN INSERT INTO change_feed (domain) VALUES (''); END`,
+		),
+		Down: execAll(
+			"DROP TRIGGER domains_insert_feed",
+			"DROP TRIGGER domains_update_feed",
+			"DROP TRIGGER domains_delete_feed",
+			"DROP TRIGGER tenants_insert_feed",
+			"DROP TRIGGER tenants_update_feed",
+			"DROP TRIGGER tenants_delete_feed",
+			"DROP TRIGGER maintenance_windows_insert_feed",
+			"DROP TRIGGER maintenance_windows_update_feed",
+			"DROP TRIGGER maintenance_windows_delete_feed",
+			"DROP TABLE change_feed",
+		),
+	},
+}
+
+var postgresMigrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create tenants table",
+		Up: execAll(`
+		CREATE TABLE IF NOT EXISTS tenants (
+			domain TEXT PRIMARY KEY,
+			tenant_id TEXT NOT NULL,
+			project_route TEXT NOT NULL DEFAULT '/projects/backend',
+			project_port INTEGER,
+			backend_domain TEXT,
+			upstream_protocol TEXT,
+			proxy_protocol TEXT,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`,
+			"CREATE INDEX IF NOT EXISTS idx_tenant_id ON tenants(tenant_id)",
+		),
+		Down: execAll("DROP TABLE tenants"),
+	},
+	{
+		Version: 2,
+		Name:    "notify tenant changes",
+		Up: execAll(`
+		CREATE OR REPLACE FUNCTION tenants_notify() RETURNS trigger AS $$
+		BEGIN
+			IF TG_OP = 'DELETE' THEN
+				PERFORM pg_notify('`+postgresChannel+`', OLD.domain);
+			ELSE
+				PERFORM pg_notify('`+postgresChannel+`', NEW.domain);
+			END IF;
+			RETURN NULL;
+		END;
+		$$ LANGUAGE plpgsql`,
+			"DROP TRIGGER IF EXISTS tenants_notify ON tenants",
+			`CREATE TRIGGER tenants_notify AFTER INSERT OR UPDATE OR DELETE ON tenants
+			FOR EACH ROW EXECUTE PROCEDURE tenants_notify()`,
+		),
+		Down: execAll(
+			"DROP TRIGGER IF EXISTS tenants_notify ON tenants",
+			"DROP FUNCTION IF EXISTS tenants_notify()",
+		),
+	},
+	{
+		Version: 3,
+		Name:    "split tenants into tenants and domains",
+		Up: execAll(
+			"DROP TRIGGER IF EXISTS tenants_notify ON tenants",
+			"ALTER TABLE tenants RENAME TO tenants_legacy",
+			"ALTER INDEX IF EXISTS tenants_pkey RENAME TO tenants_legacy_pkey",
+			"ALTER INDEX IF EXISTS idx_tenant_id RENAME TO idx_legacy_tenant_id",
+			`CREATE TABLE tenants (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				status TEXT NOT NULL DEFAULT 'active',
+				project_route TEXT NOT NULL DEFAULT '/projects/backend',
+				project_port INTEGER,
+				backend_domain TEXT,
+				upstream_protocol TEXT,
+				proxy_protocol TEXT,
+				metadata TEXT,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+				updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+			)`,
+			// A tenant only takes a setting as its default when all of its
+			// domains agree on it (unset included); otherwise the domains keep
+			// their own values below, and those without one stay unset
+			`INSERT INTO tenants (id, name, project_route, project_port, backend_domain, upstream_protocol, proxy_protocol, created_at, updated_at)
+			SELECT tenant_id, tenant_id,
+				CASE WHEN COUNT(DISTINCT COALESCE(project_route, '/projects/backend')) = 1
+					THEN MIN(COALESCE(project_route, '/projects/backend')) ELSE '/projects/backend' END,
+				CASE WHEN COUNT(DISTINCT project_port) = 1 AND COUNT(project_port) = COUNT(*) THEN MIN(project_port) END,
+				CASE WHEN COUNT(DISTINCT backend_domain) = 1 AND COUNT(backend_domain) = COUNT(*) THEN MIN(backend_domain) END,
+				CASE WHEN COUNT(DISTINCT upstream_protocol) = 1 AND COUNT(upstream_protocol) = COUNT(*) THEN MIN(upstream_protocol) END,
+				CASE WHEN COUNT(DISTINCT proxy_protocol) = 1 AND COUNT(proxy_protocol) = COUNT(*) THEN MIN(proxy_protocol) END,
+				MIN(created_at), MIN(created_at)
+			FROM tenants_legacy GROUP BY tenant_id`,
+			`CREATE TABLE domains (
+				domain TEXT PRIMARY KEY,
+				tenant_id TEXT NOT NULL REFERENCES tenants(id),
+				project_route TEXT,
+				project_port INTEGER,
+				backend_domain TEXT,
+				upstream_protocol TEXT,
+				proxy_protocol TEXT,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+			)`,
+			// Domains only keep the settings that differ from their tenant's
+			`INSERT INTO domains (domain, tenant_id, project_route, project_port, backend_domain, upstream_protocol, proxy_protocol, created_at)
+			SELECT l.domain, l.tenant_id,
+				CASE WHEN COALESCE(l.project_route, '/projects/backend') = t.project_route THEN NULL ELSE l.project_route END,
+				CASE WHEN l.project_port IS NOT DISTINCT FROM t.project_port THEN NULL ELSE l.project_port END,
+				CASE WHEN l.backend_domain IS NOT DISTINCT FROM t.backend_domain THEN NULL ELSE l.backend_domain END,
+				CASE WHEN l.upstream_protocol IS NOT DISTINCT FROM t.upstream_protocol THEN NULL ELSE l.upstream_protocol END,
+				CASE WHEN l.proxy_protocol IS NOT DISTINCT FROM t.proxy_protocol THEN NULL ELSE l.proxy_protocol END,
+				l.created_at
+			FROM tenants_legacy l JOIN tenants t ON t.id = l.tenant_id`,
+			"DROP TABLE tenants_legacy",
+			"CREATE INDEX idx_domains_tenant_id ON domains(tenant_id)",
+			`CREATE OR REPLACE FUNCTION domains_notify() RETURNS trigger AS $$
+			BEGIN
+				IF TG_OP = 'DELETE' THEN
+					PERFORM pg_notify('`+postgresChannel+`', OLD.domain);
+				ELSE
+					PERFORM pg_notify('`+postgresChannel+`', NEW.domain);
+				END IF;
+				RETURN NULL;
+			END;
+			$$ LANGUAGE plpgsql`,
+			`CREATE TRIGGER domains_notify AFTER INSERT OR UPDATE OR DELETE ON domains
+			FOR EACH ROW EXECUTE PROCEDURE domains_notify()`,
+			// A tenant's defaults apply to all of its domains: publish a reset
+			`CREATE OR REPLACE FUNCTION tenants_notify() RETURNS trigger AS $$
+			BEGIN
+				PERFORM pg_notify('`+postgresChannel+`', '');
+				RETURN NULL;
+			END;
+			$$ LANGUAGE plpgsql`,
+			`CREATE TRIGGER tenants_notify AFTER INSERT OR UPDATE OR DELETE ON tenants
+			FOR EACH ROW EXECUTE PROCEDURE tenants_notify()`,
+		),
+		Down: execAll(
+			`CREATE TABLE tenants_legacy (
+				domain TEXT PRIMARY KEY,
+				tenant_id TEXT NOT NULL,
+				project_route TEXT NOT NULL DEFAULT '/projects/backend',
+				project_port INTEGER,
+				backend_domain TEXT,
+				upstream_protocol TEXT,
+				proxy_protocol TEXT,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+			)`,
+			`INSERT INTO tenants_legacy (domain, tenant_id, project_route, project_port, backend_domain, upstream_protocol, proxy_protocol, created_at)
+			SELECT d.domain, d.tenant_id, COALESCE(d.project_route, t.project_route), COALESCE(d.project_port, t.project_port),
+				COALESCE(d.backend_domain, t.backend_domain), COALESCE(d.upstream_protocol, t.upstream_protocol),
+				COALESCE(d.proxy_protocol, t.proxy_protocol), d.created_at
+			FROM domains d JOIN tenants t ON t.id = d.tenant_id`,
+			"DROP TABLE domains",
+			"DROP FUNCTION IF EXISTS domains_notify()",
+			"DROP TABLE tenants",
+			"ALTER TABLE tenants_legacy RENAME TO tenants",
+			"ALTER INDEX tenants_legacy_pkey RENAME TO tenants_pkey",
+			"CREATE INDEX IF NOT EXISTS idx_tenant_id ON tenants(tenant_id)",
+			`CREATE OR REPLACE FUNCTION tenants_notify() RETURNS trigger AS $$
+			BEGIN
+				IF TG_OP = 'DELETE' THEN
+					PERFORM pg_notify('`+postgresChannel+`', OLD.domain);
+				ELSE
+					PERFORM pg_notify('`+postgresChannel+`', NEW.domain);
+				END IF;
+				RETURN NULL;
+			END;
+			$$ LANGUAGE plpgsql`,
+			`CREATE TRIGGER tenants_notify AFTER INSERT OR UPDATE OR DELETE ON tenants
+			FOR EACH ROW EXECUTE PROCEDURE tenants_notify()`,
+		),
+	},
+	{
+		Version: 4,
+		Name:    "add tenants.maintenance_allowlist",
+		Up:      execAll("ALTER TABLE tenants ADD COLUMN IF NOT EXISTS maintenance_allowlist TEXT"),
+		Down:    execAll("ALTER TABLE tenants DROP COLUMN maintenance_allowlist"),
+	},
+	{
+		Version: 5,
+		Name:    "create maintenance_windows table",
+		Up: execAll(`
+			CREATE TABLE maintenance_windows (
+				id BIGSERIAL PRIMARY KEY,
+				tenant_id TEXT NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+				starts_at TIMESTAMPTZ NOT NULL,
+				ends_at TIMESTAMPTZ NOT NULL,
+				message TEXT,
+				bypass_ips TEXT,
+				created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+			)`,
+			"CREATE INDEX idx_maintenance_windows_tenant_id ON maintenance_windows(tenant_id)",
+			// Windows are part of the tenant's routing state: publish a reset
+			`CREATE TRIGGER maintenance_windows_notify AFTER INSERT OR UPDATE OR DELETE ON maintenance_windows
+			FOR EACH ROW EXECUTE PROCEDURE tenants_notify()`,
+		),
+		Down: execAll("DROP TABLE maintenance_windows"),
+	},
+	{
+		Version: 6,
+		Name:    "add tenants.revision and domains.revision",
+		Up: execAll(
+			"ALTER TABLE tenants ADD COLUMN IF NOT EXISTS revision BIGINT NOT NULL DEFAULT 1",
+			"ALTER TABLE domains ADD COLUMN IF NOT EXISTS revision BIGINT NOT NULL DEFAULT 1",
+		),
+		Down: execAll(
+			"ALTER TABLE tenants DROP COLUMN revision",
+			"ALTER TABLE domains DROP COLUMN revision",
+		),
+	},
+	{
+		Version: 7,
+		Name:    "create changes table",
+		Up: execAll(`
+			CREATE TABLE changes (
+				id BIGSERIAL PRIMARY KEY,
+				tenant_id TEXT NOT NULL,
+				previous_tenant_id TEXT,
+				kind TEXT NOT NULL,
+				target TEXT NOT NULL,
+				action TEXT NOT NULL,
+				revision BIGINT NOT NULL,
+				actor TEXT,
+				note TEXT,
+				before_state TEXT,
+				after_state TEXT,
+				created_at TIMESTAMPTZ NOT NULL
+			)`,
+			"CREATE INDEX idx_changes_tenant_id ON changes(tenant_id)",
+			"CREATE INDEX idx_changes_previous_tenant_id ON changes(previous_tenant_id)",
+			"CREATE INDEX idx_changes_target ON changes(kind, target)",
+		),
+		Down: execAll("DROP TABLE changes"),
+	},
+	{
+		Version: 8,
+		Name:    "create audit_log table",
+		Up: execAll(
+			"ALTER TABLE changes ADD COLUMN IF NOT EXISTS request_id TEXT",
+			"CREATE INDEX idx_changes_request_id ON changes(request_id)",
+			`CREATE TABLE audit_log (
+				id BIGSERIAL PRIMARY KEY,
+				actor TEXT NOT NULL,
+				source_ip TEXT NOT NULL,
+				request_id TEXT,
+				action TEXT NOT NULL,
+				target TEXT,
+				payload TEXT,
+				changes TEXT,
+				result TEXT NOT NULL,
+				status INTEGER NOT NULL,
+				error TEXT,
+				created_at TIMESTAMPTZ NOT NULL
+			)`,
+			"CREATE INDEX idx_audit_log_actor ON audit_log(actor)",
+			"CREATE INDEX idx_audit_log_target ON audit_log(target)",
+			"CREATE INDEX idx_audit_log_created_at ON audit_log(created_at)",
+			`CREATE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+			BEGIN
+				RAISE EXCEPTION 'audit_log is append-only';
+			END;
+			$$ LANGUAGE plpgsql`,
+			`CREATE TRIGGER audit_log_append_only BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_log
+			FOR EACH STATEMENT EXECUTE PROCEDURE audit_log_append_only()`,
+		),
+		Down: execAll(
+			"DROP TABLE audit_log",
+			"DROP FUNCTION audit_log_append_only()",
+			"DROP INDEX idx_changes_request_id",
+			"ALTER TABLE changes DROP COLUMN request_id",
+		),
+	},
+	{
+		Version: 9,
+		Name:    "create api_keys table",
+		Up: execAll(`
+			CREATE TABLE api_keys (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL UNIQUE,
+				prefix TEXT NOT NULL,
+				key_hash TEXT NOT NULL UNIQUE,
+				scopes TEXT NOT NULL,
+				expires_at TIMESTAMPTZ,
+				last_used_at TIMESTAMPTZ,
+				revoked_at TIMESTAMPTZ,
+				created_by TEXT,
+				created_at TIMESTAMPTZ NOT NULL
+			)`,
+		),
+		Down: execAll("DROP TABLE api_keys"),
+	},
+	{
+		Version: 10,
+		Name:    "create operators and sessions tables",
+		Up: execAll(`
+			CREATE TABLE operators (
+				id TEXT PRIMARY KEY,
+				username TEXT NOT NULL UNIQUE,
+				role TEXT NOT NULL,
+				tenants TEXT,
+				disabled BOOLEAN NOT NULL DEFAULT FALSE,
+				password_hash TEXT,
+				last_login_at TIMESTAMPTZ,
+				created_at TIMESTAMPTZ NOT NULL,
+				updated_at TIMESTAMPTZ NOT NULL
+			)`,
+			`CREATE TABLE sessions (
+				id TEXT PRIMARY KEY,
+				operator_id TEXT NOT NULL REFERENCES operators(id) ON DELETE CASCADE,
+				csrf_token TEXT NOT NULL,
+				source_ip TEXT,
+				user_agent TEXT,
+				expires_at TIMESTAMPTZ NOT NULL,
+				created_at TIMESTAMPTZ NOT NULL
+			)`,
+			"CREATE INDEX idx_sessions_operator_id ON sessions(operator_id)",
+		),
+		Down: execAll("DROP TABLE sessions", "DROP TABLE operators"),
+	},
+	{
+		Version: 11,
+		Name:    "add tenants.max_domains and create tenant_tokens table",
+		Up: execAll(
+			"ALTER TABLE tenants ADD COLUMN IF NOT EXISTS max_domains INTEGER",
+			`CREATE TABLE tenant_tokens (
+				id TEXT PRIMARY KEY,
+				tenant_id TEXT NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+				name TEXT NOT NULL,
+				prefix TEXT NOT NULL,
+				key_hash TEXT NOT NULL UNIQUE,
+				allow_routing BOOLEAN NOT NULL DEFAULT FALSE,
+				expires_at TIMESTAMPTZ,
+				last_used_at TIMESTAMPTZ,
+				revoked_at TIMESTAMPTZ,
+				created_by TEXT,
+				created_at TIMESTAMPTZ NOT NULL,
+				UNIQUE (tenant_id, name)
+			)`,
+		),
+		Down: execAll("DROP TABLE tenant_tokens", "ALTER TABLE tenants DROP COLUMN max_domains"),
+	},
+	{
+		Version: 12,
+		Name:    "add domains.status and create domain_verifications table",
+		Up: execAll(
+			"ALTER TABLE domains ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active'",
+			`CREATE TABLE domain_verifications (
+				domain TEXT PRIMARY KEY REFERENCES domains(domain) ON DELETE CASCADE,
+				token TEXT NOT NULL,
+				method TEXT,
+				expires_at TIMESTAMPTZ NOT NULL,
+				verified_at TIMESTAMPTZ,
+				checked_at TIMESTAMPTZ,
+				failing_since TIMESTAMPTZ,
+				error TEXT,
+				created_at TIMESTAMPTZ NOT NULL
+			)`,
+		),
+		Down: execAll("DROP TABLE domain_verifications", "ALTER TABLE domains DROP COLUMN status"),
+	},
+	{
+		Version: 13,
+		Name:    "add domains.request_headers",
+		Up:      execAll("ALTER TABLE domains ADD COLUMN IF NOT EXISTS request_headers TEXT"),
+		Down:    execAll("ALTER TABLE domains DROP COLUMN request_headers"),
+	},
+}
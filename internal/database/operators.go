@@ -0,0 +1,475 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Operator roles
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleOwner  = "owner"
+)
+
+// roleScopes maps each role to the API key scope it corresponds to.
+var roleScopes = map[string]string{RoleViewer: ScopeRead, RoleEditor: ScopeWrite, RoleOwner: ScopeAdmin}
+
+// minPasswordLength is the shortest operator password accepted.
+const minPasswordLength = 10
+
+// Errors returned by operator authentication
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrSessionExpired     = errors.New("session expired")
+	ErrOperatorDisabled   = errors.New("operator is disabled")
+	ErrLocalOperator      = errors.New("operator signs in with a password")
+)
+
+// dummyPasswordHash is compared against when a username doesn't exist, so
+// that failed logins take as long for unknown users as for known ones.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("tenant-router"), bcrypt.DefaultCost)
+
+// Operator is a person signing in to the admin panel.
+type Operator struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+	Role     string `json:"role"`
+	// Tenants restricts the operator to these tenants; empty means all
+	Tenants     []string   `json:"tenants,omitempty"`
+	Disabled    bool       `json:"disabled"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+	CreatedAt   string     `json:"created_at"`
+	UpdatedAt   string     `json:"updated_at"`
+	// PasswordHash is the bcrypt hash of the password; empty for operators
+	// who can't sign in with a password
+	PasswordHash string `json:"-"`
+}
+
+// Session is a signed-in operator's admin panel session.
+type Session struct {
+	// ID is the SHA-256 of the session token held in the cookie
+	ID         string    `json:"-"`
+	OperatorID string    `json:"operator_id"`
+	CSRFToken  string    `json:"-"`
+	SourceIP   string    `json:"source_ip,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	CreatedAt  string    `json:"created_at"`
+}
+
+// ValidRole reports whether role is a known operator role.
+func ValidRole(role string) bool {
+	return roleScopes[role] != ""
+}
+
+// MaxRole returns the most privileged of roles, or "" if there are none.
+func MaxRole(roles ...string) string {
+	max := ""
+	for _, role := range roles {
+		if ValidRole(role) && (max == "" || scopeLevels[roleScopes[role]] > scopeLevels[roleScopes[max]]) {
+			max = role
+		}
+	}
+	return max
+}
+
+// Allows reports whether the operator's role grants scope. Operators
+// restricted to some tenants never get the admin scope, which covers
+// everything outside of tenants.
+func (o *Operator) Allows(scope string) bool {
+	if scope == ScopeAdmin && len(o.Tenants) > 0 {
+		return false
+	}
+	return scopeLevels[roleScopes[o.Role]] >= scopeLevels[scope]
+}
+
+// CanAccessTenant reports whether the operator may see and change tenant id.
+func (o *Operator) CanAccessTenant(id string) bool {
+	if len(o.Tenants) == 0 {
+		return true
+	}
+	for _, t := range o.Tenants {
+		if t == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Actor is how the operator's requests are recorded in the history and
+// audit log.
+func (o *Operator) Actor() string {
+	return "operator:" + o.Username
+}
+
+// CanAccessTenant reports whether the key may see and change tenant id.
+// API keys aren't restricted to tenants.
+func (k *APIKey) CanAccessTenant(id string) bool {
+	return true
+}
+
+// Operators
+
+func (s *sqlStore) operatorColumns() string {
+	return "id, username, role, tenants, disabled, password_hash, last_login_at, " + s.ts("created_at") + ", " + s.ts("updated_at")
+}
+
+func (s *sqlStore) CreateOperator(ctx context.Context, o Operator) error {
+	now := time.Now().UTC().Truncate(time.Second)
+	_, err := s.db.ExecContext(ctx, s.rebind(
+		"INSERT INTO operators (id, username, role, tenants, disabled, password_hash, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"),
+		o.ID, o.Username, o.Role, nullIfEmpty(strings.Join(o.Tenants, ",")), o.Disabled, nullIfEmpty(o.PasswordHash), now, now,
+	)
+	if s.isUniqueViolation(err) {
+		return fmt.Errorf("operator %s: %w", o.Username, ErrConflict)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create operator: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) GetOperator(ctx context.Context, id string) (*Operator, error) {
+	return s.getOperator(ctx, "id", id)
+}
+
+func (s *sqlStore) GetOperatorByUsername(ctx context.Context, username string) (*Operator, error) {
+	return s.getOperator(ctx, "username", username)
+}
+
+func (s *sqlStore) getOperator(ctx context.Context, column, value string) (*Operator, error) {
+	row := s.db.QueryRowContext(ctx, s.rebind("SELECT "+s.operatorColumns()+" FROM operators WHERE "+column+" = ?"), value)
+	o, err := scanOperator(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return o, nil
+}
+
+func (s *sqlStore) ListOperators(ctx context.Context) ([]Operator, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+s.operatorColumns()+" FROM operators ORDER BY username")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list operators: %w", err)
+	}
+	defer rows.Close()
+
+	var operators []Operator
+	for rows.Next() {
+		o, err := scanOperator(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan operator: %w", err)
+		}
+		operators = append(operators, *o)
+	}
+	return operators, rows.Err()
+}
+
+func (s *sqlStore) UpdateOperator(ctx context.Context, o Operator) error {
+	res, err := s.db.ExecContext(ctx, s.rebind(
+		"UPDATE operators SET role = ?, tenants = ?, disabled = ?, password_hash = ?, updated_at = ? WHERE id = ?"),
+		o.Role, nullIfEmpty(strings.Join(o.Tenants, ",")), o.Disabled, nullIfEmpty(o.PasswordHash),
+		time.Now().UTC().Truncate(time.Second), o.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update operator: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("operator %s: %w", o.ID, ErrNotFound)
+	}
+	return nil
+}
+
+func (s *sqlStore) DeleteOperator(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, s.rebind("DELETE FROM operators WHERE id = ?"), id)
+	if err != nil {
+		return fmt.Errorf("failed to delete operator: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("operator %s: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+// scanOperator reads a row selected with operatorColumns.
+func scanOperator(row rowScanner) (*Operator, error) {
+	var o Operator
+	var tenants, passwordHash, createdAt, updatedAt sql.NullString
+	var lastLogin sql.NullTime
+
+	if err := row.Scan(&o.ID, &o.Username, &o.Role, &tenants, &o.Disabled, &passwordHash, &lastLogin, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+
+	if tenants.String != "" {
+		o.Tenants = strings.Split(tenants.String, ",")
+	}
+	o.PasswordHash = passwordHash.String
+	o.LastLoginAt = timePtr(lastLogin)
+	o.CreatedAt = createdAt.String
+	o.UpdatedAt = updatedAt.String
+
+	return &o, nil
+}
+
+// Sessions
+
+func (s *sqlStore) CreateSession(ctx context.Context, sess Session) error {
+	now := time.Now().UTC().Truncate(time.Second)
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		// Signing in is a good time to forget sessions nobody can use anymore
+		if _, err := tx.ExecContext(ctx, s.rebind("DELETE FROM sessions WHERE expires_at < ?"), now); err != nil {
+			return fmt.Errorf("failed to delete expired sessions: %w", err)
+		}
+		_, err := tx.ExecContext(ctx, s.rebind(
+			"INSERT INTO sessions (id, operator_id, csrf_token, source_ip, user_agent, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)"),
+			sess.ID, sess.OperatorID, sess.CSRFToken, nullIfEmpty(sess.SourceIP), nullIfEmpty(sess.UserAgent),
+			sess.ExpiresAt.UTC().Truncate(time.Second), now,
+		)
+		if s.isForeignKeyViolation(err) {
+			return fmt.Errorf("operator %s: %w", sess.OperatorID, ErrNotFound)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to create session: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, s.rebind("UPDATE operators SET last_login_at = ? WHERE id = ?"), now, sess.OperatorID); err != nil {
+			return fmt.Errorf("failed to update operator: %w", err)
+		}
+		return nil
+	})
+}
+
+func (s *sqlStore) GetSession(ctx context.Context, id string) (*Session, error) {
+	var sess Session
+	var sourceIP, userAgent, createdAt sql.NullString
+	err := s.db.QueryRowContext(ctx, s.rebind(
+		"SELECT id, operator_id, csrf_token, source_ip, user_agent, expires_at, "+s.ts("created_at")+" FROM sessions WHERE id = ?"), id,
+	).Scan(&sess.ID, &sess.OperatorID, &sess.CSRFToken, &sourceIP, &userAgent, &sess.ExpiresAt, &createdAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	sess.SourceIP = sourceIP.String
+	sess.UserAgent = userAgent.String
+	sess.ExpiresAt = sess.ExpiresAt.UTC()
+	sess.CreatedAt = createdAt.String
+	return &sess, nil
+}
+
+func (s *sqlStore) DeleteSession(ctx context.Context, id string) error {
+	if _, err := s.db.ExecContext(ctx, s.rebind("DELETE FROM sessions WHERE id = ?"), id); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) DeleteOperatorSessions(ctx context.Context, operatorID string) error {
+	if _, err := s.db.ExecContext(ctx, s.rebind("DELETE FROM sessions WHERE operator_id = ?"), operatorID); err != nil {
+		return fmt.Errorf("failed to delete sessions: %w", err)
+	}
+	return nil
+}
+
+// validateOperator checks the role, tenants and, if set, the password of an
+// operator about to be stored.
+func validateOperator(o *Operator, password string) error {
+	if !ValidRole(o.Role) {
+		return fmt.Errorf("role must be one of: viewer, editor, owner")
+	}
+	for _, t := range o.Tenants {
+		if t == "" || strings.Contains(t, ",") {
+			return fmt.Errorf("invalid tenant ID %q", t)
+		}
+	}
+	if password != "" && len(password) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	return nil
+}
+
+// CreateOperator adds an operator. An empty password creates an operator
+// who can't sign in with a password.
+func (tm *TenantManager) CreateOperator(ctx context.Context, o Operator, password string) (*Operator, error) {
+	if o.Username == "" {
+		return nil, fmt.Errorf("username is required")
+	}
+	if err := validateOperator(&o, password); err != nil {
+		return nil, err
+	}
+
+	id, err := randomString(9)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate operator ID: %w", err)
+	}
+	o.ID = id
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash password: %w", err)
+		}
+		o.PasswordHash = string(hash)
+	}
+
+	if err := tm.store.CreateOperator(ctx, o); err != nil {
+		return nil, err
+	}
+	return tm.store.GetOperator(ctx, id)
+}
+
+// UpdateOperator stores the operator's role, tenants and disabled flag, and
+// a new password if one is given. Existing sessions are ended when the
+// password changes or the operator is disabled.
+func (tm *TenantManager) UpdateOperator(ctx context.Context, o Operator, password string) (*Operator, error) {
+	if err := validateOperator(&o, password); err != nil {
+		return nil, err
+	}
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash password: %w", err)
+		}
+		o.PasswordHash = string(hash)
+	}
+
+	if err := tm.store.UpdateOperator(ctx, o); err != nil {
+		return nil, err
+	}
+	if password != "" || o.Disabled {
+		if err := tm.store.DeleteOperatorSessions(ctx, o.ID); err != nil {
+			return nil, err
+		}
+	}
+	return tm.store.GetOperator(ctx, o.ID)
+}
+
+// GetOperator returns one operator by ID.
+func (tm *TenantManager) GetOperator(id string) (*Operator, error) {
+	return tm.store.GetOperator(context.Background(), id)
+}
+
+// GetOperatorByUsername returns one operator by username.
+func (tm *TenantManager) GetOperatorByUsername(username string) (*Operator, error) {
+	return tm.store.GetOperatorByUsername(context.Background(), username)
+}
+
+// ListOperators returns all operators ordered by username.
+func (tm *TenantManager) ListOperators() ([]Operator, error) {
+	return tm.store.ListOperators(context.Background())
+}
+
+// DeleteOperator removes an operator and ends their sessions.
+func (tm *TenantManager) DeleteOperator(ctx context.Context, id string) error {
+	return tm.store.DeleteOperator(ctx, id)
+}
+
+// AuthenticateOperator checks a username and password, returning the
+// operator or ErrInvalidCredentials. Disabled operators and operators
+// without a password can't sign in this way.
+func (tm *TenantManager) AuthenticateOperator(ctx context.Context, username, password string) (*Operator, error) {
+	o, err := tm.store.GetOperatorByUsername(ctx, username)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	hash := dummyPasswordHash
+	if o != nil && o.PasswordHash != "" {
+		hash = []byte(o.PasswordHash)
+	}
+	match := bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+
+	if o == nil || o.PasswordHash == "" || o.Disabled || !match {
+		return nil, ErrInvalidCredentials
+	}
+	return o, nil
+}
+
+// SignInSSO returns the operator signing in through single sign-on,
+// creating them on first sign-in. The role and tenants come from the
+// identity provider and replace the stored ones on every sign-in; the
+// disabled flag is kept, so operators can still be locked out locally.
+// Operators with a password are local accounts and can't be taken over.
+func (tm *TenantManager) SignInSSO(ctx context.Context, username, role string, tenants []string) (*Operator, error) {
+	o, err := tm.store.GetOperatorByUsername(ctx, username)
+	if errors.Is(err, ErrNotFound) {
+		return tm.CreateOperator(ctx, Operator{Username: username, Role: role, Tenants: tenants}, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case o.PasswordHash != "":
+		return nil, fmt.Errorf("%s: %w", username, ErrLocalOperator)
+	case o.Disabled:
+		return nil, fmt.Errorf("%s: %w", username, ErrOperatorDisabled)
+	case o.Role == role && strings.Join(o.Tenants, ",") == strings.Join(tenants, ","):
+		return o, nil
+	}
+
+	o.Role, o.Tenants = role, tenants
+	return tm.UpdateOperator(ctx, *o, "")
+}
+
+// CreateSession starts a session for the operator, returning the token for
+// the session cookie. Only its hash is stored.
+func (tm *TenantManager) CreateSession(ctx context.Context, operatorID string, ttl time.Duration, sourceIP, userAgent string) (string, *Session, error) {
+	token, err := randomString(32)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate session: %w", err)
+	}
+	csrf, err := randomString(32)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate session: %w", err)
+	}
+
+	sess := Session{
+		ID:         hashAPIKey(token),
+		OperatorID: operatorID,
+		CSRFToken:  csrf,
+		SourceIP:   sourceIP,
+		UserAgent:  userAgent,
+		ExpiresAt:  time.Now().Add(ttl).UTC().Truncate(time.Second),
+	}
+	if err := tm.store.CreateSession(ctx, sess); err != nil {
+		return "", nil, err
+	}
+	return token, &sess, nil
+}
+
+// SessionOperator returns the session for a cookie token and its operator,
+// or ErrNotFound / ErrSessionExpired. Sessions of disabled operators are
+// reported as not found.
+func (tm *TenantManager) SessionOperator(ctx context.Context, token string) (*Session, *Operator, error) {
+	sess, err := tm.store.GetSession(ctx, hashAPIKey(token))
+	if err != nil {
+		return nil, nil, err
+	}
+	if !time.Now().Before(sess.ExpiresAt) {
+		return nil, nil, ErrSessionExpired
+	}
+
+	o, err := tm.store.GetOperator(ctx, sess.OperatorID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if o.Disabled {
+		return nil, nil, ErrNotFound
+	}
+	return sess, o, nil
+}
+
+// DeleteSession ends the session with the given cookie token.
+func (tm *TenantManager) DeleteSession(ctx context.Context, token string) error {
+	return tm.store.DeleteSession(ctx, hashAPIKey(token))
+}
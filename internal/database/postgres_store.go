@@ -0,0 +1,107 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// postgresChannel is the LISTEN/NOTIFY channel the tenants and domains
+// triggers publish to. Tenant changes publish an empty payload (reset).
+const postgresChannel = "tenant_changes"
+
+// PostgresStore is a TenantStore backed by PostgreSQL, so several router
+// replicas can share one source of truth. Changes are published by a trigger
+// via NOTIFY, which makes Watch see writes from every instance and tool.
+type PostgresStore struct {
+	sqlStore
+	dsn string
+}
+
+func NewPostgresStore(dsn string, autoMigrate bool) (*PostgresStore, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("postgres driver requires DATABASE_URL")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	if err := prepareSchema(db, DriverPostgres, autoMigrate); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+
+	return &PostgresStore{
+		sqlStore: sqlStore{
+			db:                    db,
+			postgres:              true,
+			isUniqueViolation:     postgresError("23505"),
+			isForeignKeyViolation: postgresError("23503"),
+		},
+		dsn: dsn,
+	}, nil
+}
+
+func postgresError(code pq.ErrorCode) func(err error) bool {
+	return func(err error) bool {
+		var pqErr *pq.Error
+		return errors.As(err, &pqErr) && pqErr.Code == code
+	}
+}
+
+// Watch listens on the tenants NOTIFY channel. After a reconnect, events
+// that may have been missed are covered by a reset event (empty Domain).
+func (s *PostgresStore) Watch(ctx context.Context) (<-chan ChangeEvent, error) {
+	listener := pq.NewListener(s.dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("[DB] WARNING: postgres listener: %v", err)
+		}
+	})
+
+	if err := listener.Listen(postgresChannel); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to listen for tenant changes: %w", err)
+	}
+
+	ch := make(chan ChangeEvent, 64)
+	go func() {
+		defer close(ch)
+		defer listener.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-listener.Notify:
+				// A nil notification means the connection was re-established
+				ev := ChangeEvent{}
+				if n != nil {
+					ev.Domain = n.Extra
+				}
+				select {
+				case ch <- ev:
+				case <-ctx.Done():
+					return
+				}
+			case <-time.After(90 * time.Second):
+				go listener.Ping()
+			}
+		}
+	}()
+
+	return ch, nil
+}
@@ -0,0 +1,228 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// RollbackStep is one write made by a rollback.
+type RollbackStep struct {
+	Kind   string `json:"kind"`
+	Target string `json:"target"`
+	Action string `json:"action"`
+}
+
+// RollbackWrites are the writes of a rollback, made by
+// TenantStore.ApplyRollback in this order. Tenants and Domains with a
+// Revision of 0 are created, the others replace the stored record, which
+// must still be at that revision; Deleted domains are deleted at their
+// Revision.
+type RollbackWrites struct {
+	Tenants []Tenant
+	Domains []Domain
+	Deleted []Domain
+}
+
+// ListChanges returns history entries matching f, newest first.
+func (tm *TenantManager) ListChanges(f ChangeFilter) ([]Change, error) {
+	return tm.store.ListChanges(context.Background(), f)
+}
+
+// GetChange returns one history entry.
+func (tm *TenantManager) GetChange(id int64) (*Change, error) {
+	return tm.store.GetChange(context.Background(), id)
+}
+
+// RollbackTenant restores a tenant and its domains to their state right
+// after change to, which must be part of the tenant's history: settings are
+// reverted, domains deleted since are recreated and domains added since are
+// removed. With domain set only that domain is restored. The writes are
+// made in one transaction, so a rollback is applied entirely or not at all,
+// and are recorded in the history like any other, so a rollback can itself
+// be rolled back.
+func (tm *TenantManager) RollbackTenant(ctx context.Context, tenantID string, to int64, domain string) ([]RollbackStep, error) {
+	history, err := tm.store.ListChanges(ctx, ChangeFilter{TenantID: tenantID})
+	if err != nil {
+		return nil, err
+	}
+
+	found := false
+	var domains []string
+	seen := make(map[string]bool)
+	for _, c := range history {
+		if c.ID == to {
+			found = true
+		}
+		if c.Kind == KindDomain && !seen[c.Target] && (domain == "" || c.Target == domain) {
+			seen[c.Target] = true
+			domains = append(domains, c.Target)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("change %d of tenant %s: %w", to, tenantID, ErrNotFound)
+	}
+	if domain != "" && len(domains) == 0 {
+		return nil, fmt.Errorf("domain %s in the history of tenant %s: %w", domain, tenantID, ErrNotFound)
+	}
+
+	var writes RollbackWrites
+	var steps []RollbackStep
+
+	// The tenant goes first, so that restored domains can reference it
+	if domain == "" {
+		step, err := tm.rollbackTenantRecord(ctx, &writes, tenantID, to)
+		if err != nil {
+			return nil, err
+		}
+		if step != nil {
+			steps = append(steps, *step)
+		}
+	}
+
+	for _, name := range domains {
+		step, err := tm.rollbackDomainRecord(ctx, &writes, tenantID, name, to)
+		if err != nil {
+			return nil, err
+		}
+		if step != nil {
+			steps = append(steps, *step)
+		}
+	}
+	if len(steps) == 0 {
+		return nil, nil
+	}
+
+	// Domains restored to another tenant recreate it if it was deleted
+	restored := make(map[string]bool)
+	for _, t := range writes.Tenants {
+		restored[t.ID] = true
+	}
+	for _, d := range writes.Domains {
+		if restored[d.TenantID] {
+			continue
+		}
+		restored[d.TenantID] = true
+		if _, err := tm.store.GetTenant(ctx, d.TenantID); errors.Is(err, ErrNotFound) {
+			writes.Tenants = append(writes.Tenants, Tenant{ID: d.TenantID, Name: d.TenantID})
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	ctx = withChangeNote(ctx, fmt.Sprintf("rollback to change %d", to))
+	if err := tm.store.ApplyRollback(ctx, writes); err != nil {
+		return nil, err
+	}
+
+	for _, d := range writes.Domains {
+		tm.invalidateCache(d.Name)
+	}
+	for _, d := range writes.Deleted {
+		forgetPattern(d.Name)
+		tm.invalidateCache(d.Name)
+	}
+	tm.invalidateTenant(tenantID)
+
+	return steps, nil
+}
+
+// rollbackTenantRecord adds the write restoring the tenant to writes.
+func (tm *TenantManager) rollbackTenantRecord(ctx context.Context, writes *RollbackWrites, id string, to int64) (*RollbackStep, error) {
+	state, err := tm.stateAt(ctx, KindTenant, id, to)
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return nil, fmt.Errorf("%w: tenant %s did not exist at change %d", ErrConflict, id, to)
+	}
+	var want Tenant
+	if err := json.Unmarshal(state, &want); err != nil {
+		return nil, fmt.Errorf("invalid state of tenant %s: %w", id, err)
+	}
+
+	current, err := tm.store.GetTenant(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		want.Revision = 0
+		writes.Tenants = append(writes.Tenants, want)
+		return &RollbackStep{Kind: KindTenant, Target: id, Action: ChangeCreate}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	want.Revision, want.CreatedAt, want.UpdatedAt = current.Revision, current.CreatedAt, current.UpdatedAt
+	if reflect.DeepEqual(current, &want) {
+		return nil, nil
+	}
+	writes.Tenants = append(writes.Tenants, want)
+	return &RollbackStep{Kind: KindTenant, Target: id, Action: ChangeUpdate}, nil
+}
+
+// rollbackDomainRecord adds the write restoring one domain to writes.
+// Domains that belong to another tenant both now and at change to are left
+// alone.
+func (tm *TenantManager) rollbackDomainRecord(ctx context.Context, writes *RollbackWrites, tenantID, name string, to int64) (*RollbackStep, error) {
+	state, err := tm.stateAt(ctx, KindDomain, name, to)
+	if err != nil {
+		return nil, err
+	}
+	var want *Domain
+	if state != nil {
+		if err := json.Unmarshal(state, &want); err != nil {
+			return nil, fmt.Errorf("invalid state of domain %s: %w", name, err)
+		}
+	}
+
+	current, err := tm.store.GetDomain(ctx, name)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	owned := func(d *Domain) bool { return d != nil && d.TenantID == tenantID }
+	if !owned(want) && !owned(current) {
+		return nil, nil
+	}
+
+	step := &RollbackStep{Kind: KindDomain, Target: name}
+	switch {
+	case want == nil:
+		step.Action = ChangeDelete
+		writes.Deleted = append(writes.Deleted, *current)
+
+	case current == nil:
+		step.Action = ChangeCreate
+		want.Revision = 0
+		writes.Domains = append(writes.Domains, *want)
+
+	default:
+		want.Revision, want.CreatedAt = current.Revision, current.CreatedAt
+		if reflect.DeepEqual(current, want) {
+			return nil, nil
+		}
+		step.Action = ChangeUpdate
+		writes.Domains = append(writes.Domains, *want)
+	}
+	return step, nil
+}
+
+// stateAt returns the JSON state of a record right after change to, or nil
+// if it didn't exist then. For a record whose first recorded change is later
+// than to, the state before that change is used.
+func (tm *TenantManager) stateAt(ctx context.Context, kind, target string, to int64) (json.RawMessage, error) {
+	history, err := tm.store.ListChanges(ctx, ChangeFilter{Kind: kind, Target: target})
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range history {
+		if c.ID <= to {
+			return c.After, nil
+		}
+	}
+	if len(history) == 0 {
+		return nil, nil
+	}
+	return history[len(history)-1].Before, nil
+}
@@ -0,0 +1,366 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+	"maps"
+	"reflect"
+	"strings"
+)
+
+// routeTable is a snapshot of every domain, indexed for host lookups:
+// exact names in a map and wildcard patterns in a trie of their labels,
+// rightmost first. It also holds the tenants and their maintenance
+// windows, so resolving a host costs the same however many domains and
+// tenants there are, and unknown hosts, such as random subdomains, never
+// reach the store. Tables aren't modified once built: a change copies the
+// index it touches into a new table and swaps that in.
+type routeTable struct {
+	exact     map[string]*Domain
+	root      *routeNode
+	wildcards []Domain // By precedence, for explaining lookups
+	tenants   map[string]*Tenant
+	windows   map[string][]MaintenanceWindow // By tenant ID
+}
+
+// routeNode is reached by the labels of a pattern from the right.
+type routeNode struct {
+	literal  map[string]*routeNode // Children by literal label
+	wild     []routeEdge           // Children by *, partial and capture labels
+	anyDepth []*Domain             // Patterns whose leftmost label, **, is next
+	domains  []*Domain             // Patterns that end here
+}
+
+// routeEdge leads to the node for a label that isn't literal.
+type routeEdge struct {
+	label patternLabel
+	node  *routeNode
+}
+
+// buildRoutes indexes domains, tenants and windows, which the table keeps.
+// Patterns that don't parse are left out, as they never match.
+func buildRoutes(domains []Domain, tenants []Tenant, windows []MaintenanceWindow) *routeTable {
+	t := &routeTable{
+		exact:   make(map[string]*Domain, len(domains)),
+		tenants: make(map[string]*Tenant, len(tenants)),
+		windows: make(map[string][]MaintenanceWindow),
+	}
+	var wildcards []Domain
+	for i := range domains {
+		if isWildcard(domains[i].Name) {
+			wildcards = append(wildcards, domains[i])
+			continue
+		}
+		t.exact[domains[i].Name] = &domains[i]
+	}
+	t.indexWildcards(wildcards)
+
+	for i := range tenants {
+		t.tenants[tenants[i].ID] = &tenants[i]
+	}
+	for _, w := range windows {
+		t.windows[w.TenantID] = append(t.windows[w.TenantID], w)
+	}
+	return t
+}
+
+// indexWildcards replaces the table's patterns with wildcards.
+func (t *routeTable) indexWildcards(wildcards []Domain) {
+	sortWildcards(wildcards)
+	t.wildcards = wildcards
+	t.root = &routeNode{}
+	for i := range t.wildcards {
+		d := &t.wildcards[i]
+		p, err := parsePattern(d.Name)
+		if err != nil {
+			continue
+		}
+		t.root.insert(p.labels, d)
+	}
+}
+
+// domain returns the stored record of the domain or pattern name, or nil.
+func (t *routeTable) domain(name string) *Domain {
+	if !isWildcard(name) {
+		return t.exact[name]
+	}
+	for i := range t.wildcards {
+		if t.wildcards[i].Name == name {
+			return &t.wildcards[i]
+		}
+	}
+	return nil
+}
+
+// withDomain returns a copy of t in which the domain name is d, or is
+// removed if d is nil.
+func (t *routeTable) withDomain(name string, d *Domain) *routeTable {
+	next := *t
+	if !isWildcard(name) {
+		next.exact = maps.Clone(t.exact)
+		if d != nil {
+			next.exact[name] = d
+		} else {
+			delete(next.exact, name)
+		}
+		return &next
+	}
+
+	wildcards := make([]Domain, 0, len(t.wildcards)+1)
+	for _, w := range t.wildcards {
+		if w.Name != name {
+			wildcards = append(wildcards, w)
+		}
+	}
+	if d != nil {
+		wildcards = append(wildcards, *d)
+	}
+	next.indexWildcards(wildcards)
+	return &next
+}
+
+// withTenant returns a copy of t in which the tenant id is tenant with
+// windows, or is removed if tenant is nil.
+func (t *routeTable) withTenant(id string, tenant *Tenant, windows []MaintenanceWindow) *routeTable {
+	next := *t
+	next.tenants = maps.Clone(t.tenants)
+	next.windows = maps.Clone(t.windows)
+	if tenant != nil {
+		next.tenants[id] = tenant
+	} else {
+		delete(next.tenants, id)
+	}
+	if len(windows) > 0 {
+		next.windows[id] = windows
+	} else {
+		delete(next.windows, id)
+	}
+	return &next
+}
+
+func (n *routeNode) insert(labels []patternLabel, d *Domain) {
+	for len(labels) > 0 {
+		label := labels[len(labels)-1]
+		labels = labels[:len(labels)-1]
+
+		switch label.kind {
+		case labelAnyDepth:
+			n.anyDepth = append(n.anyDepth, d)
+			return
+		case labelLiteral:
+			if n.literal == nil {
+				n.literal = make(map[string]*routeNode)
+			}
+			child := n.literal[label.text]
+			if child == nil {
+				child = &routeNode{}
+				n.literal[label.text] = child
+			}
+			n = child
+		default:
+			n = n.wildChild(label)
+		}
+	}
+	n.domains = append(n.domains, d)
+}
+
+// wildChild returns the child for a label that isn't literal, shared by
+// patterns with the same label.
+func (n *routeNode) wildChild(label patternLabel) *routeNode {
+	for _, e := range n.wild {
+		if e.label.text == label.text {
+			return e.node
+		}
+	}
+	child := &routeNode{}
+	n.wild = append(n.wild, routeEdge{label: label, node: child})
+	return child
+}
+
+// lookup returns the most specific pattern host matches, or nil.
+func (t *routeTable) lookup(host string) *Domain {
+	var best *Domain
+	t.root.lookup(strings.Split(host, "."), &best)
+	return best
+}
+
+// lookup follows every branch the remaining host labels match, keeping
+// the most specific pattern found in best.
+func (n *routeNode) lookup(labels []string, best **Domain) {
+	if len(labels) == 0 {
+		for _, d := range n.domains {
+			keepBest(best, d)
+		}
+		return
+	}
+	// ** matches the one or more labels that are left
+	for _, d := range n.anyDepth {
+		keepBest(best, d)
+	}
+
+	label, rest := labels[len(labels)-1], labels[:len(labels)-1]
+	if child := n.literal[label]; child != nil {
+		child.lookup(rest, best)
+	}
+	for _, e := range n.wild {
+		if _, ok := e.label.match(label); ok {
+			e.node.lookup(rest, best)
+		}
+	}
+}
+
+func keepBest(best **Domain, d *Domain) {
+	if *best == nil || comparePatterns(d.Name, (*best).Name) < 0 {
+		*best = d
+	}
+}
+
+// loadRoutes builds a routing table from the store.
+func (tm *TenantManager) loadRoutes(ctx context.Context) (*routeTable, error) {
+	domains, err := tm.store.ListDomains(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	tenants, err := tm.store.ListTenants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	windows, err := tm.store.ListMaintenanceWindows(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	return buildRoutes(domains, tenants, windows), nil
+}
+
+// reloadRoutes rebuilds the routing table from the store and swaps it in.
+// Until a failed reload is retried by the next change, hosts are resolved
+// from the store.
+func (tm *TenantManager) reloadRoutes() {
+	if !tm.cacheEnabled {
+		return
+	}
+
+	tm.routesMutex.Lock()
+	defer tm.routesMutex.Unlock()
+
+	tm.reloadRoutesLocked()
+}
+
+func (tm *TenantManager) reloadRoutesLocked() {
+	routes, err := tm.loadRoutes(context.Background())
+	if err != nil {
+		log.Printf("[DB] WARNING: failed to reload routing table, resolving from the store: %v", err)
+		tm.routes.Store(nil)
+		return
+	}
+	tm.routes.Store(routes)
+	keepPatterns(routes.wildcards)
+}
+
+// updateDomainRoute reads one domain from the store into the routing
+// table, and reports whether that changed the table. A write is reported
+// several times (by the writer, by the store's Watch and, with SQLite, by
+// its change feed), so a domain that's already up to date is left alone.
+func (tm *TenantManager) updateDomainRoute(name string) bool {
+	tm.routesMutex.Lock()
+	defer tm.routesMutex.Unlock()
+
+	routes := tm.routes.Load()
+	if routes == nil {
+		tm.reloadRoutesLocked()
+		return true
+	}
+
+	ctx := context.Background()
+	d, err := tm.store.GetDomain(ctx, name)
+	if errors.Is(err, ErrNotFound) {
+		d, err = nil, nil
+	}
+	if err != nil {
+		log.Printf("[DB] WARNING: failed to update routing table for %s, resolving from the store: %v", name, err)
+		tm.routes.Store(nil)
+		return true
+	}
+
+	current := routes.domain(name)
+	if d == nil && current == nil || d != nil && current != nil && reflect.DeepEqual(d, current) {
+		return false
+	}
+	routes = routes.withDomain(name, d)
+	if d == nil && isWildcard(name) {
+		forgetPattern(name)
+	}
+
+	// The tenant is created just before its first domain
+	if d != nil && routes.tenants[d.TenantID] == nil {
+		t, windows, err := tm.storedTenant(ctx, d.TenantID)
+		if err != nil {
+			log.Printf("[DB] WARNING: failed to update routing table for %s, resolving from the store: %v", name, err)
+			tm.routes.Store(nil)
+			return true
+		}
+		routes = routes.withTenant(d.TenantID, t, windows)
+	}
+	tm.routes.Store(routes)
+	return true
+}
+
+// updateTenantRoute is updateDomainRoute for a tenant and its maintenance
+// windows.
+func (tm *TenantManager) updateTenantRoute(id string) bool {
+	tm.routesMutex.Lock()
+	defer tm.routesMutex.Unlock()
+
+	routes := tm.routes.Load()
+	if routes == nil {
+		tm.reloadRoutesLocked()
+		return true
+	}
+
+	t, windows, err := tm.storedTenant(context.Background(), id)
+	if err != nil {
+		log.Printf("[DB] WARNING: failed to update routing table for tenant %s, resolving from the store: %v", id, err)
+		tm.routes.Store(nil)
+		return true
+	}
+	if reflect.DeepEqual(t, routes.tenants[id]) && reflect.DeepEqual(windows, routes.windows[id]) {
+		return false
+	}
+	tm.routes.Store(routes.withTenant(id, t, windows))
+	return true
+}
+
+// storedTenant reads a tenant and its windows from the store; a tenant
+// that doesn't exist is nil.
+func (tm *TenantManager) storedTenant(ctx context.Context, id string) (*Tenant, []MaintenanceWindow, error) {
+	t, err := tm.store.GetTenant(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	windows, err := tm.store.ListMaintenanceWindows(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return t, windows, nil
+}
+
+// routedTenant returns the tenant and its windows from the routing table,
+// or from the store without one.
+func (tm *TenantManager) routedTenant(id string) (*Tenant, []MaintenanceWindow, error) {
+	if routes := tm.routes.Load(); routes != nil {
+		if t := routes.tenants[id]; t != nil {
+			return t, routes.windows[id], nil
+		}
+		return nil, nil, ErrNotFound
+	}
+
+	t, windows, err := tm.storedTenant(context.Background(), id)
+	if err == nil && t == nil {
+		err = ErrNotFound
+	}
+	return t, windows, err
+}
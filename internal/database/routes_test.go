@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// BenchmarkGetTenantInfo measures resolving a host with 10 to 10000
+// tenants, each with an exact domain and a wildcard pattern, with caching
+// disabled (every lookup queries the store) and enabled (lookups use the
+// routing table). Hosts are never repeated, so the cache of resolved hosts
+// doesn't hide the lookup: "wildcard" hosts match a random tenant's
+// pattern, "unknown" hosts match nothing, like a flood of random
+// subdomains. With caching enabled, the time per lookup should not grow
+// with the number of tenants:
+//
+//	go test -run '^$' -bench GetTenantInfo ./internal/database
+func BenchmarkGetTenantInfo(b *testing.B) {
+	for _, size := range []int{10, 100, 1000, 10000} {
+		store, err := OpenStore(DriverSQLite, filepath.Join(b.TempDir(), "tenants.db"), true)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := populate(store, size); err != nil {
+			b.Fatal(err)
+		}
+
+		for _, cache := range []bool{false, true} {
+			tm, err := NewTenantManager(store, cache)
+			if err != nil {
+				b.Fatal(err)
+			}
+			hosts := map[string]func(i int) string{
+				"wildcard": func(i int) string {
+					return fmt.Sprintf("host%d.tenant%d.example.com", i, rand.Intn(size))
+				},
+				"unknown": func(i int) string {
+					return fmt.Sprintf("host%d.unknown.example.org", i)
+				},
+			}
+			for _, kind := range []string{"wildcard", "unknown"} {
+				host := hosts[kind]
+				b.Run(fmt.Sprintf("tenants=%d/cache=%v/%s", size, cache, kind), func(b *testing.B) {
+					for i := 0; i < b.N; i++ {
+						tm.GetTenantInfo(host(i))
+					}
+				})
+			}
+
+			// The last manager closes the store
+			if cache {
+				tm.Close()
+			}
+		}
+	}
+}
+
+// populate adds tenant0 to tenant<size-1> with the domains
+// tenantN.example.com and *.tenantN.example.com.
+func populate(s TenantStore, size int) error {
+	ctx := WithActor(context.Background(), "bench")
+	for n := 0; n < size; n++ {
+		id := "tenant" + strconv.Itoa(n)
+		if err := s.AddTenant(ctx, Tenant{ID: id}); err != nil {
+			return err
+		}
+		for _, name := range []string{id + ".example.com", "*." + id + ".example.com"} {
+			if err := s.AddDomain(ctx, Domain{Name: name, TenantID: id}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
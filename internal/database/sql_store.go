@@ -0,0 +1,716 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sqlStore holds the queries shared by the SQLite and PostgreSQL stores.
+// Queries are written with ? placeholders and rebound for PostgreSQL.
+type sqlStore struct {
+	db       *sql.DB
+	postgres bool
+
+	// isUniqueViolation reports whether err is a primary key conflict
+	isUniqueViolation func(err error) bool
+	// isForeignKeyViolation reports whether err is a missing/in-use reference
+	isForeignKeyViolation func(err error) bool
+	// changed is called after a successful write
+	changed func(ev ChangeEvent)
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqlStore) rebind(query string) string {
+	if !s.postgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// ts renders a timestamp column in UTC RFC 3339, as the SQLite driver returns
+// it, so both stores list identically.
+func (s *sqlStore) ts(column string) string {
+	if s.postgres {
+		return `to_char(` + column + ` AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"')`
+	}
+	return column
+}
+
+// forUpdate returns the row locking clause for a SELECT inside a write
+// transaction. SQLite needs none: its write transactions begin immediately
+// and exclude other writers.
+func (s *sqlStore) forUpdate(lock bool) string {
+	if lock && s.postgres {
+		return " FOR UPDATE"
+	}
+	return ""
+}
+
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// inTx runs fn in a transaction, committing if it returns nil.
+func (s *sqlStore) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) notify(domain string) {
+	if s.changed != nil {
+		s.changed(ChangeEvent{Domain: domain})
+	}
+}
+
+func (s *sqlStore) notifyTenant(id string) {
+	if s.changed != nil {
+		s.changed(ChangeEvent{Tenant: id})
+	}
+}
+
+// Tenants
+
+func (s *sqlStore) tenantColumns() string {
+	return "id, name, status, project_route, project_port, backend_domain, upstream_protocol, proxy_protocol, metadata, maintenance_allowlist, max_domains, revision, " +
+		s.ts("created_at") + ", " + s.ts("updated_at")
+}
+
+func (s *sqlStore) GetTenant(ctx context.Context, id string) (*Tenant, error) {
+	return s.getTenant(ctx, s.db, id, false)
+}
+
+// getTenant reads a tenant through q; with lock the row stays locked until
+// the transaction ends (PostgreSQL; SQLite write transactions are exclusive).
+func (s *sqlStore) getTenant(ctx context.Context, q querier, id string, lock bool) (*Tenant, error) {
+	row := q.QueryRowContext(ctx, s.rebind("SELECT "+s.tenantColumns()+" FROM tenants WHERE id = ?"+s.forUpdate(lock)), id)
+	t, err := scanTenant(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return t, nil
+}
+
+func (s *sqlStore) ListTenants(ctx context.Context) ([]Tenant, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+s.tenantColumns()+" FROM tenants ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenants: %w", err)
+	}
+	defer rows.Close()
+
+	var tenants []Tenant
+	for rows.Next() {
+		t, err := scanTenant(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan tenant: %w", err)
+		}
+		tenants = append(tenants, *t)
+	}
+	return tenants, rows.Err()
+}
+
+func (s *sqlStore) AddTenant(ctx context.Context, t Tenant) error {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		return s.addTenant(ctx, tx, t)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.notifyTenant(t.ID)
+	return nil
+}
+
+func (s *sqlStore) addTenant(ctx context.Context, tx *sql.Tx, t Tenant) error {
+	args, err := tenantArgs(t)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+
+	_, err = tx.ExecContext(ctx, s.rebind(
+		"INSERT INTO tenants (id, name, status, project_route, project_port, backend_domain, upstream_protocol, proxy_protocol, metadata, maintenance_allowlist, max_domains, created_at, updated_at) "+
+			"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+		append(args, now, now)...,
+	)
+	if s.isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add tenant: %w", err)
+	}
+
+	after, err := s.getTenant(ctx, tx, t.ID, false)
+	if err != nil {
+		return err
+	}
+	return s.recordTenantChange(ctx, tx, nil, after)
+}
+
+func (s *sqlStore) UpdateTenant(ctx context.Context, t Tenant) error {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		return s.updateTenant(ctx, tx, t)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.notifyTenant(t.ID)
+	return nil
+}
+
+func (s *sqlStore) updateTenant(ctx context.Context, tx *sql.Tx, t Tenant) error {
+	args, err := tenantArgs(t)
+	if err != nil {
+		return err
+	}
+
+	before, err := s.getTenant(ctx, tx, t.ID, true)
+	if err != nil {
+		return err
+	}
+	if t.Revision != 0 && t.Revision != before.Revision {
+		return ErrRevisionMismatch
+	}
+
+	_, err = tx.ExecContext(ctx, s.rebind(
+		"UPDATE tenants SET name = ?, status = ?, project_route = ?, project_port = ?, backend_domain = ?, upstream_protocol = ?, proxy_protocol = ?, metadata = ?, maintenance_allowlist = ?, max_domains = ?, updated_at = ?, revision = revision + 1 "+
+			"WHERE id = ?"),
+		append(args[1:], time.Now().UTC().Truncate(time.Second), t.ID)...,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update tenant: %w", err)
+	}
+
+	after, err := s.getTenant(ctx, tx, t.ID, false)
+	if err != nil {
+		return err
+	}
+	return s.recordTenantChange(ctx, tx, before, after)
+}
+
+func (s *sqlStore) DeleteTenant(ctx context.Context, id string, revision int64) error {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := s.getTenant(ctx, tx, id, true)
+		if err != nil {
+			return err
+		}
+		if revision != 0 && revision != before.Revision {
+			return ErrRevisionMismatch
+		}
+
+		var domains int
+		if err := tx.QueryRowContext(ctx, s.rebind("SELECT COUNT(*) FROM domains WHERE tenant_id = ?"), id).Scan(&domains); err != nil {
+			return fmt.Errorf("failed to delete tenant: %w", err)
+		}
+		if domains > 0 {
+			return fmt.Errorf("%w (%d)", ErrTenantHasDomains, domains)
+		}
+
+		_, err = tx.ExecContext(ctx, s.rebind("DELETE FROM tenants WHERE id = ?"), id)
+		if s.isForeignKeyViolation(err) {
+			return ErrTenantHasDomains
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete tenant: %w", err)
+		}
+		return s.recordTenantChange(ctx, tx, before, nil)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.notifyTenant(id)
+	return nil
+}
+
+// Domains
+
+func (s *sqlStore) domainColumns() string {
+	return "domain, tenant_id, project_route, project_port, backend_domain, upstream_protocol, proxy_protocol, request_headers, status, revision, " + s.ts("created_at")
+}
+
+func (s *sqlStore) GetDomain(ctx context.Context, domain string) (*Domain, error) {
+	return s.getDomain(ctx, s.db, domain, false)
+}
+
+func (s *sqlStore) getDomain(ctx context.Context, q querier, domain string, lock bool) (*Domain, error) {
+	row := q.QueryRowContext(ctx, s.rebind("SELECT "+s.domainColumns()+" FROM domains WHERE domain = ?"+s.forUpdate(lock)), domain)
+	d, err := scanDomain(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return d, nil
+}
+
+func (s *sqlStore) ListDomains(ctx context.Context, tenantID string) ([]Domain, error) {
+	if tenantID == "" {
+		return s.queryDomains(ctx, "SELECT "+s.domainColumns()+" FROM domains ORDER BY domain")
+	}
+	return s.queryDomains(ctx, "SELECT "+s.domainColumns()+" FROM domains WHERE tenant_id = ? ORDER BY domain", tenantID)
+}
+
+func (s *sqlStore) ListWildcardDomains(ctx context.Context) ([]Domain, error) {
+	return s.queryDomains(ctx, "SELECT "+s.domainColumns()+" FROM domains WHERE domain LIKE '%*%' OR domain LIKE '%{%' ORDER BY domain")
+}
+
+func (s *sqlStore) queryDomains(ctx context.Context, query string, args ...interface{}) ([]Domain, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list domains: %w", err)
+	}
+	defer rows.Close()
+
+	var domains []Domain
+	for rows.Next() {
+		d, err := scanDomain(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan domain: %w", err)
+		}
+		domains = append(domains, *d)
+	}
+	return domains, rows.Err()
+}
+
+func (s *sqlStore) AddDomain(ctx context.Context, d Domain) error {
+	return s.AddDomainWithinQuota(ctx, d, nil, -1)
+}
+
+func (s *sqlStore) AddDomainWithinQuota(ctx context.Context, d Domain, v *DomainVerification, defaultQuota int) error {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		return s.addDomain(ctx, tx, d, v, defaultQuota)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.notify(d.Name)
+	return nil
+}
+
+// addDomain inserts a domain and, if v isn't nil, its verification; with a
+// defaultQuota of 0 or more the tenant must exist and have fewer domains
+// than its quota.
+func (s *sqlStore) addDomain(ctx context.Context, tx *sql.Tx, d Domain, v *DomainVerification, defaultQuota int) error {
+	if defaultQuota >= 0 {
+		// Locking the tenant serializes concurrent adds to its quota
+		t, err := s.getTenant(ctx, tx, d.TenantID, true)
+		if errors.Is(err, ErrNotFound) {
+			return fmt.Errorf("tenant %s: %w", d.TenantID, ErrNotFound)
+		}
+		if err != nil {
+			return err
+		}
+		quota := defaultQuota
+		if t.MaxDomains != nil {
+			quota = *t.MaxDomains
+		}
+
+		var domains int
+		if err := tx.QueryRowContext(ctx, s.rebind("SELECT COUNT(*) FROM domains WHERE tenant_id = ?"), d.TenantID).Scan(&domains); err != nil {
+			return fmt.Errorf("failed to add domain: %w", err)
+		}
+		if domains >= quota {
+			return fmt.Errorf("%w: tenant %s has %d of %d domains", ErrQuotaExceeded, d.TenantID, domains, quota)
+		}
+	}
+
+	// Domains are only pending while their ownership is being verified
+	status := DomainActive
+	if v != nil {
+		status = DomainPending
+	}
+	_, err := tx.ExecContext(ctx, s.rebind(
+		"INSERT INTO domains (domain, tenant_id, project_route, project_port, backend_domain, upstream_protocol, proxy_protocol, request_headers, status) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+		append(domainArgs(d), status)...,
+	)
+	if s.isUniqueViolation(err) {
+		return ErrConflict
+	}
+	if s.isForeignKeyViolation(err) {
+		return fmt.Errorf("tenant %s: %w", d.TenantID, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to add domain: %w", err)
+	}
+	if v != nil {
+		v.Domain = d.Name
+		if err := s.insertDomainVerification(ctx, tx, *v); err != nil {
+			return err
+		}
+	}
+
+	after, err := s.getDomain(ctx, tx, d.Name, false)
+	if err != nil {
+		return err
+	}
+	return s.recordDomainChange(ctx, tx, nil, after)
+}
+
+func (s *sqlStore) UpdateDomain(ctx context.Context, d Domain) error {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		return s.updateDomain(ctx, tx, d)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.notify(d.Name)
+	return nil
+}
+
+func (s *sqlStore) updateDomain(ctx context.Context, tx *sql.Tx, d Domain) error {
+	before, err := s.getDomain(ctx, tx, d.Name, true)
+	if err != nil {
+		return err
+	}
+	if d.Revision != 0 && d.Revision != before.Revision {
+		return ErrRevisionMismatch
+	}
+
+	_, err = tx.ExecContext(ctx, s.rebind(
+		"UPDATE domains SET tenant_id = ?, project_route = ?, project_port = ?, backend_domain = ?, upstream_protocol = ?, proxy_protocol = ?, request_headers = ?, revision = revision + 1 WHERE domain = ?"),
+		append(domainArgs(d)[1:], d.Name)...,
+	)
+	if s.isForeignKeyViolation(err) {
+		return fmt.Errorf("tenant %s: %w", d.TenantID, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update domain: %w", err)
+	}
+
+	after, err := s.getDomain(ctx, tx, d.Name, false)
+	if err != nil {
+		return err
+	}
+	return s.recordDomainChange(ctx, tx, before, after)
+}
+
+func (s *sqlStore) DeleteDomain(ctx context.Context, domain string, revision int64) error {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		return s.deleteDomain(ctx, tx, domain, revision)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.notify(domain)
+	return nil
+}
+
+func (s *sqlStore) deleteDomain(ctx context.Context, tx *sql.Tx, domain string, revision int64) error {
+	before, err := s.getDomain(ctx, tx, domain, true)
+	if err != nil {
+		return err
+	}
+	if revision != 0 && revision != before.Revision {
+		return ErrRevisionMismatch
+	}
+
+	if _, err := tx.ExecContext(ctx, s.rebind("DELETE FROM domains WHERE domain = ?"), domain); err != nil {
+		return fmt.Errorf("failed to delete domain: %w", err)
+	}
+	return s.recordDomainChange(ctx, tx, before, nil)
+}
+
+// ApplyRollback makes every write of w in one transaction.
+func (s *sqlStore) ApplyRollback(ctx context.Context, w RollbackWrites) error {
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		for _, t := range w.Tenants {
+			var err error
+			if t.Revision == 0 {
+				err = s.addTenant(ctx, tx, t)
+			} else {
+				err = s.updateTenant(ctx, tx, t)
+			}
+			if err != nil {
+				return fmt.Errorf("tenant %s: %w", t.ID, err)
+			}
+		}
+		for _, d := range w.Domains {
+			var err error
+			if d.Revision == 0 {
+				err = s.addDomain(ctx, tx, d, nil, -1)
+			} else {
+				err = s.updateDomain(ctx, tx, d)
+			}
+			if err != nil {
+				return fmt.Errorf("domain %s: %w", d.Name, err)
+			}
+		}
+		for _, d := range w.Deleted {
+			if err := s.deleteDomain(ctx, tx, d.Name, d.Revision); err != nil {
+				return fmt.Errorf("domain %s: %w", d.Name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, t := range w.Tenants {
+		s.notifyTenant(t.ID)
+	}
+	for _, d := range append(w.Domains, w.Deleted...) {
+		s.notify(d.Name)
+	}
+	return nil
+}
+
+// Maintenance windows
+
+func (s *sqlStore) ListMaintenanceWindows(ctx context.Context, tenantID string) ([]MaintenanceWindow, error) {
+	query := "SELECT id, tenant_id, starts_at, ends_at, message, bypass_ips, " + s.ts("created_at") + " FROM maintenance_windows"
+	var args []interface{}
+	if tenantID != "" {
+		query += " WHERE tenant_id = ?"
+		args = append(args, tenantID)
+	}
+	rows, err := s.db.QueryContext(ctx, s.rebind(query+" ORDER BY starts_at, id"), args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list maintenance windows: %w", err)
+	}
+	defer rows.Close()
+
+	var windows []MaintenanceWindow
+	for rows.Next() {
+		var w MaintenanceWindow
+		var message, bypass, createdAt sql.NullString
+		if err := rows.Scan(&w.ID, &w.TenantID, &w.StartsAt, &w.EndsAt, &message, &bypass, &createdAt); err != nil {
+			return nil, fmt.Errorf("failed to scan maintenance window: %w", err)
+		}
+		w.StartsAt = w.StartsAt.UTC()
+		w.EndsAt = w.EndsAt.UTC()
+		w.Message = message.String
+		if bypass.String != "" {
+			w.BypassIPs = strings.Split(bypass.String, ",")
+		}
+		w.CreatedAt = createdAt.String
+		windows = append(windows, w)
+	}
+	return windows, rows.Err()
+}
+
+func (s *sqlStore) AddMaintenanceWindow(ctx context.Context, w MaintenanceWindow) (*MaintenanceWindow, error) {
+	w.StartsAt = w.StartsAt.UTC().Truncate(time.Second)
+	w.EndsAt = w.EndsAt.UTC().Truncate(time.Second)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	err := s.db.QueryRowContext(ctx, s.rebind(
+		"INSERT INTO maintenance_windows (tenant_id, starts_at, ends_at, message, bypass_ips, created_at) VALUES (?, ?, ?, ?, ?, ?) RETURNING id"),
+		w.TenantID, w.StartsAt, w.EndsAt, nullIfEmpty(w.Message), nullIfEmpty(strings.Join(w.BypassIPs, ",")), now,
+	).Scan(&w.ID)
+	if s.isForeignKeyViolation(err) {
+		return nil, fmt.Errorf("tenant %s: %w", w.TenantID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to add maintenance window: %w", err)
+	}
+	w.CreatedAt = now.Format(time.RFC3339)
+
+	s.notifyTenant(w.TenantID)
+	return &w, nil
+}
+
+func (s *sqlStore) DeleteMaintenanceWindow(ctx context.Context, tenantID string, id int64) error {
+	res, err := s.db.ExecContext(ctx, s.rebind("DELETE FROM maintenance_windows WHERE tenant_id = ? AND id = ?"), tenantID, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete maintenance window: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+
+	s.notifyTenant(tenantID)
+	return nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanTenant reads a row selected with tenantColumns.
+func scanTenant(row rowScanner) (*Tenant, error) {
+	var t Tenant
+	var projectRoute, backendDomain, upstreamProtocol, proxyProtocol, metadata, allowlist, createdAt, updatedAt sql.NullString
+	var projectPort, maxDomains sql.NullInt64
+
+	if err := row.Scan(&t.ID, &t.Name, &t.Status, &projectRoute, &projectPort, &backendDomain, &upstreamProtocol, &proxyProtocol, &metadata, &allowlist, &maxDomains, &t.Revision, &createdAt, &updatedAt); err != nil {
+		return nil, err
+	}
+
+	// Set default if empty
+	t.ProjectRoute = projectRoute.String
+	if t.ProjectRoute == "" {
+		t.ProjectRoute = "/projects/backend"
+	}
+	t.ProjectPort = intPtr(projectPort)
+	t.BackendDomain = stringPtr(backendDomain)
+	t.UpstreamProtocol = upstreamProtocol.String
+	t.ProxyProtocol = proxyProtocol.String
+	t.MaxDomains = intPtr(maxDomains)
+	t.CreatedAt = createdAt.String
+	t.UpdatedAt = updatedAt.String
+	if allowlist.String != "" {
+		t.MaintenanceAllowlist = strings.Split(allowlist.String, ",")
+	}
+
+	if metadata.Valid && metadata.String != "" {
+		if err := json.Unmarshal([]byte(metadata.String), &t.Metadata); err != nil {
+			return nil, fmt.Errorf("invalid metadata for tenant %s: %w", t.ID, err)
+		}
+	}
+
+	return &t, nil
+}
+
+// scanDomain reads a row selected with domainColumns.
+func scanDomain(row rowScanner) (*Domain, error) {
+	var d Domain
+	var projectRoute, backendDomain, upstreamProtocol, proxyProtocol, headers, createdAt sql.NullString
+	var projectPort sql.NullInt64
+
+	if err := row.Scan(&d.Name, &d.TenantID, &projectRoute, &projectPort, &backendDomain, &upstreamProtocol, &proxyProtocol, &headers, &d.Status, &d.Revision, &createdAt); err != nil {
+		return nil, err
+	}
+
+	d.ProjectRoute = projectRoute.String
+	d.ProjectPort = intPtr(projectPort)
+	d.BackendDomain = stringPtr(backendDomain)
+	d.UpstreamProtocol = upstreamProtocol.String
+	d.ProxyProtocol = proxyProtocol.String
+	d.CreatedAt = createdAt.String
+	if headers.Valid && headers.String != "" {
+		if err := json.Unmarshal([]byte(headers.String), &d.RequestHeaders); err != nil {
+			return nil, fmt.Errorf("invalid request headers for domain %s: %w", d.Name, err)
+		}
+	}
+
+	return &d, nil
+}
+
+// tenantArgs returns the column values in the order id, name, status,
+// project_route, project_port, backend_domain, upstream_protocol, proxy_protocol, metadata,
+// maintenance_allowlist, max_domains.
+func tenantArgs(t Tenant) ([]interface{}, error) {
+	projectRoute := t.ProjectRoute
+	if projectRoute == "" {
+		projectRoute = "/projects/backend"
+	}
+
+	status := t.Status
+	if status == "" {
+		status = TenantActive
+	}
+
+	name := t.Name
+	if name == "" {
+		name = t.ID
+	}
+
+	var metadata interface{}
+	if len(t.Metadata) > 0 {
+		raw, err := json.Marshal(t.Metadata)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metadata: %w", err)
+		}
+		metadata = string(raw)
+	}
+
+	return []interface{}{
+		t.ID, name, status, projectRoute, intValue(t.ProjectPort), stringValue(t.BackendDomain),
+		nullIfEmpty(t.UpstreamProtocol), nullIfEmpty(t.ProxyProtocol), metadata,
+		nullIfEmpty(strings.Join(t.MaintenanceAllowlist, ",")), intValue(t.MaxDomains),
+	}, nil
+}
+
+// domainArgs returns the column values in the order domain, tenant_id,
+// project_route, project_port, backend_domain, upstream_protocol, proxy_protocol,
+// request_headers.
+func domainArgs(d Domain) []interface{} {
+	var headers interface{}
+	if len(d.RequestHeaders) > 0 {
+		raw, _ := json.Marshal(d.RequestHeaders) // Can't fail for a map of strings
+		headers = string(raw)
+	}
+
+	return []interface{}{
+		d.Name, d.TenantID, nullIfEmpty(d.ProjectRoute), intValue(d.ProjectPort), stringValue(d.BackendDomain),
+		nullIfEmpty(d.UpstreamProtocol), nullIfEmpty(d.ProxyProtocol), headers,
+	}
+}
+
+func intPtr(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	i := int(v.Int64)
+	return &i
+}
+
+func stringPtr(v sql.NullString) *string {
+	if !v.Valid || v.String == "" {
+		return nil
+	}
+	s := v.String
+	return &s
+}
+
+func intValue(p *int) interface{} {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+func stringValue(p *string) interface{} {
+	if p == nil || *p == "" {
+		return nil
+	}
+	return *p
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
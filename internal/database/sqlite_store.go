@@ -0,0 +1,184 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// DefaultPollInterval is how often Watch polls the change feed for writes
+// by other processes, until SetPollInterval is called.
+const DefaultPollInterval = time.Second
+
+const (
+	// feedRetention is how many changes the feed keeps. A process that falls
+	// further behind reloads everything.
+	feedRetention = 10000
+	// feedBatch is the most changes delivered one by one per poll; a larger
+	// backlog is delivered as a reset.
+	feedBatch = 256
+)
+
+// SQLiteStore is the embedded, single-file TenantStore.
+type SQLiteStore struct {
+	sqlStore
+
+	mu           sync.Mutex
+	watchers     watchers
+	pollInterval time.Duration
+}
+
+func NewSQLiteStore(path string, autoMigrate bool) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite3", sqliteDSN(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if err := prepareSchema(db, DriverSQLite, autoMigrate); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+
+	s := &SQLiteStore{pollInterval: DefaultPollInterval}
+	s.sqlStore = sqlStore{
+		db:                    db,
+		isUniqueViolation:     sqliteConstraint(sqlite3.ErrConstraintPrimaryKey, sqlite3.ErrConstraintUnique),
+		isForeignKeyViolation: sqliteConstraint(sqlite3.ErrConstraintForeignKey),
+		changed:               s.notify,
+	}
+	return s, nil
+}
+
+func sqliteDSN(path string) string {
+	return path + "?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=1&_txlock=immediate"
+}
+
+func sqliteConstraint(codes ...sqlite3.ErrNoExtended) func(err error) bool {
+	return func(err error) bool {
+		var sqliteErr sqlite3.Error
+		if !errors.As(err, &sqliteErr) {
+			return false
+		}
+		for _, code := range codes {
+			if sqliteErr.ExtendedCode == code {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// SetPollInterval sets how often Watch polls the change feed, which bounds
+// how long writes by other processes take to be seen. It applies to Watch
+// calls made afterwards.
+func (s *SQLiteStore) SetPollInterval(d time.Duration) {
+	s.mu.Lock()
+	s.pollInterval = d
+	s.mu.Unlock()
+}
+
+// Watch reports changes made through this store as they happen. SQLite has
+// no change notification across processes, so triggers also append every
+// change to the change_feed table, which Watch polls: writes by other
+// routers and tools sharing the database file are reported within the poll
+// interval. Writes through this store are reported twice; the feed reports
+// changes to tenants without saying which, which reloads everything.
+func (s *SQLiteStore) Watch(ctx context.Context) (<-chan ChangeEvent, error) {
+	var seq int64
+	if err := s.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(seq), 0) FROM change_feed").Scan(&seq); err != nil {
+		return nil, fmt.Errorf("failed to read change feed: %w", err)
+	}
+
+	ch := make(chan ChangeEvent, 64)
+
+	s.mu.Lock()
+	s.watchers.add(ch)
+	interval := s.pollInterval
+	s.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				s.mu.Lock()
+				s.watchers.remove(ch)
+				s.mu.Unlock()
+				close(ch)
+				return
+			case <-ticker.C:
+				seq = s.pollFeed(ctx, ch, seq)
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// pollFeed delivers the changes appended to the feed after seq and returns
+// the last one read. When changes were pruned before they were read, or
+// there are more than feedBatch of them, a reset is delivered instead.
+func (s *SQLiteStore) pollFeed(ctx context.Context, ch chan ChangeEvent, seq int64) int64 {
+	rows, err := s.db.QueryContext(ctx, "SELECT seq, domain FROM change_feed WHERE seq > ? ORDER BY seq LIMIT ?", seq, feedBatch+1)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("[DB] WARNING: failed to poll change feed: %v", err)
+		}
+		return seq
+	}
+	defer rows.Close()
+
+	last := seq
+	reset := false
+	var events []ChangeEvent
+	for rows.Next() {
+		var next int64
+		var domain string
+		if err := rows.Scan(&next, &domain); err != nil {
+			log.Printf("[DB] WARNING: failed to poll change feed: %v", err)
+			return seq
+		}
+		if next != last+1 {
+			reset = true
+		}
+		last = next
+		events = append(events, ChangeEvent{Domain: domain})
+	}
+	if err := rows.Err(); err != nil {
+		if ctx.Err() == nil {
+			log.Printf("[DB] WARNING: failed to poll change feed: %v", err)
+		}
+		return seq
+	}
+	if reset || len(events) > feedBatch {
+		events = []ChangeEvent{{}}
+	}
+
+	s.mu.Lock()
+	for _, ev := range events {
+		deliver(ch, ev)
+	}
+	s.mu.Unlock()
+
+	// Pruned whenever another 1000 changes were read
+	if last/1000 != seq/1000 {
+		if _, err := s.db.ExecContext(ctx, "DELETE FROM change_feed WHERE seq <= ?", last-feedRetention); err != nil && ctx.Err() == nil {
+			log.Printf("[DB] WARNING: failed to prune change feed: %v", err)
+		}
+	}
+	return last
+}
+
+func (s *SQLiteStore) notify(ev ChangeEvent) {
+	s.mu.Lock()
+	s.watchers.notify(ev)
+	s.mu.Unlock()
+}
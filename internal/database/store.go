@@ -0,0 +1,323 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Supported store drivers (DB_DRIVER)
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrConflict         = errors.New("already exists")
+	ErrTenantHasDomains = errors.New("tenant still has domains")
+	ErrRevisionMismatch = errors.New("revision mismatch")
+)
+
+// Tenant is a customer. Its routing settings are the defaults inherited by
+// every domain that belongs to it.
+type Tenant struct {
+	ID               string            `json:"id"`
+	Name             string            `json:"name"`
+	Status           string            `json:"status"`
+	ProjectRoute     string            `json:"project_route"`
+	ProjectPort      *int              `json:"project_port,omitempty"`
+	BackendDomain    *string           `json:"backend_domain,omitempty"`
+	UpstreamProtocol string            `json:"upstream_protocol,omitempty"`
+	ProxyProtocol    string            `json:"proxy_protocol,omitempty"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+	// IPs/CIDRs still routed while the tenant is in maintenance
+	MaintenanceAllowlist []string `json:"maintenance_allowlist,omitempty"`
+	// Domains the tenant may have before its tokens can't add more; nil
+	// uses the router's default (TENANT_API_MAX_DOMAINS)
+	MaxDomains *int `json:"max_domains,omitempty"`
+	// Source is "file" for tenants defined in the tenants file, which
+	// can't be changed through the API
+	Source string `json:"source,omitempty"`
+	// Revision starts at 1 and is incremented by every update
+	Revision  int64  `json:"revision"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// Domain is a host name (or wildcard pattern) routed to a tenant. Empty or
+// nil routing fields inherit the tenant's value.
+type Domain struct {
+	Name             string  `json:"domain"`
+	TenantID         string  `json:"tenant_id"`
+	ProjectRoute     string  `json:"project_route,omitempty"`
+	ProjectPort      *int    `json:"project_port,omitempty"`
+	BackendDomain    *string `json:"backend_domain,omitempty"`
+	UpstreamProtocol string  `json:"upstream_protocol,omitempty"`
+	ProxyProtocol    string  `json:"proxy_protocol,omitempty"`
+	// RequestHeaders are set on requests forwarded to the backend
+	RequestHeaders map[string]string `json:"request_headers,omitempty"`
+	// Status is pending while ownership of a domain claimed through the
+	// tenant API is unverified; pending domains aren't routed
+	Status string `json:"status"`
+	// Source is "file" for domains defined in the tenants file
+	Source    string `json:"source,omitempty"`
+	Revision  int64  `json:"revision"`
+	CreatedAt string `json:"created_at"`
+}
+
+// MaintenanceWindow is a scheduled period during which a tenant is answered
+// with the maintenance page, except for clients in BypassIPs.
+type MaintenanceWindow struct {
+	ID        int64     `json:"id"`
+	TenantID  string    `json:"tenant_id"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	Message   string    `json:"message,omitempty"`
+	BypassIPs []string  `json:"bypass_ips,omitempty"`
+	CreatedAt string    `json:"created_at"`
+}
+
+// ChangeEvent is emitted by TenantStore.Watch whenever a domain row is
+// created, updated or deleted, or a tenant or its maintenance windows
+// change. An event with neither Domain nor Tenant means the change can't be
+// attributed (events were lost, or another process changed a tenant) and
+// everything must be reloaded.
+type ChangeEvent struct {
+	Domain string
+	Tenant string // Set instead of Domain when the tenant itself changed
+}
+
+// TenantStore is the persistence layer behind TenantManager. Domains passed
+// in must already be normalized (lower-case outside of captures, without port).
+type TenantStore interface {
+	// GetTenant returns the tenant with this ID, or ErrNotFound.
+	GetTenant(ctx context.Context, id string) (*Tenant, error)
+
+	// ListTenants returns all tenants ordered by ID.
+	ListTenants(ctx context.Context) ([]Tenant, error)
+
+	// AddTenant creates a tenant, or returns ErrConflict if the ID exists.
+	AddTenant(ctx context.Context, t Tenant) error
+
+	// UpdateTenant replaces an existing tenant, or returns ErrNotFound.
+	// A non-zero t.Revision must match the stored one (ErrRevisionMismatch
+	// otherwise); the stored revision is incremented.
+	UpdateTenant(ctx context.Context, t Tenant) error
+
+	// DeleteTenant removes a tenant, or returns ErrNotFound, or
+	// ErrTenantHasDomains while domains still reference it. A non-zero
+	// revision must match the stored one.
+	DeleteTenant(ctx context.Context, id string, revision int64) error
+
+	// GetDomain returns the record with exactly this domain (no wildcard
+	// matching), or ErrNotFound.
+	GetDomain(ctx context.Context, domain string) (*Domain, error)
+
+	// ListDomains returns domains ordered by name; all of them when
+	// tenantID is empty, otherwise only that tenant's.
+	ListDomains(ctx context.Context, tenantID string) ([]Domain, error)
+
+	// ListWildcardDomains returns all domains whose name is a pattern,
+	// containing a '*' or a '{'.
+	ListWildcardDomains(ctx context.Context) ([]Domain, error)
+
+	// AddDomain creates a domain, or returns ErrConflict if it exists.
+	// The tenant must exist (ErrNotFound otherwise).
+	AddDomain(ctx context.Context, d Domain) error
+
+	// AddDomainWithinQuota creates a domain like AddDomain, but only while
+	// the tenant has fewer domains than its MaxDomains, or defaultQuota if
+	// that is nil (ErrQuotaExceeded otherwise). With a verification the
+	// domain is created pending, along with the verification.
+	AddDomainWithinQuota(ctx context.Context, d Domain, v *DomainVerification, defaultQuota int) error
+
+	// UpdateDomain replaces an existing domain, or returns ErrNotFound.
+	// Revisions are checked and incremented as in UpdateTenant.
+	UpdateDomain(ctx context.Context, d Domain) error
+
+	// DeleteDomain removes a domain, or returns ErrNotFound. A non-zero
+	// revision must match the stored one.
+	DeleteDomain(ctx context.Context, domain string, revision int64) error
+
+	// ApplyRollback makes the writes of a rollback in one transaction:
+	// either all of them are applied or, if one fails, none is.
+	ApplyRollback(ctx context.Context, w RollbackWrites) error
+
+	// ListMaintenanceWindows returns the tenant's windows ordered by start;
+	// every tenant's when tenantID is empty.
+	ListMaintenanceWindows(ctx context.Context, tenantID string) ([]MaintenanceWindow, error)
+
+	// AddMaintenanceWindow stores a new window and returns it with its ID.
+	// The tenant must exist (ErrNotFound otherwise).
+	AddMaintenanceWindow(ctx context.Context, w MaintenanceWindow) (*MaintenanceWindow, error)
+
+	// DeleteMaintenanceWindow removes one of the tenant's windows, or returns
+	// ErrNotFound.
+	DeleteMaintenanceWindow(ctx context.Context, tenantID string, id int64) error
+
+	// ListChanges returns history entries matching f, newest first. Every
+	// tenant and domain write appends one, with the actor from the context
+	// (see WithActor).
+	ListChanges(ctx context.Context, f ChangeFilter) ([]Change, error)
+
+	// GetChange returns one history entry, or ErrNotFound.
+	GetChange(ctx context.Context, id int64) (*Change, error)
+
+	// AppendAudit adds an entry to the audit log, which can't be changed or
+	// deleted afterwards.
+	AppendAudit(ctx context.Context, e AuditEntry) error
+
+	// ListAudit returns audit entries matching f, newest first unless
+	// f.Ascending.
+	ListAudit(ctx context.Context, f AuditFilter) ([]AuditEntry, error)
+
+	// CreateAPIKey stores a new API key, or returns ErrConflict if its name
+	// is taken.
+	CreateAPIKey(ctx context.Context, k APIKey) error
+
+	// GetAPIKey returns an API key by ID, or ErrNotFound.
+	GetAPIKey(ctx context.Context, id string) (*APIKey, error)
+
+	// GetAPIKeyByHash returns the API key with the given hash, or
+	// ErrNotFound.
+	GetAPIKeyByHash(ctx context.Context, hash string) (*APIKey, error)
+
+	// ListAPIKeys returns all API keys, oldest first.
+	ListAPIKeys(ctx context.Context) ([]APIKey, error)
+
+	// RevokeAPIKey marks a key revoked (keeping the first revocation time),
+	// or returns ErrNotFound.
+	RevokeAPIKey(ctx context.Context, id string) error
+
+	// TouchAPIKey records when a key was last used.
+	TouchAPIKey(ctx context.Context, id string, at time.Time) error
+
+	// CreateOperator stores a new operator, or returns ErrConflict if the
+	// username is taken.
+	CreateOperator(ctx context.Context, o Operator) error
+
+	// GetOperator returns an operator by ID, or ErrNotFound.
+	GetOperator(ctx context.Context, id string) (*Operator, error)
+
+	// GetOperatorByUsername returns an operator by username, or ErrNotFound.
+	GetOperatorByUsername(ctx context.Context, username string) (*Operator, error)
+
+	// ListOperators returns all operators ordered by username.
+	ListOperators(ctx context.Context) ([]Operator, error)
+
+	// UpdateOperator replaces an operator's role, tenants, disabled flag and
+	// password hash, or returns ErrNotFound.
+	UpdateOperator(ctx context.Context, o Operator) error
+
+	// DeleteOperator removes an operator and their sessions, or returns
+	// ErrNotFound.
+	DeleteOperator(ctx context.Context, id string) error
+
+	// CreateSession stores a new session and records the operator's login.
+	CreateSession(ctx context.Context, s Session) error
+
+	// GetSession returns a session by ID, or ErrNotFound.
+	GetSession(ctx context.Context, id string) (*Session, error)
+
+	// DeleteSession removes a session; removing a missing one succeeds.
+	DeleteSession(ctx context.Context, id string) error
+
+	// DeleteOperatorSessions removes all sessions of an operator.
+	DeleteOperatorSessions(ctx context.Context, operatorID string) error
+
+	// CreateTenantToken stores a new tenant token, or returns ErrConflict if
+	// the tenant already has a token with its name, or ErrNotFound if the
+	// tenant doesn't exist.
+	CreateTenantToken(ctx context.Context, t TenantToken) error
+
+	// GetTenantToken returns a tenant token by ID, or ErrNotFound.
+	GetTenantToken(ctx context.Context, id string) (*TenantToken, error)
+
+	// GetTenantTokenByHash returns the tenant token with the given hash, or
+	// ErrNotFound.
+	GetTenantTokenByHash(ctx context.Context, hash string) (*TenantToken, error)
+
+	// ListTenantTokens returns the tenant's tokens, oldest first.
+	ListTenantTokens(ctx context.Context, tenantID string) ([]TenantToken, error)
+
+	// RevokeTenantToken marks one of the tenant's tokens revoked (keeping
+	// the first revocation time), or returns ErrNotFound.
+	RevokeTenantToken(ctx context.Context, tenantID, id string) error
+
+	// TouchTenantToken records when a tenant token was last used.
+	TouchTenantToken(ctx context.Context, id string, at time.Time) error
+
+	// GetDomainVerification returns the ownership verification of a domain
+	// claimed through the tenant API, or ErrNotFound.
+	GetDomainVerification(ctx context.Context, domain string) (*DomainVerification, error)
+
+	// ListDomainVerifications returns all verifications ordered by domain.
+	ListDomainVerifications(ctx context.Context) ([]DomainVerification, error)
+
+	// UpdateDomainVerification stores the outcome of checking a
+	// verification and sets its domain's status, or returns ErrNotFound.
+	// A status change is recorded in the history like UpdateDomain.
+	UpdateDomainVerification(ctx context.Context, v DomainVerification, status string) error
+
+	// Watch streams change events until ctx is cancelled. Stores backed by a
+	// shared database also report changes made by other router instances.
+	Watch(ctx context.Context) (<-chan ChangeEvent, error)
+
+	Close() error
+}
+
+// OpenStore opens the TenantStore for the given driver. For sqlite dsn is a
+// file path; for postgres it is a connection URL or key=value DSN. With
+// autoMigrate pending schema migrations are applied; without it, opening
+// fails unless the schema is already current.
+func OpenStore(driver, dsn string, autoMigrate bool) (TenantStore, error) {
+	switch driver {
+	case "", DriverSQLite:
+		return NewSQLiteStore(dsn, autoMigrate)
+	case DriverPostgres:
+		return NewPostgresStore(dsn, autoMigrate)
+	}
+	return nil, fmt.Errorf("unsupported database driver: %s", driver)
+}
+
+// watchers fans change events out to Watch subscribers within this process.
+type watchers struct {
+	subs map[chan ChangeEvent]struct{}
+}
+
+func (w *watchers) add(ch chan ChangeEvent) {
+	if w.subs == nil {
+		w.subs = make(map[chan ChangeEvent]struct{})
+	}
+	w.subs[ch] = struct{}{}
+}
+
+func (w *watchers) remove(ch chan ChangeEvent) {
+	delete(w.subs, ch)
+}
+
+// notify delivers ev to every subscriber without blocking.
+func (w *watchers) notify(ev ChangeEvent) {
+	for ch := range w.subs {
+		deliver(ch, ev)
+	}
+}
+
+// deliver sends ev without blocking. A subscriber that has fallen behind
+// gets a reset event in place of one pending event instead.
+func deliver(ch chan ChangeEvent, ev ChangeEvent) {
+	select {
+	case ch <- ev:
+	default:
+		select {
+		case <-ch:
+		default:
+		}
+		select {
+		case ch <- ChangeEvent{}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package storetest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tenantical/router/internal/database"
+)
+
+// TestInheritance checks that tm resolves a domain's unset routing settings
+// to its tenant's, and that 0 and None turn the tenant's settings off
+// instead. s must not have the tenant "tenant-i" and is left without it.
+func TestInheritance(t *testing.T, tm *database.TenantManager, s database.TenantStore) {
+	ctx := database.WithActor(context.Background(), "storetest")
+
+	tenant := database.Tenant{ID: "tenant-i", ProjectPort: ptr(8081), BackendDomain: ptr("tenant.internal"),
+		UpstreamProtocol: database.UpstreamHTTP1, ProxyProtocol: "v1"}
+	if err := s.AddTenant(ctx, tenant); err != nil {
+		t.Fatalf("AddTenant(%s): %v", tenant.ID, err)
+	}
+	domains := []database.Domain{
+		{Name: "inherits.example.com", TenantID: tenant.ID},
+		{Name: "none.example.com", TenantID: tenant.ID, ProjectPort: ptr(0), BackendDomain: ptr(database.None),
+			UpstreamProtocol: database.None, ProxyProtocol: database.None},
+	}
+	for _, d := range domains {
+		if err := tm.CreateDomain(ctx, d); err != nil {
+			t.Fatalf("CreateDomain(%s): %v", d.Name, err)
+		}
+	}
+
+	info, err := tm.GetTenantInfo("inherits.example.com")
+	switch {
+	case err != nil:
+		t.Fatalf("GetTenantInfo(inherits.example.com): %v", err)
+	case info.ProjectPort == nil || *info.ProjectPort != 8081,
+		info.BackendDomain == nil || *info.BackendDomain != "tenant.internal",
+		info.UpstreamProtocol != database.UpstreamHTTP1, info.ProxyProtocol != "v1":
+		t.Fatalf("GetTenantInfo(inherits.example.com): got %+v, want the tenant's settings", info)
+	}
+	info, err = tm.GetTenantInfo("none.example.com")
+	switch {
+	case err != nil:
+		t.Fatalf("GetTenantInfo(none.example.com): %v", err)
+	case info.ProjectPort != nil, info.BackendDomain != nil, info.UpstreamProtocol != "", info.ProxyProtocol != "":
+		t.Fatalf("GetTenantInfo(none.example.com): got %+v, want no settings", info)
+	}
+
+	for _, d := range domains {
+		if err := s.DeleteDomain(ctx, d.Name, 0); err != nil {
+			t.Fatalf("DeleteDomain(%s): %v", d.Name, err)
+		}
+	}
+	if err := s.DeleteTenant(ctx, tenant.ID, 0); err != nil {
+		t.Fatalf("DeleteTenant(%s): %v", tenant.ID, err)
+	}
+}
@@ -0,0 +1,92 @@
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tenantical/router/internal/database"
+)
+
+// TestInstances checks that a, b are two stores opened on the same
+// database, as by two router instances, and that changes made through a
+// invalidate the cache of a TenantManager on b within a few seconds:
+// including an unknown host it cached before a added the domain. Both
+// must be empty; they are left empty on success.
+func TestInstances(t *testing.T, a, b database.TenantStore) {
+	ctx := database.WithActor(context.Background(), "storetest")
+
+	tm, err := database.NewTenantManager(b, true)
+	if err != nil {
+		t.Fatalf("NewTenantManager: %v", err)
+	}
+
+	const host = "shared.example.com"
+	if _, err := tm.GetTenantInfo(host); !errors.Is(err, database.ErrUnknownHost) {
+		t.Fatalf("GetTenantInfo(%s): got %v, want ErrUnknownHost", host, err)
+	}
+
+	tenant := database.Tenant{ID: "tenant-i", ProjectRoute: "/projects/one"}
+	if err := a.AddTenant(ctx, tenant); err != nil {
+		t.Fatalf("AddTenant(%s): %v", tenant.ID, err)
+	}
+	if err := a.AddDomain(ctx, database.Domain{Name: host, TenantID: tenant.ID}); err != nil {
+		t.Fatalf("AddDomain(%s): %v", host, err)
+	}
+	if err := eventually(func() error {
+		_, err := tm.GetTenantInfo(host)
+		return err
+	}); err != nil {
+		t.Fatalf("GetTenantInfo(%s) after it was added by another instance: %v", host, err)
+	}
+
+	// Tenant-wide changes reset the cache
+	tenant.ProjectRoute = "/projects/two"
+	if err := a.UpdateTenant(ctx, tenant); err != nil {
+		t.Fatalf("UpdateTenant(%s): %v", tenant.ID, err)
+	}
+	if err := eventually(func() error {
+		info, err := tm.GetTenantInfo(host)
+		if err == nil && info.ProjectRoute != tenant.ProjectRoute {
+			err = fmt.Errorf("ProjectRoute = %q, want %q", info.ProjectRoute, tenant.ProjectRoute)
+		}
+		return err
+	}); err != nil {
+		t.Fatalf("GetTenantInfo(%s) after its tenant was updated by another instance: %v", host, err)
+	}
+
+	if err := a.DeleteDomain(ctx, host, 0); err != nil {
+		t.Fatalf("DeleteDomain(%s): %v", host, err)
+	}
+	if err := eventually(func() error {
+		if _, err := tm.GetTenantInfo(host); !errors.Is(err, database.ErrUnknownHost) {
+			return fmt.Errorf("got %v, want ErrUnknownHost", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("GetTenantInfo(%s) after it was deleted by another instance: %v", host, err)
+	}
+	if err := a.DeleteTenant(ctx, tenant.ID, 0); err != nil {
+		t.Fatalf("DeleteTenant(%s): %v", tenant.ID, err)
+	}
+}
+
+// eventually retries check until it succeeds or 5 seconds have passed,
+// returning its last error.
+func eventually(check func() error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,1083 @@
+// Package storetest implements a conformance check for database.TenantStore
+// implementations, in the spirit of testing/fstest.TestFS.
+package storetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tenantical/router/internal/database"
+)
+
+// Run opens the store of driver at dsn, which must be empty, and runs every
+// check against it, each in its own subtest: TestStore, TestWildcards and
+// TestInheritance with and without the host cache, TestInstances with a
+// second store on the same database, and TestTenantsFile.
+func Run(t *testing.T, driver, dsn string) {
+	store, err := database.OpenStore(driver, dsn, true)
+	if err != nil {
+		t.Fatalf("open tenant store: %v", err)
+	}
+	defer store.Close()
+
+	t.Run("store", func(t *testing.T) {
+		TestStore(t, store)
+	})
+	for _, cache := range []bool{false, true} {
+		t.Run(fmt.Sprintf("cache=%v", cache), func(t *testing.T) {
+			tm, err := database.NewTenantManager(nopCloser{store}, cache)
+			if err != nil {
+				t.Fatalf("NewTenantManager: %v", err)
+			}
+			defer tm.Close()
+			t.Run("wildcards", func(t *testing.T) {
+				TestWildcards(t, tm, store)
+			})
+			t.Run("inheritance", func(t *testing.T) {
+				TestInheritance(t, tm, store)
+			})
+		})
+	}
+	t.Run("instances", func(t *testing.T) {
+		other, err := database.OpenStore(driver, dsn, true)
+		if err != nil {
+			t.Fatalf("open second tenant store: %v", err)
+		}
+		defer other.Close()
+		TestInstances(t, store, other)
+	})
+	t.Run("tenants file", func(t *testing.T) {
+		TestTenantsFile(t, store)
+	})
+}
+
+// TestStore exercises every TenantStore operation against s, one area per
+// subtest, and reports every behaviour that differs from the contract. s
+// must be empty and is left empty on success, apart from the history of the
+// changes it made.
+func TestStore(t *testing.T, s database.TenantStore) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	ctx = database.WithActor(ctx, "storetest")
+
+	existingTenants, err := s.ListTenants(ctx)
+	if err != nil {
+		t.Fatalf("ListTenants: %v", err)
+	}
+	existingDomains, err := s.ListDomains(ctx, "")
+	if err != nil {
+		t.Fatalf("ListDomains: %v", err)
+	}
+	if len(existingTenants) != 0 || len(existingDomains) != 0 {
+		t.Fatalf("store must be empty, has %d tenants and %d domains", len(existingTenants), len(existingDomains))
+	}
+
+	// History survives deletes: only look at changes made from here on
+	var mark int64
+	latest, err := s.ListChanges(ctx, database.ChangeFilter{Limit: 1})
+	if err != nil {
+		t.Fatalf("ListChanges: %v", err)
+	}
+	if len(latest) > 0 {
+		mark = latest[0].ID
+	}
+
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	defer stopWatch()
+	events, err := s.Watch(watchCtx)
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+
+	t.Run("tenants", func(t *testing.T) {
+		testTenants(t, ctx, s, events)
+	})
+	t.Run("domains", func(t *testing.T) {
+		testDomains(t, ctx, s, events)
+	})
+	t.Run("history", func(t *testing.T) {
+		testHistory(t, ctx, s, mark)
+	})
+	t.Run("audit", func(t *testing.T) {
+		testAudit(t, ctx, s)
+	})
+	t.Run("api keys", func(t *testing.T) {
+		testAPIKeys(t, ctx, s)
+	})
+	t.Run("operators", func(t *testing.T) {
+		testOperators(t, ctx, s)
+	})
+	t.Run("tenant tokens", func(t *testing.T) {
+		testTenantTokens(t, ctx, s)
+	})
+	t.Run("domain verifications", func(t *testing.T) {
+		testDomainVerifications(t, ctx, s)
+	})
+	t.Run("rollback", func(t *testing.T) {
+		testRollback(t, ctx, s)
+	})
+
+	// Watch channel is closed once its context ends
+	stopWatch()
+	for range events {
+	}
+}
+
+func testTenants(t *testing.T, ctx context.Context, s database.TenantStore, events <-chan database.ChangeEvent) {
+	port := 9000
+	tenant := database.Tenant{
+		ID:           "tenant-t",
+		Name:         "Tenant T",
+		ProjectRoute: "/projects/t",
+		ProjectPort:  &port,
+		Metadata:     map[string]string{"plan": "pro"},
+	}
+
+	// AddTenant
+	if err := s.AddTenant(ctx, tenant); err != nil {
+		t.Fatalf("AddTenant(%s): %v", tenant.ID, err)
+	}
+	if err := expectEvent(events, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddTenant(ctx, tenant); !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("AddTenant of existing tenant: got %v, want ErrConflict", err)
+	}
+
+	// GetTenant
+	got, err := s.GetTenant(ctx, tenant.ID)
+	if err != nil {
+		t.Fatalf("GetTenant(%s): %v", tenant.ID, err)
+	}
+	if err := equalTenant(got, &tenant); err != nil {
+		t.Fatalf("GetTenant(%s): %v", tenant.ID, err)
+	}
+	if got.Status != database.TenantActive {
+		t.Fatalf("GetTenant(%s): Status = %q, want default %q", tenant.ID, got.Status, database.TenantActive)
+	}
+	if got.CreatedAt == "" || got.UpdatedAt == "" {
+		t.Fatalf("GetTenant(%s): CreatedAt/UpdatedAt not set", tenant.ID)
+	}
+	if got.Revision != 1 {
+		t.Fatalf("GetTenant(%s): Revision = %d, want 1", tenant.ID, got.Revision)
+	}
+	if _, err := s.GetTenant(ctx, "missing"); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("GetTenant of missing tenant: got %v, want ErrNotFound", err)
+	}
+
+	// UpdateTenant
+	updated := tenant
+	updated.Name = "Renamed"
+	updated.ProjectPort = nil
+	updated.Metadata = nil
+	if err := s.UpdateTenant(ctx, updated); err != nil {
+		t.Fatalf("UpdateTenant(%s): %v", updated.ID, err)
+	}
+	if err := expectEvent(events, ""); err != nil {
+		t.Fatal(err)
+	}
+	got, err = s.GetTenant(ctx, updated.ID)
+	if err != nil {
+		t.Fatalf("GetTenant after UpdateTenant: %v", err)
+	}
+	if err := equalTenant(got, &updated); err != nil {
+		t.Fatalf("GetTenant after UpdateTenant: %v", err)
+	}
+	if err := s.UpdateTenant(ctx, database.Tenant{ID: "missing"}); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("UpdateTenant of missing tenant: got %v, want ErrNotFound", err)
+	}
+
+	// Revisions: every update increments, stale revisions are refused
+	if got.Revision != 2 {
+		t.Fatalf("GetTenant after UpdateTenant: Revision = %d, want 2", got.Revision)
+	}
+	stale := updated
+	stale.Revision = 1
+	if err := s.UpdateTenant(ctx, stale); !errors.Is(err, database.ErrRevisionMismatch) {
+		t.Fatalf("UpdateTenant with stale revision: got %v, want ErrRevisionMismatch", err)
+	}
+	if err := s.DeleteTenant(ctx, tenant.ID, 1); !errors.Is(err, database.ErrRevisionMismatch) {
+		t.Fatalf("DeleteTenant with stale revision: got %v, want ErrRevisionMismatch", err)
+	}
+	current := updated
+	current.Revision = 2
+	if err := s.UpdateTenant(ctx, current); err != nil {
+		t.Fatalf("UpdateTenant with current revision: %v", err)
+	}
+	if err := expectEvent(events, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.UpdateTenant(ctx, database.Tenant{ID: "missing", Revision: 1}); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("UpdateTenant of missing tenant with revision: got %v, want ErrNotFound", err)
+	}
+
+	// ListTenants
+	all, err := s.ListTenants(ctx)
+	if err != nil {
+		t.Fatalf("ListTenants: %v", err)
+	}
+	if len(all) != 1 || all[0].ID != tenant.ID {
+		t.Fatalf("ListTenants: got %d tenants, want [%s]", len(all), tenant.ID)
+	}
+
+	// Maintenance windows
+	start := time.Date(2030, 1, 2, 3, 0, 0, 0, time.UTC)
+	window := database.MaintenanceWindow{
+		TenantID:  tenant.ID,
+		StartsAt:  start,
+		EndsAt:    start.Add(time.Hour),
+		Message:   "upgrading",
+		BypassIPs: []string{"10.0.0.0/8", "192.0.2.1"},
+	}
+	created, err := s.AddMaintenanceWindow(ctx, window)
+	if err != nil {
+		t.Fatalf("AddMaintenanceWindow: %v", err)
+	}
+	if created.ID == 0 {
+		t.Fatalf("AddMaintenanceWindow: ID not set")
+	}
+	if err := expectEvent(events, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddMaintenanceWindow(ctx, database.MaintenanceWindow{TenantID: "missing", StartsAt: start, EndsAt: start.Add(time.Hour)}); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("AddMaintenanceWindow for missing tenant: got %v, want ErrNotFound", err)
+	}
+	windows, err := s.ListMaintenanceWindows(ctx, tenant.ID)
+	if err != nil {
+		t.Fatalf("ListMaintenanceWindows: %v", err)
+	}
+	if len(windows) != 1 {
+		t.Fatalf("ListMaintenanceWindows: got %d windows, want 1", len(windows))
+	}
+	gotWindow := windows[0]
+	if gotWindow.ID != created.ID || !gotWindow.StartsAt.Equal(window.StartsAt) || !gotWindow.EndsAt.Equal(window.EndsAt) ||
+		gotWindow.Message != window.Message || len(gotWindow.BypassIPs) != 2 || gotWindow.BypassIPs[1] != "192.0.2.1" {
+		t.Fatalf("ListMaintenanceWindows: got %+v, want %+v", gotWindow, window)
+	}
+	if all, err := s.ListMaintenanceWindows(ctx, ""); err != nil || len(all) != 1 || all[0].ID != created.ID {
+		t.Fatalf("ListMaintenanceWindows of every tenant: got %+v, %v, want the one window", all, err)
+	}
+	if err := s.DeleteMaintenanceWindow(ctx, "other", created.ID); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("DeleteMaintenanceWindow of another tenant's window: got %v, want ErrNotFound", err)
+	}
+	if err := s.DeleteMaintenanceWindow(ctx, tenant.ID, created.ID); err != nil {
+		t.Fatalf("DeleteMaintenanceWindow: %v", err)
+	}
+	if err := expectEvent(events, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteMaintenanceWindow(ctx, tenant.ID, created.ID); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("DeleteMaintenanceWindow of missing window: got %v, want ErrNotFound", err)
+	}
+
+	// Windows are removed together with their tenant
+	if _, err := s.AddMaintenanceWindow(ctx, window); err != nil {
+		t.Fatalf("AddMaintenanceWindow: %v", err)
+	}
+	if err := expectEvent(events, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// DeleteTenant
+	if err := s.DeleteTenant(ctx, tenant.ID, 0); err != nil {
+		t.Fatalf("DeleteTenant(%s): %v", tenant.ID, err)
+	}
+	if err := expectEvent(events, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteTenant(ctx, tenant.ID, 0); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("DeleteTenant of missing tenant: got %v, want ErrNotFound", err)
+	}
+	if windows, err := s.ListMaintenanceWindows(ctx, tenant.ID); err != nil || len(windows) != 0 {
+		t.Fatalf("ListMaintenanceWindows after DeleteTenant: got %d windows (%v), want none", len(windows), err)
+	}
+}
+
+func testDomains(t *testing.T, ctx context.Context, s database.TenantStore, events <-chan database.ChangeEvent) {
+	for _, id := range []string{"tenant-a", "tenant-a2", "tenant-w"} {
+		if err := s.AddTenant(ctx, database.Tenant{ID: id}); err != nil {
+			t.Fatalf("AddTenant(%s): %v", id, err)
+		}
+		if err := expectEvent(events, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	port := 8081
+	backend := "backend.internal"
+	exact := database.Domain{
+		Name:             "a.example.com",
+		TenantID:         "tenant-a",
+		ProjectRoute:     "/projects/a",
+		ProjectPort:      &port,
+		BackendDomain:    &backend,
+		UpstreamProtocol: database.UpstreamH2C,
+		ProxyProtocol:    "v2",
+		RequestHeaders:   map[string]string{"X-Customer": "a"},
+	}
+	wildcard := database.Domain{
+		Name:     "*.example.com",
+		TenantID: "tenant-w",
+	}
+
+	// AddDomain
+	if err := s.AddDomain(ctx, exact); err != nil {
+		t.Fatalf("AddDomain(%s): %v", exact.Name, err)
+	}
+	if err := expectEvent(events, exact.Name); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddDomain(ctx, wildcard); err != nil {
+		t.Fatalf("AddDomain(%s): %v", wildcard.Name, err)
+	}
+	if err := expectEvent(events, wildcard.Name); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddDomain(ctx, exact); !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("AddDomain of existing domain: got %v, want ErrConflict", err)
+	}
+	if err := s.AddDomain(ctx, database.Domain{Name: "orphan.example.com", TenantID: "missing"}); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("AddDomain for missing tenant: got %v, want ErrNotFound", err)
+	}
+
+	// GetDomain
+	got, err := s.GetDomain(ctx, exact.Name)
+	if err != nil {
+		t.Fatalf("GetDomain(%s): %v", exact.Name, err)
+	}
+	if err := equalDomain(got, &exact); err != nil {
+		t.Fatalf("GetDomain(%s): %v", exact.Name, err)
+	}
+	if got.CreatedAt == "" {
+		t.Fatalf("GetDomain(%s): CreatedAt not set", exact.Name)
+	}
+	got, err = s.GetDomain(ctx, wildcard.Name)
+	if err != nil {
+		t.Fatalf("GetDomain(%s): %v", wildcard.Name, err)
+	}
+	if err := equalDomain(got, &wildcard); err != nil {
+		t.Fatalf("GetDomain(%s): unset overrides must stay empty: %v", wildcard.Name, err)
+	}
+	if _, err := s.GetDomain(ctx, "missing.example.com"); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("GetDomain of missing domain: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetDomain(ctx, "b.example.com"); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("GetDomain must not apply wildcard matching: got %v, want ErrNotFound", err)
+	}
+
+	// ListDomains / ListWildcardDomains
+	all, err := s.ListDomains(ctx, "")
+	if err != nil {
+		t.Fatalf("ListDomains: %v", err)
+	}
+	if len(all) != 2 || all[0].Name != wildcard.Name || all[1].Name != exact.Name {
+		t.Fatalf("ListDomains: got %v, want [%s %s] ordered by domain", names(all), wildcard.Name, exact.Name)
+	}
+	owned, err := s.ListDomains(ctx, exact.TenantID)
+	if err != nil {
+		t.Fatalf("ListDomains(%s): %v", exact.TenantID, err)
+	}
+	if len(owned) != 1 || owned[0].Name != exact.Name {
+		t.Fatalf("ListDomains(%s): got %v, want [%s]", exact.TenantID, names(owned), exact.Name)
+	}
+	wildcards, err := s.ListWildcardDomains(ctx)
+	if err != nil {
+		t.Fatalf("ListWildcardDomains: %v", err)
+	}
+	if len(wildcards) != 1 || wildcards[0].Name != wildcard.Name {
+		t.Fatalf("ListWildcardDomains: got %v, want [%s]", names(wildcards), wildcard.Name)
+	}
+
+	// DeleteTenant refuses while domains reference the tenant
+	if err := s.DeleteTenant(ctx, wildcard.TenantID, 0); !errors.Is(err, database.ErrTenantHasDomains) {
+		t.Fatalf("DeleteTenant with domains: got %v, want ErrTenantHasDomains", err)
+	}
+
+	// UpdateDomain
+	updated := exact
+	updated.TenantID = "tenant-a2"
+	updated.ProjectRoute = ""
+	updated.ProjectPort = nil
+	updated.BackendDomain = nil
+	updated.UpstreamProtocol = ""
+	updated.ProxyProtocol = ""
+	updated.RequestHeaders = nil
+	if err := s.UpdateDomain(ctx, updated); err != nil {
+		t.Fatalf("UpdateDomain(%s): %v", updated.Name, err)
+	}
+	if err := expectEvent(events, updated.Name); err != nil {
+		t.Fatal(err)
+	}
+	got, err = s.GetDomain(ctx, updated.Name)
+	if err != nil {
+		t.Fatalf("GetDomain after UpdateDomain: %v", err)
+	}
+	if err := equalDomain(got, &updated); err != nil {
+		t.Fatalf("GetDomain after UpdateDomain: %v", err)
+	}
+	if err := s.UpdateDomain(ctx, database.Domain{Name: "missing.example.com", TenantID: "tenant-a"}); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("UpdateDomain of missing domain: got %v, want ErrNotFound", err)
+	}
+	if got.Revision != 2 {
+		t.Fatalf("GetDomain after UpdateDomain: Revision = %d, want 2", got.Revision)
+	}
+	stale := updated
+	stale.Revision = 1
+	if err := s.UpdateDomain(ctx, stale); !errors.Is(err, database.ErrRevisionMismatch) {
+		t.Fatalf("UpdateDomain with stale revision: got %v, want ErrRevisionMismatch", err)
+	}
+	if err := s.DeleteDomain(ctx, updated.Name, 1); !errors.Is(err, database.ErrRevisionMismatch) {
+		t.Fatalf("DeleteDomain with stale revision: got %v, want ErrRevisionMismatch", err)
+	}
+
+	// DeleteDomain
+	for _, domain := range []string{exact.Name, wildcard.Name} {
+		if err := s.DeleteDomain(ctx, domain, 0); err != nil {
+			t.Fatalf("DeleteDomain(%s): %v", domain, err)
+		}
+		if err := expectEvent(events, domain); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.DeleteDomain(ctx, exact.Name, 0); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("DeleteDomain of missing domain: got %v, want ErrNotFound", err)
+	}
+	if _, err := s.GetDomain(ctx, exact.Name); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("GetDomain after DeleteDomain: got %v, want ErrNotFound", err)
+	}
+
+	for _, id := range []string{"tenant-a", "tenant-a2", "tenant-w"} {
+		if err := s.DeleteTenant(ctx, id, 0); err != nil {
+			t.Fatalf("DeleteTenant(%s): %v", id, err)
+		}
+	}
+}
+
+// expectEvent waits for a change event for domain. Events without a Domain
+// (tenant changes and resets) are accepted as covering any change.
+func expectEvent(events <-chan database.ChangeEvent, domain string) error {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return fmt.Errorf("Watch: channel closed while waiting for %q", domain)
+			}
+			if ev.Domain == domain || ev.Domain == "" {
+				return nil
+			}
+		case <-timeout:
+			return fmt.Errorf("Watch: no change event for %q", domain)
+		}
+	}
+}
+
+func testHistory(t *testing.T, ctx context.Context, s database.TenantStore, mark int64) {
+	since := func(changes []database.Change) []database.Change {
+		var recent []database.Change
+		for _, c := range changes {
+			if c.ID > mark {
+				recent = append(recent, c)
+			}
+		}
+		return recent
+	}
+
+	// Tenant: created, updated twice (stale updates leave no trace), deleted
+	all, err := s.ListChanges(ctx, database.ChangeFilter{TenantID: "tenant-t"})
+	if err != nil {
+		t.Fatalf("ListChanges: %v", err)
+	}
+	changes := since(all)
+	wantActions := []string{database.ChangeDelete, database.ChangeUpdate, database.ChangeUpdate, database.ChangeCreate}
+	if len(changes) != len(wantActions) {
+		t.Fatalf("ListChanges(tenant-t): got %d changes, want %d", len(changes), len(wantActions))
+	}
+	for i, c := range changes {
+		if c.Action != wantActions[i] || c.Kind != database.KindTenant || c.Target != "tenant-t" {
+			t.Fatalf("ListChanges(tenant-t)[%d]: got %s %s %s, want %s tenant tenant-t", i, c.Action, c.Kind, c.Target, wantActions[i])
+		}
+		if c.Actor != "storetest" {
+			t.Fatalf("ListChanges(tenant-t)[%d]: Actor = %q, want storetest", i, c.Actor)
+		}
+	}
+	created, deleted := changes[3], changes[0]
+	if created.Before != nil || created.After == nil || created.Revision != 1 {
+		t.Fatalf("create change: got before=%s after=%s revision=%d", created.Before, created.After, created.Revision)
+	}
+	if deleted.Before == nil || deleted.After != nil || deleted.Revision != 3 {
+		t.Fatalf("delete change: got before=%s after=%s revision=%d", deleted.Before, deleted.After, deleted.Revision)
+	}
+	diff, err := database.Diff(changes[2].Before, changes[2].After)
+	if err != nil {
+		t.Fatalf("Diff: %v", err)
+	}
+	if d, ok := diff["name"]; !ok || d.From != "Tenant T" || d.To != "Renamed" || len(diff) != 3 {
+		t.Fatalf("Diff of the first update: got %v, want name, project_port and metadata", diff)
+	}
+
+	// GetChange
+	got, err := s.GetChange(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("GetChange(%d): %v", created.ID, err)
+	}
+	if got.Action != created.Action || got.Target != created.Target || string(got.After) != string(created.After) {
+		t.Fatalf("GetChange(%d): got %+v, want %+v", created.ID, got, created)
+	}
+	if _, err := s.GetChange(ctx, deleted.ID+1000000); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("GetChange of missing change: got %v, want ErrNotFound", err)
+	}
+
+	// Pagination
+	page, err := s.ListChanges(ctx, database.ChangeFilter{TenantID: "tenant-t", BeforeID: deleted.ID, Limit: 1})
+	if err != nil {
+		t.Fatalf("ListChanges with BeforeID: %v", err)
+	}
+	if len(page) != 1 || page[0].ID != changes[1].ID {
+		t.Fatalf("ListChanges with BeforeID and Limit: got %d changes, want [%d]", len(page), changes[1].ID)
+	}
+
+	// A domain moved away still shows up in its previous tenant's history
+	all, err = s.ListChanges(ctx, database.ChangeFilter{TenantID: "tenant-a", Kind: database.KindDomain, Target: "a.example.com"})
+	if err != nil {
+		t.Fatalf("ListChanges: %v", err)
+	}
+	changes = since(all)
+	if len(changes) != 2 || changes[0].Action != database.ChangeUpdate || changes[0].TenantID != "tenant-a2" || changes[0].PreviousTenantID != "tenant-a" {
+		t.Fatalf("ListChanges(tenant-a, a.example.com): got %+v, want the move to tenant-a2 and the create", changes)
+	}
+}
+
+func testAudit(t *testing.T, ctx context.Context, s database.TenantStore) {
+	// The log is append-only, so entries are told apart by a request ID
+	// unique to this run
+	requestID := fmt.Sprintf("storetest-%d", time.Now().UnixNano())
+	entries := []database.AuditEntry{
+		{Actor: "storetest", SourceIP: "192.0.2.1", RequestID: requestID, Action: "PUT /admin/domains/{domain}",
+			Target: "domain:a_b.example.com", Payload: json.RawMessage(`{"tenant_id":"t"}`), Result: database.AuditSuccess, Status: 201},
+		{Actor: "storetest", SourceIP: "192.0.2.1", RequestID: requestID, Action: "DELETE /admin/tenants/{id}",
+			Target: "tenant:t", Result: database.AuditFailure, Status: 409, Error: "tenant has domains"},
+	}
+	for _, e := range entries {
+		if err := s.AppendAudit(ctx, e); err != nil {
+			t.Fatalf("AppendAudit: %v", err)
+		}
+	}
+
+	got, err := s.ListAudit(ctx, database.AuditFilter{RequestID: requestID})
+	if err != nil {
+		t.Fatalf("ListAudit: %v", err)
+	}
+	if len(got) != 2 || got[0].Action != entries[1].Action || got[1].Action != entries[0].Action {
+		t.Fatalf("ListAudit: got %+v, want both entries newest first", got)
+	}
+	if got[0].Error != entries[1].Error || got[0].Status != 409 || got[1].Payload == nil || got[1].CreatedAt == "" {
+		t.Fatalf("ListAudit: entries not stored as written: %+v", got)
+	}
+
+	filters := map[string]database.AuditFilter{
+		"result":           {RequestID: requestID, Result: database.AuditFailure},
+		"target prefix":    {RequestID: requestID, Target: "tenant:"},
+		"action substring": {RequestID: requestID, Action: "DELETE"},
+		"ascending page":   {RequestID: requestID, AfterID: got[1].ID, Ascending: true, Limit: 1},
+	}
+	for name, f := range filters {
+		page, err := s.ListAudit(ctx, f)
+		if err != nil {
+			t.Fatalf("ListAudit by %s: %v", name, err)
+		}
+		if len(page) != 1 || page[0].ID != got[0].ID {
+			t.Fatalf("ListAudit by %s: got %d entries, want [%d]", name, len(page), got[0].ID)
+		}
+	}
+
+	// LIKE wildcards in filters are matched literally
+	page, err := s.ListAudit(ctx, database.AuditFilter{RequestID: requestID, Action: "%"})
+	if err != nil {
+		t.Fatalf("ListAudit: %v", err)
+	}
+	if len(page) != 0 {
+		t.Fatalf("ListAudit with action %%: got %d entries, want none", len(page))
+	}
+}
+
+func testAPIKeys(t *testing.T, ctx context.Context, s database.TenantStore) {
+	// Keys are never deleted, so names and hashes are unique to this run
+	run := fmt.Sprintf("%d", time.Now().UnixNano())
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	key := database.APIKey{
+		ID:        "storetest-" + run,
+		Name:      "storetest-" + run,
+		Prefix:    "trk_test",
+		Scopes:    []string{database.ScopeRead, database.ScopeWrite},
+		ExpiresAt: &expires,
+		CreatedBy: "storetest",
+		Hash:      "hash-" + run,
+	}
+	if err := s.CreateAPIKey(ctx, key); err != nil {
+		t.Fatalf("CreateAPIKey: %v", err)
+	}
+	dup := key
+	dup.ID, dup.Hash = "storetest-dup-"+run, "hash-dup-"+run
+	if err := s.CreateAPIKey(ctx, dup); !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("CreateAPIKey with a taken name: got %v, want ErrConflict", err)
+	}
+
+	got, err := s.GetAPIKeyByHash(ctx, key.Hash)
+	if err != nil {
+		t.Fatalf("GetAPIKeyByHash: %v", err)
+	}
+	if got.ID != key.ID || got.Name != key.Name || strings.Join(got.Scopes, ",") != "read,write" ||
+		got.ExpiresAt == nil || !got.ExpiresAt.Equal(expires) || got.LastUsedAt != nil || got.RevokedAt != nil || got.CreatedAt == "" {
+		t.Fatalf("GetAPIKeyByHash: got %+v, want %+v", got, key)
+	}
+	if _, err := s.GetAPIKeyByHash(ctx, "hash-missing-"+run); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("GetAPIKeyByHash of missing key: got %v, want ErrNotFound", err)
+	}
+
+	used := time.Now().UTC().Truncate(time.Second)
+	if err := s.TouchAPIKey(ctx, key.ID, used); err != nil {
+		t.Fatalf("TouchAPIKey: %v", err)
+	}
+	if err := s.RevokeAPIKey(ctx, key.ID); err != nil {
+		t.Fatalf("RevokeAPIKey: %v", err)
+	}
+	got, err = s.GetAPIKey(ctx, key.ID)
+	if err != nil {
+		t.Fatalf("GetAPIKey: %v", err)
+	}
+	if got.LastUsedAt == nil || !got.LastUsedAt.Equal(used) || got.RevokedAt == nil {
+		t.Fatalf("GetAPIKey after use and revoke: got last used %v, revoked %v", got.LastUsedAt, got.RevokedAt)
+	}
+	revoked := *got.RevokedAt
+	if err := s.RevokeAPIKey(ctx, key.ID); err != nil {
+		t.Fatalf("RevokeAPIKey of a revoked key: %v", err)
+	}
+	if err := s.RevokeAPIKey(ctx, "missing-"+run); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("RevokeAPIKey of missing key: got %v, want ErrNotFound", err)
+	}
+
+	keys, err := s.ListAPIKeys(ctx)
+	if err != nil {
+		t.Fatalf("ListAPIKeys: %v", err)
+	}
+	for _, k := range keys {
+		if k.ID == key.ID {
+			if k.RevokedAt == nil || !k.RevokedAt.Equal(revoked) || k.Hash != "" {
+				t.Fatalf("ListAPIKeys: got %+v, want it revoked once and without its hash", k)
+			}
+			return
+		}
+	}
+	t.Fatalf("ListAPIKeys: %s not listed", key.ID)
+}
+
+func testOperators(t *testing.T, ctx context.Context, s database.TenantStore) {
+	run := fmt.Sprintf("%d", time.Now().UnixNano())
+	o := database.Operator{
+		ID:           "storetest-" + run,
+		Username:     "storetest-" + run,
+		Role:         database.RoleEditor,
+		Tenants:      []string{"acme", "globex"},
+		PasswordHash: "hash-" + run,
+	}
+	if err := s.CreateOperator(ctx, o); err != nil {
+		t.Fatalf("CreateOperator: %v", err)
+	}
+	defer s.DeleteOperator(ctx, o.ID)
+	dup := o
+	dup.ID = "storetest-dup-" + run
+	if err := s.CreateOperator(ctx, dup); !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("CreateOperator with a taken username: got %v, want ErrConflict", err)
+	}
+
+	got, err := s.GetOperatorByUsername(ctx, o.Username)
+	if err != nil {
+		t.Fatalf("GetOperatorByUsername: %v", err)
+	}
+	if got.ID != o.ID || got.Role != o.Role || strings.Join(got.Tenants, ",") != "acme,globex" ||
+		got.PasswordHash != o.PasswordHash || got.Disabled || got.LastLoginAt != nil || got.CreatedAt == "" {
+		t.Fatalf("GetOperatorByUsername: got %+v, want %+v", got, o)
+	}
+
+	o.Role, o.Tenants, o.Disabled, o.PasswordHash = database.RoleOwner, nil, true, ""
+	if err := s.UpdateOperator(ctx, o); err != nil {
+		t.Fatalf("UpdateOperator: %v", err)
+	}
+	got, err = s.GetOperator(ctx, o.ID)
+	if err != nil {
+		t.Fatalf("GetOperator: %v", err)
+	}
+	if got.Role != database.RoleOwner || got.Tenants != nil || !got.Disabled || got.PasswordHash != "" {
+		t.Fatalf("GetOperator after update: got %+v", got)
+	}
+	missing := o
+	missing.ID = "missing-" + run
+	if err := s.UpdateOperator(ctx, missing); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("UpdateOperator of missing operator: got %v, want ErrNotFound", err)
+	}
+
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	sess := database.Session{ID: "session-" + run, OperatorID: o.ID, CSRFToken: "csrf-" + run, SourceIP: "192.0.2.1", ExpiresAt: expires}
+	if err := s.CreateSession(ctx, sess); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	orphan := sess
+	orphan.ID, orphan.OperatorID = "session-orphan-"+run, "missing-"+run
+	if err := s.CreateSession(ctx, orphan); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("CreateSession of missing operator: got %v, want ErrNotFound", err)
+	}
+	gotSess, err := s.GetSession(ctx, sess.ID)
+	if err != nil {
+		t.Fatalf("GetSession: %v", err)
+	}
+	if gotSess.OperatorID != o.ID || gotSess.CSRFToken != sess.CSRFToken || gotSess.SourceIP != sess.SourceIP || !gotSess.ExpiresAt.Equal(expires) {
+		t.Fatalf("GetSession: got %+v, want %+v", gotSess, sess)
+	}
+	if got, err := s.GetOperator(ctx, o.ID); err != nil || got.LastLoginAt == nil {
+		t.Fatalf("GetOperator after CreateSession: got %+v, %v, want its last login set", got, err)
+	}
+
+	if err := s.DeleteSession(ctx, sess.ID); err != nil {
+		t.Fatalf("DeleteSession: %v", err)
+	}
+	if _, err := s.GetSession(ctx, sess.ID); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("GetSession after delete: got %v, want ErrNotFound", err)
+	}
+
+	// Deleting an operator ends their sessions
+	sess.ID = "session-2-" + run
+	if err := s.CreateSession(ctx, sess); err != nil {
+		t.Fatalf("CreateSession: %v", err)
+	}
+	if err := s.DeleteOperator(ctx, o.ID); err != nil {
+		t.Fatalf("DeleteOperator: %v", err)
+	}
+	if _, err := s.GetSession(ctx, sess.ID); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("GetSession of deleted operator: got %v, want ErrNotFound", err)
+	}
+	if err := s.DeleteOperator(ctx, o.ID); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("DeleteOperator of missing operator: got %v, want ErrNotFound", err)
+	}
+}
+
+func testTenantTokens(t *testing.T, ctx context.Context, s database.TenantStore) {
+	run := fmt.Sprintf("%d", time.Now().UnixNano())
+	one := 1
+	tenant := database.Tenant{ID: "tenant-q", MaxDomains: &one}
+	if err := s.AddTenant(ctx, tenant); err != nil {
+		t.Fatalf("AddTenant(%s): %v", tenant.ID, err)
+	}
+	got, err := s.GetTenant(ctx, tenant.ID)
+	if err != nil {
+		t.Fatalf("GetTenant(%s): %v", tenant.ID, err)
+	}
+	if got.MaxDomains == nil || *got.MaxDomains != 1 {
+		t.Fatalf("GetTenant(%s): MaxDomains = %v, want 1", tenant.ID, got.MaxDomains)
+	}
+
+	// The tenant's own quota takes precedence over the default
+	if err := s.AddDomainWithinQuota(ctx, database.Domain{Name: "q1.example.com", TenantID: tenant.ID}, nil, 5); err != nil {
+		t.Fatalf("AddDomainWithinQuota: %v", err)
+	}
+	if err := s.AddDomainWithinQuota(ctx, database.Domain{Name: "q2.example.com", TenantID: tenant.ID}, nil, 5); !errors.Is(err, database.ErrQuotaExceeded) {
+		t.Fatalf("AddDomainWithinQuota beyond the quota: got %v, want ErrQuotaExceeded", err)
+	}
+	if err := s.AddDomainWithinQuota(ctx, database.Domain{Name: "q2.example.com", TenantID: "missing"}, nil, 5); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("AddDomainWithinQuota for missing tenant: got %v, want ErrNotFound", err)
+	}
+	tenant.MaxDomains, tenant.Revision = nil, 0
+	if err := s.UpdateTenant(ctx, tenant); err != nil {
+		t.Fatalf("UpdateTenant(%s): %v", tenant.ID, err)
+	}
+	if err := s.AddDomainWithinQuota(ctx, database.Domain{Name: "q2.example.com", TenantID: tenant.ID}, nil, 2); err != nil {
+		t.Fatalf("AddDomainWithinQuota within the default quota: %v", err)
+	}
+	if err := s.AddDomainWithinQuota(ctx, database.Domain{Name: "q3.example.com", TenantID: tenant.ID}, nil, 2); !errors.Is(err, database.ErrQuotaExceeded) {
+		t.Fatalf("AddDomainWithinQuota beyond the default quota: got %v, want ErrQuotaExceeded", err)
+	}
+
+	token := database.TenantToken{
+		ID:           "storetest-" + run,
+		TenantID:     tenant.ID,
+		Name:         "storetest",
+		Prefix:       "ttk_test",
+		AllowRouting: true,
+		CreatedBy:    "storetest",
+		Hash:         "hash-" + run,
+	}
+	if err := s.CreateTenantToken(ctx, token); err != nil {
+		t.Fatalf("CreateTenantToken: %v", err)
+	}
+	dup := token
+	dup.ID, dup.Hash = "storetest-dup-"+run, "hash-dup-"+run
+	if err := s.CreateTenantToken(ctx, dup); !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("CreateTenantToken with a taken name: got %v, want ErrConflict", err)
+	}
+	dup.TenantID = "missing"
+	if err := s.CreateTenantToken(ctx, dup); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("CreateTenantToken for missing tenant: got %v, want ErrNotFound", err)
+	}
+
+	gotToken, err := s.GetTenantTokenByHash(ctx, token.Hash)
+	if err != nil {
+		t.Fatalf("GetTenantTokenByHash: %v", err)
+	}
+	if gotToken.ID != token.ID || gotToken.TenantID != tenant.ID || !gotToken.AllowRouting ||
+		gotToken.ExpiresAt != nil || gotToken.RevokedAt != nil || gotToken.CreatedAt == "" {
+		t.Fatalf("GetTenantTokenByHash: got %+v, want %+v", gotToken, token)
+	}
+
+	used := time.Now().UTC().Truncate(time.Second)
+	if err := s.TouchTenantToken(ctx, token.ID, used); err != nil {
+		t.Fatalf("TouchTenantToken: %v", err)
+	}
+	if err := s.RevokeTenantToken(ctx, "tenant-other", token.ID); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("RevokeTenantToken of another tenant's token: got %v, want ErrNotFound", err)
+	}
+	if err := s.RevokeTenantToken(ctx, tenant.ID, token.ID); err != nil {
+		t.Fatalf("RevokeTenantToken: %v", err)
+	}
+	tokens, err := s.ListTenantTokens(ctx, tenant.ID)
+	if err != nil {
+		t.Fatalf("ListTenantTokens: %v", err)
+	}
+	if len(tokens) != 1 || tokens[0].RevokedAt == nil || tokens[0].LastUsedAt == nil || !tokens[0].LastUsedAt.Equal(used) {
+		t.Fatalf("ListTenantTokens: got %+v, want %s used and revoked", tokens, token.ID)
+	}
+
+	// Deleting the tenant deletes its tokens
+	for _, name := range []string{"q1.example.com", "q2.example.com"} {
+		if err := s.DeleteDomain(ctx, name, 0); err != nil {
+			t.Fatalf("DeleteDomain(%s): %v", name, err)
+		}
+	}
+	if err := s.DeleteTenant(ctx, tenant.ID, 0); err != nil {
+		t.Fatalf("DeleteTenant(%s): %v", tenant.ID, err)
+	}
+	if _, err := s.GetTenantToken(ctx, token.ID); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("GetTenantToken of deleted tenant: got %v, want ErrNotFound", err)
+	}
+}
+
+func testDomainVerifications(t *testing.T, ctx context.Context, s database.TenantStore) {
+	tenant := database.Tenant{ID: "tenant-v"}
+	if err := s.AddTenant(ctx, tenant); err != nil {
+		t.Fatalf("AddTenant(%s): %v", tenant.ID, err)
+	}
+
+	// Claims start pending, other domains active
+	expires := time.Now().UTC().Add(time.Hour).Truncate(time.Second)
+	claim := database.Domain{Name: "claimed.example.com", TenantID: tenant.ID}
+	if err := s.AddDomainWithinQuota(ctx, claim, &database.DomainVerification{Token: "token-v", ExpiresAt: expires}, 5); err != nil {
+		t.Fatalf("AddDomainWithinQuota with verification: %v", err)
+	}
+	if err := s.AddDomain(ctx, database.Domain{Name: "added.example.com", TenantID: tenant.ID}); err != nil {
+		t.Fatalf("AddDomain: %v", err)
+	}
+	d, err := s.GetDomain(ctx, claim.Name)
+	if err != nil {
+		t.Fatalf("GetDomain(%s): %v", claim.Name, err)
+	}
+	if d.Status != database.DomainPending {
+		t.Fatalf("GetDomain(%s): Status = %q, want pending", claim.Name, d.Status)
+	}
+	if d, err := s.GetDomain(ctx, "added.example.com"); err != nil || d.Status != database.DomainActive {
+		t.Fatalf("GetDomain(added.example.com): got %+v, %v, want active", d, err)
+	}
+	if _, err := s.GetDomainVerification(ctx, "added.example.com"); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("GetDomainVerification of added domain: got %v, want ErrNotFound", err)
+	}
+
+	v, err := s.GetDomainVerification(ctx, claim.Name)
+	if err != nil {
+		t.Fatalf("GetDomainVerification(%s): %v", claim.Name, err)
+	}
+	if v.Token != "token-v" || !v.ExpiresAt.Equal(expires) || v.VerifiedAt != nil || v.CreatedAt == "" {
+		t.Fatalf("GetDomainVerification(%s): got %+v", claim.Name, v)
+	}
+
+	// A failed check keeps the domain pending
+	checked := time.Now().UTC().Truncate(time.Second)
+	v.CheckedAt, v.Error = &checked, "no TXT record"
+	if err := s.UpdateDomainVerification(ctx, *v, database.DomainPending); err != nil {
+		t.Fatalf("UpdateDomainVerification: %v", err)
+	}
+	if got, _ := s.GetDomain(ctx, claim.Name); got == nil || got.Revision != d.Revision {
+		t.Fatalf("UpdateDomainVerification without status change: got %+v, want revision %d", got, d.Revision)
+	}
+
+	// A successful one activates it and is recorded in the history
+	v.Method, v.VerifiedAt, v.Error = "dns", &checked, ""
+	if err := s.UpdateDomainVerification(ctx, *v, database.DomainActive); err != nil {
+		t.Fatalf("UpdateDomainVerification: %v", err)
+	}
+	got, err := s.GetDomain(ctx, claim.Name)
+	if err != nil {
+		t.Fatalf("GetDomain(%s): %v", claim.Name, err)
+	}
+	if got.Status != database.DomainActive || got.Revision != d.Revision+1 {
+		t.Fatalf("GetDomain(%s) after verification: got %+v", claim.Name, got)
+	}
+	changes, err := s.ListChanges(ctx, database.ChangeFilter{Target: claim.Name, Limit: 1})
+	if err != nil {
+		t.Fatalf("ListChanges: %v", err)
+	}
+	if len(changes) != 1 || changes[0].Action != database.ChangeUpdate {
+		t.Fatalf("ListChanges(%s): got %+v, want the activation", claim.Name, changes)
+	}
+	verifications, err := s.ListDomainVerifications(ctx)
+	if err != nil {
+		t.Fatalf("ListDomainVerifications: %v", err)
+	}
+	if len(verifications) != 1 || verifications[0].Method != "dns" || verifications[0].VerifiedAt == nil || verifications[0].Error != "" {
+		t.Fatalf("ListDomainVerifications: got %+v", verifications)
+	}
+
+	// Deleting the domain deletes its verification
+	v.Domain = "missing.example.com"
+	if err := s.UpdateDomainVerification(ctx, *v, database.DomainActive); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("UpdateDomainVerification of missing domain: got %v, want ErrNotFound", err)
+	}
+	for _, name := range []string{claim.Name, "added.example.com"} {
+		if err := s.DeleteDomain(ctx, name, 0); err != nil {
+			t.Fatalf("DeleteDomain(%s): %v", name, err)
+		}
+	}
+	if _, err := s.GetDomainVerification(ctx, claim.Name); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("GetDomainVerification of deleted domain: got %v, want ErrNotFound", err)
+	}
+	if err := s.DeleteTenant(ctx, tenant.ID, 0); err != nil {
+		t.Fatalf("DeleteTenant(%s): %v", tenant.ID, err)
+	}
+}
+
+func testRollback(t *testing.T, ctx context.Context, s database.TenantStore) {
+	tenant := database.Tenant{ID: "tenant-r", Name: "Tenant R"}
+	if err := s.AddTenant(ctx, tenant); err != nil {
+		t.Fatalf("AddTenant(%s): %v", tenant.ID, err)
+	}
+	if err := s.AddDomain(ctx, database.Domain{Name: "r1.example.com", TenantID: tenant.ID}); err != nil {
+		t.Fatalf("AddDomain(r1.example.com): %v", err)
+	}
+
+	restored := tenant
+	restored.Name, restored.Revision = "Restored", 1
+	writes := database.RollbackWrites{
+		Tenants: []database.Tenant{restored},
+		Domains: []database.Domain{{Name: "r2.example.com", TenantID: tenant.ID}},
+		Deleted: []database.Domain{{Name: "r1.example.com", Revision: 1}},
+	}
+	if err := s.ApplyRollback(ctx, writes); err != nil {
+		t.Fatalf("ApplyRollback: %v", err)
+	}
+	if got, err := s.GetTenant(ctx, tenant.ID); err != nil || got.Name != "Restored" || got.Revision != 2 {
+		t.Fatalf("GetTenant(%s) after ApplyRollback: got %+v, %v, want it renamed", tenant.ID, got, err)
+	}
+	if _, err := s.GetDomain(ctx, "r2.example.com"); err != nil {
+		t.Fatalf("GetDomain(r2.example.com) after ApplyRollback: %v", err)
+	}
+	if _, err := s.GetDomain(ctx, "r1.example.com"); !errors.Is(err, database.ErrNotFound) {
+		t.Fatalf("GetDomain(r1.example.com) after ApplyRollback: got %v, want ErrNotFound", err)
+	}
+
+	// One failed write undoes the others
+	restored.Name, restored.Revision = "Again", 2
+	writes = database.RollbackWrites{
+		Tenants: []database.Tenant{restored},
+		Domains: []database.Domain{{Name: "r3.example.com", TenantID: tenant.ID}},
+		Deleted: []database.Domain{{Name: "r2.example.com", Revision: 5}},
+	}
+	if err := s.ApplyRollback(ctx, writes); !errors.Is(err, database.ErrRevisionMismatch) {
+		t.Fatalf("ApplyRollback with a stale revision: got %v, want ErrRevisionMismatch", err)
+	}
+	if got, err := s.GetTenant(ctx, tenant.ID); err != nil || got.Name != "Restored" || got.Revision != 2 {
+		t.Errorf("GetTenant(%s) after a failed ApplyRollback: got %+v, %v, want it unchanged", tenant.ID, got, err)
+	}
+	if _, err := s.GetDomain(ctx, "r3.example.com"); !errors.Is(err, database.ErrNotFound) {
+		t.Errorf("GetDomain(r3.example.com) after a failed ApplyRollback: got %v, want ErrNotFound", err)
+		s.DeleteDomain(ctx, "r3.example.com", 0)
+	}
+	if _, err := s.GetDomain(ctx, "r2.example.com"); err != nil {
+		t.Errorf("GetDomain(r2.example.com) after a failed ApplyRollback: %v", err)
+	}
+
+	if err := s.DeleteDomain(ctx, "r2.example.com", 0); err != nil {
+		t.Fatalf("DeleteDomain(r2.example.com): %v", err)
+	}
+	if err := s.DeleteTenant(ctx, tenant.ID, 0); err != nil {
+		t.Fatalf("DeleteTenant(%s): %v", tenant.ID, err)
+	}
+}
+
+func equalTenant(got, want *database.Tenant) error {
+	switch {
+	case got.ID != want.ID:
+		return fmt.Errorf("ID = %q, want %q", got.ID, want.ID)
+	case got.Name != want.Name:
+		return fmt.Errorf("Name = %q, want %q", got.Name, want.Name)
+	case got.ProjectRoute != want.ProjectRoute:
+		return fmt.Errorf("ProjectRoute = %q, want %q", got.ProjectRoute, want.ProjectRoute)
+	case !equalPtr(got.ProjectPort, want.ProjectPort):
+		return fmt.Errorf("ProjectPort = %v, want %v", got.ProjectPort, want.ProjectPort)
+	case !equalPtr(got.BackendDomain, want.BackendDomain):
+		return fmt.Errorf("BackendDomain = %v, want %v", got.BackendDomain, want.BackendDomain)
+	case got.UpstreamProtocol != want.UpstreamProtocol:
+		return fmt.Errorf("UpstreamProtocol = %q, want %q", got.UpstreamProtocol, want.UpstreamProtocol)
+	case got.ProxyProtocol != want.ProxyProtocol:
+		return fmt.Errorf("ProxyProtocol = %q, want %q", got.ProxyProtocol, want.ProxyProtocol)
+	case !equalPtr(got.MaxDomains, want.MaxDomains):
+		return fmt.Errorf("MaxDomains = %v, want %v", got.MaxDomains, want.MaxDomains)
+	case len(got.Metadata) != len(want.Metadata):
+		return fmt.Errorf("Metadata = %v, want %v", got.Metadata, want.Metadata)
+	}
+	for k, v := range want.Metadata {
+		if got.Metadata[k] != v {
+			return fmt.Errorf("Metadata[%q] = %q, want %q", k, got.Metadata[k], v)
+		}
+	}
+	return nil
+}
+
+func equalDomain(got, want *database.Domain) error {
+	switch {
+	case got.Name != want.Name:
+		return fmt.Errorf("Name = %q, want %q", got.Name, want.Name)
+	case got.TenantID != want.TenantID:
+		return fmt.Errorf("TenantID = %q, want %q", got.TenantID, want.TenantID)
+	case got.ProjectRoute != want.ProjectRoute:
+		return fmt.Errorf("ProjectRoute = %q, want %q", got.ProjectRoute, want.ProjectRoute)
+	case !equalPtr(got.ProjectPort, want.ProjectPort):
+		return fmt.Errorf("ProjectPort = %v, want %v", got.ProjectPort, want.ProjectPort)
+	case !equalPtr(got.BackendDomain, want.BackendDomain):
+		return fmt.Errorf("BackendDomain = %v, want %v", got.BackendDomain, want.BackendDomain)
+	case got.UpstreamProtocol != want.UpstreamProtocol:
+		return fmt.Errorf("UpstreamProtocol = %q, want %q", got.UpstreamProtocol, want.UpstreamProtocol)
+	case got.ProxyProtocol != want.ProxyProtocol:
+		return fmt.Errorf("ProxyProtocol = %q, want %q", got.ProxyProtocol, want.ProxyProtocol)
+	case !maps.Equal(got.RequestHeaders, want.RequestHeaders):
+		return fmt.Errorf("RequestHeaders = %v, want %v", got.RequestHeaders, want.RequestHeaders)
+	}
+	return nil
+}
+
+func equalPtr[T comparable](a, b *T) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func names(domains []database.Domain) []string {
+	var out []string
+	for _, d := range domains {
+		out = append(out, d.Name)
+	}
+	return out
+}
@@ -0,0 +1,257 @@
+package storetest
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tenantical/router/internal/database"
+)
+
+// tenantsFile defines tenant "shared", which s also has, with a domain s
+// also has, and tenant "file-t".
+const tenantsFile = `
+tenants:
+  - id: shared
+    project_route: /projects/file
+    domains:
+      - domain: both.example.com
+      - domain: file.example.com
+  - id: file-t
+    domains:
+      - domain: "{slug}.file.example.com"
+        backend_domain: "{slug}.internal"
+`
+
+// invalidTenantsFiles fail schema validation.
+var invalidTenantsFiles = map[string]string{
+	"unknown setting":   "tenants:\n  - id: a\n    project_rout: /x\n",
+	"missing id":        "tenants:\n  - name: a\n",
+	"duplicate tenant":  "tenants:\n  - id: a\n  - id: a\n",
+	"duplicate domain":  "tenants:\n  - id: a\n    domains: [{domain: a.com}]\n  - id: b\n    domains: [{domain: a.com}]\n",
+	"invalid pattern":   "tenants:\n  - id: a\n    domains: [{domain: 'a.**.com'}]\n",
+	"uncaptured name":   "tenants:\n  - id: a\n    domains: [{domain: '*.a.com', project_route: '/p/{slug}'}]\n",
+	"invalid status":    "tenants:\n  - id: a\n    status: paused\n",
+	"invalid protocols": "tenants:\n  - id: a\n    upstream_protocol: h2\n    proxy_protocol: v1\n",
+}
+
+// TestTenantsFile checks how a FileStore combines a tenants file with s in
+// every mode, that what the file defines can't be changed, that invalid
+// files are refused, and that changes to the file are picked up; and that
+// with an empty file it passes TestStore. s must be empty and is left empty
+// on success.
+func TestTenantsFile(t *testing.T, s database.TenantStore) {
+	ctx := database.WithActor(context.Background(), "storetest")
+
+	path := filepath.Join(t.TempDir(), "tenants.yaml")
+
+	// Without tenants in the file, a FileStore is just s
+	if err := os.WriteFile(path, []byte("tenants: []\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fs, err := database.NewFileStore(nopCloser{s}, path, database.FileOverride)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	t.Run("empty file", func(t *testing.T) {
+		TestStore(t, fs)
+	})
+	fs.Close()
+
+	for name, content := range invalidTenantsFiles {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if fs, err := database.NewFileStore(nopCloser{s}, path, database.FileOverride); err == nil {
+			fs.Close()
+			t.Fatalf("NewFileStore with %s: no error", name)
+		}
+	}
+
+	shared := database.Tenant{ID: "shared", ProjectRoute: "/projects/db"}
+	dbTenant := database.Tenant{ID: "db-t"}
+	for _, tenant := range []database.Tenant{shared, dbTenant} {
+		if err := s.AddTenant(ctx, tenant); err != nil {
+			t.Fatalf("AddTenant(%s): %v", tenant.ID, err)
+		}
+	}
+	for _, name := range []string{"both.example.com", "db.example.com"} {
+		if err := s.AddDomain(ctx, database.Domain{Name: name, TenantID: dbTenant.ID}); err != nil {
+			t.Fatalf("AddDomain(%s): %v", name, err)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(tenantsFile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, mode := range []string{database.FileAuthoritative, database.FileOverride, database.FileFallback} {
+		t.Run(mode, func(t *testing.T) {
+			fs, err := database.NewFileStore(nopCloser{s}, path, mode)
+			if err != nil {
+				t.Fatalf("NewFileStore(%s): %v", mode, err)
+			}
+			defer fs.Close()
+			testFileMode(t, ctx, fs, mode)
+		})
+	}
+	t.Run("reload", func(t *testing.T) {
+		testFileReload(t, ctx, s, path)
+	})
+
+	for _, name := range []string{"both.example.com", "db.example.com"} {
+		if err := s.DeleteDomain(ctx, name, 0); err != nil {
+			t.Fatalf("DeleteDomain(%s): %v", name, err)
+		}
+	}
+	for _, id := range []string{shared.ID, dbTenant.ID} {
+		if err := s.DeleteTenant(ctx, id, 0); err != nil {
+			t.Fatalf("DeleteTenant(%s): %v", id, err)
+		}
+	}
+}
+
+func testFileMode(t *testing.T, ctx context.Context, fs *database.FileStore, mode string) {
+	// Which tenant each domain resolves to, "" for none
+	want := map[string]string{
+		"both.example.com":        "shared",
+		"file.example.com":        "shared",
+		"db.example.com":          "db-t",
+		"{slug}.file.example.com": "file-t",
+	}
+	wantRoute := "/projects/file"
+	switch mode {
+	case database.FileAuthoritative:
+		want["db.example.com"] = ""
+	case database.FileFallback:
+		want["both.example.com"] = "db-t"
+		wantRoute = "/projects/db"
+	}
+
+	for name, tenantID := range want {
+		d, err := fs.GetDomain(ctx, name)
+		switch {
+		case tenantID == "" && !errors.Is(err, database.ErrNotFound):
+			t.Fatalf("GetDomain(%s): got %v, want ErrNotFound", name, err)
+		case tenantID == "":
+		case err != nil:
+			t.Fatalf("GetDomain(%s): %v", name, err)
+		case d.TenantID != tenantID:
+			t.Fatalf("GetDomain(%s): tenant %s, want %s", name, d.TenantID, tenantID)
+		}
+	}
+	tenant, err := fs.GetTenant(ctx, "shared")
+	if err != nil || tenant.ProjectRoute != wantRoute {
+		t.Fatalf("GetTenant(shared): got %+v, %v, want project route %s", tenant, err, wantRoute)
+	}
+
+	domains, err := fs.ListDomains(ctx, "")
+	if err != nil {
+		t.Fatalf("ListDomains: %v", err)
+	}
+	for _, d := range domains {
+		if want[d.Name] != d.TenantID {
+			t.Fatalf("ListDomains: %s belongs to %s, want %q", d.Name, d.TenantID, want[d.Name])
+		}
+	}
+	routed := 0
+	for _, tenantID := range want {
+		if tenantID != "" {
+			routed++
+		}
+	}
+	if len(domains) != routed {
+		t.Fatalf("ListDomains: got %v, want %d domains", names(domains), routed)
+	}
+	wildcards, err := fs.ListWildcardDomains(ctx)
+	if err != nil || len(wildcards) != 1 || wildcards[0].Source != database.SourceFile {
+		t.Fatalf("ListWildcardDomains: got %v, %v, want the file's pattern", names(wildcards), err)
+	}
+
+	// What the file defines can't be changed, nor anything while it's
+	// authoritative
+	if err := fs.UpdateTenant(ctx, database.Tenant{ID: "file-t"}); !errors.Is(err, database.ErrReadOnly) {
+		t.Fatalf("UpdateTenant(file-t): got %v, want ErrReadOnly", err)
+	}
+	if err := fs.DeleteDomain(ctx, "file.example.com", 0); !errors.Is(err, database.ErrReadOnly) {
+		t.Fatalf("DeleteDomain(file.example.com): got %v, want ErrReadOnly", err)
+	}
+	err = fs.AddDomain(ctx, database.Domain{Name: "new.example.com", TenantID: "file-t"})
+	if !errors.Is(err, database.ErrReadOnly) {
+		t.Fatalf("AddDomain(new.example.com) to file-t: got %v, want ErrReadOnly", err)
+	}
+	err = fs.UpdateTenant(ctx, database.Tenant{ID: "db-t"})
+	if mode == database.FileAuthoritative {
+		if !errors.Is(err, database.ErrReadOnly) {
+			t.Fatalf("UpdateTenant(db-t): got %v, want ErrReadOnly", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("UpdateTenant(db-t): %v", err)
+	}
+	if err := fs.AddTenant(ctx, database.Tenant{ID: "file-t"}); !errors.Is(err, database.ErrConflict) {
+		t.Fatalf("AddTenant(file-t): got %v, want ErrConflict", err)
+	}
+
+	// The database's "shared" is only used, and so can only be changed, when
+	// the database wins
+	err = fs.UpdateTenant(ctx, database.Tenant{ID: "shared", ProjectRoute: "/projects/db"})
+	if mode == database.FileFallback && err != nil {
+		t.Fatalf("UpdateTenant(shared): %v", err)
+	}
+	if mode == database.FileOverride && !errors.Is(err, database.ErrReadOnly) {
+		t.Fatalf("UpdateTenant(shared): got %v, want ErrReadOnly", err)
+	}
+}
+
+// testFileReload checks that a changed file is reloaded and reported, and
+// that an invalid change keeps the previous contents.
+func testFileReload(t *testing.T, ctx context.Context, s database.TenantStore, path string) {
+	fs, err := database.NewFileStore(nopCloser{s}, path, database.FileOverride)
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	defer fs.Close()
+	fs.SetPollInterval(100 * time.Millisecond)
+
+	watchCtx, stopWatch := context.WithCancel(ctx)
+	defer stopWatch()
+	events, err := fs.Watch(watchCtx)
+	if err != nil {
+		t.Fatalf("Watch: %v", err)
+	}
+
+	changed := tenantsFile + "      - domain: added.example.com\n"
+	if err := os.WriteFile(path, []byte(changed), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := expectEvent(events, ""); err != nil {
+		t.Fatalf("after changing the tenants file: %v", err)
+	}
+	if d, err := fs.GetDomain(ctx, "added.example.com"); err != nil || d.TenantID != "file-t" {
+		t.Fatalf("GetDomain(added.example.com) after reload: got %+v, %v", d, err)
+	}
+
+	if err := os.WriteFile(path, []byte(changed+"    unknown: true\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(500 * time.Millisecond)
+	if _, err := fs.GetDomain(ctx, "added.example.com"); err != nil {
+		t.Fatalf("GetDomain(added.example.com) after an invalid change: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(tenantsFile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// nopCloser keeps a FileStore from closing the store it wraps.
+type nopCloser struct {
+	database.TenantStore
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
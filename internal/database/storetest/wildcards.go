@@ -0,0 +1,172 @@
+package storetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tenantical/router/internal/database"
+)
+
+// wildcardPatterns are stored together, so every host below is resolved
+// against all of them.
+var wildcardPatterns = []string{
+	"*.example.com",
+	"*.eu.example.com",
+	"*.*.example.com",
+	"**.example.com",
+	"tenant-*.example.com",
+	"tenant-eu-*.example.com",
+	"api.*",
+	"pr-*.apps.example.com",
+	"pr-{id:[0-9]+}.apps.example.com",
+}
+
+// templated captures the customer in the host and routes with it.
+var templated = database.Domain{
+	Name:           "{slug}.apps.example.com",
+	ProjectRoute:   "/projects/{slug}",
+	BackendDomain:  ptr("{slug}.internal"),
+	RequestHeaders: map[string]string{"X-Customer": "{slug}"},
+}
+
+// wildcardPrecedence documents which pattern wins for a host: the most
+// specific one, comparing labels from the right.
+var wildcardPrecedence = []struct {
+	host, want string
+}{
+	// * is exactly one label, ** one or more
+	{"shop.example.com", "*.example.com"},
+	{"a.b.example.com", "*.*.example.com"},
+	{"a.b.c.example.com", "**.example.com"},
+	{"example.com", ""},
+	{"shop.evilexample.com", ""},
+
+	// A literal label beats *, whichever pattern is longer
+	{"shop.eu.example.com", "*.eu.example.com"},
+	{"a.shop.eu.example.com", "**.example.com"},
+
+	// A partial label beats *; more fixed characters beat fewer
+	{"tenant-acme.example.com", "tenant-*.example.com"},
+	{"tenant-eu-acme.example.com", "tenant-eu-*.example.com"},
+	{"tenant-.example.com", "*.example.com"},
+
+	// Labels are compared from the right: a literal domain beats a
+	// literal first label
+	{"api.example.com", "*.example.com"},
+	{"api.org", "api.*"},
+	{"api.co.uk", ""},
+
+	// Captures match like *; a regular expression beats no constraint
+	{"acme.apps.example.com", "{slug}.apps.example.com"},
+	{"pr-42.apps.example.com", "pr-{id:[0-9]+}.apps.example.com"},
+	{"pr-new.apps.example.com", "pr-*.apps.example.com"},
+}
+
+// invalidPatterns are rejected when the domain is added.
+var invalidPatterns = []string{
+	"*",
+	"*.*",
+	"**.*",
+	"a.**.example.com",
+	"**tenant.example.com",
+	"*-*.example.com",
+	"*..example.com",
+	"*_x.example.com",
+	"{slug}.{slug}.example.com",
+	"{1st}.example.com",
+	"{id:[0-9}.example.com",
+	"{slug}*.example.com",
+}
+
+// TestWildcards checks how tm, a TenantManager on s, matches hosts against
+// wildcard patterns, which pattern wins when several match, and that
+// invalid patterns are refused. Run it with caching both disabled and
+// enabled, as hosts are then resolved from the store or from the routing
+// table. s must be empty and is left empty on success.
+func TestWildcards(t *testing.T, tm *database.TenantManager, s database.TenantStore) {
+	ctx := database.WithActor(context.Background(), "storetest")
+
+	tenant := database.Tenant{ID: "tenant-w"}
+	if err := s.AddTenant(ctx, tenant); err != nil {
+		t.Fatalf("AddTenant(%s): %v", tenant.ID, err)
+	}
+
+	for _, name := range invalidPatterns {
+		err := tm.CreateDomain(ctx, database.Domain{Name: name, TenantID: tenant.ID})
+		if !errors.Is(err, database.ErrInvalidPattern) {
+			t.Fatalf("CreateDomain(%s): got %v, want ErrInvalidPattern", name, err)
+		}
+	}
+
+	for _, name := range wildcardPatterns {
+		if err := tm.CreateDomain(ctx, database.Domain{Name: name, TenantID: tenant.ID}); err != nil {
+			t.Fatalf("CreateDomain(%s): %v", name, err)
+		}
+	}
+	d := templated
+	d.TenantID = tenant.ID
+	if err := tm.CreateDomain(ctx, d); err != nil {
+		t.Fatalf("CreateDomain(%s): %v", d.Name, err)
+	}
+
+	for _, tc := range wildcardPrecedence {
+		res := tm.ExplainHost(tc.host)
+		got := ""
+		if res.Matched != nil {
+			got = res.Matched.Name
+		}
+		if got != tc.want {
+			t.Fatalf("ExplainHost(%s): matched %q, want %q", tc.host, got, tc.want)
+		}
+	}
+
+	// Templates are expanded with the captures when resolving, not stored
+	res := tm.ExplainHost("acme.apps.example.com")
+	switch info := res.Info; {
+	case info == nil:
+		t.Fatalf("ExplainHost(acme.apps.example.com): no tenant info: %s", res.Error)
+	case res.Captures["slug"] != "acme":
+		t.Fatalf("ExplainHost(acme.apps.example.com): Captures = %v, want slug=acme", res.Captures)
+	case info.ProjectRoute != "/projects/acme":
+		t.Fatalf("ExplainHost(acme.apps.example.com): ProjectRoute = %q, want /projects/acme", info.ProjectRoute)
+	case info.BackendDomain == nil || *info.BackendDomain != "acme.internal":
+		t.Fatalf("ExplainHost(acme.apps.example.com): BackendDomain = %v, want acme.internal", info.BackendDomain)
+	case info.RequestHeaders["X-Customer"] != "acme":
+		t.Fatalf("ExplainHost(acme.apps.example.com): RequestHeaders = %v", info.RequestHeaders)
+	}
+	stored, err := s.GetDomain(ctx, templated.Name)
+	if err != nil || stored.ProjectRoute != templated.ProjectRoute {
+		t.Fatalf("GetDomain(%s): got %+v, %v, want the templates unexpanded", templated.Name, stored, err)
+	}
+
+	// Only the labels are lowercased: \D in the capture isn't \d
+	mixed := database.Domain{Name: `Build-{tag:\D+}.CI.example.com`, TenantID: tenant.ID}
+	if err := tm.CreateDomain(ctx, mixed); err != nil {
+		t.Fatalf("CreateDomain(%s): %v", mixed.Name, err)
+	}
+	for host, want := range map[string]string{
+		"build-abc.ci.example.com": `build-{tag:\D+}.ci.example.com`,
+		"build-123.ci.example.com": "*.*.example.com",
+	} {
+		if res := tm.ExplainHost(host); res.Matched == nil || res.Matched.Name != want {
+			t.Fatalf("ExplainHost(%s): matched %+v, want %q", host, res.Matched, want)
+		}
+	}
+	if err := tm.DeleteDomain(ctx, mixed.Name, 0); err != nil {
+		t.Fatalf("DeleteDomain(%s): %v", mixed.Name, err)
+	}
+
+	for _, name := range append(wildcardPatterns, templated.Name) {
+		if err := s.DeleteDomain(ctx, name, 0); err != nil {
+			t.Fatalf("DeleteDomain(%s): %v", name, err)
+		}
+	}
+	if err := s.DeleteTenant(ctx, tenant.ID, 0); err != nil {
+		t.Fatalf("DeleteTenant(%s): %v", tenant.ID, err)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
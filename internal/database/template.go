@@ -0,0 +1,96 @@
+package database
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A domain's project route, backend domain and request header values are
+// templates: {name} is replaced with what the {name} capture of the
+// domain's pattern matched, so one {slug}.apps.example.com row can route
+// every customer:
+//
+//	project_route:   /projects/{slug}
+//	backend_domain:  {slug}.internal
+//	request_headers: {"X-Customer": "{slug}"}
+//
+// The tenant's route and backend domain are templates too when a domain
+// inherits them. Names the matched domain doesn't capture are left as they
+// are.
+
+// templateVar finds the {name} references in a template.
+var templateVar = regexp.MustCompile(`\{([a-z_][a-z0-9_]*)\}`)
+
+// expandTemplate replaces the {name} references in s with vars.
+func expandTemplate(s string, vars map[string]string) string {
+	if !strings.Contains(s, "{") {
+		return s
+	}
+	return templateVar.ReplaceAllStringFunc(s, func(ref string) string {
+		if v, ok := vars[ref[1:len(ref)-1]]; ok {
+			return v
+		}
+		return ref
+	})
+}
+
+// expand applies the captured vars to the templates of info.
+func (i *TenantInfo) expand(vars map[string]string) {
+	if len(vars) == 0 {
+		return
+	}
+	i.Vars = vars
+	i.ProjectRoute = expandTemplate(i.ProjectRoute, vars)
+	if i.BackendDomain != nil {
+		backend := expandTemplate(*i.BackendDomain, vars)
+		i.BackendDomain = &backend
+	}
+	if len(i.RequestHeaders) > 0 {
+		headers := make(map[string]string, len(i.RequestHeaders))
+		for k, v := range i.RequestHeaders {
+			headers[k] = expandTemplate(v, vars)
+		}
+		i.RequestHeaders = headers
+	}
+}
+
+// patternCaptures returns the names a domain pattern captures, sorted.
+func patternCaptures(name string) ([]string, error) {
+	if !isWildcard(name) {
+		return nil, nil
+	}
+	p, err := compilePattern(name)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, l := range p.labels {
+		if l.name != "" {
+			names = append(names, l.name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ValidateTemplate checks that template only references names the domain
+// pattern captures.
+func ValidateTemplate(pattern, template string) error {
+	refs := templateVar.FindAllStringSubmatch(template, -1)
+	if len(refs) == 0 {
+		return nil
+	}
+	names, err := patternCaptures(pattern)
+	if err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		i := sort.SearchStrings(names, ref[1])
+		if i == len(names) || names[i] != ref[1] {
+			return fmt.Errorf("{%s} isn't captured by %s", ref[1], pattern)
+		}
+	}
+	return nil
+}
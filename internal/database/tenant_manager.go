@@ -1,80 +1,199 @@
 package database
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"golang.org/x/net/http/httpguts"
 	"golang.org/x/sync/singleflight"
 )
 
+// Upstream protocols a tenant can use to reach its backend.
+const (
+	UpstreamHTTP1 = "http1" // HTTP/1.1 (default)
+	UpstreamH2    = "h2"    // HTTP/2 over TLS
+	UpstreamH2C   = "h2c"   // HTTP/2 over cleartext (prior knowledge), e.g. gRPC without TLS
+)
+
+// Tenant lifecycle statuses.
+const (
+	TenantActive      = "active"      // Routed normally (default)
+	TenantSuspended   = "suspended"   // Answered with the suspended page (402/403)
+	TenantMaintenance = "maintenance" // Answered with the maintenance page (503) except for allowlisted IPs
+	TenantArchived    = "archived"    // Kept for reference but not routable
+)
+
+// ValidTenantStatus reports whether s is a tenant lifecycle status. An empty
+// value is valid and means active.
+func ValidTenantStatus(s string) bool {
+	switch s {
+	case "", TenantActive, TenantSuspended, TenantMaintenance, TenantArchived:
+		return true
+	}
+	return false
+}
+
 type TenantInfo struct {
 	TenantID     string
 	ProjectRoute string
 	ProjectPort  *int    // Optional port, nil means use default from config
 	BackendDomain *string // Optional backend domain (e.g., localhost, admin.local), nil means use default from BACKEND_URL
+	UpstreamProtocol string // Optional upstream protocol (http1, h2, h2c), empty means use default from config
+	ProxyProtocol    string // Optional PROXY protocol version (v1, v2) to send to the backend, empty means none
+	Status           string // Tenant lifecycle status (active, suspended, maintenance)
+	MaintenanceAllow []*net.IPNet // Client networks still routed during maintenance
+	Scheduled        []ScheduledMaintenance // Current and upcoming maintenance windows
+	RequestHeaders   map[string]string      // Headers set on requests forwarded to the backend
+	Vars             map[string]string      // What the domain pattern's captures matched in the host
 }
 
-type TenantManager struct {
-	db          *sql.DB
-	sf          *singleflight.Group
-	cache       map[string]TenantInfo
-	cacheMutex  sync.RWMutex
-	cacheEnabled bool
+// ScheduledMaintenance is a maintenance window prepared for request-time checks.
+type ScheduledMaintenance struct {
+	Start, End time.Time
+	Message    string
+	Bypass     []*net.IPNet
 }
 
-func NewTenantManager(dbPath string, enableCache bool) (*TenantManager, error) {
-	db, err := sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_busy_timeout=5000")
-	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+// MaintenanceAt returns the scheduled maintenance window covering t, if any.
+// Windows are evaluated per request, so cached tenant info switches into and
+// out of maintenance on time without invalidation.
+func (i *TenantInfo) MaintenanceAt(t time.Time) *ScheduledMaintenance {
+	for n := range i.Scheduled {
+		w := &i.Scheduled[n]
+		if !t.Before(w.Start) && t.Before(w.End) {
+			return w
+		}
+	}
+	return nil
+}
+
+// ValidUpstreamProtocol reports whether p is an accepted upstream protocol.
+// An empty value is valid and means "use the configured default".
+func ValidUpstreamProtocol(p string) bool {
+	switch p {
+	case "", UpstreamHTTP1, UpstreamH2, UpstreamH2C:
+		return true
+	}
+	return false
+}
+
+// None, as a domain's backend_domain, upstream_protocol or proxy_protocol,
+// turns the tenant's setting off for that domain, where an empty value
+// inherits it; a project_port of 0 does the same for the port.
+const None = "none"
+
+// StripNone returns a domain's port, upstream protocol and PROXY protocol
+// with the values that turn its tenant's settings off (0 and None) removed,
+// leaving what must be valid on its own.
+func StripNone(port *int, upstreamProtocol, proxyProtocol string) (*int, string, string) {
+	if port != nil && *port == 0 {
+		port = nil
+	}
+	if upstreamProtocol == None {
+		upstreamProtocol = ""
+	}
+	if proxyProtocol == None {
+		proxyProtocol = ""
 	}
+	return port, upstreamProtocol, proxyProtocol
+}
+
+// ValidateRequestHeader checks a header that a domain sets on requests
+// forwarded to the backend.
+func ValidateRequestHeader(name, value string) error {
+	if !httpguts.ValidHeaderFieldName(name) || !httpguts.ValidHeaderFieldValue(value) {
+		return fmt.Errorf("invalid header %q", name)
+	}
+	// The proxy manages framing, connection and routing headers itself
+	switch http.CanonicalHeaderKey(name) {
+	case "Host", "Content-Length", "Transfer-Encoding", "Connection", "Keep-Alive",
+		"Upgrade", "Te", "Trailer", "Proxy-Authorization", "Proxy-Connection":
+		return fmt.Errorf("%s can't be set", name)
+	}
+	return nil
+}
 
+type TenantManager struct {
+	store        TenantStore
+	sf           *singleflight.Group
+	cache        *hostCache
+	cacheEnabled bool
+	stopWatch    context.CancelFunc
+
+	// routes is the routing table, kept while caching is enabled
+	routes      atomic.Pointer[routeTable]
+	routesMutex sync.Mutex // Serializes reloads
+
+	verifier     DomainChecker
+	verifyPolicy VerificationPolicy
+	stopVerify   context.CancelFunc
+}
+
+// NewTenantManager wraps a TenantStore with host resolution and caching.
+// When caching is enabled, hosts are resolved from an in-memory routing
+// table of all domains and tenants, and the store's change events update
+// the table and invalidate the cache. That includes writes by other instances sharing
+// the database: PostgreSQL notifies them, and SQLite's change feed is
+// polled (see SQLiteStore.Watch).
+func NewTenantManager(store TenantStore, enableCache bool) (*TenantManager, error) {
 	tm := &TenantManager{
-		db:           db,
+		store:        store,
 		sf:           &singleflight.Group{},
-		cache:        make(map[string]TenantInfo),
+		cache:        newHostCache(DefaultCachePolicy),
 		cacheEnabled: enableCache,
+		verifyPolicy: DefaultVerificationPolicy,
 	}
 
-	if err := tm.initDB(); err != nil {
-		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	if enableCache {
+		ctx, cancel := context.WithCancel(context.Background())
+		events, err := store.Watch(ctx)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to watch tenant store: %w", err)
+		}
+		tm.stopWatch = cancel
+		go tm.watch(events)
+
+		// Loaded once watching, so no change is missed
+		routes, err := tm.loadRoutes(ctx)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to load routing table: %w", err)
+		}
+		tm.routes.Store(routes)
 	}
 
 	return tm, nil
 }
 
-func (tm *TenantManager) initDB() error {
-	createTable := `
-	CREATE TABLE IF NOT EXISTS tenants (
-		domain TEXT PRIMARY KEY,
-		tenant_id TEXT NOT NULL,
-		project_route TEXT NOT NULL DEFAULT '/projects/backend',
-		project_port INTEGER,
-		backend_domain TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_domain ON tenants(domain);
-	CREATE INDEX IF NOT EXISTS idx_tenant_id ON tenants(tenant_id);
-	`
-	_, err := tm.db.Exec(createTable)
-	
-	// Migration: Add project_route column if it doesn't exist (for existing databases)
-	_, _ = tm.db.Exec("ALTER TABLE tenants ADD COLUMN project_route TEXT DEFAULT '/projects/backend'")
-	
-	// Migration: Add project_port column if it doesn't exist (for existing databases)
-	_, _ = tm.db.Exec("ALTER TABLE tenants ADD COLUMN project_port INTEGER")
-	
-	// Migration: Add backend_domain column if it doesn't exist (for existing databases)
-	_, _ = tm.db.Exec("ALTER TABLE tenants ADD COLUMN backend_domain TEXT")
-	
-	return err
+func (tm *TenantManager) watch(events <-chan ChangeEvent) {
+	for ev := range events {
+		switch {
+		case ev.Domain != "":
+			tm.invalidateCache(ev.Domain)
+		case ev.Tenant != "":
+			tm.invalidateTenant(ev.Tenant)
+		default:
+			tm.ClearCache()
+		}
+	}
 }
 
 func (tm *TenantManager) Close() error {
-	return tm.db.Close()
+	if tm.stopWatch != nil {
+		tm.stopWatch()
+	}
+	if tm.stopVerify != nil {
+		tm.stopVerify()
+	}
+	return tm.store.Close()
 }
 
 // GetTenantInfo returns both tenant ID and project route for a given host
@@ -82,34 +201,37 @@ func (tm *TenantManager) GetTenantInfo(host string) (*TenantInfo, error) {
 	// Normalize host (remove port if present)
 	host = strings.ToLower(strings.Split(host, ":")[0])
 
-	// Check cache first
+	// Check cache first; unknown hosts are cached too
+	var gen uint64
 	if tm.cacheEnabled {
-		tm.cacheMutex.RLock()
-		if info, found := tm.cache[host]; found {
-			tm.cacheMutex.RUnlock()
+		entry, found, refresh := tm.cache.get(host, time.Now())
+		if found {
+			if refresh {
+				go tm.refreshHost(host)
+			}
+			if entry.info == nil {
+				return nil, entry.err
+			}
+			info := *entry.info
 			return &info, nil
 		}
-		tm.cacheMutex.RUnlock()
+		gen = tm.cache.generation()
 	}
 
 	// Use singleflight to prevent thundering herd
 	result, err, _ := tm.sf.Do(host, func() (interface{}, error) {
 		return tm.resolveTenantInfo(host)
 	})
-
-	if err != nil {
-		return nil, err
-	}
-
-	info := result.(*TenantInfo)
+	info, _ := result.(*TenantInfo)
 
 	// Update cache
-	if tm.cacheEnabled && info.TenantID != "" {
-		tm.cacheMutex.Lock()
-		tm.cache[host] = *info
-		tm.cacheMutex.Unlock()
+	if tm.cacheEnabled {
+		tm.cache.put(host, gen, info, err, time.Now())
 	}
 
+	if err != nil {
+		return nil, err
+	}
 	return info, nil
 }
 
@@ -123,217 +245,367 @@ func (tm *TenantManager) GetTenantID(host string) (string, error) {
 }
 
 func (tm *TenantManager) resolveTenantInfo(host string) (*TenantInfo, error) {
-	// Direct match
-	var tenantID, projectRoute, backendDomain sql.NullString
-	var projectPort sql.NullInt64
-	err := tm.db.QueryRow(
-		"SELECT tenant_id, project_route, project_port, backend_domain FROM tenants WHERE domain = ?",
-		host,
-	).Scan(&tenantID, &projectRoute, &projectPort, &backendDomain)
-
-	if err == nil {
-		// Set default if empty
-		route := projectRoute.String
-		if route == "" {
-			route = "/projects/backend"
-		}
-		
-		var port *int
-		if projectPort.Valid {
-			p := int(projectPort.Int64)
-			port = &p
+	return tm.explainTenantInfo(host, nil)
+}
+
+// explainTenantInfo is resolveTenantInfo, recording how the domain was
+// found in res if it isn't nil.
+func (tm *TenantManager) explainTenantInfo(host string, res *Resolution) (*TenantInfo, error) {
+	d, err := tm.explainDomain(host, res)
+	if err != nil {
+		return nil, err
+	}
+	if res != nil {
+		res.Matched = d
+	}
+
+	t, windows, err := tm.routedTenant(d.TenantID)
+	if err != nil {
+		return nil, fmt.Errorf("tenant %s of domain %s: %w", d.TenantID, d.Name, err)
+	}
+
+	// Archived tenants are kept but behave as if they didn't exist
+	if t.Status == TenantArchived {
+		return nil, fmt.Errorf("%w: %s (tenant %s is archived)", ErrUnknownHost, host, t.ID)
+	}
+
+	info := d.info(t)
+	if isWildcard(d.Name) {
+		vars, _ := matchPattern(host, d.Name)
+		info.expand(vars)
+		if res != nil {
+			res.Captures = vars
 		}
-		
-		var backendDom *string
-		if backendDomain.Valid && backendDomain.String != "" {
-			dom := backendDomain.String
-			backendDom = &dom
+	}
+
+	now := time.Now()
+	for _, w := range windows {
+		if !w.EndsAt.After(now) {
+			continue // Already over
 		}
-		
-		return &TenantInfo{
-			TenantID:     tenantID.String,
-			ProjectRoute: route,
-			ProjectPort:  port,
-			BackendDomain: backendDom,
-		}, nil
+		bypass, _ := ParseIPList(w.BypassIPs)
+		info.Scheduled = append(info.Scheduled, ScheduledMaintenance{
+			Start:   w.StartsAt,
+			End:     w.EndsAt,
+			Message: w.Message,
+			Bypass:  bypass,
+		})
 	}
 
-	if err != sql.ErrNoRows {
-		return nil, fmt.Errorf("database error: %w", err)
+	return info, nil
+}
+
+// resolveDomain finds the domain record for host, exact match first.
+func (tm *TenantManager) resolveDomain(host string) (*Domain, error) {
+	return tm.explainDomain(host, nil)
+}
+
+// explainDomain is resolveDomain, recording in res (if not nil) the exact
+// row and every wildcard pattern it considered, and why it chose the match.
+// With caching enabled it looks in the routing table, otherwise in the
+// store.
+func (tm *TenantManager) explainDomain(host string, res *Resolution) (*Domain, error) {
+	ctx := context.Background()
+	routes := tm.routes.Load()
+
+	// Direct match; domains pending verification aren't routed yet
+	var d *Domain
+	var err error
+	if routes != nil {
+		if d = routes.exact[host]; d == nil {
+			err = ErrNotFound
+		}
+	} else {
+		d, err = tm.store.GetDomain(ctx, host)
+	}
+	if err == nil && d.Status != DomainPending {
+		if res != nil {
+			res.Exact = d
+			res.Reason = "exact match"
+		}
+		return d, nil
 	}
 
-	// Wildcard match (e.g., *.example.com)
-	rows, err := tm.db.Query("SELECT domain, tenant_id, project_route, project_port, backend_domain FROM tenants WHERE domain LIKE '%*%'")
-	if err != nil {
-		return nil, fmt.Errorf("wildcard query error: %w", err)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if res != nil && err == nil {
+		res.Exact = d
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var domain, tid, route string
-		var backendDom sql.NullString
-		var projectPort sql.NullInt64
-		if err := rows.Scan(&domain, &tid, &route, &projectPort, &backendDom); err != nil {
-			continue
+	// Wildcard match (e.g., *.example.com)
+	var match *Domain
+	var wildcards []Domain
+	if routes != nil {
+		match = routes.lookup(host)
+		wildcards = routes.wildcards
+	} else {
+		wildcards, err = tm.store.ListWildcardDomains(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("wildcard query error: %w", err)
 		}
 
-		// Convert wildcard pattern to match
-		if tm.matchWildcard(host, domain) {
-			if route == "" {
-				route = "/projects/backend"
+		// Most specific pattern first (e.g., *.eu.example.com before *.example.com)
+		sortWildcards(wildcards)
+		for i := range wildcards {
+			if tm.matchWildcard(host, wildcards[i].Name) {
+				match = &wildcards[i]
+				break
 			}
-			
-			var port *int
-			if projectPort.Valid {
-				p := int(projectPort.Int64)
-				port = &p
-			}
-			
-			var backendDomain *string
-			if backendDom.Valid && backendDom.String != "" {
-				dom := backendDom.String
-				backendDomain = &dom
-			}
-			
-			return &TenantInfo{
-				TenantID:     tid,
-				ProjectRoute: route,
-				ProjectPort:  port,
-				BackendDomain: backendDomain,
-			}, nil
 		}
 	}
 
-	return nil, fmt.Errorf("tenant not found for domain: %s", host)
+	if res != nil {
+		for _, w := range wildcards {
+			res.Candidates = append(res.Candidates, WildcardCandidate{
+				Pattern:  w.Name,
+				TenantID: w.TenantID,
+				Matches:  tm.matchWildcard(host, w.Name),
+				Selected: match != nil && match.Name == w.Name,
+			})
+		}
+	}
+
+	if match == nil {
+		if res != nil && res.Exact != nil {
+			res.Reason = "exact domain is pending verification and no wildcard pattern matches"
+		}
+		return nil, fmt.Errorf("%w: %s", ErrUnknownHost, host)
+	}
+	if res != nil {
+		res.Reason = "wildcard " + match.Name + " is the most specific pattern that matches"
+		if res.Exact != nil {
+			res.Reason = "exact domain is pending verification; " + res.Reason
+		}
+	}
+	return match, nil
 }
 
+// matchWildcard reports whether host matches pattern; see wildcard.go for
+// the pattern syntax.
 func (tm *TenantManager) matchWildcard(host, pattern string) bool {
-	pattern = strings.ToLower(pattern)
+	pattern = LowerDomain(pattern)
 
-	if !strings.Contains(pattern, "*") {
+	if !isWildcard(pattern) {
 		return host == pattern
 	}
+	_, matched := matchPattern(host, pattern)
+	return matched
+}
+
+// GetTenant returns the tenant with this ID.
+func (tm *TenantManager) GetTenant(id string) (*Tenant, error) {
+	return tm.store.GetTenant(context.Background(), id)
+}
 
-	// Simple wildcard matching: *.example.com matches subdomain.example.com
-	if strings.HasPrefix(pattern, "*.") {
-		suffix := pattern[2:] // Remove "*."
-		return strings.HasSuffix(host, suffix) && strings.Count(host, ".") >= strings.Count(suffix, ".")+1
+func (tm *TenantManager) ListTenants() ([]Tenant, error) {
+	return tm.store.ListTenants(context.Background())
+}
+
+// CreateTenant adds a new tenant, or returns ErrConflict if the ID is taken.
+func (tm *TenantManager) CreateTenant(ctx context.Context, t Tenant) error {
+	if err := tm.store.AddTenant(ctx, t); err != nil {
+		return err
 	}
 
-	if strings.HasSuffix(pattern, ".*") {
-		prefix := pattern[:len(pattern)-2] // Remove ".*"
-		return strings.HasPrefix(host, prefix) && strings.Count(host, ".") >= strings.Count(prefix, ".")+1
+	// Defaults apply to every domain of the tenant
+	tm.invalidateTenant(t.ID)
+
+	return nil
+}
+
+// PutTenant creates the tenant, or replaces it if it already exists, and
+// reports whether it was created. With a non-zero t.Revision the tenant must
+// exist at that revision.
+func (tm *TenantManager) PutTenant(ctx context.Context, t Tenant) (bool, error) {
+	created := t.Revision == 0
+	err := ErrConflict
+	if created {
+		err = tm.store.AddTenant(ctx, t)
+	}
+	if errors.Is(err, ErrConflict) {
+		created = false
+		err = tm.store.UpdateTenant(ctx, t)
+	}
+	if err != nil {
+		return false, err
 	}
 
-	// Full pattern matching (more complex cases)
-	parts := strings.Split(pattern, "*")
-	if len(parts) != 2 {
-		return false
+	tm.invalidateTenant(t.ID)
+
+	return created, nil
+}
+
+// UpdateTenant replaces an existing tenant's settings.
+func (tm *TenantManager) UpdateTenant(ctx context.Context, t Tenant) error {
+	if err := tm.store.UpdateTenant(ctx, t); err != nil {
+		return err
 	}
 
-	return strings.HasPrefix(host, parts[0]) && strings.HasSuffix(host, parts[1])
+	tm.invalidateTenant(t.ID)
+
+	return nil
 }
 
-func (tm *TenantManager) AddTenant(domain, tenantID, projectRoute string, projectPort *int, backendDomain *string) error {
-	domain = normalizeDomain(domain)
-	
-	// Set default if empty
-	if projectRoute == "" {
-		projectRoute = "/projects/backend"
-	}
-	
-	var portValue interface{}
-	if projectPort != nil {
-		portValue = *projectPort
-	} else {
-		portValue = nil
+// DeleteTenant removes a tenant that no longer has any domains. A non-zero
+// revision must match the stored one (ErrRevisionMismatch otherwise).
+func (tm *TenantManager) DeleteTenant(ctx context.Context, id string, revision int64) error {
+	if err := tm.store.DeleteTenant(ctx, id, revision); err != nil {
+		return err
 	}
-	
-	var backendDomainValue interface{}
-	if backendDomain != nil && *backendDomain != "" {
-		backendDomainValue = *backendDomain
-	} else {
-		backendDomainValue = nil
-	}
-	
-	_, err := tm.db.Exec(
-		"INSERT OR REPLACE INTO tenants (domain, tenant_id, project_route, project_port, backend_domain) VALUES (?, ?, ?, ?, ?)",
-		domain, tenantID, projectRoute, portValue, backendDomainValue,
-	)
-	
+
+	tm.invalidateTenant(id)
+
+	return nil
+}
+
+// ListMaintenanceWindows returns the tenant's scheduled maintenance windows.
+func (tm *TenantManager) ListMaintenanceWindows(tenantID string) ([]MaintenanceWindow, error) {
+	return tm.store.ListMaintenanceWindows(context.Background(), tenantID)
+}
+
+// AddMaintenanceWindow schedules a maintenance window for a tenant.
+func (tm *TenantManager) AddMaintenanceWindow(w MaintenanceWindow) (*MaintenanceWindow, error) {
+	created, err := tm.store.AddMaintenanceWindow(context.Background(), w)
 	if err != nil {
-		return fmt.Errorf("failed to add tenant: %w", err)
+		return nil, err
+	}
+
+	tm.invalidateTenant(w.TenantID)
+
+	return created, nil
+}
+
+// DeleteMaintenanceWindow cancels one of a tenant's maintenance windows.
+func (tm *TenantManager) DeleteMaintenanceWindow(tenantID string, id int64) error {
+	if err := tm.store.DeleteMaintenanceWindow(context.Background(), tenantID, id); err != nil {
+		return err
+	}
+
+	tm.invalidateTenant(tenantID)
+
+	return nil
+}
+
+// GetDomain returns the stored record for exactly this domain.
+func (tm *TenantManager) GetDomain(domain string) (*Domain, error) {
+	return tm.store.GetDomain(context.Background(), normalizeDomain(domain))
+}
+
+// ListDomains returns all domains, or only those of tenantID if it is set.
+func (tm *TenantManager) ListDomains(tenantID string) ([]Domain, error) {
+	return tm.store.ListDomains(context.Background(), tenantID)
+}
+
+// CreateDomain adds a new domain, or returns ErrConflict if it exists. A
+// tenant that doesn't exist yet is created with default settings. Invalid
+// wildcard patterns are ErrInvalidPattern.
+func (tm *TenantManager) CreateDomain(ctx context.Context, d Domain) error {
+	d.Name = normalizeDomain(d.Name)
+	if err := ValidateDomainPattern(d.Name); err != nil {
+		return err
+	}
+
+	if err := tm.ensureTenant(ctx, d.TenantID); err != nil {
+		return err
+	}
+
+	if err := tm.store.AddDomain(ctx, d); err != nil {
+		return err
 	}
 
 	// Invalidate cache
-	tm.invalidateCache(domain)
+	tm.invalidateCache(d.Name)
 
 	return nil
 }
 
-func (tm *TenantManager) DeleteTenant(domain string) error {
-	domain = normalizeDomain(domain)
-	
-	_, err := tm.db.Exec("DELETE FROM tenants WHERE domain = ?", domain)
-	
-	if err != nil {
-		return fmt.Errorf("failed to delete tenant: %w", err)
+// UpdateDomain replaces an existing domain record, or returns ErrNotFound.
+func (tm *TenantManager) UpdateDomain(ctx context.Context, d Domain) error {
+	d.Name = normalizeDomain(d.Name)
+
+	if err := tm.ensureTenant(ctx, d.TenantID); err != nil {
+		return err
+	}
+
+	if err := tm.store.UpdateDomain(ctx, d); err != nil {
+		return err
 	}
 
 	// Invalidate cache
-	tm.invalidateCache(domain)
+	tm.invalidateCache(d.Name)
 
 	return nil
 }
 
-func (tm *TenantManager) ListTenants() ([]map[string]interface{}, error) {
-	rows, err := tm.db.Query("SELECT domain, tenant_id, project_route, project_port, backend_domain, created_at FROM tenants ORDER BY domain")
+// PutDomain creates the domain record, or replaces it if it already exists,
+// and reports whether it was created. A tenant that doesn't exist yet is
+// created with default settings. With a non-zero d.Revision the domain must
+// exist at that revision.
+func (tm *TenantManager) PutDomain(ctx context.Context, d Domain) (bool, error) {
+	d.Name = normalizeDomain(d.Name)
+	if err := ValidateDomainPattern(d.Name); err != nil {
+		return false, err
+	}
+
+	if err := tm.ensureTenant(ctx, d.TenantID); err != nil {
+		return false, err
+	}
+
+	created := d.Revision == 0
+	err := ErrConflict
+	if created {
+		err = tm.store.AddDomain(ctx, d)
+	}
+	if errors.Is(err, ErrConflict) {
+		created = false
+		err = tm.store.UpdateDomain(ctx, d)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to list tenants: %w", err)
-	}
-	defer rows.Close()
-
-	var tenants []map[string]interface{}
-	for rows.Next() {
-		var domain, tenantID, projectRoute string
-		var backendDomain sql.NullString
-		var projectPort sql.NullInt64
-		var createdAt string
-		
-		if err := rows.Scan(&domain, &tenantID, &projectRoute, &projectPort, &backendDomain, &createdAt); err != nil {
-			continue
-		}
+		return false, err
+	}
 
-		if projectRoute == "" {
-			projectRoute = "/projects/backend"
-		}
+	// Invalidate cache
+	tm.invalidateCache(d.Name)
 
-		tenant := map[string]interface{}{
-			"domain":       domain,
-			"tenant_id":    tenantID,
-			"project_route": projectRoute,
-			"created_at":   createdAt,
-		}
-		
-		if projectPort.Valid {
-			tenant["project_port"] = projectPort.Int64
-		}
-		
-		if backendDomain.Valid && backendDomain.String != "" {
-			tenant["backend_domain"] = backendDomain.String
+	return created, nil
+}
+
+// ensureTenant creates the tenant with default settings if it doesn't exist.
+func (tm *TenantManager) ensureTenant(ctx context.Context, id string) error {
+	_, err := tm.store.GetTenant(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		err = tm.store.AddTenant(ctx, Tenant{ID: id, Name: id})
+		if errors.Is(err, ErrConflict) {
+			return nil
 		}
+	}
+	return err
+}
+
+// DeleteDomain removes a domain. A non-zero revision must match the stored
+// one (ErrRevisionMismatch otherwise).
+func (tm *TenantManager) DeleteDomain(ctx context.Context, domain string, revision int64) error {
+	domain = normalizeDomain(domain)
 
-		tenants = append(tenants, tenant)
+	if err := tm.store.DeleteDomain(ctx, domain, revision); err != nil {
+		return err
 	}
+	forgetPattern(domain)
+
+	// Invalidate cache
+	tm.invalidateCache(domain)
 
-	return tenants, nil
+	return nil
 }
 
+// ClearCache reloads the routing table and drops every cached lookup.
 func (tm *TenantManager) ClearCache() {
 	if tm.cacheEnabled {
-		tm.cacheMutex.Lock()
-		tm.cache = make(map[string]TenantInfo)
-		tm.cacheMutex.Unlock()
+		// The table first, so lookups after the clear don't use the old one
+		tm.reloadRoutes()
+
+		tm.cache.clear()
 	}
 }
 
@@ -342,18 +614,116 @@ func (tm *TenantManager) invalidateCache(domain string) {
 		return
 	}
 
-	if strings.Contains(domain, "*") {
-		tm.ClearCache()
+	if !tm.updateDomainRoute(domain) {
 		return
 	}
+	if isWildcard(domain) {
+		// A pattern can change which domain any host resolves to
+		tm.cache.clear()
+		return
+	}
+	tm.cache.delete(domain)
+}
 
-	tm.cacheMutex.Lock()
-	delete(tm.cache, domain)
-	tm.cacheMutex.Unlock()
+// invalidateTenant updates the tenant in the routing table and drops the
+// cached lookups, as its settings apply to all of its domains.
+func (tm *TenantManager) invalidateTenant(id string) {
+	if !tm.cacheEnabled {
+		return
+	}
+
+	if tm.updateTenantRoute(id) {
+		tm.cache.clear()
+	}
+}
+
+// info merges the domain's overrides with its tenant's defaults into the
+// routing information used by the proxy.
+func (d *Domain) info(t *Tenant) *TenantInfo {
+	info := &TenantInfo{
+		TenantID:         t.ID,
+		ProjectRoute:     t.ProjectRoute,
+		ProjectPort:      t.ProjectPort,
+		BackendDomain:    t.BackendDomain,
+		UpstreamProtocol: t.UpstreamProtocol,
+		ProxyProtocol:    t.ProxyProtocol,
+		Status:           t.Status,
+	}
+	// Entries are validated when the tenant is saved
+	info.MaintenanceAllow, _ = ParseIPList(t.MaintenanceAllowlist)
+	if d.ProjectRoute != "" {
+		info.ProjectRoute = d.ProjectRoute
+	}
+	if d.ProjectPort != nil {
+		info.ProjectPort = d.ProjectPort
+		if *d.ProjectPort == 0 {
+			info.ProjectPort = nil
+		}
+	}
+	if d.BackendDomain != nil {
+		info.BackendDomain = d.BackendDomain
+		if *d.BackendDomain == None {
+			info.BackendDomain = nil
+		}
+	}
+	if d.UpstreamProtocol != "" {
+		info.UpstreamProtocol = d.UpstreamProtocol
+		if d.UpstreamProtocol == None {
+			info.UpstreamProtocol = ""
+		}
+	}
+	if d.ProxyProtocol != "" {
+		info.ProxyProtocol = d.ProxyProtocol
+		if d.ProxyProtocol == None {
+			info.ProxyProtocol = ""
+		}
+	}
+	info.RequestHeaders = d.RequestHeaders
+	return info
+}
+
+// ParseIPList parses IP addresses and CIDRs into networks.
+func ParseIPList(entries []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q: %w", entry, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// isWildcard reports whether a domain is a wildcard pattern.
+func isWildcard(domain string) bool {
+	return strings.ContainsAny(domain, "*{")
 }
 
 func normalizeDomain(domain string) string {
-	domain = strings.ToLower(domain)
-	parts := strings.Split(domain, ":")
-	return parts[0]
+	domain = LowerDomain(domain)
+	// Strip the port, but not the ':' of a {name:regexp} capture
+	if i := strings.LastIndexByte(domain, ':'); i > strings.LastIndexByte(domain, '}') {
+		domain = domain[:i]
+	}
+	return domain
 }
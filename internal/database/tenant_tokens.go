@@ -0,0 +1,290 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TenantTokenPrefix starts every tenant token, so they can't be mistaken
+// for admin API keys.
+const TenantTokenPrefix = "ttk_"
+
+// Errors returned by AuthenticateTenantToken and AddTenantDomain
+var (
+	ErrInvalidTenantToken = errors.New("invalid tenant token")
+	ErrTenantTokenExpired = errors.New("tenant token expired")
+	ErrTenantTokenRevoked = errors.New("tenant token revoked")
+	ErrQuotaExceeded      = errors.New("domain quota exceeded")
+)
+
+// TenantToken is a credential for the tenant API, with which a customer
+// manages the domains of one tenant. As with API keys only a hash is stored.
+type TenantToken struct {
+	ID       string `json:"id"`
+	TenantID string `json:"tenant_id"`
+	Name     string `json:"name"`
+	// Prefix is the start of the token, to tell tokens apart
+	Prefix string `json:"prefix"`
+	// AllowRouting lets the token set the routing settings of its domains
+	// (backend domain, port, route and protocols); without it domains
+	// always inherit the tenant's
+	AllowRouting bool       `json:"allow_routing"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+	CreatedBy    string     `json:"created_by,omitempty"`
+	CreatedAt    string     `json:"created_at"`
+	// Hash is the SHA-256 of the token, set when it is created; never listed
+	Hash string `json:"-"`
+}
+
+// Actor is how the token's requests are recorded in the history and audit
+// log.
+func (t *TenantToken) Actor() string {
+	return "token:" + t.TenantID + "/" + t.Name
+}
+
+// Allows reports whether the token grants scope within its tenant: tokens
+// read and write, but never administer.
+func (t *TenantToken) Allows(scope string) bool {
+	return scopeLevels[scope] <= scopeLevels[ScopeWrite]
+}
+
+// CanAccessTenant reports whether id is the token's tenant.
+func (t *TenantToken) CanAccessTenant(id string) bool {
+	return id == t.TenantID
+}
+
+// Tenant tokens
+
+func (s *sqlStore) tenantTokenColumns() string {
+	return "id, tenant_id, name, prefix, allow_routing, expires_at, last_used_at, revoked_at, created_by, " + s.ts("created_at")
+}
+
+func (s *sqlStore) CreateTenantToken(ctx context.Context, t TenantToken) error {
+	var expires interface{}
+	if t.ExpiresAt != nil {
+		expires = t.ExpiresAt.UTC().Truncate(time.Second)
+	}
+	_, err := s.db.ExecContext(ctx, s.rebind(
+		"INSERT INTO tenant_tokens (id, tenant_id, name, prefix, key_hash, allow_routing, expires_at, created_by, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+		t.ID, t.TenantID, t.Name, t.Prefix, t.Hash, t.AllowRouting, expires, nullIfEmpty(t.CreatedBy),
+		time.Now().UTC().Truncate(time.Second),
+	)
+	if s.isUniqueViolation(err) {
+		return fmt.Errorf("tenant token %s: %w", t.Name, ErrConflict)
+	}
+	if s.isForeignKeyViolation(err) {
+		return fmt.Errorf("tenant %s: %w", t.TenantID, ErrNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create tenant token: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) GetTenantToken(ctx context.Context, id string) (*TenantToken, error) {
+	return s.getTenantToken(ctx, "id", id)
+}
+
+func (s *sqlStore) GetTenantTokenByHash(ctx context.Context, hash string) (*TenantToken, error) {
+	return s.getTenantToken(ctx, "key_hash", hash)
+}
+
+func (s *sqlStore) getTenantToken(ctx context.Context, column, value string) (*TenantToken, error) {
+	row := s.db.QueryRowContext(ctx, s.rebind("SELECT "+s.tenantTokenColumns()+" FROM tenant_tokens WHERE "+column+" = ?"), value)
+	t, err := scanTenantToken(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return t, nil
+}
+
+func (s *sqlStore) ListTenantTokens(ctx context.Context, tenantID string) ([]TenantToken, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind("SELECT "+s.tenantTokenColumns()+" FROM tenant_tokens WHERE tenant_id = ? ORDER BY created_at, id"), tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tenant tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []TenantToken
+	for rows.Next() {
+		t, err := scanTenantToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan tenant token: %w", err)
+		}
+		tokens = append(tokens, *t)
+	}
+	return tokens, rows.Err()
+}
+
+func (s *sqlStore) RevokeTenantToken(ctx context.Context, tenantID, id string) error {
+	res, err := s.db.ExecContext(ctx, s.rebind("UPDATE tenant_tokens SET revoked_at = COALESCE(revoked_at, ?) WHERE tenant_id = ? AND id = ?"),
+		time.Now().UTC().Truncate(time.Second), tenantID, id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke tenant token: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("tenant token %s: %w", id, ErrNotFound)
+	}
+	return nil
+}
+
+func (s *sqlStore) TouchTenantToken(ctx context.Context, id string, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, s.rebind("UPDATE tenant_tokens SET last_used_at = ? WHERE id = ?"), at.UTC().Truncate(time.Second), id)
+	if err != nil {
+		return fmt.Errorf("failed to update tenant token: %w", err)
+	}
+	return nil
+}
+
+// scanTenantToken reads a row selected with tenantTokenColumns.
+func scanTenantToken(row rowScanner) (*TenantToken, error) {
+	var t TenantToken
+	var expires, lastUsed, revoked sql.NullTime
+	var createdBy, createdAt sql.NullString
+
+	if err := row.Scan(&t.ID, &t.TenantID, &t.Name, &t.Prefix, &t.AllowRouting, &expires, &lastUsed, &revoked, &createdBy, &createdAt); err != nil {
+		return nil, err
+	}
+
+	t.ExpiresAt = timePtr(expires)
+	t.LastUsedAt = timePtr(lastUsed)
+	t.RevokedAt = timePtr(revoked)
+	t.CreatedBy = createdBy.String
+	t.CreatedAt = createdAt.String
+
+	return &t, nil
+}
+
+// CreateTenantToken generates a token for the tenant, stores its hash and
+// returns the token, which can't be retrieved again. A nil expiresAt never
+// expires.
+func (tm *TenantManager) CreateTenantToken(ctx context.Context, tenantID, name string, allowRouting bool, expiresAt *time.Time) (string, *TenantToken, error) {
+	if name == "" {
+		return "", nil, fmt.Errorf("tenant token name is required")
+	}
+
+	id, err := randomString(9)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate tenant token: %w", err)
+	}
+	secret, err := randomString(32)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to generate tenant token: %w", err)
+	}
+	token := TenantTokenPrefix + secret
+
+	t := TenantToken{
+		ID:           id,
+		TenantID:     tenantID,
+		Name:         name,
+		Prefix:       token[:len(TenantTokenPrefix)+6],
+		AllowRouting: allowRouting,
+		ExpiresAt:    expiresAt,
+		CreatedBy:    ActorFromContext(ctx),
+		Hash:         hashAPIKey(token),
+	}
+	if err := tm.store.CreateTenantToken(ctx, t); err != nil {
+		return "", nil, err
+	}
+
+	created, err := tm.store.GetTenantToken(ctx, id)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, created, nil
+}
+
+// AuthenticateTenantToken returns the stored token matching token, or
+// ErrInvalidTenantToken, ErrTenantTokenExpired or ErrTenantTokenRevoked.
+// The token's last-used time is updated at most once a minute.
+func (tm *TenantManager) AuthenticateTenantToken(ctx context.Context, token string) (*TenantToken, error) {
+	if !strings.HasPrefix(token, TenantTokenPrefix) {
+		return nil, ErrInvalidTenantToken
+	}
+
+	t, err := tm.store.GetTenantTokenByHash(ctx, hashAPIKey(token))
+	if errors.Is(err, ErrNotFound) {
+		return nil, ErrInvalidTenantToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if t.RevokedAt != nil {
+		return nil, ErrTenantTokenRevoked
+	}
+	if t.ExpiresAt != nil && !now.Before(*t.ExpiresAt) {
+		return nil, ErrTenantTokenExpired
+	}
+
+	if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) >= apiKeyTouchInterval {
+		if err := tm.store.TouchTenantToken(ctx, t.ID, now); err != nil {
+			return nil, err
+		}
+		t.LastUsedAt = &now
+	}
+
+	return t, nil
+}
+
+// ListTenantTokens returns the tenant's tokens, revoked ones included,
+// oldest first.
+func (tm *TenantManager) ListTenantTokens(tenantID string) ([]TenantToken, error) {
+	return tm.store.ListTenantTokens(context.Background(), tenantID)
+}
+
+// GetTenantToken returns one tenant token by its ID.
+func (tm *TenantManager) GetTenantToken(id string) (*TenantToken, error) {
+	return tm.store.GetTenantToken(context.Background(), id)
+}
+
+// RevokeTenantToken disables one of the tenant's tokens for good.
+func (tm *TenantManager) RevokeTenantToken(ctx context.Context, tenantID, id string) error {
+	return tm.store.RevokeTenantToken(ctx, tenantID, id)
+}
+
+// AddTenantDomain claims a domain for an existing tenant on the tenant's own
+// behalf: unlike CreateDomain the tenant isn't created, and ErrQuotaExceeded
+// is returned once the tenant has its max_domains domains, or defaultQuota
+// if it has no quota of its own. The domain is pending, and not routed,
+// until its ownership is verified with the returned verification. Domains
+// another tenant's wildcard covers are ErrConflict.
+func (tm *TenantManager) AddTenantDomain(ctx context.Context, d Domain, defaultQuota int) (*DomainVerification, error) {
+	d.Name = normalizeDomain(d.Name)
+
+	wildcards, err := tm.store.ListWildcardDomains(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("wildcard query error: %w", err)
+	}
+	for _, w := range wildcards {
+		if w.TenantID != d.TenantID && tm.matchWildcard(d.Name, w.Name) {
+			return nil, fmt.Errorf("domain %s is covered by %s: %w", d.Name, w.Name, ErrConflict)
+		}
+	}
+
+	token, err := randomString(24)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate verification token: %w", err)
+	}
+	v := &DomainVerification{
+		Token:     token,
+		ExpiresAt: time.Now().UTC().Add(tm.verifyPolicy.ClaimTTL),
+	}
+	if err := tm.store.AddDomainWithinQuota(ctx, d, v, defaultQuota); err != nil {
+		return nil, err
+	}
+
+	tm.invalidateCache(d.Name)
+
+	return tm.store.GetDomainVerification(ctx, d.Name)
+}
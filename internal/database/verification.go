@@ -0,0 +1,318 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Domain statuses.
+const (
+	DomainActive  = "active"  // Routed (default)
+	DomainPending = "pending" // Claimed through the tenant API, ownership not yet verified
+)
+
+// verifierActor records the changes the background verification makes.
+const verifierActor = "domain-verifier"
+
+// ErrVerificationDisabled is returned by VerifyDomain when no DomainChecker
+// has been set up.
+var ErrVerificationDisabled = errors.New("domain verification is not enabled")
+
+// DomainVerification is the proof of ownership a tenant owes for a domain it
+// claimed through the tenant API. The domain stays pending, and isn't
+// routed, until the token is found in DNS or on the domain itself.
+type DomainVerification struct {
+	Domain string `json:"domain"`
+	Token  string `json:"token"`
+	// Method is how the domain was last verified: dns or http
+	Method string `json:"method,omitempty"`
+	// ExpiresAt is when the claim is dropped unless verified by then
+	ExpiresAt  time.Time  `json:"expires_at"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+	CheckedAt  *time.Time `json:"checked_at,omitempty"`
+	// FailingSince is set while a verified domain fails re-verification
+	FailingSince *time.Time `json:"failing_since,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	CreatedAt    string     `json:"created_at"`
+}
+
+// DomainChecker looks for a domain's verification token and reports the
+// method that found it.
+type DomainChecker interface {
+	Check(ctx context.Context, domain, token string) (method string, err error)
+}
+
+// VerificationPolicy sets how long claims may stay unverified and how often
+// domains are checked.
+type VerificationPolicy struct {
+	ClaimTTL time.Duration // Unverified claims are dropped after this long
+	Interval time.Duration // How often pending claims are checked
+	Reverify time.Duration // How often verified domains are checked again; 0 never
+	Grace    time.Duration // How long a verified domain may fail before it's pending again
+}
+
+// DefaultVerificationPolicy is used until StartDomainVerification is called.
+var DefaultVerificationPolicy = VerificationPolicy{
+	ClaimTTL: 72 * time.Hour,
+	Interval: time.Minute,
+	Reverify: 24 * time.Hour,
+	Grace:    72 * time.Hour,
+}
+
+// Domain verifications
+
+func (s *sqlStore) domainVerificationColumns() string {
+	return "domain, token, method, expires_at, verified_at, checked_at, failing_since, error, " + s.ts("created_at")
+}
+
+func (s *sqlStore) insertDomainVerification(ctx context.Context, tx *sql.Tx, v DomainVerification) error {
+	_, err := tx.ExecContext(ctx, s.rebind(
+		"INSERT INTO domain_verifications (domain, token, expires_at, created_at) VALUES (?, ?, ?, ?)"),
+		v.Domain, v.Token, v.ExpiresAt.UTC().Truncate(time.Second), time.Now().UTC().Truncate(time.Second),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to add domain verification: %w", err)
+	}
+	return nil
+}
+
+func (s *sqlStore) GetDomainVerification(ctx context.Context, domain string) (*DomainVerification, error) {
+	row := s.db.QueryRowContext(ctx, s.rebind("SELECT "+s.domainVerificationColumns()+" FROM domain_verifications WHERE domain = ?"), domain)
+	v, err := scanDomainVerification(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	return v, nil
+}
+
+func (s *sqlStore) ListDomainVerifications(ctx context.Context) ([]DomainVerification, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT "+s.domainVerificationColumns()+" FROM domain_verifications ORDER BY domain")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list domain verifications: %w", err)
+	}
+	defer rows.Close()
+
+	var verifications []DomainVerification
+	for rows.Next() {
+		v, err := scanDomainVerification(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan domain verification: %w", err)
+		}
+		verifications = append(verifications, *v)
+	}
+	return verifications, rows.Err()
+}
+
+func (s *sqlStore) UpdateDomainVerification(ctx context.Context, v DomainVerification, status string) error {
+	changed := false
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := s.getDomain(ctx, tx, v.Domain, true)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, s.rebind(
+			"UPDATE domain_verifications SET method = ?, expires_at = ?, verified_at = ?, checked_at = ?, failing_since = ?, error = ? WHERE domain = ?"),
+			nullIfEmpty(v.Method), v.ExpiresAt.UTC().Truncate(time.Second), timeValue(v.VerifiedAt), timeValue(v.CheckedAt),
+			timeValue(v.FailingSince), nullIfEmpty(v.Error), v.Domain,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to update domain verification: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return fmt.Errorf("domain verification %s: %w", v.Domain, ErrNotFound)
+		}
+
+		if before.Status == status {
+			return nil
+		}
+		_, err = tx.ExecContext(ctx, s.rebind("UPDATE domains SET status = ?, revision = revision + 1 WHERE domain = ?"), status, v.Domain)
+		if err != nil {
+			return fmt.Errorf("failed to update domain: %w", err)
+		}
+		after, err := s.getDomain(ctx, tx, v.Domain, false)
+		if err != nil {
+			return err
+		}
+		changed = true
+		return s.recordDomainChange(ctx, tx, before, after)
+	})
+	if err != nil {
+		return err
+	}
+
+	if changed {
+		s.notify(v.Domain)
+	}
+	return nil
+}
+
+// scanDomainVerification reads a row selected with domainVerificationColumns.
+func scanDomainVerification(row rowScanner) (*DomainVerification, error) {
+	var v DomainVerification
+	var method, lastError, createdAt sql.NullString
+	var verified, checked, failing sql.NullTime
+
+	if err := row.Scan(&v.Domain, &v.Token, &method, &v.ExpiresAt, &verified, &checked, &failing, &lastError, &createdAt); err != nil {
+		return nil, err
+	}
+
+	v.Method = method.String
+	v.VerifiedAt = timePtr(verified)
+	v.CheckedAt = timePtr(checked)
+	v.FailingSince = timePtr(failing)
+	v.Error = lastError.String
+	v.CreatedAt = createdAt.String
+
+	return &v, nil
+}
+
+// timeValue converts an optional time to a column value.
+func timeValue(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Truncate(time.Second)
+}
+
+// StartDomainVerification checks claimed domains with checker in the
+// background until Close: pending claims every p.Interval, verified domains
+// every p.Reverify. Claims that aren't verified within p.ClaimTTL are
+// deleted, and verified domains that keep failing for p.Grace become pending
+// again, with a new claim period.
+func (tm *TenantManager) StartDomainVerification(checker DomainChecker, p VerificationPolicy) {
+	tm.verifier = checker
+	tm.verifyPolicy = p
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tm.stopVerify = cancel
+	go func() {
+		ticker := time.NewTicker(p.Interval)
+		defer ticker.Stop()
+		for {
+			if err := tm.checkDomainVerifications(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("[VERIFY] ERROR: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// checkDomainVerifications runs one round of the background verification.
+func (tm *TenantManager) checkDomainVerifications(ctx context.Context) error {
+	verifications, err := tm.store.ListDomainVerifications(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctx = WithActor(ctx, verifierActor)
+	now := time.Now().UTC()
+	for i := range verifications {
+		v := &verifications[i]
+		d, err := tm.store.GetDomain(ctx, v.Domain)
+		if err != nil {
+			continue // Deleted meanwhile
+		}
+
+		if d.Status == DomainPending && !now.Before(v.ExpiresAt) {
+			log.Printf("[VERIFY] Claim of %s by tenant %s expired unverified", d.Name, d.TenantID)
+			if err := tm.DeleteDomain(withChangeNote(ctx, "claim expired unverified"), d.Name, d.Revision); err != nil && !errors.Is(err, ErrNotFound) && !errors.Is(err, ErrRevisionMismatch) {
+				return err
+			}
+			continue
+		}
+		if d.Status == DomainActive && (tm.verifyPolicy.Reverify <= 0 || v.CheckedAt != nil && now.Sub(*v.CheckedAt) < tm.verifyPolicy.Reverify) {
+			continue
+		}
+
+		if _, err := tm.verifyDomain(ctx, d, v); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// VerifyDomain checks a claimed domain right away, activating it if its
+// token is found, and returns the updated verification.
+func (tm *TenantManager) VerifyDomain(ctx context.Context, domain string) (*DomainVerification, error) {
+	if tm.verifier == nil {
+		return nil, ErrVerificationDisabled
+	}
+
+	d, err := tm.store.GetDomain(ctx, normalizeDomain(domain))
+	if err != nil {
+		return nil, err
+	}
+	v, err := tm.store.GetDomainVerification(ctx, d.Name)
+	if err != nil {
+		return nil, err
+	}
+	return tm.verifyDomain(ctx, d, v)
+}
+
+// verifyDomain checks v and stores the outcome: pending domains are
+// activated once their token is found, while verified domains go back to
+// pending once they've failed for longer than the grace period.
+func (tm *TenantManager) verifyDomain(ctx context.Context, d *Domain, v *DomainVerification) (*DomainVerification, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	status := d.Status
+
+	method, err := tm.verifier.Check(ctx, d.Name, v.Token)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	v.CheckedAt = &now
+	switch {
+	case err == nil:
+		if status == DomainPending {
+			log.Printf("[VERIFY] Verified %s for tenant %s by %s", d.Name, d.TenantID, method)
+		}
+		status = DomainActive
+		v.Method = method
+		v.VerifiedAt = &now
+		v.FailingSince = nil
+		v.Error = ""
+	case status == DomainPending:
+		v.Error = err.Error()
+	default:
+		v.Error = err.Error()
+		if v.FailingSince == nil {
+			log.Printf("[VERIFY] Re-verification of %s failed: %v", d.Name, err)
+			v.FailingSince = &now
+		}
+		if now.Sub(*v.FailingSince) >= tm.verifyPolicy.Grace {
+			log.Printf("[VERIFY] %s failed re-verification since %s; pending again", d.Name, v.FailingSince.Format(time.RFC3339))
+			status = DomainPending
+			v.ExpiresAt = now.Add(tm.verifyPolicy.ClaimTTL)
+			v.FailingSince = nil
+		}
+	}
+
+	if err := tm.store.UpdateDomainVerification(ctx, *v, status); err != nil {
+		return nil, err
+	}
+	if status != d.Status {
+		tm.invalidateCache(d.Name)
+	}
+	return v, nil
+}
+
+// GetDomainVerification returns the verification of a claimed domain, or
+// ErrNotFound for domains added by an administrator.
+func (tm *TenantManager) GetDomainVerification(domain string) (*DomainVerification, error) {
+	return tm.store.GetDomainVerification(context.Background(), normalizeDomain(domain))
+}
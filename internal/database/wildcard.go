@@ -0,0 +1,363 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// ErrInvalidPattern is returned for wildcard domains that aren't valid
+// patterns.
+var ErrInvalidPattern = errors.New("invalid wildcard pattern")
+
+// Wildcard patterns are matched label by label:
+//
+//	*.example.com         exactly one label: a.example.com, not a.b.example.com
+//	*.*.example.com       exactly two labels: a.b.example.com
+//	**.example.com        one or more labels: a.example.com, a.b.example.com
+//	tenant-*.example.com  one label starting with tenant-: tenant-a.example.com
+//	tenant.*              tenant.com, tenant.org
+//
+// A '*' can also be written as a named capture, {slug}, which matches the
+// same but makes the matched text available to the domain's templates (see
+// template.go), or {slug:regexp}, which only matches labels the regular
+// expression matches in full:
+//
+//	{slug}.apps.example.com          acme.apps.example.com, with slug=acme
+//	pr-{id:[0-9]+}.preview.example.com  pr-42.preview.example.com, with id=42
+//
+// When several patterns match a host the most specific one wins. Patterns
+// are compared label by label from the right, and the first label that
+// differs decides: a literal label beats a partial one (tenant-*), which
+// beats *, which beats **; of two partial labels the one with more fixed
+// characters wins, and a capture with a regular expression beats one
+// without. A pattern with more labels beats one that is otherwise equal,
+// and remaining ties go to the name that sorts first.
+
+// Kinds of pattern labels, from least to most specific.
+const (
+	labelAnyDepth = iota // **
+	labelAny             // *, {slug}
+	labelPartial         // tenant-*, tenant-{slug}
+	labelLiteral         // example
+)
+
+// captureName is the syntax of the name in {name} and {name:regexp}.
+var captureName = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+// patternLabel is one label of a parsed pattern.
+type patternLabel struct {
+	text           string
+	kind           int
+	prefix, suffix string         // Fixed text around the '*' or capture
+	name           string         // Capture name, if the label captures
+	re             *regexp.Regexp // Constraint of a {name:regexp} capture
+}
+
+// hostPattern is a parsed wildcard pattern.
+type hostPattern struct {
+	labels []patternLabel
+}
+
+// patterns caches the stored patterns by name, as they're parsed when
+// hosts are matched against them. Deleting a domain drops its entry, and
+// reloading the routing table drops those of domains deleted elsewhere.
+var patterns sync.Map // string -> *hostPattern
+
+// parsePattern parses a stored wildcard pattern, reusing an earlier parse.
+func parsePattern(name string) (*hostPattern, error) {
+	if p, ok := patterns.Load(name); ok {
+		return p.(*hostPattern), nil
+	}
+
+	p, err := compilePattern(name)
+	if err != nil {
+		return nil, err
+	}
+	patterns.Store(name, p)
+	return p, nil
+}
+
+// forgetPattern drops the parse of a pattern that's no longer stored.
+func forgetPattern(name string) {
+	patterns.Delete(name)
+}
+
+// keepPatterns drops the parses of patterns that aren't among domains.
+func keepPatterns(domains []Domain) {
+	stored := make(map[string]bool, len(domains))
+	for _, d := range domains {
+		stored[d.Name] = true
+	}
+	patterns.Range(func(name, _ any) bool {
+		if !stored[name.(string)] {
+			patterns.Delete(name)
+		}
+		return true
+	})
+}
+
+func compilePattern(name string) (*hostPattern, error) {
+	p, err := compileLabels(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %v", ErrInvalidPattern, name, err)
+	}
+	return p, nil
+}
+
+func compileLabels(name string) (*hostPattern, error) {
+	if len(name) > 253 {
+		return nil, errors.New("longer than 253 characters")
+	}
+	labels := splitLabels(name)
+	if len(labels) < 2 {
+		return nil, errors.New("needs at least two labels, e.g. *.example.com")
+	}
+
+	p := &hostPattern{}
+	literal := false
+	captured := make(map[string]bool)
+	for i, text := range labels {
+		label, err := parseLabel(text)
+		if err != nil {
+			return nil, err
+		}
+		if label.kind == labelAnyDepth && i != 0 {
+			return nil, errors.New("** is only allowed as the first label")
+		}
+		if label.kind == labelLiteral {
+			literal = true
+		}
+		if label.name != "" {
+			if captured[label.name] {
+				return nil, fmt.Errorf("{%s} is captured twice", label.name)
+			}
+			captured[label.name] = true
+		}
+		p.labels = append(p.labels, label)
+	}
+	if !literal {
+		return nil, errors.New("needs at least one label without * or captures")
+	}
+	return p, nil
+}
+
+// LowerDomain lowercases a domain or pattern, as hosts are matched
+// lowercased, but leaves the {name:regexp} captures as they are: case
+// matters in regular expressions, where \D isn't \d.
+func LowerDomain(name string) string {
+	if !strings.Contains(name, "{") {
+		return strings.ToLower(name)
+	}
+	var b strings.Builder
+	b.Grow(len(name))
+	depth := 0
+	for _, c := range name {
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		}
+		if depth == 0 {
+			c = unicode.ToLower(c)
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// splitLabels splits a pattern at the dots outside of captures, which may
+// contain dots in their regular expression.
+func splitLabels(name string) []string {
+	var labels []string
+	depth, start := 0, 0
+	for i, c := range name {
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case '.':
+			if depth == 0 {
+				labels = append(labels, name[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(labels, name[start:])
+}
+
+func parseLabel(text string) (patternLabel, error) {
+	if text == "" {
+		return patternLabel{}, errors.New("empty label")
+	}
+	if text == "**" {
+		return patternLabel{text: text, kind: labelAnyDepth}, nil
+	}
+
+	label := patternLabel{text: text}
+	if open := strings.IndexByte(text, '{'); open >= 0 {
+		end := strings.LastIndexByte(text, '}')
+		if end < open {
+			return label, fmt.Errorf("label %q has an unclosed {", text)
+		}
+		label.prefix, label.suffix = text[:open], text[end+1:]
+
+		capture := text[open+1 : end]
+		name, expr, constrained := strings.Cut(capture, ":")
+		if !captureName.MatchString(name) {
+			return label, fmt.Errorf("capture {%s} needs a name of lowercase letters, digits and _", capture)
+		}
+		label.name = name
+		if constrained {
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				return label, fmt.Errorf("capture {%s}: %v", capture, err)
+			}
+			label.re = re
+		}
+	} else if prefix, suffix, found := strings.Cut(text, "*"); found {
+		label.prefix, label.suffix = prefix, suffix
+	} else {
+		label.prefix = text
+		label.kind = labelLiteral
+	}
+
+	fixed := label.prefix + label.suffix
+	if label.kind != labelLiteral {
+		label.kind = labelAny
+		if fixed != "" {
+			label.kind = labelPartial
+		}
+	}
+	if len(fixed) > 63 {
+		return label, fmt.Errorf("label %q is longer than 63 characters", text)
+	}
+	for _, c := range fixed {
+		if c == '*' || c == '{' || c == '}' {
+			return label, fmt.Errorf("label %q can contain only one * or capture", text)
+		}
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return label, fmt.Errorf("label %q may only contain lowercase letters, digits, - and *", text)
+		}
+	}
+	return label, nil
+}
+
+// ValidateDomainPattern checks the syntax of a wildcard domain; domains
+// without a '*' or capture are always accepted.
+func ValidateDomainPattern(name string) error {
+	if !isWildcard(name) {
+		return nil
+	}
+	_, err := compilePattern(name)
+	return err
+}
+
+// matchPattern reports whether host matches the wildcard pattern, and
+// returns the labels captured by name.
+func matchPattern(host, pattern string) (map[string]string, bool) {
+	p, err := parsePattern(pattern)
+	if err != nil {
+		return nil, false
+	}
+
+	hostLabels := strings.Split(host, ".")
+	labels := p.labels
+	if labels[0].kind == labelAnyDepth {
+		// One or more labels, then the rest of the pattern
+		labels = labels[1:]
+		if len(hostLabels) <= len(labels) {
+			return nil, false
+		}
+		hostLabels = hostLabels[len(hostLabels)-len(labels):]
+	}
+	if len(hostLabels) != len(labels) {
+		return nil, false
+	}
+
+	var captures map[string]string
+	for i, label := range labels {
+		value, ok := label.match(hostLabels[i])
+		if !ok {
+			return nil, false
+		}
+		if label.name != "" {
+			if captures == nil {
+				captures = make(map[string]string)
+			}
+			captures[label.name] = value
+		}
+	}
+	return captures, true
+}
+
+// match matches one host label, returning the part the '*' or capture
+// stands for; that is one or more characters.
+func (l *patternLabel) match(hostLabel string) (string, bool) {
+	if l.kind == labelLiteral {
+		return "", hostLabel == l.prefix
+	}
+	if len(hostLabel) <= len(l.prefix)+len(l.suffix) ||
+		!strings.HasPrefix(hostLabel, l.prefix) || !strings.HasSuffix(hostLabel, l.suffix) {
+		return "", false
+	}
+	value := hostLabel[len(l.prefix) : len(hostLabel)-len(l.suffix)]
+	if l.re != nil && !l.re.MatchString(value) {
+		return "", false
+	}
+	return value, true
+}
+
+// comparePatterns orders patterns by precedence: negative if a wins over b.
+// Patterns that don't parse never match and sort last.
+func comparePatterns(a, b string) int {
+	pa, errA := parsePattern(a)
+	pb, errB := parsePattern(b)
+	switch {
+	case errA != nil || errB != nil:
+		if (errA != nil) != (errB != nil) {
+			if errA != nil {
+				return 1
+			}
+			return -1
+		}
+		return strings.Compare(a, b)
+	}
+
+	al, bl := pa.labels, pb.labels
+	for i := 1; i <= len(al) && i <= len(bl); i++ {
+		x, y := &al[len(al)-i], &bl[len(bl)-i]
+		if x.kind != y.kind {
+			return y.kind - x.kind
+		}
+		if x.kind == labelLiteral {
+			continue
+		}
+		if fx, fy := len(x.prefix)+len(x.suffix), len(y.prefix)+len(y.suffix); fx != fy {
+			return fy - fx
+		}
+		if (x.re != nil) != (y.re != nil) {
+			if x.re != nil {
+				return -1
+			}
+			return 1
+		}
+	}
+	if len(al) != len(bl) {
+		return len(bl) - len(al)
+	}
+	return strings.Compare(a, b)
+}
+
+// sortWildcards orders wildcard domains by precedence, most specific first.
+func sortWildcards(domains []Domain) {
+	sort.SliceStable(domains, func(i, j int) bool {
+		return comparePatterns(domains[i].Name, domains[j].Name) < 0
+	})
+}
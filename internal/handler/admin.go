@@ -1,73 +1,549 @@
 package handler
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/tenantical/router/internal/database"
+	"github.com/tenantical/router/internal/proxyproto"
 )
 
 type AdminHandler struct {
 	tenantManager *database.TenantManager
+	auth          AuthSettings
+	proxy         *ProxyHandler // Explains routing for GET /admin/resolve
 }
 
-func NewAdminHandler(tm *database.TenantManager) *AdminHandler {
+// AuthSettings configure how operators sign in to the admin panel.
+type AuthSettings struct {
+	SessionTTL    time.Duration // Lifetime of operator sessions
+	SecureCookies bool          // Send the session cookie over HTTPS only
+	PasswordLogin bool          // Allow signing in with a username and password
+	SSO           *SSO          // Single sign-on with an OpenID Connect provider; nil if disabled
+}
+
+func NewAdminHandler(tm *database.TenantManager, auth AuthSettings) *AdminHandler {
 	return &AdminHandler{
 		tenantManager: tm,
+		auth:          auth,
+	}
+}
+
+// routingSettings are the per-tenant defaults that a domain can override.
+type routingSettings struct {
+	ProjectRoute     string  `json:"project_route"`               // Optional, defaults to /projects/backend (or the tenant's route for domains)
+	ProjectPort      *int    `json:"project_port,omitempty"`      // Optional port for project
+	BackendDomain    *string `json:"backend_domain,omitempty"`    // Optional backend domain (e.g., localhost, admin.local)
+	UpstreamProtocol string  `json:"upstream_protocol,omitempty"` // Optional upstream protocol: http1, h2, h2c
+	ProxyProtocol    string  `json:"proxy_protocol,omitempty"`    // Optional PROXY protocol header sent to backend: v1, v2
+}
+
+// validate returns a message describing the first invalid setting, or "".
+func (s *routingSettings) validate() string {
+	if s.ProjectPort != nil && (*s.ProjectPort <= 0 || *s.ProjectPort > 65535) {
+		return "project_port must be between 1 and 65535"
+	}
+
+	if !database.ValidUpstreamProtocol(s.UpstreamProtocol) {
+		return "upstream_protocol must be one of: http1, h2, h2c"
+	}
+
+	if !proxyproto.ValidVersion(s.ProxyProtocol) {
+		return "proxy_protocol must be one of: v1, v2"
+	}
+
+	// PROXY protocol needs one backend connection per client, which HTTP/2 multiplexing can't provide
+	if s.ProxyProtocol != "" && s.UpstreamProtocol != "" && s.UpstreamProtocol != database.UpstreamHTTP1 {
+		return "proxy_protocol is only supported with upstream_protocol http1"
+	}
+
+	return ""
+}
+
+// statusSettings are a tenant's lifecycle status and maintenance allowlist.
+type statusSettings struct {
+	Status               string   `json:"status,omitempty"`                // active (default), suspended, maintenance, archived
+	MaintenanceAllowlist []string `json:"maintenance_allowlist,omitempty"` // IPs/CIDRs routed normally during maintenance
+}
+
+// validate returns a message describing the first invalid setting, or "".
+func (s *statusSettings) validate() string {
+	if !database.ValidTenantStatus(s.Status) {
+		return "status must be one of: active, suspended, maintenance, archived"
+	}
+
+	if _, err := database.ParseIPList(s.MaintenanceAllowlist); err != nil {
+		return "maintenance_allowlist: " + err.Error()
+	}
+
+	return ""
+}
+
+// Tenants
+
+// tenantWithDomains is a tenant as returned by the admin API.
+type tenantWithDomains struct {
+	database.Tenant
+	Domains []string `json:"domains"`
+}
+
+// tenantRequest is the body of POST/PUT /admin/tenants and the document a
+// PATCH is applied to.
+type tenantRequest struct {
+	ID       string            `json:"id"`
+	Name     string            `json:"name"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Domains the tenant may add through the tenant API; omitted for the default
+	MaxDomains *int `json:"max_domains,omitempty"`
+	routingSettings
+	statusSettings
+}
+
+// validate returns a message describing the first invalid field, or "".
+func (req *tenantRequest) validate() string {
+	if req.ID == "" {
+		return "id is required"
+	}
+	if req.MaxDomains != nil && *req.MaxDomains < 0 {
+		return "max_domains must be 0 or more"
+	}
+	if msg := req.routingSettings.validate(); msg != "" {
+		return msg
+	}
+	return req.statusSettings.validate()
+}
+
+func (req *tenantRequest) tenant() database.Tenant {
+	return database.Tenant{
+		ID:               req.ID,
+		Name:             req.Name,
+		ProjectRoute:     req.ProjectRoute,
+		ProjectPort:      req.ProjectPort,
+		BackendDomain:    req.BackendDomain,
+		UpstreamProtocol: req.UpstreamProtocol,
+		ProxyProtocol:    req.ProxyProtocol,
+		Metadata:         req.Metadata,
+		Status:           req.Status,
+		MaxDomains:       req.MaxDomains,
+
+		MaintenanceAllowlist: req.MaintenanceAllowlist,
 	}
 }
 
+// AddTenant creates a tenant: 201 Created, or 409 Conflict if it exists.
 func (h *AdminHandler) AddTenant(w http.ResponseWriter, r *http.Request) {
+	raw, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
 	var req struct {
-		Domain        string  `json:"domain"`
-		TenantID      string  `json:"tenant_id"`
-		ProjectRoute  string  `json:"project_route"`           // Optional, defaults to /projects/backend
-		ProjectPort   *int    `json:"project_port,omitempty"`  // Optional port for project
-		BackendDomain *string `json:"backend_domain,omitempty"` // Optional backend domain (e.g., localhost, admin.local)
+		tenantRequest
+		Domain string `json:"domain"` // Deprecated: use POST /admin/domains
+	}
+
+	if err := json.Unmarshal(raw, &req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	// Before tenants were entities, this endpoint added a domain record
+	if req.Domain != "" {
+		r.Body = io.NopCloser(bytes.NewReader(raw))
+		h.AddDomain(w, r)
+		return
+	}
+
+	if msg := req.validate(); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if !canAccessTenant(r, req.ID) {
+		forbiddenTenant(w, req.ID)
+		return
+	}
+
+	if err := h.tenantManager.CreateTenant(changeContext(r), req.tenant()); err != nil {
+		if errors.Is(err, database.ErrConflict) {
+			http.Error(w, "tenant "+req.ID+" already exists (use PUT or PATCH to change it)", http.StatusConflict)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeTenant(w, http.StatusCreated, req.ID)
+}
+
+// PutTenant replaces a tenant with the request body, creating it if needed:
+// 201 Created or 200 OK. Omitted fields are reset to their defaults. If-Match
+// makes the replace conditional, If-None-Match: * makes it create-only.
+func (h *AdminHandler) PutTenant(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var req tenantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.ID == "" {
+		req.ID = id
+	}
+	if req.ID != id {
+		http.Error(w, "id in body does not match the URL", http.StatusBadRequest)
+		return
+	}
+
+	if msg := req.validate(); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	current, err := h.tenantManager.GetTenant(id)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	t := req.tenant()
+	var ok bool
+	if current != nil {
+		t.Revision, ok = checkPreconditions(w, r, true, current.Revision)
+	} else {
+		t.Revision, ok = checkPreconditions(w, r, false, 0)
+	}
+	if !ok {
+		return
+	}
+
+	created, err := h.tenantManager.PutTenant(changeContext(r), t)
+	if err != nil {
+		if errors.Is(err, database.ErrRevisionMismatch) || errors.Is(err, database.ErrNotFound) {
+			preconditionFailed(w, 0)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	h.writeTenant(w, status, id)
+}
+
+// PatchTenant applies a JSON merge patch (RFC 7386) to an existing tenant.
+// The patch is applied to the revision it was computed against, so
+// concurrent changes are never silently overwritten.
+func (h *AdminHandler) PatchTenant(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	if !isMergePatch(r) {
+		http.Error(w, "Content-Type must be application/merge-patch+json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	current, err := h.tenantManager.GetTenant(id)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "tenant "+id+" not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var req tenantRequest
+	if err := patchInto(current, r.Body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.ID != id {
+		http.Error(w, "id can't be changed", http.StatusBadRequest)
+		return
+	}
+
+	if msg := req.validate(); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if _, ok := checkPreconditions(w, r, true, current.Revision); !ok {
+		return
+	}
+
+	t := req.tenant()
+	t.Revision = current.Revision
+	if err := h.tenantManager.UpdateTenant(changeContext(r), t); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "tenant "+id+" not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, database.ErrRevisionMismatch) {
+			preconditionFailed(w, 0)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeTenant(w, http.StatusOK, id)
+}
+
+// writeTenant responds with the stored tenant and its domains.
+func (h *AdminHandler) writeTenant(w http.ResponseWriter, status int, id string) {
+	tenant, err := h.tenantManager.GetTenant(id)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "tenant "+id+" not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	domains, err := h.tenantManager.ListDomains(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(tenant.Revision))
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(tenantWithDomains{Tenant: *tenant, Domains: domainNames(domains)})
+}
+
+// SetTenantStatus changes a tenant's lifecycle status and, optionally, its
+// maintenance allowlist.
+func (h *AdminHandler) SetTenantStatus(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var req statusSettings
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	if req.Domain == "" || req.TenantID == "" {
-		http.Error(w, "domain and tenant_id are required", http.StatusBadRequest)
+	if req.Status == "" {
+		http.Error(w, "status is required", http.StatusBadRequest)
+		return
+	}
+
+	if msg := req.validate(); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	tenant, err := h.tenantManager.GetTenant(id)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "tenant "+id+" not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, ok := checkPreconditions(w, r, true, tenant.Revision); !ok {
 		return
 	}
 
-	if err := h.tenantManager.AddTenant(req.Domain, req.TenantID, req.ProjectRoute, req.ProjectPort, req.BackendDomain); err != nil {
+	tenant.Status = req.Status
+	if req.MaintenanceAllowlist != nil {
+		tenant.MaintenanceAllowlist = req.MaintenanceAllowlist
+	}
+
+	if err := h.tenantManager.UpdateTenant(changeContext(r), *tenant); err != nil {
+		if errors.Is(err, database.ErrRevisionMismatch) || errors.Is(err, database.ErrNotFound) {
+			preconditionFailed(w, 0)
+			return
+		}
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	response := map[string]interface{}{
-		"message":      "Tenant added successfully",
-		"domain":       req.Domain,
-		"tenant_id":    req.TenantID,
-		"project_route": req.ProjectRoute,
+	// Report the revision the store saved, like writeTenant
+	updated, err := h.tenantManager.GetTenant(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(updated.Revision))
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":               "Tenant status updated successfully",
+		"id":                    id,
+		"status":                updated.Status,
+		"maintenance_allowlist": updated.MaintenanceAllowlist,
+		"revision":              updated.Revision,
+	})
+}
+
+// Maintenance windows
+
+func (h *AdminHandler) ListMaintenanceWindows(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	if _, err := h.tenantManager.GetTenant(id); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "tenant "+id+" not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	if req.ProjectPort != nil {
-		response["project_port"] = *req.ProjectPort
+
+	windows, err := h.tenantManager.ListMaintenanceWindows(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
-	if req.BackendDomain != nil && *req.BackendDomain != "" {
-		response["backend_domain"] = *req.BackendDomain
+	if windows == nil {
+		windows = []database.MaintenanceWindow{}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"windows": windows,
+		"count":   len(windows),
+	})
+}
+
+func (h *AdminHandler) AddMaintenanceWindow(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var req struct {
+		StartsAt  time.Time `json:"starts_at"` // RFC 3339
+		EndsAt    time.Time `json:"ends_at"`   // RFC 3339
+		Message   string    `json:"message"`
+		BypassIPs []string  `json:"bypass_ips"` // IPs/CIDRs routed normally during the window
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body (starts_at and ends_at must be RFC 3339 timestamps)", http.StatusBadRequest)
+		return
+	}
+
+	if req.StartsAt.IsZero() || req.EndsAt.IsZero() {
+		http.Error(w, "starts_at and ends_at are required", http.StatusBadRequest)
+		return
+	}
+
+	if !req.EndsAt.After(req.StartsAt) {
+		http.Error(w, "ends_at must be after starts_at", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := database.ParseIPList(req.BypassIPs); err != nil {
+		http.Error(w, "bypass_ips: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	window, err := h.tenantManager.AddMaintenanceWindow(database.MaintenanceWindow{
+		TenantID:  id,
+		StartsAt:  req.StartsAt,
+		EndsAt:    req.EndsAt,
+		Message:   req.Message,
+		BypassIPs: req.BypassIPs,
+	})
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "tenant "+id+" not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(window)
+}
+
+func (h *AdminHandler) DeleteMaintenanceWindow(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	windowID, err := strconv.ParseInt(chi.URLParam(r, "windowID"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid window id", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.tenantManager.DeleteMaintenanceWindow(id, windowID); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "maintenance window not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Maintenance window deleted successfully",
+		"id":      windowID,
+	})
+}
+
+func (h *AdminHandler) GetTenant(w http.ResponseWriter, r *http.Request) {
+	h.writeTenant(w, http.StatusOK, chi.URLParam(r, "id"))
 }
 
 func (h *AdminHandler) DeleteTenant(w http.ResponseWriter, r *http.Request) {
-	domain := chi.URLParam(r, "domain")
-	
-	if domain == "" {
-		http.Error(w, "domain parameter is required", http.StatusBadRequest)
+	id := chi.URLParam(r, "id")
+
+	if id == "" {
+		http.Error(w, "id parameter is required", http.StatusBadRequest)
 		return
 	}
 
-	if err := h.tenantManager.DeleteTenant(domain); err != nil {
+	current, err := h.tenantManager.GetTenant(id)
+	if errors.Is(err, database.ErrNotFound) {
+		// Before tenants were entities, this endpoint deleted a domain record
+		if _, derr := h.tenantManager.GetDomain(id); derr == nil {
+			chi.RouteContext(r.Context()).URLParams.Add("domain", id)
+			h.requireDomainAccess(h.requireWritable(http.HandlerFunc(h.DeleteDomain))).ServeHTTP(w, r)
+			return
+		}
+		http.Error(w, "tenant "+id+" not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	revision, ok := checkPreconditions(w, r, true, current.Revision)
+	if !ok {
+		return
+	}
+
+	err = h.tenantManager.DeleteTenant(changeContext(r), id, revision)
+	if errors.Is(err, database.ErrNotFound) {
+		http.Error(w, "tenant "+id+" not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, database.ErrRevisionMismatch) {
+		preconditionFailed(w, 0)
+		return
+	}
+	if errors.Is(err, database.ErrTenantHasDomains) {
+		http.Error(w, err.Error()+": delete or move its domains first", http.StatusConflict)
+		return
+	}
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -75,7 +551,7 @@ func (h *AdminHandler) DeleteTenant(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"message": "Tenant deleted successfully",
-		"domain":  domain,
+		"id":      id,
 	})
 }
 
@@ -86,18 +562,103 @@ func (h *AdminHandler) ListTenants(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	domains, err := h.tenantManager.ListDomains("")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	byTenant := make(map[string][]database.Domain)
+	for _, d := range domains {
+		byTenant[d.TenantID] = append(byTenant[d.TenantID], d)
+	}
+
+	result := make([]tenantWithDomains, 0, len(tenants))
+	for _, t := range tenants {
+		if !canAccessTenant(r, t.ID) {
+			continue
+		}
+		result = append(result, tenantWithDomains{Tenant: t, Domains: domainNames(byTenant[t.ID])})
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"tenants": tenants,
-		"count":   len(tenants),
+		"tenants": result,
+		"count":   len(result),
 	})
 }
 
+func domainNames(domains []database.Domain) []string {
+	names := make([]string, 0, len(domains))
+	for _, d := range domains {
+		names = append(names, d.Name)
+	}
+	return names
+}
+
 func (h *AdminHandler) RegisterRoutes(r chi.Router) {
+	r.Get("/admin/auth", h.AuthMethods)
+	r.Post("/admin/login", h.Login)
+	r.Post("/admin/logout", h.Logout)
+	r.Get("/admin/session", h.GetSession)
+	r.Get("/admin/oidc/login", h.OIDCLogin)
+	r.Get("/admin/oidc/callback", h.OIDCCallback)
 	r.Route("/admin/tenants", func(r chi.Router) {
-		r.Post("/", h.AddTenant)
-		r.Delete("/{domain}", h.DeleteTenant)
+		r.Use(h.requireMethodScope, h.audit)
+		r.With(h.requireWritable).Post("/", h.AddTenant)
 		r.Get("/", h.ListTenants)
+		r.Group(func(r chi.Router) {
+			r.Use(requireTenantAccess, h.requireWritable)
+			r.Get("/{id}", h.GetTenant)
+			r.Put("/{id}", h.PutTenant)
+			r.Patch("/{id}", h.PatchTenant)
+			r.Put("/{id}/status", h.SetTenantStatus)
+			r.Get("/{id}/maintenance", h.ListMaintenanceWindows)
+			r.Post("/{id}/maintenance", h.AddMaintenanceWindow)
+			r.Delete("/{id}/maintenance/{windowID}", h.DeleteMaintenanceWindow)
+			r.Get("/{id}/history", h.ListHistory)
+			r.Get("/{id}/history/diff", h.DiffHistory)
+			r.Get("/{id}/history/{changeID}", h.GetHistoryChange)
+			r.Post("/{id}/rollback", h.RollbackTenant)
+			r.Get("/{id}/tokens", h.ListTenantTokens)
+			r.Post("/{id}/tokens", h.CreateTenantToken)
+			r.Delete("/{id}/tokens/{tokenID}", h.RevokeTenantToken)
+			r.Delete("/{id}", h.DeleteTenant)
+		})
+	})
+	r.Route("/admin/domains", func(r chi.Router) {
+		r.Use(h.requireMethodScope, h.audit)
+		r.With(h.requireWritable).Post("/", h.AddDomain)
+		r.Get("/", h.ListDomains)
+		r.Group(func(r chi.Router) {
+			r.Use(h.requireDomainAccess, h.requireWritable)
+			r.Get("/{domain}", h.GetDomain)
+			r.Put("/{domain}", h.PutDomain)
+			r.Patch("/{domain}", h.PatchDomain)
+			r.Delete("/{domain}", h.DeleteDomain)
+			r.Get("/{domain}/verification", h.GetDomainVerification)
+			r.Post("/{domain}/verify", h.VerifyDomain)
+		})
+	})
+	r.With(h.requireScope(database.ScopeRead)).Get("/admin/resolve", h.Resolve)
+	r.With(h.requireScope(database.ScopeRead)).Get("/admin/cache", h.CacheStats)
+	r.Route("/admin/audit", func(r chi.Router) {
+		r.Use(h.requireScope(database.ScopeAdmin))
+		r.Get("/", h.ListAudit)
+		r.Get("/export", h.ExportAudit)
+	})
+	r.Route("/admin/keys", func(r chi.Router) {
+		r.Use(h.requireScope(database.ScopeAdmin), h.audit)
+		r.Get("/", h.ListAPIKeys)
+		r.Post("/", h.CreateAPIKey)
+		r.Get("/{keyID}", h.GetAPIKey)
+		r.Delete("/{keyID}", h.RevokeAPIKey)
+	})
+	r.Route("/admin/operators", func(r chi.Router) {
+		r.Use(h.requireScope(database.ScopeAdmin), h.audit)
+		r.Get("/", h.ListOperators)
+		r.Post("/", h.CreateOperator)
+		r.Get("/{operatorID}", h.GetOperator)
+		r.Put("/{operatorID}", h.UpdateOperator)
+		r.Delete("/{operatorID}", h.DeleteOperator)
 	})
 }
-
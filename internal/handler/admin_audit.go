@@ -0,0 +1,333 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/tenantical/router/internal/database"
+)
+
+const (
+	// maxAuditPayload is the largest request body stored in an audit entry
+	maxAuditPayload = 64 << 10
+	// maxAuditError is how much of an error response is stored
+	maxAuditError = 1 << 10
+
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// auditChange is a history entry as summarized in an audit entry.
+type auditChange struct {
+	ID     int64                         `json:"id"`
+	Kind   string                        `json:"kind"`
+	Target string                        `json:"target"`
+	Action string                        `json:"action"`
+	Diff   map[string]database.FieldDiff `json:"diff"`
+}
+
+// audit records every request that may mutate state (anything but GET,
+// HEAD and OPTIONS) in the audit log, after it has been handled.
+func (h *AdminHandler) audit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		response := &cappedBuffer{max: maxAuditError}
+		ww.Tee(response)
+
+		next.ServeHTTP(ww, r)
+
+		h.recordAudit(r, body, ww.Status(), response.String())
+	})
+}
+
+func (h *AdminHandler) recordAudit(r *http.Request, body []byte, status int, response string) {
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	entry := database.AuditEntry{
+		Actor:     requestActor(r),
+		SourceIP:  clientIP(r),
+		RequestID: middleware.GetReqID(r.Context()),
+		Action:    r.Method + " " + chi.RouteContext(r.Context()).RoutePattern(),
+		Target:    auditTarget(r, body),
+		Status:    status,
+		Result:    database.AuditSuccess,
+	}
+	if len(body) > 0 && len(body) <= maxAuditPayload && json.Valid(body) {
+		entry.Payload = redactPayload(body)
+	}
+	if status >= http.StatusBadRequest {
+		entry.Result = database.AuditFailure
+		entry.Error = strings.TrimSpace(response)
+	}
+
+	if entry.RequestID != "" {
+		changes, err := h.tenantManager.ListChanges(database.ChangeFilter{RequestID: entry.RequestID})
+		if err != nil {
+			log.Printf("[ADMIN] ERROR: Failed to load changes of request %s for the audit log: %v", entry.RequestID, err)
+		}
+		summary := make([]auditChange, 0, len(changes))
+		for i := len(changes) - 1; i >= 0; i-- { // oldest first
+			c := changes[i]
+			diff, err := database.Diff(c.Before, c.After)
+			if err != nil {
+				log.Printf("[ADMIN] ERROR: Failed to diff change %d for the audit log: %v", c.ID, err)
+			}
+			summary = append(summary, auditChange{ID: c.ID, Kind: c.Kind, Target: c.Target, Action: c.Action, Diff: diff})
+		}
+		if len(summary) > 0 {
+			entry.Changes, _ = json.Marshal(summary)
+		}
+	}
+
+	// The request may have been cancelled; the entry must be written anyway
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := h.tenantManager.AppendAudit(ctx, entry); err != nil {
+		log.Printf("[ADMIN] ERROR: Failed to write audit entry for %s (request %s): %v", entry.Action, entry.RequestID, err)
+	}
+}
+
+// auditTarget names the record a request acts on, from its URL parameters
+// or, for creates, its body.
+func auditTarget(r *http.Request, body []byte) string {
+	params := chi.RouteContext(r.Context()).URLParams
+	param := func(key string) string {
+		for i, k := range params.Keys {
+			if k == key {
+				return params.Values[i]
+			}
+		}
+		return ""
+	}
+
+	if id := param("keyID"); id != "" {
+		return "key:" + id
+	}
+	if id := param("operatorID"); id != "" {
+		return "operator:" + id
+	}
+	if domain := param("domain"); domain != "" {
+		return database.KindDomain + ":" + domain
+	}
+	if id := param("id"); id != "" {
+		target := database.KindTenant + ":" + id
+		if window := param("windowID"); window != "" {
+			target += "/maintenance:" + window
+		}
+		if token := param("tokenID"); token != "" {
+			target += "/token:" + token
+		}
+		return target
+	}
+
+	var created struct {
+		Domain   string `json:"domain"`
+		ID       string `json:"id"`
+		Name     string `json:"name"`
+		Username string `json:"username"`
+	}
+	json.Unmarshal(body, &created)
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/admin/keys"):
+		if created.Name != "" {
+			return "key:" + created.Name
+		}
+	case strings.HasPrefix(r.URL.Path, "/admin/operators"), r.URL.Path == "/admin/login", r.URL.Path == "/admin/oidc/callback":
+		if created.Username != "" {
+			return "operator:" + created.Username
+		}
+	case created.Domain != "":
+		return database.KindDomain + ":" + created.Domain
+	case created.ID != "":
+		return database.KindTenant + ":" + created.ID
+	}
+	return ""
+}
+
+// redactPayload returns body with the password of operator requests
+// replaced, so audit entries never contain one.
+func redactPayload(body []byte) json.RawMessage {
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil || fields["password"] == nil {
+		return json.RawMessage(body)
+	}
+	fields["password"] = json.RawMessage(`"[redacted]"`)
+	redacted, err := json.Marshal(fields)
+	if err != nil {
+		return nil
+	}
+	return redacted
+}
+
+// cappedBuffer keeps the first max bytes written to it.
+type cappedBuffer struct {
+	max int
+	buf []byte
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - len(b.buf); room > 0 {
+		if len(p) > room {
+			b.buf = append(b.buf, p[:room]...)
+		} else {
+			b.buf = append(b.buf, p...)
+		}
+	}
+	return len(p), nil
+}
+
+func (b *cappedBuffer) String() string {
+	return string(b.buf)
+}
+
+// ListAudit lists audit entries, newest first. Filters: actor, source_ip,
+// request_id, action (substring), target (exact, or a prefix ending in ':'),
+// result, since and until (RFC 3339); pagination: limit and before (the
+// next_before of the previous page).
+func (h *AdminHandler) ListAudit(w http.ResponseWriter, r *http.Request) {
+	filter, msg := parseAuditFilter(r)
+	if msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultAuditLimit
+	}
+
+	entries, err := h.tenantManager.ListAudit(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []database.AuditEntry{}
+	}
+
+	result := map[string]interface{}{
+		"entries": entries,
+		"count":   len(entries),
+	}
+	if len(entries) == filter.Limit {
+		result["next_before"] = entries[len(entries)-1].ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// ExportAudit streams the matching audit entries as JSON lines, oldest
+// first. It takes the same filters as ListAudit; without limit everything
+// matching is exported.
+func (h *AdminHandler) ExportAudit(w http.ResponseWriter, r *http.Request) {
+	filter, msg := parseAuditFilter(r)
+	if msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	remaining := filter.Limit
+	filter.Ascending = true
+	filter.BeforeID = 0
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+	enc := json.NewEncoder(w)
+
+	for {
+		filter.Limit = maxAuditLimit
+		if remaining > 0 && remaining < filter.Limit {
+			filter.Limit = remaining
+		}
+		entries, err := h.tenantManager.ListAudit(filter)
+		if err != nil {
+			// Headers are gone once a line has been written; a short file is
+			// all the client will see
+			log.Printf("[ADMIN] ERROR: Audit export failed: %v", err)
+			return
+		}
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+		}
+		if remaining > 0 {
+			remaining -= len(entries)
+			if remaining == 0 {
+				return
+			}
+		}
+		if len(entries) < filter.Limit || r.Context().Err() != nil {
+			return
+		}
+		filter.AfterID = entries[len(entries)-1].ID
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+}
+
+// parseAuditFilter reads the audit query parameters, returning a message
+// describing the first invalid one.
+func parseAuditFilter(r *http.Request) (database.AuditFilter, string) {
+	query := r.URL.Query()
+	filter := database.AuditFilter{
+		Actor:     query.Get("actor"),
+		SourceIP:  query.Get("source_ip"),
+		RequestID: query.Get("request_id"),
+		Action:    query.Get("action"),
+		Target:    query.Get("target"),
+		Result:    query.Get("result"),
+	}
+
+	if filter.Result != "" && filter.Result != database.AuditSuccess && filter.Result != database.AuditFailure {
+		return filter, "result must be one of: success, failure"
+	}
+	for name, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := query.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, name + " must be an RFC 3339 time"
+			}
+			*dst = t
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxAuditLimit {
+			return filter, "limit must be between 1 and " + strconv.Itoa(maxAuditLimit)
+		}
+		filter.Limit = limit
+	}
+	if v := query.Get("before"); v != "" {
+		before, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || before < 1 {
+			return filter, "before must be an audit entry ID"
+		}
+		filter.BeforeID = before
+	}
+
+	return filter, ""
+}
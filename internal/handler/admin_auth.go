@@ -0,0 +1,347 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tenantical/router/internal/database"
+)
+
+// identity is who an admin request was authenticated as: an API key or a
+// signed-in operator.
+type identity interface {
+	// Actor is how the identity is recorded in the history and audit log
+	Actor() string
+	// Allows reports whether the identity has scope
+	Allows(scope string) bool
+	// CanAccessTenant reports whether the identity may see and change a tenant
+	CanAccessTenant(id string) bool
+}
+
+type identityContextKey struct{}
+
+// identityFromRequest returns who the request was authenticated as, or nil.
+func identityFromRequest(r *http.Request) identity {
+	id, _ := r.Context().Value(identityContextKey{}).(identity)
+	return id
+}
+
+func withIdentity(ctx context.Context, ident identity) context.Context {
+	return context.WithValue(ctx, identityContextKey{}, ident)
+}
+
+// canAccessTenant reports whether the request's identity may see and change
+// tenant id. Unauthenticated requests never reach the handlers asking.
+func canAccessTenant(r *http.Request, id string) bool {
+	ident := identityFromRequest(r)
+	return ident == nil || ident.CanAccessTenant(id)
+}
+
+func forbiddenTenant(w http.ResponseWriter, id string) {
+	http.Error(w, "no access to tenant "+id, http.StatusForbidden)
+}
+
+// requestKey returns the API key sent as "Authorization: Bearer <key>" or
+// in the X-API-Key header.
+func requestKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if scheme, key, ok := strings.Cut(auth, " "); ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(key)
+		}
+		return ""
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// requireScope only lets requests through that carry a valid API key, or
+// the session cookie of a signed-in operator, granting scope.
+func (h *AdminHandler) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.authorize(w, r, next, scope)
+		})
+	}
+}
+
+// requireMethodScope is requireScope with the read scope for GET and HEAD
+// requests and the write scope for everything else.
+func (h *AdminHandler) requireMethodScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := database.ScopeWrite
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			scope = database.ScopeRead
+		}
+		h.authorize(w, r, next, scope)
+	})
+}
+
+func (h *AdminHandler) authorize(w http.ResponseWriter, r *http.Request, next http.Handler, scope string) {
+	// CORS preflights carry no credentials
+	if r.Method == http.MethodOptions {
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	// An API key takes precedence over a session cookie sent along with it
+	if key := requestKey(r); key != "" {
+		k, err := h.tenantManager.AuthenticateAPIKey(r.Context(), key)
+		switch {
+		case errors.Is(err, database.ErrInvalidAPIKey), errors.Is(err, database.ErrAPIKeyExpired), errors.Is(err, database.ErrAPIKeyRevoked):
+			log.Printf("[ADMIN] Rejected API key from %s for %s %s: %v", clientIP(r), r.Method, r.URL.Path, err)
+			unauthorized(w, err.Error())
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if !k.Allows(scope) {
+			http.Error(w, "API key "+k.Name+" lacks the "+scope+" scope", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(withIdentity(r.Context(), k)))
+		return
+	}
+
+	sess, o, ok := h.requestSession(w, r)
+	if !ok {
+		return
+	}
+	if sess == nil {
+		unauthorized(w, "API key or sign-in required")
+		return
+	}
+
+	// Browsers send the cookie along with cross-site requests; only the
+	// panel itself knows the session's CSRF token
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	default:
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get(csrfHeader)), []byte(sess.CSRFToken)) != 1 {
+			http.Error(w, "missing or invalid "+csrfHeader+" header", http.StatusForbidden)
+			return
+		}
+	}
+
+	if !o.Allows(scope) {
+		http.Error(w, "operator "+o.Username+" ("+o.Role+") lacks the "+scope+" scope", http.StatusForbidden)
+		return
+	}
+
+	next.ServeHTTP(w, r.WithContext(withIdentity(r.Context(), o)))
+}
+
+// requireTenantAccess rejects requests for a tenant ({id} in the route) that
+// the identity is restricted from.
+func requireTenantAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := chi.URLParam(r, "id"); !canAccessTenant(r, id) {
+			forbiddenTenant(w, id)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireDomainAccess rejects requests for an existing domain ({domain} in
+// the route) whose tenant the identity is restricted from.
+func (h *AdminHandler) requireDomainAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d, err := h.tenantManager.GetDomain(chi.URLParam(r, "domain"))
+		if err != nil && !errors.Is(err, database.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if d != nil && !canAccessTenant(r, d.TenantID) {
+			forbiddenTenant(w, d.TenantID)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireWritable rejects changes to tenants and domains defined in the
+// tenants file: the tenant ({id}) or domain ({domain}) in the route, or
+// any while the file is authoritative.
+func (h *AdminHandler) requireWritable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var err error
+		if domain := chi.URLParam(r, "domain"); domain != "" {
+			err = h.tenantManager.DomainWritable(domain)
+		} else {
+			err = h.tenantManager.TenantWritable(chi.URLParam(r, "id"))
+		}
+		if err != nil {
+			if !readOnly(w, err) {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readOnly answers 403 if err is a change refused because the tenants file
+// defines what it changes, and reports whether it was.
+func readOnly(w http.ResponseWriter, err error) bool {
+	if !errors.Is(err, database.ErrReadOnly) {
+		return false
+	}
+	http.Error(w, err.Error(), http.StatusForbidden)
+	return true
+}
+
+// requestExpiry returns when a credential created with expires_at or
+// expires_in (a Go duration) expires, nil for never, or a message
+// describing why they are invalid.
+func requestExpiry(expiresAt *time.Time, expiresIn string) (*time.Time, string) {
+	if expiresIn != "" {
+		if expiresAt != nil {
+			return nil, "set either expires_at or expires_in"
+		}
+		d, err := time.ParseDuration(expiresIn)
+		if err != nil || d <= 0 {
+			return nil, "expires_in must be a positive duration, e.g. 720h"
+		}
+		expires := time.Now().Add(d)
+		return &expires, ""
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "expires_at must be in the future"
+	}
+	return expiresAt, ""
+}
+
+func unauthorized(w http.ResponseWriter, msg string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="tenant-router admin"`)
+	http.Error(w, msg, http.StatusUnauthorized)
+}
+
+// ListAPIKeys lists all API keys, revoked ones included. Keys themselves
+// are never returned, only their prefixes.
+func (h *AdminHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.tenantManager.ListAPIKeys()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if keys == nil {
+		keys = []database.APIKey{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys":  keys,
+		"count": len(keys),
+	})
+}
+
+// GetAPIKey returns one API key.
+func (h *AdminHandler) GetAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "keyID")
+
+	k, err := h.tenantManager.GetAPIKey(id)
+	if errors.Is(err, database.ErrNotFound) {
+		http.Error(w, "API key "+id+" not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(k)
+}
+
+// CreateAPIKey creates an API key and returns it. The key is only part of
+// this response. Body: {"name": "ci", "scopes": ["write"]}, optionally with
+// "expires_at" (RFC 3339) or "expires_in" (a Go duration such as "720h").
+func (h *AdminHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
+		ExpiresIn string     `json:"expires_in,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		http.Error(w, "scopes is required (read, write, admin)", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !database.ValidScope(scope) {
+			http.Error(w, "scopes must be one or more of: read, write, admin", http.StatusBadRequest)
+			return
+		}
+	}
+	expiresAt, msg := requestExpiry(req.ExpiresAt, req.ExpiresIn)
+	if msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	key, k, err := h.tenantManager.CreateAPIKey(changeContext(r), req.Name, req.Scopes, expiresAt)
+	if errors.Is(err, database.ErrConflict) {
+		http.Error(w, "an API key named "+req.Name+" already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "API key created; store it now, it can't be shown again",
+		"key":     key,
+		"api_key": k,
+	})
+}
+
+// RevokeAPIKey revokes an API key. Revoking a revoked key succeeds and
+// keeps the original revocation time.
+func (h *AdminHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "keyID")
+
+	err := h.tenantManager.RevokeAPIKey(changeContext(r), id)
+	if errors.Is(err, database.ErrNotFound) {
+		http.Error(w, "API key "+id+" not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "API key revoked successfully",
+		"id":      id,
+	})
+}
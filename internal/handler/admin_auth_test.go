@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/tenantical/router/internal/database"
+)
+
+func TestAPIKeyScopes(t *testing.T) {
+	tm := newTenantManager(t)
+	_, h := newAdminRouter(tm)
+	read := newAPIKey(t, tm, "read", database.ScopeRead)
+	write := newAPIKey(t, tm, "write", database.ScopeWrite)
+	admin := newAPIKey(t, tm, "admin", database.ScopeAdmin)
+
+	revoked, k, err := tm.CreateAPIKey(testContext(), "revoked", []string{database.ScopeAdmin}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.RevokeAPIKey(testContext(), k.ID); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Minute)
+	expired, _, err := tm.CreateAPIKey(testContext(), "expired", []string{database.ScopeAdmin}, &past)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		key          string
+		method, path string
+		body         string
+		want         int
+	}{
+		{"read key listing", read, http.MethodGet, "/admin/tenants", "", http.StatusOK},
+		{"read key creating", read, http.MethodPost, "/admin/tenants", `{"id": "acme"}`, http.StatusForbidden},
+		{"read key deleting", read, http.MethodDelete, "/admin/domains/shop.example.com", "", http.StatusForbidden},
+		{"write key creating", write, http.MethodPost, "/admin/tenants", `{"id": "acme"}`, http.StatusCreated},
+		{"write key listing keys", write, http.MethodGet, "/admin/keys", "", http.StatusForbidden},
+		{"admin key listing keys", admin, http.MethodGet, "/admin/keys", "", http.StatusOK},
+		{"admin key creating", admin, http.MethodPost, "/admin/tenants", `{"id": "globex"}`, http.StatusCreated},
+		{"revoked key", revoked, http.MethodGet, "/admin/tenants", "", http.StatusUnauthorized},
+		{"expired key", expired, http.MethodGet, "/admin/tenants", "", http.StatusUnauthorized},
+		{"unknown key", "trk_unknown", http.MethodGet, "/admin/tenants", "", http.StatusUnauthorized},
+		{"no key", "", http.MethodGet, "/admin/tenants", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		var header []string
+		if tt.key != "" {
+			header = []string{"Authorization", "Bearer " + tt.key}
+		}
+		if w := serve(h, tt.method, tt.path, tt.body, header...); w.Code != tt.want {
+			t.Errorf("%s: %s %s got %d, want %d (%s)", tt.name, tt.method, tt.path, w.Code, tt.want, w.Body)
+		}
+	}
+
+	// X-API-Key works like the Authorization header
+	if w := serve(h, http.MethodGet, "/admin/tenants", "", "X-API-Key", read); w.Code != http.StatusOK {
+		t.Errorf("GET /admin/tenants with X-API-Key: got %d, want 200", w.Code)
+	}
+}
+
+// signIn creates an operator with role, restricted to tenants if any, and
+// a session for them. It returns the session's cookie and CSRF token.
+func signIn(t *testing.T, h *AdminHandler, tm *database.TenantManager, username, role string, tenants ...string) (cookie, csrf string) {
+	t.Helper()
+	o, err := tm.CreateOperator(testContext(), database.Operator{Username: username, Role: role, Tenants: tenants}, "correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, sess, err := tm.CreateSession(testContext(), o.ID, time.Hour, "192.0.2.1", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h.sessionCookie() + "=" + token, sess.CSRFToken
+}
+
+func TestSessionCSRF(t *testing.T) {
+	tm := newTenantManager(t)
+	h, r := newAdminRouter(tm)
+	cookie, csrf := signIn(t, h, tm, "editor", database.RoleEditor)
+
+	tests := []struct {
+		name   string
+		header []string
+		want   int
+	}{
+		{"without the CSRF token", []string{"Cookie", cookie}, http.StatusForbidden},
+		{"with another CSRF token", []string{"Cookie", cookie, csrfHeader, "forged"}, http.StatusForbidden},
+		{"with the session's CSRF token", []string{"Cookie", cookie, csrfHeader, csrf}, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		if w := serve(r, http.MethodPost, "/admin/tenants", `{"id": "acme"}`, tt.header...); w.Code != tt.want {
+			t.Errorf("POST /admin/tenants %s: got %d, want %d (%s)", tt.name, w.Code, tt.want, w.Body)
+		}
+	}
+
+	// Reading needs no CSRF token
+	if w := serve(r, http.MethodGet, "/admin/tenants", "", "Cookie", cookie); w.Code != http.StatusOK {
+		t.Errorf("GET /admin/tenants with the session cookie: got %d, want 200", w.Code)
+	}
+	if w := serve(r, http.MethodGet, "/admin/tenants", "", "Cookie", h.sessionCookie()+"=unknown"); w.Code != http.StatusUnauthorized {
+		t.Errorf("GET /admin/tenants with an unknown session: got %d, want 401", w.Code)
+	}
+}
+
+func TestOperatorRoles(t *testing.T) {
+	tm := newTenantManager(t)
+	h, r := newAdminRouter(tm)
+	for _, d := range []database.Domain{{Name: "shop.acme.com", TenantID: "acme"}, {Name: "shop.globex.com", TenantID: "globex"}} {
+		if err := tm.CreateDomain(testContext(), d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	viewer, viewerCSRF := signIn(t, h, tm, "viewer", database.RoleViewer)
+	scoped, scopedCSRF := signIn(t, h, tm, "acme-editor", database.RoleEditor, "acme")
+	owner, ownerCSRF := signIn(t, h, tm, "owner", database.RoleOwner)
+
+	tests := []struct {
+		name         string
+		cookie, csrf string
+		method, path string
+		body         string
+		want         int
+	}{
+		{"viewer reading", viewer, "", http.MethodGet, "/admin/domains/shop.acme.com", "", http.StatusOK},
+		{"viewer deleting", viewer, viewerCSRF, http.MethodDelete, "/admin/domains/shop.acme.com", "", http.StatusForbidden},
+		{"viewer changing", viewer, viewerCSRF, http.MethodPatch, "/admin/tenants/acme", `{"name": "Acme"}`, http.StatusForbidden},
+		{"viewer listing keys", viewer, "", http.MethodGet, "/admin/keys", "", http.StatusForbidden},
+
+		{"scoped editor reading its tenant", scoped, "", http.MethodGet, "/admin/tenants/acme", "", http.StatusOK},
+		{"scoped editor reading another tenant", scoped, "", http.MethodGet, "/admin/tenants/globex", "", http.StatusForbidden},
+		{"scoped editor reading another tenant's domain", scoped, "", http.MethodGet, "/admin/domains/shop.globex.com", "", http.StatusForbidden},
+		{"scoped editor deleting another tenant's domain", scoped, scopedCSRF, http.MethodDelete, "/admin/domains/shop.globex.com", "", http.StatusForbidden},
+		{"scoped editor changing another tenant", scoped, scopedCSRF, http.MethodPatch, "/admin/tenants/globex", `{"name": "Globex"}`, http.StatusForbidden},
+		{"scoped editor adding a domain to another tenant", scoped, scopedCSRF, http.MethodPut, "/admin/domains/new.globex.com", `{"tenant_id": "globex"}`, http.StatusForbidden},
+		{"scoped editor moving its domain to another tenant", scoped, scopedCSRF, http.MethodPatch, "/admin/domains/shop.acme.com", `{"tenant_id": "globex"}`, http.StatusForbidden},
+		{"scoped editor listing keys", scoped, "", http.MethodGet, "/admin/keys", "", http.StatusForbidden},
+		{"scoped editor changing its tenant", scoped, scopedCSRF, http.MethodPatch, "/admin/tenants/acme", `{"name": "Acme"}`, http.StatusPreconditionRequired},
+
+		{"owner listing keys", owner, "", http.MethodGet, "/admin/keys", "", http.StatusOK},
+		{"owner deleting", owner, ownerCSRF, http.MethodDelete, "/admin/domains/shop.globex.com", "", http.StatusPreconditionRequired},
+	}
+	for _, tt := range tests {
+		header := []string{"Cookie", tt.cookie}
+		if tt.csrf != "" {
+			header = append(header, csrfHeader, tt.csrf)
+		}
+		if w := serve(r, tt.method, tt.path, tt.body, header...); w.Code != tt.want {
+			t.Errorf("%s: %s %s got %d, want %d (%s)", tt.name, tt.method, tt.path, w.Code, tt.want, w.Body)
+		}
+	}
+
+	// Lists only show the tenants an operator may access
+	w := serve(r, http.MethodGet, "/admin/domains", "", "Cookie", scoped)
+	var list struct {
+		Domains []database.Domain `json:"domains"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Domains) != 1 || list.Domains[0].Name != "shop.acme.com" {
+		t.Errorf("GET /admin/domains as the scoped editor: got %+v, want only shop.acme.com", list.Domains)
+	}
+}
@@ -0,0 +1,14 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// CacheStats reports the counters of the cache of resolved hosts: hits,
+// hits on hosts cached as unknown, misses, LRU evictions and background
+// refreshes.
+func (h *AdminHandler) CacheStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.tenantManager.CacheStats())
+}
@@ -0,0 +1,329 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tenantical/router/internal/database"
+)
+
+// domainRequest is the body of POST/PUT /admin/domains and the document a
+// PATCH is applied to.
+type domainRequest struct {
+	Domain   string `json:"domain"`
+	TenantID string `json:"tenant_id"`
+	routingSettings
+	RequestHeaders map[string]string `json:"request_headers,omitempty"` // Headers set on requests forwarded to the backend
+}
+
+// validate returns a message describing the first invalid field, or "".
+func (req *domainRequest) validate() string {
+	if req.Domain == "" || req.TenantID == "" {
+		return "domain and tenant_id are required"
+	}
+	pattern := database.LowerDomain(req.Domain)
+	if err := database.ValidateDomainPattern(pattern); err != nil {
+		return err.Error()
+	}
+
+	// Templates may only use what the domain's pattern captures
+	templates := [][2]string{{"project_route", req.ProjectRoute}}
+	if req.BackendDomain != nil {
+		templates = append(templates, [2]string{"backend_domain", *req.BackendDomain})
+	}
+	headers := make([]string, 0, len(req.RequestHeaders))
+	for name := range req.RequestHeaders {
+		headers = append(headers, name)
+	}
+	sort.Strings(headers)
+	for _, name := range headers {
+		value := req.RequestHeaders[name]
+		if err := database.ValidateRequestHeader(name, value); err != nil {
+			return "request_headers: " + err.Error()
+		}
+		templates = append(templates, [2]string{"request_headers." + name, value})
+	}
+	for _, t := range templates {
+		if err := database.ValidateTemplate(pattern, t[1]); err != nil {
+			return t[0] + ": " + err.Error()
+		}
+	}
+
+	// 0 and "none" turn the tenant's setting off for the domain
+	settings := req.routingSettings
+	settings.ProjectPort, settings.UpstreamProtocol, settings.ProxyProtocol = database.StripNone(
+		settings.ProjectPort, settings.UpstreamProtocol, settings.ProxyProtocol)
+	return settings.validate()
+}
+
+func (req *domainRequest) domain() database.Domain {
+	return database.Domain{
+		Name:             req.Domain,
+		TenantID:         req.TenantID,
+		ProjectRoute:     req.ProjectRoute,
+		ProjectPort:      req.ProjectPort,
+		BackendDomain:    req.BackendDomain,
+		UpstreamProtocol: req.UpstreamProtocol,
+		ProxyProtocol:    req.ProxyProtocol,
+		RequestHeaders:   req.RequestHeaders,
+	}
+}
+
+// AddDomain creates a domain: 201 Created, or 409 Conflict if it exists.
+// A tenant that doesn't exist yet is created with default settings.
+func (h *AdminHandler) AddDomain(w http.ResponseWriter, r *http.Request) {
+	var req domainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if msg := req.validate(); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if !canAccessTenant(r, req.TenantID) {
+		forbiddenTenant(w, req.TenantID)
+		return
+	}
+
+	if err := h.tenantManager.CreateDomain(changeContext(r), req.domain()); err != nil {
+		if errors.Is(err, database.ErrConflict) {
+			http.Error(w, "domain "+req.Domain+" already exists (use PUT or PATCH to change it)", http.StatusConflict)
+			return
+		}
+		if readOnly(w, err) {
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeDomain(w, http.StatusCreated, req.Domain)
+}
+
+func (h *AdminHandler) GetDomain(w http.ResponseWriter, r *http.Request) {
+	h.writeDomain(w, http.StatusOK, chi.URLParam(r, "domain"))
+}
+
+// PutDomain replaces a domain with the request body, creating it if needed:
+// 201 Created or 200 OK. Omitted settings go back to inheriting the tenant's.
+// If-Match makes the replace conditional, If-None-Match: * makes it
+// create-only.
+func (h *AdminHandler) PutDomain(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "domain")
+
+	var req domainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Domain == "" {
+		req.Domain = name
+	}
+	if database.LowerDomain(req.Domain) != database.LowerDomain(name) {
+		http.Error(w, "domain in body does not match the URL", http.StatusBadRequest)
+		return
+	}
+
+	if msg := req.validate(); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if !canAccessTenant(r, req.TenantID) {
+		forbiddenTenant(w, req.TenantID)
+		return
+	}
+
+	current, err := h.tenantManager.GetDomain(name)
+	if err != nil && !errors.Is(err, database.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	d := req.domain()
+	var ok bool
+	if current != nil {
+		d.Revision, ok = checkPreconditions(w, r, true, current.Revision)
+	} else {
+		d.Revision, ok = checkPreconditions(w, r, false, 0)
+	}
+	if !ok {
+		return
+	}
+
+	created, err := h.tenantManager.PutDomain(changeContext(r), d)
+	if err != nil {
+		if errors.Is(err, database.ErrRevisionMismatch) || errors.Is(err, database.ErrNotFound) {
+			preconditionFailed(w, 0)
+			return
+		}
+		if readOnly(w, err) {
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	h.writeDomain(w, status, name)
+}
+
+// PatchDomain applies a JSON merge patch (RFC 7386) to an existing domain,
+// at the revision the patch was computed against.
+func (h *AdminHandler) PatchDomain(w http.ResponseWriter, r *http.Request) {
+	name := chi.URLParam(r, "domain")
+
+	if !isMergePatch(r) {
+		http.Error(w, "Content-Type must be application/merge-patch+json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	current, err := h.tenantManager.GetDomain(name)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "domain "+name+" not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var req domainRequest
+	if err := patchInto(current, r.Body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.Domain != current.Name {
+		http.Error(w, "domain can't be changed", http.StatusBadRequest)
+		return
+	}
+
+	if msg := req.validate(); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	if !canAccessTenant(r, req.TenantID) {
+		forbiddenTenant(w, req.TenantID)
+		return
+	}
+
+	if _, ok := checkPreconditions(w, r, true, current.Revision); !ok {
+		return
+	}
+
+	d := req.domain()
+	d.Revision = current.Revision
+	if err := h.tenantManager.UpdateDomain(changeContext(r), d); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "domain "+name+" not found", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, database.ErrRevisionMismatch) {
+			preconditionFailed(w, 0)
+			return
+		}
+		if readOnly(w, err) {
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeDomain(w, http.StatusOK, name)
+}
+
+func (h *AdminHandler) DeleteDomain(w http.ResponseWriter, r *http.Request) {
+	domain := chi.URLParam(r, "domain")
+
+	if domain == "" {
+		http.Error(w, "domain parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	current, err := h.tenantManager.GetDomain(domain)
+	if errors.Is(err, database.ErrNotFound) {
+		http.Error(w, "domain "+domain+" not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	revision, ok := checkPreconditions(w, r, true, current.Revision)
+	if !ok {
+		return
+	}
+
+	if err := h.tenantManager.DeleteDomain(changeContext(r), domain, revision); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, database.ErrRevisionMismatch) {
+			preconditionFailed(w, 0)
+			return
+		}
+		if readOnly(w, err) {
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Domain deleted successfully",
+		"domain":  domain,
+	})
+}
+
+// ListDomains lists all domains, or one tenant's with ?tenant_id=.
+func (h *AdminHandler) ListDomains(w http.ResponseWriter, r *http.Request) {
+	all, err := h.tenantManager.ListDomains(r.URL.Query().Get("tenant_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	domains := make([]database.Domain, 0, len(all))
+	for _, d := range all {
+		if canAccessTenant(r, d.TenantID) {
+			domains = append(domains, d)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"domains": domains,
+		"count":   len(domains),
+	})
+}
+
+// writeDomain responds with the stored domain record.
+func (h *AdminHandler) writeDomain(w http.ResponseWriter, status int, name string) {
+	domain, err := h.tenantManager.GetDomain(name)
+	if err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "domain "+name+" not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(domain.Revision))
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(domain)
+}
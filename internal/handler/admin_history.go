@@ -0,0 +1,230 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/tenantical/router/internal/database"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+// changeContext returns the request context, carrying the actor and request
+// ID that the store records in the history for writes made on its behalf.
+func changeContext(r *http.Request) context.Context {
+	ctx := database.WithActor(r.Context(), requestActor(r))
+	return database.WithRequestID(ctx, middleware.GetReqID(r.Context()))
+}
+
+// requestActor identifies who made an admin request: the API key or
+// operator it was authenticated as, or else the client address.
+func requestActor(r *http.Request) string {
+	if ident := identityFromRequest(r); ident != nil {
+		return ident.Actor()
+	}
+	return "ip:" + clientIP(r)
+}
+
+// changeView is a history entry as returned by the admin API.
+type changeView struct {
+	database.Change
+	Diff map[string]database.FieldDiff `json:"diff"`
+}
+
+func newChangeView(c database.Change) (changeView, error) {
+	diff, err := database.Diff(c.Before, c.After)
+	if err != nil {
+		return changeView{}, err
+	}
+	return changeView{Change: c, Diff: diff}, nil
+}
+
+// ListHistory lists the changes to a tenant and its domains, newest first.
+// Filters: kind (tenant, domain), target; pagination: limit and before (the
+// next_before of the previous page).
+func (h *AdminHandler) ListHistory(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	query := r.URL.Query()
+
+	filter := database.ChangeFilter{
+		TenantID: id,
+		Kind:     query.Get("kind"),
+		Target:   query.Get("target"),
+		Limit:    defaultHistoryLimit,
+	}
+	if filter.Kind != "" && filter.Kind != database.KindTenant && filter.Kind != database.KindDomain {
+		http.Error(w, "kind must be one of: tenant, domain", http.StatusBadRequest)
+		return
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxHistoryLimit {
+			http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxHistoryLimit), http.StatusBadRequest)
+			return
+		}
+		filter.Limit = limit
+	}
+	if v := query.Get("before"); v != "" {
+		before, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || before < 1 {
+			http.Error(w, "before must be a change ID", http.StatusBadRequest)
+			return
+		}
+		filter.BeforeID = before
+	}
+
+	changes, err := h.tenantManager.ListChanges(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	views := make([]changeView, 0, len(changes))
+	for _, c := range changes {
+		view, err := newChangeView(c)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		views = append(views, view)
+	}
+
+	result := map[string]interface{}{
+		"changes": views,
+		"count":   len(views),
+	}
+	if len(changes) == filter.Limit {
+		result["next_before"] = changes[len(changes)-1].ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// GetHistoryChange returns one change of the tenant with its diff.
+func (h *AdminHandler) GetHistoryChange(w http.ResponseWriter, r *http.Request) {
+	change, ok := h.tenantChange(w, chi.URLParam(r, "id"), chi.URLParam(r, "changeID"))
+	if !ok {
+		return
+	}
+
+	view, err := newChangeView(*change)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(view)
+}
+
+// DiffHistory compares one record as it was after two changes (?from=&to=).
+func (h *AdminHandler) DiffHistory(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	query := r.URL.Query()
+
+	from, ok := h.tenantChange(w, id, query.Get("from"))
+	if !ok {
+		return
+	}
+	to, ok := h.tenantChange(w, id, query.Get("to"))
+	if !ok {
+		return
+	}
+	if from.Kind != to.Kind || from.Target != to.Target {
+		http.Error(w, "from and to must be changes of the same record", http.StatusBadRequest)
+		return
+	}
+
+	diff, err := database.Diff(from.After, to.After)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"kind":   from.Kind,
+		"target": from.Target,
+		"from":   from.ID,
+		"to":     to.ID,
+		"diff":   diff,
+	})
+}
+
+// tenantChange looks up a change by its ID in string form and checks that it
+// belongs to the tenant's history, answering 400/404 otherwise.
+func (h *AdminHandler) tenantChange(w http.ResponseWriter, tenantID, changeID string) (*database.Change, bool) {
+	id, err := strconv.ParseInt(changeID, 10, 64)
+	if err != nil || id < 1 {
+		http.Error(w, "invalid change ID", http.StatusBadRequest)
+		return nil, false
+	}
+
+	change, err := h.tenantManager.GetChange(id)
+	if err == nil && !change.Touches(tenantID) {
+		err = database.ErrNotFound
+	}
+	if errors.Is(err, database.ErrNotFound) {
+		http.Error(w, "change "+changeID+" of tenant "+tenantID+" not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return change, true
+}
+
+// RollbackTenant restores the tenant and its domains to their state right
+// after a change of its history. Body: {"change_id": 12}, optionally with
+// "domain" to restore only that domain (e.g. to undelete it).
+func (h *AdminHandler) RollbackTenant(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var req struct {
+		ChangeID int64  `json:"change_id"`
+		Domain   string `json:"domain,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.ChangeID < 1 {
+		http.Error(w, "change_id is required", http.StatusBadRequest)
+		return
+	}
+
+	steps, err := h.tenantManager.RollbackTenant(changeContext(r), id, req.ChangeID, req.Domain)
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, database.ErrNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, database.ErrConflict), errors.Is(err, database.ErrRevisionMismatch):
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if steps == nil {
+		steps = []database.RollbackStep{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":     "Tenant rolled back successfully",
+		"id":          id,
+		"change_id":   req.ChangeID,
+		"steps":       steps,
+		"steps_count": len(steps),
+	})
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// AdminHostRouter serves requests for the admin hosts with admin and all
+// other requests with public, so tenant domains never reach the admin
+// routes and their own /admin paths are proxied like any other.
+func AdminHostRouter(hosts []string, admin, public http.Handler) http.Handler {
+	return HostRouter(hosts, admin, public)
+}
+
+// HostRouter serves requests for hosts with matched and all other requests
+// with other.
+func HostRouter(hosts []string, matched, other http.Handler) http.Handler {
+	set := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		set[normalizeHost(host)] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if set[normalizeHost(r.Host)] {
+			matched.ServeHTTP(w, r)
+			return
+		}
+		other.ServeHTTP(w, r)
+	})
+}
+
+// normalizeHost lowercases host and strips its port and trailing dot.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
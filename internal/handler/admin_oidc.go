@@ -0,0 +1,200 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/tenantical/router/internal/database"
+	"github.com/tenantical/router/internal/oidc"
+)
+
+// oidcFlowLifetime is how long a user has to sign in at the provider.
+const oidcFlowLifetime = 600 // seconds
+
+// SSO configures single sign-on to the admin panel with an OpenID Connect
+// provider, and how its users become operators.
+type SSO struct {
+	Provider      *oidc.Provider
+	UsernameClaim string            // Claim operators are named after, e.g. email
+	GroupsClaim   string            // Claim listing the user's groups
+	TenantsClaim  string            // Optional claim restricting operators to tenants ("*" for all)
+	RoleMap       map[string]string // Group → role; the highest matching role wins
+	DefaultRole   string            // Role of users in no mapped group; empty denies them
+	PostLogoutURL string            // Where the provider sends users after signing out
+}
+
+// operator maps the claims of a verified ID token to the operator's
+// username, role and tenants.
+func (s *SSO) operator(claims oidc.Claims) (username, role string, tenants []string, err error) {
+	username = claims.String(s.UsernameClaim)
+	if username == "" {
+		return "", "", nil, fmt.Errorf("ID token has no %s claim", s.UsernameClaim)
+	}
+	if verified, ok := claims.Bool("email_verified"); s.UsernameClaim == "email" && ok && !verified {
+		return "", "", nil, fmt.Errorf("email %s is not verified", username)
+	}
+
+	var roles []string
+	for _, group := range claims.Strings(s.GroupsClaim) {
+		roles = append(roles, s.RoleMap[group])
+	}
+	if role = database.MaxRole(roles...); role == "" {
+		role = s.DefaultRole
+	}
+	if role == "" {
+		return "", "", nil, fmt.Errorf("%s is in no group with access to the admin panel", username)
+	}
+
+	if s.TenantsClaim != "" {
+		tenants = claims.Strings(s.TenantsClaim)
+		if len(tenants) == 0 {
+			return "", "", nil, fmt.Errorf("ID token of %s has no %s claim", username, s.TenantsClaim)
+		}
+		for _, t := range tenants {
+			if t == "*" {
+				tenants = nil
+				break
+			}
+		}
+	}
+	return username, role, tenants, nil
+}
+
+// oidcCookie holds the state, nonce and PKCE verifier of a sign-in in
+// progress. Unlike the session cookie it is SameSite=Lax, since the
+// provider redirects back to us from another site.
+func (h *AdminHandler) oidcCookie() string {
+	if h.auth.SecureCookies {
+		return "__Host-tr_oidc"
+	}
+	return "tr_oidc"
+}
+
+func (h *AdminHandler) setOIDCCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     h.oidcCookie(),
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   h.auth.SecureCookies,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// OIDCLogin starts single sign-on: it redirects to the provider with a
+// fresh state, nonce and PKCE challenge, whose secrets stay in a cookie.
+func (h *AdminHandler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if h.auth.SSO == nil {
+		http.Error(w, "single sign-on is not enabled", http.StatusNotFound)
+		return
+	}
+
+	var secrets [3]string // state, nonce, verifier
+	for i := range secrets {
+		s, err := oidc.RandomString(32)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		secrets[i] = s
+	}
+
+	authURL, err := h.auth.SSO.Provider.AuthCodeURL(r.Context(), secrets[0], secrets[1], secrets[2])
+	if err != nil {
+		log.Printf("[ADMIN] ERROR: Single sign-on unavailable: %v", err)
+		http.Error(w, "single sign-on provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	h.setOIDCCookie(w, strings.Join(secrets[:], "."), oidcFlowLifetime)
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback completes single sign-on: it checks the state, redeems the
+// code with the PKCE verifier, verifies the ID token and signs the operator
+// in, then returns to the panel. Failures go back to the panel with
+// ?sso_error=. Attempts are recorded in the audit log.
+func (h *AdminHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if h.auth.SSO == nil {
+		http.Error(w, "single sign-on is not enabled", http.StatusNotFound)
+		return
+	}
+	sso := h.auth.SSO
+	query := r.URL.Query()
+
+	var body []byte
+	fail := func(status int, msg string) {
+		log.Printf("[ADMIN] Failed single sign-on from %s: %s", clientIP(r), msg)
+		h.recordAudit(r, body, status, msg)
+		http.Redirect(w, r, "/admin?sso_error="+url.QueryEscape(msg), http.StatusFound)
+	}
+
+	// The cookie is single-use
+	cookie, err := r.Cookie(h.oidcCookie())
+	h.setOIDCCookie(w, "", -1)
+	if err != nil {
+		fail(http.StatusBadRequest, "sign-in expired or started in another browser; try again")
+		return
+	}
+	secrets := strings.Split(cookie.Value, ".")
+	if len(secrets) != 3 || subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(secrets[0])) != 1 {
+		fail(http.StatusBadRequest, "sign-in state mismatch; try again")
+		return
+	}
+	nonce, verifier := secrets[1], secrets[2]
+
+	if e := query.Get("error"); e != "" {
+		fail(http.StatusUnauthorized, "provider refused sign-in: "+strings.TrimSpace(e+" "+query.Get("error_description")))
+		return
+	}
+
+	rawIDToken, err := sso.Provider.Exchange(r.Context(), query.Get("code"), verifier)
+	if err != nil {
+		fail(http.StatusBadGateway, err.Error())
+		return
+	}
+	claims, err := sso.Provider.Verify(r.Context(), rawIDToken, nonce)
+	if err != nil {
+		fail(http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	username, role, tenants, err := sso.operator(claims)
+	body, _ = json.Marshal(map[string]interface{}{
+		"username": username,
+		"subject":  claims.String("sub"),
+		"role":     role,
+		"groups":   claims.Strings(sso.GroupsClaim),
+	})
+	if err != nil {
+		fail(http.StatusForbidden, err.Error())
+		return
+	}
+
+	o, err := h.tenantManager.SignInSSO(changeContext(r), username, role, tenants)
+	if errors.Is(err, database.ErrLocalOperator) || errors.Is(err, database.ErrOperatorDisabled) {
+		fail(http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		fail(http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	token, sess, err := h.tenantManager.CreateSession(r.Context(), o.ID, h.auth.SessionTTL, clientIP(r), r.UserAgent())
+	if err != nil {
+		fail(http.StatusInternalServerError, err.Error())
+		return
+	}
+	h.recordAudit(r.WithContext(withIdentity(r.Context(), o)), body, http.StatusFound, "")
+
+	h.setSessionCookie(w, token, sess.ExpiresAt)
+	http.Redirect(w, r, "/admin", http.StatusFound)
+}
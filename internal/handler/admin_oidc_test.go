@@ -0,0 +1,282 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tenantical/router/internal/database"
+	"github.com/tenantical/router/internal/oidc"
+	"github.com/tenantical/router/internal/oidc/oidctest"
+)
+
+// newSSORouter returns the admin routes of an AdminHandler on tm with
+// single sign-on at an oidctest provider that signs in users.
+func newSSORouter(t *testing.T, tm *database.TenantManager, users ...oidctest.User) (*AdminHandler, http.Handler) {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(nil)
+	mock, err := oidctest.New("http://"+srv.Listener.Addr().String(), "router", users...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Config.Handler = mock
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	h := NewAdminHandler(tm, AuthSettings{SessionTTL: time.Hour, SSO: &SSO{
+		Provider: oidc.NewProvider(oidc.Config{
+			Issuer:      mock.Issuer,
+			ClientID:    "router",
+			RedirectURL: "http://admin.test/admin/oidc/callback",
+			Scopes:      []string{"email", "groups"},
+		}),
+		UsernameClaim: "email",
+		GroupsClaim:   "groups",
+		TenantsClaim:  "tenants",
+		RoleMap:       map[string]string{"admins": database.RoleOwner, "support": database.RoleViewer},
+	}})
+	r := chi.NewRouter()
+	h.RegisterRoutes(r)
+	return h, r
+}
+
+// ssoLogin starts single sign-on and returns the provider's authorization
+// URL and the cookie holding the flow's state, nonce and verifier.
+func ssoLogin(t *testing.T, h *AdminHandler, r http.Handler) (authURL *url.URL, cookie *http.Cookie) {
+	t.Helper()
+	w := serve(r, http.MethodGet, "/admin/oidc/login", "")
+	if w.Code != http.StatusFound {
+		t.Fatalf("GET /admin/oidc/login: %d %s", w.Code, w.Body)
+	}
+	authURL, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range w.Result().Cookies() {
+		if c.Name == h.oidcCookie() {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		t.Fatalf("GET /admin/oidc/login set no %s cookie", h.oidcCookie())
+	}
+	return authURL, cookie
+}
+
+// authorize signs email in at the provider and returns the query the
+// provider redirects back to the callback with.
+func authorize(t *testing.T, authURL *url.URL, email string) url.Values {
+	t.Helper()
+	q := authURL.Query()
+	q.Set("login_hint", email)
+	u := *authURL
+	u.RawQuery = q.Encode()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	back, err := resp.Location()
+	if err != nil {
+		t.Fatalf("authorize %s: %s without a redirect", email, resp.Status)
+	}
+	return back.Query()
+}
+
+// callback completes single sign-on with query and the flow's cookie, and
+// returns the response.
+func callback(r http.Handler, query url.Values, cookie string) *httptest.ResponseRecorder {
+	var header []string
+	if cookie != "" {
+		header = []string{"Cookie", cookie}
+	}
+	return serve(r, http.MethodGet, "/admin/oidc/callback?"+query.Encode(), "", header...)
+}
+
+// ssoError returns the sso_error a callback redirected to the panel with.
+func ssoError(w *httptest.ResponseRecorder) string {
+	u, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		return ""
+	}
+	return u.Query().Get("sso_error")
+}
+
+func TestSSORoundTrip(t *testing.T) {
+	tm := newTenantManager(t)
+	alice := oidctest.User{Email: "alice@example.com", Groups: []string{"support", "admins"}, Tenants: []string{"acme"}}
+	h, r := newSSORouter(t, tm, alice)
+	for _, d := range []database.Domain{{Name: "shop.acme.com", TenantID: "acme"}, {Name: "shop.globex.com", TenantID: "globex"}} {
+		if err := tm.CreateDomain(testContext(), d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	authURL, cookie := ssoLogin(t, h, r)
+	secrets := strings.Split(cookie.Value, ".")
+	if len(secrets) != 3 {
+		t.Fatalf("%s cookie: got %q, want state, nonce and verifier", cookie.Name, cookie.Value)
+	}
+	q := authURL.Query()
+	if q.Get("state") != secrets[0] || q.Get("nonce") != secrets[1] {
+		t.Errorf("authorization URL: got state %q and nonce %q, want the cookie's", q.Get("state"), q.Get("nonce"))
+	}
+	if q.Get("code_challenge") != oidc.Challenge(secrets[2]) || q.Get("code_challenge_method") != "S256" {
+		t.Errorf("authorization URL: got challenge %q (%s), want the S256 challenge of the cookie's verifier", q.Get("code_challenge"), q.Get("code_challenge_method"))
+	}
+	if cookie.SameSite != http.SameSiteLaxMode || !cookie.HttpOnly {
+		t.Errorf("%s cookie: want HttpOnly and SameSite=Lax", cookie.Name)
+	}
+
+	w := callback(r, authorize(t, authURL, alice.Email), cookie.Name+"="+cookie.Value)
+	if w.Code != http.StatusFound || w.Header().Get("Location") != "/admin" {
+		t.Fatalf("GET /admin/oidc/callback: got %d to %s, want a redirect to /admin (%s)", w.Code, w.Header().Get("Location"), ssoError(w))
+	}
+	var session string
+	for _, c := range w.Result().Cookies() {
+		switch c.Name {
+		case h.sessionCookie():
+			session = c.Name + "=" + c.Value
+		case h.oidcCookie():
+			if c.MaxAge >= 0 {
+				t.Errorf("GET /admin/oidc/callback kept the %s cookie", c.Name)
+			}
+		}
+	}
+	if session == "" {
+		t.Fatalf("GET /admin/oidc/callback set no session cookie")
+	}
+
+	o, err := tm.GetOperatorByUsername(alice.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Role != database.RoleOwner || !reflect.DeepEqual(o.Tenants, []string{"acme"}) {
+		t.Errorf("operator %s: got role %s for %v, want owner for [acme]", o.Username, o.Role, o.Tenants)
+	}
+	if w := serve(r, http.MethodGet, "/admin/tenants/acme", "", "Cookie", session); w.Code != http.StatusOK {
+		t.Errorf("GET /admin/tenants/acme with the SSO session: got %d, want 200", w.Code)
+	}
+	if w := serve(r, http.MethodGet, "/admin/tenants/globex", "", "Cookie", session); w.Code != http.StatusForbidden {
+		t.Errorf("GET /admin/tenants/globex with the SSO session: got %d, want 403", w.Code)
+	}
+}
+
+func TestSSOCallbackRejects(t *testing.T) {
+	tm := newTenantManager(t)
+	users := []oidctest.User{
+		{Email: "alice@example.com", Groups: []string{"admins"}, Tenants: []string{"*"}},
+		{Email: "mallory@example.com", Groups: []string{"guests"}, Tenants: []string{"*"}},
+	}
+	h, r := newSSORouter(t, tm, users...)
+
+	tests := []struct {
+		name   string
+		email  string
+		change func(query url.Values, secrets []string) (url.Values, []string)
+		want   string // in sso_error
+	}{
+		{"another state", "alice@example.com", func(q url.Values, s []string) (url.Values, []string) {
+			q.Set("state", "forged")
+			return q, s
+		}, "state mismatch"},
+		{"without a cookie", "alice@example.com", func(q url.Values, s []string) (url.Values, []string) {
+			return q, nil
+		}, "sign-in expired"},
+		{"another nonce", "alice@example.com", func(q url.Values, s []string) (url.Values, []string) {
+			s[1] = "forged"
+			return q, s
+		}, "nonce mismatch"},
+		{"another verifier", "alice@example.com", func(q url.Values, s []string) (url.Values, []string) {
+			s[2] = "forged"
+			return q, s
+		}, "code_verifier"},
+		{"a user in no mapped group", "mallory@example.com", func(q url.Values, s []string) (url.Values, []string) {
+			return q, s
+		}, "no group with access"},
+	}
+	for _, tt := range tests {
+		authURL, cookie := ssoLogin(t, h, r)
+		query, secrets := tt.change(authorize(t, authURL, tt.email), strings.Split(cookie.Value, "."))
+		header := ""
+		if secrets != nil {
+			header = cookie.Name + "=" + strings.Join(secrets, ".")
+		}
+		w := callback(r, query, header)
+		if got := ssoError(w); w.Code != http.StatusFound || !strings.Contains(got, tt.want) {
+			t.Errorf("callback with %s: got %d with sso_error %q, want %q", tt.name, w.Code, got, tt.want)
+		}
+		for _, c := range w.Result().Cookies() {
+			if c.Name == h.sessionCookie() {
+				t.Errorf("callback with %s set a session cookie", tt.name)
+			}
+		}
+	}
+
+	if _, err := tm.GetOperatorByUsername("mallory@example.com"); err == nil {
+		t.Errorf("an operator was created for a user in no mapped group")
+	}
+}
+
+func TestSSOOperator(t *testing.T) {
+	sso := &SSO{
+		UsernameClaim: "email",
+		GroupsClaim:   "groups",
+		TenantsClaim:  "tenants",
+		RoleMap:       map[string]string{"admins": database.RoleOwner, "devs": database.RoleEditor, "support": database.RoleViewer},
+	}
+	alice := func(extra oidc.Claims) oidc.Claims {
+		claims := oidc.Claims{"email": "alice@example.com", "email_verified": true, "tenants": "*"}
+		for k, v := range extra {
+			claims[k] = v
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name        string
+		claims      oidc.Claims
+		defaultRole string
+		wantRole    string
+		wantTenants []string
+		wantErr     string
+	}{
+		{"the only group", alice(oidc.Claims{"groups": "devs"}), "", database.RoleEditor, nil, ""},
+		{"the highest group", alice(oidc.Claims{"groups": []interface{}{"support", "admins", "devs"}}), "", database.RoleOwner, nil, ""},
+		{"unmapped groups", alice(oidc.Claims{"groups": []interface{}{"support", "guests"}}), "", database.RoleViewer, nil, ""},
+		{"no mapped group", alice(oidc.Claims{"groups": []interface{}{"guests"}}), "", "", nil, "no group with access"},
+		{"no mapped group with a default role", alice(oidc.Claims{"groups": []interface{}{"guests"}}), database.RoleViewer, database.RoleViewer, nil, ""},
+		{"a mapped group above the default role", alice(oidc.Claims{"groups": "admins"}), database.RoleViewer, database.RoleOwner, nil, ""},
+		{"listed tenants", alice(oidc.Claims{"groups": "devs", "tenants": []interface{}{"acme", "globex"}}), "", database.RoleEditor, []string{"acme", "globex"}, ""},
+		{"a wildcard among tenants", alice(oidc.Claims{"groups": "devs", "tenants": []interface{}{"acme", "*"}}), "", database.RoleEditor, nil, ""},
+		{"no tenants claim", oidc.Claims{"email": "alice@example.com", "groups": "devs"}, "", "", nil, "no tenants claim"},
+		{"no username", oidc.Claims{"groups": "admins", "tenants": "*"}, "", "", nil, "no email claim"},
+		{"an unverified email", alice(oidc.Claims{"groups": "admins", "email_verified": false}), "", "", nil, "not verified"},
+	}
+	for _, tt := range tests {
+		sso.DefaultRole = tt.defaultRole
+		username, role, tenants, err := sso.operator(tt.claims)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got %v, want an error about %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || username != "alice@example.com" || role != tt.wantRole || !reflect.DeepEqual(tenants, tt.wantTenants) {
+			t.Errorf("%s: got %q, %q, %v, %v, want alice@example.com, %q, %v", tt.name, username, role, tenants, err, tt.wantRole, tt.wantTenants)
+		}
+	}
+
+	// Without a tenants claim configured, operators may access all tenants
+	sso.TenantsClaim = ""
+	if _, _, tenants, err := sso.operator(oidc.Claims{"email": "alice@example.com", "groups": "devs"}); err != nil || tenants != nil {
+		t.Errorf("without a tenants claim: got %v, %v, want all tenants", tenants, err)
+	}
+}
@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tenantical/router/internal/database"
+)
+
+// operatorRequest is the body of POST /admin/operators and PUT
+// /admin/operators/{operatorID}.
+type operatorRequest struct {
+	Username string   `json:"username"`
+	Password string   `json:"password,omitempty"` // Optional on PUT, where it keeps the current password
+	Role     string   `json:"role"`               // viewer, editor or owner
+	Tenants  []string `json:"tenants,omitempty"`  // Tenants the operator is restricted to; empty means all
+	Disabled bool     `json:"disabled"`
+}
+
+// ListOperators lists all operators. Password hashes are never returned.
+func (h *AdminHandler) ListOperators(w http.ResponseWriter, r *http.Request) {
+	operators, err := h.tenantManager.ListOperators()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if operators == nil {
+		operators = []database.Operator{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"operators": operators,
+		"count":     len(operators),
+	})
+}
+
+// GetOperator returns one operator.
+func (h *AdminHandler) GetOperator(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "operatorID")
+
+	o, err := h.tenantManager.GetOperator(id)
+	if errors.Is(err, database.ErrNotFound) {
+		http.Error(w, "operator "+id+" not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(o)
+}
+
+// CreateOperator adds an operator: 201 Created, or 409 Conflict if the
+// username is taken. Without a password the operator can't sign in with
+// one.
+func (h *AdminHandler) CreateOperator(w http.ResponseWriter, r *http.Request) {
+	var req operatorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	o, err := h.tenantManager.CreateOperator(changeContext(r), database.Operator{
+		Username: req.Username,
+		Role:     req.Role,
+		Tenants:  req.Tenants,
+		Disabled: req.Disabled,
+	}, req.Password)
+	if errors.Is(err, database.ErrConflict) {
+		http.Error(w, "operator "+req.Username+" already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(o)
+}
+
+// UpdateOperator replaces an operator's role, tenants and disabled flag,
+// and their password if one is given. Changing the password or disabling
+// the operator ends their sessions.
+func (h *AdminHandler) UpdateOperator(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "operatorID")
+
+	var req operatorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	current, err := h.tenantManager.GetOperator(id)
+	if errors.Is(err, database.ErrNotFound) {
+		http.Error(w, "operator "+id+" not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if req.Username != "" && req.Username != current.Username {
+		http.Error(w, "username can't be changed", http.StatusBadRequest)
+		return
+	}
+
+	current.Role = req.Role
+	current.Tenants = req.Tenants
+	current.Disabled = req.Disabled
+	o, err := h.tenantManager.UpdateOperator(changeContext(r), *current, req.Password)
+	if errors.Is(err, database.ErrNotFound) {
+		http.Error(w, "operator "+id+" not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(o)
+}
+
+// DeleteOperator removes an operator and ends their sessions. Operators
+// can't delete themselves, so an owner can't lock everyone out by accident.
+func (h *AdminHandler) DeleteOperator(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "operatorID")
+
+	if o, ok := identityFromRequest(r).(*database.Operator); ok && o.ID == id {
+		http.Error(w, "operators can't delete themselves", http.StatusConflict)
+		return
+	}
+
+	err := h.tenantManager.DeleteOperator(changeContext(r), id)
+	if errors.Is(err, database.ErrNotFound) {
+		http.Error(w, "operator "+id+" not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Operator deleted successfully",
+		"id":      id,
+	})
+}
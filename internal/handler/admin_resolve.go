@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tenantical/router/internal/database"
+	"github.com/tenantical/router/internal/verify"
+)
+
+// Ways the proxy handles a request, as reported by GET /admin/resolve.
+const (
+	actionProxy             = "proxy"              // Forwarded to the backend
+	actionNotFound          = "not_found"          // No tenant; answered 404
+	actionVerification      = "verification"       // Answered with the domain's verification token
+	actionSuspended         = "suspended"          // Answered with the suspended page
+	actionMaintenance       = "maintenance"        // Answered with the maintenance page, except for allowlisted clients
+	actionMaintenanceWindow = "maintenance_window" // Within a scheduled window; answered with the maintenance page, except for bypassing clients
+)
+
+// routeExplanation describes how the proxy handles a request.
+type routeExplanation struct {
+	*database.Resolution
+	Method       string       `json:"method"`
+	Path         string       `json:"path"`
+	Action       string       `json:"action"`
+	TenantID     string       `json:"tenant_id,omitempty"`
+	TenantStatus string       `json:"tenant_status,omitempty"`
+	Backend      *backendView `json:"backend,omitempty"`
+}
+
+// backendView is where a request is forwarded to.
+type backendView struct {
+	URL           string      `json:"url"`
+	Host          string      `json:"host_header"`
+	Protocol      string      `json:"protocol"`
+	ProxyProtocol string      `json:"proxy_protocol,omitempty"`
+	Headers       http.Header `json:"headers,omitempty"`
+}
+
+// SetProxyHandler lets GET /admin/resolve report where the proxy sends
+// requests.
+func (h *AdminHandler) SetProxyHandler(p *ProxyHandler) {
+	h.proxy = p
+}
+
+// explain resolves a request like Handle would, without sending it.
+func (h *ProxyHandler) explain(method, host, path, rawQuery string) (*routeExplanation, error) {
+	ex := &routeExplanation{
+		Resolution: h.tenantManager.ExplainHost(host),
+		Method:     method,
+		Path:       path,
+		Action:     actionNotFound,
+	}
+
+	if method == http.MethodGet && path == verify.WellKnownPath {
+		_, err := h.tenantManager.GetDomainVerification(ex.Host)
+		if err == nil {
+			ex.Action = actionVerification
+			return ex, nil
+		}
+		if !errors.Is(err, database.ErrNotFound) {
+			return nil, err
+		}
+	}
+
+	info := ex.Info
+	if info == nil {
+		return ex, nil
+	}
+	ex.TenantID = info.TenantID
+	ex.TenantStatus = info.Status
+
+	switch {
+	case info.Status == database.TenantSuspended:
+		ex.Action = actionSuspended
+	case info.Status == database.TenantMaintenance:
+		ex.Action = actionMaintenance
+	case info.MaintenanceAt(time.Now()) != nil:
+		ex.Action = actionMaintenanceWindow
+	default:
+		ex.Action = actionProxy
+	}
+
+	target, err := h.backendFor(info, path, rawQuery)
+	if err != nil {
+		return nil, err
+	}
+	ex.Backend = &backendView{
+		URL:           target.URL.String(),
+		Host:          target.Host,
+		Protocol:      target.Protocol,
+		ProxyProtocol: info.ProxyProtocol,
+		Headers:       target.Header,
+	}
+
+	return ex, nil
+}
+
+// Resolve explains how the proxy handles a request, for debugging routing:
+// GET /admin/resolve?host=shop.example.com&path=/cart%3Fid%3D1&method=POST. It
+// reports the exact domain row and wildcard patterns considered, which one
+// matched and why, whether the answer is cached, and the backend URL and
+// Host header the request is sent with. path defaults to / and method to
+// GET.
+func (h *AdminHandler) Resolve(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	host := strings.TrimSuffix(strings.TrimSpace(query.Get("host")), ".")
+	if host == "" {
+		http.Error(w, "host is required", http.StatusBadRequest)
+		return
+	}
+	method := strings.ToUpper(query.Get("method"))
+	if method == "" {
+		method = http.MethodGet
+	}
+	path := query.Get("path")
+	if path == "" {
+		path = "/"
+	}
+	target, err := url.ParseRequestURI(path)
+	if err != nil || target.Host != "" {
+		http.Error(w, "path must be an absolute path, optionally with a query", http.StatusBadRequest)
+		return
+	}
+	if h.proxy == nil {
+		http.Error(w, "route resolution is not available", http.StatusNotImplemented)
+		return
+	}
+
+	ex, err := h.proxy.explain(method, host, target.Path, target.RawQuery)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Operators limited to some tenants only learn about those
+	for _, id := range []string{ex.TenantID, matchedTenant(ex.Resolution)} {
+		if id != "" && !canAccessTenant(r, id) {
+			forbiddenTenant(w, id)
+			return
+		}
+	}
+	candidates := ex.Candidates[:0:0]
+	for _, c := range ex.Candidates {
+		if canAccessTenant(r, c.TenantID) {
+			candidates = append(candidates, c)
+		}
+	}
+	ex.Candidates = candidates
+	if ex.Exact != nil && !canAccessTenant(r, ex.Exact.TenantID) {
+		ex.Exact = nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ex)
+}
+
+func matchedTenant(res *database.Resolution) string {
+	if res.Matched == nil {
+		return ""
+	}
+	return res.Matched.TenantID
+}
@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/tenantical/router/internal/database"
+)
+
+// csrfHeader carries the session's CSRF token on requests that change state.
+const csrfHeader = "X-CSRF-Token"
+
+// sessionCookie is the name of the admin panel's session cookie. The
+// __Host- prefix makes browsers refuse it unless it's Secure, host-only and
+// for the whole site, so it can only be used over HTTPS.
+func (h *AdminHandler) sessionCookie() string {
+	if h.auth.SecureCookies {
+		return "__Host-tr_session"
+	}
+	return "tr_session"
+}
+
+func (h *AdminHandler) setSessionCookie(w http.ResponseWriter, token string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     h.sessionCookie(),
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   h.auth.SecureCookies,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func (h *AdminHandler) clearSessionCookie(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     h.sessionCookie(),
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   h.auth.SecureCookies,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// requestSession returns the session of the request's cookie and its
+// operator, or nils if there is none or it has ended. ok is false if an
+// error response has been written.
+func (h *AdminHandler) requestSession(w http.ResponseWriter, r *http.Request) (sess *database.Session, o *database.Operator, ok bool) {
+	cookie, err := r.Cookie(h.sessionCookie())
+	if err != nil || cookie.Value == "" {
+		return nil, nil, true
+	}
+
+	sess, o, err = h.tenantManager.SessionOperator(r.Context(), cookie.Value)
+	switch {
+	case errors.Is(err, database.ErrNotFound), errors.Is(err, database.ErrSessionExpired):
+		h.clearSessionCookie(w)
+		return nil, nil, true
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	return sess, o, true
+}
+
+// sessionView is a session as returned to the admin panel, with the CSRF
+// token it must send along with changes.
+type sessionView struct {
+	Operator  *database.Operator `json:"operator"`
+	CSRFToken string             `json:"csrf_token"`
+	ExpiresAt time.Time          `json:"expires_at"`
+}
+
+func writeSession(w http.ResponseWriter, status int, sess *database.Session, o *database.Operator) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(sessionView{Operator: o, CSRFToken: sess.CSRFToken, ExpiresAt: sess.ExpiresAt})
+}
+
+// AuthMethods tells the admin panel which ways of signing in are enabled.
+func (h *AdminHandler) AuthMethods(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{
+		"password": h.auth.PasswordLogin,
+		"sso":      h.auth.SSO != nil,
+	})
+}
+
+// Login signs an operator in with {"username": ..., "password": ...}, sets
+// the session cookie and returns the session. Attempts are recorded in the
+// audit log, without the password.
+func (h *AdminHandler) Login(w http.ResponseWriter, r *http.Request) {
+	if !h.auth.PasswordLogin {
+		http.Error(w, "password sign-in is disabled; use single sign-on", http.StatusForbidden)
+		return
+	}
+
+	// HTML forms can't post JSON, so other sites can't sign browsers in
+	// to an account of their choosing
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	body, _ := json.Marshal(map[string]string{"username": req.Username})
+
+	o, err := h.tenantManager.AuthenticateOperator(r.Context(), req.Username, req.Password)
+	if errors.Is(err, database.ErrInvalidCredentials) {
+		log.Printf("[ADMIN] Failed sign-in for %q from %s", req.Username, clientIP(r))
+		h.recordAudit(r, body, http.StatusUnauthorized, err.Error())
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	token, sess, err := h.tenantManager.CreateSession(r.Context(), o.ID, h.auth.SessionTTL, clientIP(r), r.UserAgent())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	h.recordAudit(r.WithContext(withIdentity(r.Context(), o)), body, http.StatusOK, "")
+
+	h.setSessionCookie(w, token, sess.ExpiresAt)
+	writeSession(w, http.StatusOK, sess, o)
+}
+
+// Logout ends the session of the request's cookie, if any. Operators who
+// signed in through single sign-on also get the provider's logout_url, to
+// end their session there too.
+func (h *AdminHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	response := map[string]string{
+		"message": "Signed out",
+	}
+
+	if cookie, err := r.Cookie(h.sessionCookie()); err == nil && cookie.Value != "" {
+		sess, o, ok := h.requestSession(w, r)
+		if !ok {
+			return
+		}
+		if err := h.tenantManager.DeleteSession(r.Context(), cookie.Value); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if sess != nil {
+			h.recordAudit(r.WithContext(withIdentity(r.Context(), o)), nil, http.StatusOK, "")
+
+			if h.auth.SSO != nil && o.PasswordHash == "" {
+				logoutURL, err := h.auth.SSO.Provider.LogoutURL(r.Context(), h.auth.SSO.PostLogoutURL)
+				if err != nil {
+					log.Printf("[ADMIN] ERROR: Failed to get the single sign-on logout URL: %v", err)
+				}
+				if logoutURL != "" {
+					response["logout_url"] = logoutURL
+				}
+			}
+		}
+	}
+
+	h.clearSessionCookie(w)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// GetSession returns the signed-in operator and the session's CSRF token.
+func (h *AdminHandler) GetSession(w http.ResponseWriter, r *http.Request) {
+	sess, o, ok := h.requestSession(w, r)
+	if !ok {
+		return
+	}
+	if sess == nil {
+		unauthorized(w, "not signed in")
+		return
+	}
+	writeSession(w, http.StatusOK, sess, o)
+}
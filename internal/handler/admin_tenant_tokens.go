@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tenantical/router/internal/database"
+)
+
+// ListTenantTokens lists a tenant's tokens for the tenant API, revoked ones
+// included. Tokens themselves are never returned, only their prefixes.
+func (h *AdminHandler) ListTenantTokens(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	if _, err := h.tenantManager.GetTenant(id); err != nil {
+		if errors.Is(err, database.ErrNotFound) {
+			http.Error(w, "tenant "+id+" not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := h.tenantManager.ListTenantTokens(id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if tokens == nil {
+		tokens = []database.TenantToken{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tokens": tokens,
+		"count":  len(tokens),
+	})
+}
+
+// CreateTenantToken creates a token for the tenant API and returns it. The
+// token is only part of this response. Body: {"name": "ci"}, optionally
+// with "allow_routing": true and "expires_at" or "expires_in" as for API
+// keys.
+func (h *AdminHandler) CreateTenantToken(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+
+	var req struct {
+		Name         string     `json:"name"`
+		AllowRouting bool       `json:"allow_routing"`
+		ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+		ExpiresIn    string     `json:"expires_in,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	expiresAt, msg := requestExpiry(req.ExpiresAt, req.ExpiresIn)
+	if msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	token, t, err := h.tenantManager.CreateTenantToken(changeContext(r), id, req.Name, req.AllowRouting, expiresAt)
+	if errors.Is(err, database.ErrNotFound) {
+		http.Error(w, "tenant "+id+" not found", http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, database.ErrConflict) {
+		http.Error(w, "tenant "+id+" already has a token named "+req.Name, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":      "Tenant token created; store it now, it can't be shown again",
+		"token":        token,
+		"tenant_token": t,
+	})
+}
+
+// RevokeTenantToken revokes one of the tenant's tokens. Revoking a revoked
+// token succeeds and keeps the original revocation time.
+func (h *AdminHandler) RevokeTenantToken(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	tokenID := chi.URLParam(r, "tokenID")
+
+	err := h.tenantManager.RevokeTenantToken(changeContext(r), id, tokenID)
+	if errors.Is(err, database.ErrNotFound) {
+		http.Error(w, "tenant token "+tokenID+" not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Tenant token revoked successfully",
+		"id":      tokenID,
+	})
+}
@@ -122,6 +122,24 @@ func (h *AdminUIHandler) ServeAdminPanel(w http.ResponseWriter, r *http.Request)
             background: #c0392b;
         }
         
+        .btn-secondary {
+            background: #ecf0f1;
+            color: #333;
+            padding: 8px 20px;
+            font-size: 0.9rem;
+        }
+        
+        .btn-secondary:hover {
+            background: #dfe6e9;
+        }
+        
+        .user-bar {
+            display: none;
+            justify-content: space-between;
+            align-items: center;
+            padding: 15px 30px;
+        }
+        
         .btn:disabled {
             opacity: 0.6;
             cursor: not-allowed;
@@ -220,8 +238,32 @@ func (h *AdminUIHandler) ServeAdminPanel(w http.ResponseWriter, r *http.Request)
             <p>مدیریت Tenants و Domain Routing</p>
         </div>
         
+        <div class="card user-bar" id="userBar">
+            <span>ورود با نام <strong id="userName"></strong></span>
+            <button type="button" class="btn btn-secondary" id="logoutBtn">خروج</button>
+        </div>
+        
+        <div class="card" id="loginCard" style="display: none;">
+            <h2 style="margin-bottom: 20px; color: #333;">ورود به پنل</h2>
+            <div class="alert alert-error" id="loginError"></div>
+            <form id="loginForm" style="display: none;">
+                <div class="form-group">
+                    <label for="username">نام کاربری:</label>
+                    <input type="text" id="username" name="username" autocomplete="username" required>
+                </div>
+                <div class="form-group">
+                    <label for="password">رمز عبور:</label>
+                    <input type="password" id="password" name="password" autocomplete="current-password" required>
+                </div>
+                <button type="submit" class="btn btn-primary">ورود</button>
+            </form>
+            <a href="/admin/oidc/login" class="btn btn-primary" id="ssoBtn" style="display: none; text-decoration: none; margin-top: 15px;">ورود با SSO</a>
+            <small id="loginHint" style="display: none; margin-top: 15px; color: #666; font-size: 0.9rem;">اولین اپراتور با دستور tenant-router operator create ساخته می‌شود</small>
+        </div>
+        
+        <div id="panel" style="display: none;">
         <div class="card">
-            <h2 style="margin-bottom: 20px; color: #333;">افزودن Tenant جدید</h2>
+            <h2 style="margin-bottom: 20px; color: #333;">افزودن دامنه جدید</h2>
             <div id="alert"></div>
             <form id="tenantForm">
                 <div class="form-group">
@@ -231,6 +273,7 @@ func (h *AdminUIHandler) ServeAdminPanel(w http.ResponseWriter, r *http.Request)
                 <div class="form-group">
                     <label for="tenant_id">Tenant ID:</label>
                     <input type="text" id="tenant_id" name="tenant_id" placeholder="مثال: tenant-123" required>
+                    <small style="display: block; margin-top: 5px; color: #666; font-size: 0.9rem;">اگر tenant وجود نداشته باشد با تنظیمات پیش‌فرض ساخته می‌شود. فیلدهای خالی زیر از تنظیمات tenant به ارث می‌رسند</small>
                 </div>
                 <div class="form-group">
                     <label for="backend_domain">دامنه داخلی (Backend Domain):</label>
@@ -239,7 +282,7 @@ func (h *AdminUIHandler) ServeAdminPanel(w http.ResponseWriter, r *http.Request)
                 </div>
                 <div class="form-group">
                     <label for="project_route">مسیر پروژه (Project Route):</label>
-                    <input type="text" id="project_route" name="project_route" placeholder="مثال: /projects/backend (اختیاری)" value="/projects/backend">
+                    <input type="text" id="project_route" name="project_route" placeholder="مثال: /projects/backend (اختیاری - پیش‌فرض از tenant)">
                     <small style="display: block; margin-top: 5px; color: #666; font-size: 0.9rem;">مسیر پروژه در reverse proxy (مثل /projects/backend، /projects/frontend). این مسیر به URL بک‌اند اضافه می‌شود تا درخواست به سرویس مناسب forward شود.</small>
                 </div>
                 <div class="form-group">
@@ -247,19 +290,136 @@ func (h *AdminUIHandler) ServeAdminPanel(w http.ResponseWriter, r *http.Request)
                     <input type="number" id="project_port" name="project_port" placeholder="مثال: 85 (اختیاری - برای پروژه‌های روی پورت‌های مختلف)" min="1" max="65535">
                     <small style="display: block; margin-top: 5px; color: #666; font-size: 0.9rem;">اگر خالی بماند، از پورت پیش‌فرض در BACKEND_URL استفاده می‌شود</small>
                 </div>
-                <button type="submit" class="btn btn-primary" id="submitBtn">افزودن Tenant</button>
+                <div class="form-group">
+                    <label for="upstream_protocol">پروتکل بک‌اند (Upstream Protocol):</label>
+                    <select id="upstream_protocol" name="upstream_protocol" style="width: 100%; padding: 12px; border: 2px solid #e0e0e0; border-radius: 8px; font-size: 1rem;">
+                        <option value="">پیش‌فرض (PROXY_UPSTREAM_PROTOCOL)</option>
+                        <option value="http1">HTTP/1.1</option>
+                        <option value="h2">HTTP/2 (TLS)</option>
+                        <option value="h2c">h2c (HTTP/2 بدون TLS - مثلاً gRPC)</option>
+                    </select>
+                    <small style="display: block; margin-top: 5px; color: #666; font-size: 0.9rem;">برای سرویس‌های gRPC از h2 یا h2c استفاده کنید</small>
+                </div>
+                <div class="form-group">
+                    <label for="proxy_protocol">PROXY Protocol به بک‌اند:</label>
+                    <select id="proxy_protocol" name="proxy_protocol" style="width: 100%; padding: 12px; border: 2px solid #e0e0e0; border-radius: 8px; font-size: 1rem;">
+                        <option value="">غیرفعال</option>
+                        <option value="v1">v1 (متنی)</option>
+                        <option value="v2">v2 (باینری)</option>
+                    </select>
+                    <small style="display: block; margin-top: 5px; color: #666; font-size: 0.9rem;">فقط برای بک‌اندهایی که PROXY protocol انتظار دارند (فقط با HTTP/1.1)</small>
+                </div>
+                <button type="submit" class="btn btn-primary" id="submitBtn">افزودن دامنه</button>
             </form>
         </div>
         
         <div class="card">
             <h2 style="margin-bottom: 20px; color: #333;">لیست Tenants</h2>
-            <div class="loading" id="loading">در حال بارگذاری...</div>
             <div id="tenantsContainer"></div>
         </div>
+        
+        <div class="card">
+            <h2 style="margin-bottom: 20px; color: #333;">لیست دامنه‌ها</h2>
+            <div class="loading" id="loading">در حال بارگذاری...</div>
+            <div id="domainsContainer"></div>
+        </div>
+        </div>
     </div>
     
     <script>
-        const API_BASE = '/admin/tenants';
+        const API_BASE = '/admin/domains';
+        const TENANTS_API = '/admin/tenants';
+        let csrfToken = '';
+        let signInWaiters = null;
+        
+        // درخواست به API مدیریت با کوکی نشست؛ در صورت 401 فرم ورود نمایش داده و درخواست تکرار می‌شود
+        async function api(url, options = {}) {
+            for (let attempt = 0; attempt < 2; attempt++) {
+                const headers = Object.assign({}, options.headers || {});
+                const method = (options.method || 'GET').toUpperCase();
+                if (method !== 'GET' && method !== 'HEAD') {
+                    headers['X-CSRF-Token'] = csrfToken;
+                }
+                const response = await fetch(url, Object.assign({}, options, { headers: headers, credentials: 'same-origin' }));
+                if (response.status !== 401 || attempt > 0) {
+                    return response;
+                }
+                // درخواست‌های هم‌زمان منتظر همان ورود می‌مانند
+                await new Promise(resolve => {
+                    showLogin();
+                    signInWaiters.push(resolve);
+                });
+            }
+        }
+        
+        // روش‌های ورود فعال (رمز عبور و/یا SSO)
+        const authMethods = fetch('/admin/auth').then(response => response.json());
+        
+        async function showLogin() {
+            if (!signInWaiters) {
+                signInWaiters = [];
+            }
+            const methods = await authMethods;
+            document.getElementById('loginForm').style.display = methods.password ? 'block' : 'none';
+            document.getElementById('loginHint').style.display = methods.password ? 'block' : 'none';
+            document.getElementById('ssoBtn').style.display = methods.sso ? 'inline-block' : 'none';
+            document.getElementById('loginCard').style.display = 'block';
+            document.getElementById('panel').style.display = 'none';
+            document.getElementById('userBar').style.display = 'none';
+        }
+        
+        function showSession(session) {
+            csrfToken = session.csrf_token;
+            const operator = session.operator;
+            document.getElementById('userName').textContent = operator.username + ' (' + operator.role + ')';
+            document.getElementById('userBar').style.display = 'flex';
+            document.getElementById('loginCard').style.display = 'none';
+            document.getElementById('panel').style.display = 'block';
+        }
+        
+        async function login(e) {
+            e.preventDefault();
+            const errorDiv = document.getElementById('loginError');
+            errorDiv.style.display = 'none';
+            try {
+                const response = await fetch('/admin/login', {
+                    method: 'POST',
+                    headers: { 'Content-Type': 'application/json' },
+                    credentials: 'same-origin',
+                    body: JSON.stringify({
+                        username: document.getElementById('username').value.trim(),
+                        password: document.getElementById('password').value
+                    })
+                });
+                if (!response.ok) {
+                    throw new Error(await response.text() || 'ورود ناموفق بود');
+                }
+                document.getElementById('password').value = '';
+                showSession(await response.json());
+                const waiters = signInWaiters || [];
+                signInWaiters = null;
+                if (waiters.length > 0) {
+                    waiters.forEach(resolve => resolve());
+                } else {
+                    loadAll();
+                }
+            } catch (error) {
+                errorDiv.textContent = error.message;
+                errorDiv.style.display = 'block';
+            }
+        }
+        
+        async function logout() {
+            const response = await fetch('/admin/logout', { method: 'POST', credentials: 'same-origin' });
+            csrfToken = '';
+            const result = await response.json().catch(() => ({}));
+            // خروج از SSO در سمت provider
+            if (result.logout_url) {
+                window.location.href = result.logout_url;
+                return;
+            }
+            showLogin();
+        }
         
         // نمایش پیام
         function showAlert(message, type = 'success') {
@@ -275,38 +435,133 @@ func (h *AdminUIHandler) ServeAdminPanel(w http.ResponseWriter, r *http.Request)
         // بارگذاری لیست tenants
         async function loadTenants() {
             const container = document.getElementById('tenantsContainer');
+            
+            try {
+                const response = await api(TENANTS_API);
+                if (!response.ok) throw new Error('خطا در دریافت اطلاعات');
+                
+                const data = await response.json();
+                const tenants = data.tenants || [];
+                
+                if (tenants.length === 0) {
+                    container.innerHTML = '<div class="empty-state"><p>هیچ tenant ثبت نشده است</p></div>';
+                    return;
+                }
+                
+                let tableHTML = '<table class="tenants-table"><thead><tr><th>Tenant ID</th><th>نام</th><th>وضعیت</th><th>مسیر پروژه</th><th>پورت پروژه</th><th>دامنه‌ها</th><th>عملیات</th></tr></thead><tbody>';
+                
+                tenants.forEach(tenant => {
+                    const projectPort = tenant.project_port ? escapeHtml(tenant.project_port.toString()) : '<span style="color: #999;">-</span>';
+                    const domains = (tenant.domains || []).map(d => escapeHtml(d)).join('<br>') || '<span style="color: #999;">-</span>';
+                    tableHTML += '<tr>' +
+                        '<td><code>' + escapeHtml(tenant.id) + '</code></td>' +
+                        '<td>' + escapeHtml(tenant.name) + '</td>' +
+                        '<td>' + statusSelect(tenant) + '</td>' +
+                        '<td><code>' + escapeHtml(tenant.project_route) + '</code></td>' +
+                        '<td>' + projectPort + '</td>' +
+                        '<td>' + domains + '</td>' +
+                        '<td>' + (tenant.source === 'file' ? FROM_FILE : '<button class="btn btn-danger" onclick="deleteTenant(\'' + escapeHtml(tenant.id) + '\', ' + tenant.revision + ')">حذف</button>') + '</td>' +
+                        '</tr>';
+                });
+                
+                tableHTML += '</tbody></table>';
+                container.innerHTML = tableHTML;
+            } catch (error) {
+                container.innerHTML = '<div class="empty-state"><p>خطا در بارگذاری اطلاعات: ' + escapeHtml(error.message) + '</p></div>';
+            }
+        }
+        
+        // tenantها و دامنه‌های فایل tenantها از طریق پنل قابل تغییر نیستند
+        const FROM_FILE = '<span style="color: #999;">از فایل (فقط‌خواندنی)</span>';
+        
+        const STATUSES = {
+            active: 'فعال',
+            suspended: 'تعلیق‌شده',
+            maintenance: 'در حال تعمیر',
+            archived: 'بایگانی‌شده'
+        };
+        
+        function statusSelect(tenant) {
+            let html = '<select onchange="setTenantStatus(\'' + escapeHtml(tenant.id) + '\', this)" data-revision="' + tenant.revision + '" data-allowlist="' + escapeHtml((tenant.maintenance_allowlist || []).join(', ')) + '"' + (tenant.source === 'file' ? ' disabled' : '') + '>';
+            Object.keys(STATUSES).forEach(status => {
+                html += '<option value="' + status + '"' + (tenant.status === status ? ' selected' : '') + '>' + STATUSES[status] + '</option>';
+            });
+            return html + '</select>';
+        }
+        
+        // تغییر وضعیت tenant
+        async function setTenantStatus(id, select) {
+            const body = { status: select.value };
+            
+            // IPهایی که در حالت تعمیر همچنان به بک‌اند می‌رسند
+            if (select.value === 'maintenance') {
+                const allowlist = prompt('IP یا CIDRهای مجاز در حالت تعمیر (با کاما جدا کنید، اختیاری):', select.dataset.allowlist || '');
+                if (allowlist === null) {
+                    await loadTenants();
+                    return;
+                }
+                body.maintenance_allowlist = allowlist.split(',').map(ip => ip.trim()).filter(ip => ip);
+            }
+            
+            try {
+                const response = await api(TENANTS_API + '/' + encodeURIComponent(id) + '/status', {
+                    method: 'PUT',
+                    headers: {
+                        'Content-Type': 'application/json',
+                        'If-Match': '"' + select.dataset.revision + '"'
+                    },
+                    body: JSON.stringify(body)
+                });
+                
+                if (!response.ok) {
+                    throw new Error(await responseError(response, 'خطا در تغییر وضعیت'));
+                }
+                
+                showAlert('وضعیت tenant به «' + STATUSES[select.value] + '» تغییر کرد', 'success');
+            } catch (error) {
+                showAlert('خطا: ' + error.message, 'error');
+            }
+            await loadTenants();
+        }
+        
+        // بارگذاری لیست دامنه‌ها
+        async function loadDomains() {
+            const container = document.getElementById('domainsContainer');
             const loading = document.getElementById('loading');
             
             loading.classList.add('active');
             container.innerHTML = '';
             
             try {
-                const response = await fetch(API_BASE);
+                const response = await api(API_BASE);
                 if (!response.ok) throw new Error('خطا در دریافت اطلاعات');
                 
                 const data = await response.json();
-                const tenants = data.tenants || [];
+                const domains = data.domains || [];
                 
                 loading.classList.remove('active');
                 
-                if (tenants.length === 0) {
-                    container.innerHTML = '<div class="empty-state"><svg xmlns="http://www.w3.org/2000/svg" fill="none" viewBox="0 0 24 24" stroke="currentColor"><path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M20 13V6a2 2 0 00-2-2H6a2 2 0 00-2 2v7m16 0v5a2 2 0 01-2 2H6a2 2 0 01-2-2v-5m16 0h-2.586a1 1 0 00-.707.293l-2.414 2.414a1 1 0 01-.707.293h-3.172a1 1 0 01-.707-.293l-2.414-2.414A1 1 0 006.586 13H4" /></svg><p>هیچ tenant ثبت نشده است</p></div>';
+                if (domains.length === 0) {
+                    container.innerHTML = '<div class="empty-state"><svg xmlns="http://www.w3.org/2000/svg" fill="none" viewBox="0 0 24 24" stroke="currentColor"><path stroke-linecap="round" stroke-linejoin="round" stroke-width="2" d="M20 13V6a2 2 0 00-2-2H6a2 2 0 00-2 2v7m16 0v5a2 2 0 01-2 2H6a2 2 0 01-2-2v-5m16 0h-2.586a1 1 0 00-.707.293l-2.414 2.414a1 1 0 01-.707.293h-3.172a1 1 0 01-.707-.293l-2.414-2.414A1 1 0 006.586 13H4" /></svg><p>هیچ دامنه‌ای ثبت نشده است</p></div>';
                     return;
                 }
                 
-                let tableHTML = '<table class="tenants-table"><thead><tr><th>دامنه</th><th>Tenant ID</th><th>دامنه داخلی</th><th>مسیر پروژه</th><th>پورت پروژه</th><th>تاریخ ایجاد</th><th>عملیات</th></tr></thead><tbody>';
+                const inherited = '<span style="color: #999;">از tenant</span>';
+                let tableHTML = '<table class="tenants-table"><thead><tr><th>دامنه</th><th>Tenant ID</th><th>دامنه داخلی</th><th>مسیر پروژه</th><th>پورت پروژه</th><th>پروتکل</th><th>تاریخ ایجاد</th><th>عملیات</th></tr></thead><tbody>';
                 
-                tenants.forEach(tenant => {
-                    const projectPort = tenant.project_port ? escapeHtml(tenant.project_port.toString()) : '<span style="color: #999;">-</span>';
-                    const backendDomain = tenant.backend_domain ? escapeHtml(tenant.backend_domain) : '<span style="color: #999;">-</span>';
+                domains.forEach(domain => {
+                    // Port 0 turns the tenant's port off for the domain, like "none" elsewhere
+                    const projectPort = domain.project_port === 0 ? 'none' : domain.project_port ? escapeHtml(domain.project_port.toString()) : inherited;
+                    const backendDomain = domain.backend_domain ? escapeHtml(domain.backend_domain) : inherited;
                     tableHTML += '<tr>' +
-                        '<td><strong>' + escapeHtml(tenant.domain) + '</strong></td>' +
-                        '<td><code>' + escapeHtml(tenant.tenant_id) + '</code></td>' +
+                        '<td><strong>' + escapeHtml(domain.domain) + '</strong></td>' +
+                        '<td><code>' + escapeHtml(domain.tenant_id) + '</code></td>' +
                         '<td>' + backendDomain + '</td>' +
-                        '<td><code>' + escapeHtml(tenant.project_route || '/projects/backend') + '</code></td>' +
+                        '<td>' + (domain.project_route ? '<code>' + escapeHtml(domain.project_route) + '</code>' : inherited) + '</td>' +
                         '<td>' + projectPort + '</td>' +
-                        '<td>' + escapeHtml(tenant.created_at || '-') + '</td>' +
-                        '<td><button class="btn btn-danger" onclick="deleteTenant(\'' + escapeHtml(tenant.domain) + '\')">حذف</button></td>' +
+                        '<td>' + (domain.upstream_protocol ? '<code>' + escapeHtml(domain.upstream_protocol) + '</code>' : inherited) + '</td>' +
+                        '<td>' + escapeHtml(domain.created_at || '-') + '</td>' +
+                        '<td>' + (domain.source === 'file' ? FROM_FILE : '<button class="btn btn-danger" onclick="deleteDomain(\'' + escapeHtml(domain.domain) + '\', ' + domain.revision + ')">حذف</button>') + '</td>' +
                         '</tr>';
                 });
                 
@@ -318,24 +573,45 @@ func (h *AdminUIHandler) ServeAdminPanel(w http.ResponseWriter, r *http.Request)
             }
         }
         
-        // افزودن tenant جدید
-        async function addTenant(e) {
+        async function loadAll() {
+            await Promise.all([loadTenants(), loadDomains()]);
+        }
+        
+        // افزودن دامنه جدید
+        async function addDomain(e) {
             e.preventDefault();
             
             const submitBtn = document.getElementById('submitBtn');
             const projectPortValue = document.getElementById('project_port').value.trim();
             const backendDomainValue = document.getElementById('backend_domain').value.trim();
+            const upstreamProtocolValue = document.getElementById('upstream_protocol').value;
+            const proxyProtocolValue = document.getElementById('proxy_protocol').value;
             const formData = {
                 domain: document.getElementById('domain').value.trim(),
-                tenant_id: document.getElementById('tenant_id').value.trim(),
-                project_route: document.getElementById('project_route').value.trim() || '/projects/backend'
+                tenant_id: document.getElementById('tenant_id').value.trim()
             };
+            const projectRouteValue = document.getElementById('project_route').value.trim();
+            
+            // اضافه کردن project_route فقط اگر مقدار داشته باشد (در غیر این صورت از tenant)
+            if (projectRouteValue) {
+                formData.project_route = projectRouteValue;
+            }
             
             // اضافه کردن backend_domain فقط اگر مقدار داشته باشد
             if (backendDomainValue) {
                 formData.backend_domain = backendDomainValue;
             }
             
+            // اضافه کردن upstream_protocol فقط اگر انتخاب شده باشد
+            if (upstreamProtocolValue) {
+                formData.upstream_protocol = upstreamProtocolValue;
+            }
+            
+            // اضافه کردن proxy_protocol فقط اگر انتخاب شده باشد
+            if (proxyProtocolValue) {
+                formData.proxy_protocol = proxyProtocolValue;
+            }
+            
             // اضافه کردن project_port فقط اگر مقدار داشته باشد
             if (projectPortValue) {
                 const port = parseInt(projectPortValue);
@@ -356,7 +632,7 @@ func (h *AdminUIHandler) ServeAdminPanel(w http.ResponseWriter, r *http.Request)
             submitBtn.textContent = 'در حال افزودن...';
             
             try {
-                const response = await fetch(API_BASE, {
+                const response = await api(API_BASE, {
                     method: 'POST',
                     headers: {
                         'Content-Type': 'application/json'
@@ -364,50 +640,82 @@ func (h *AdminUIHandler) ServeAdminPanel(w http.ResponseWriter, r *http.Request)
                     body: JSON.stringify(formData)
                 });
                 
-                const data = await response.json();
-                
                 if (!response.ok) {
-                    throw new Error(data.error || data.message || 'خطا در افزودن tenant');
+                    throw new Error(await response.text() || 'خطا در افزودن دامنه');
                 }
                 
-                showAlert('Tenant با موفقیت افزوده شد', 'success');
+                showAlert('دامنه با موفقیت افزوده شد', 'success');
                 document.getElementById('tenantForm').reset();
-                document.getElementById('project_route').value = '/projects/backend';
                 document.getElementById('project_port').value = '';
                 document.getElementById('backend_domain').value = '';
-                await loadTenants();
+                await loadAll();
             } catch (error) {
                 showAlert('خطا: ' + error.message, 'error');
             } finally {
                 submitBtn.disabled = false;
-                submitBtn.textContent = 'افزودن Tenant';
+                submitBtn.textContent = 'افزودن دامنه';
             }
         }
         
-        // حذف tenant
-        async function deleteTenant(domain) {
-            if (!confirm('آیا از حذف این tenant اطمینان دارید؟\n\n' + domain)) {
+        // حذف دامنه
+        async function deleteDomain(domain, revision) {
+            if (!confirm('آیا از حذف این دامنه اطمینان دارید؟\n\n' + domain)) {
                 return;
             }
             
             try {
-                const encodedDomain = encodeURIComponent(domain);
-                const response = await fetch(API_BASE + '/' + encodedDomain, {
-                    method: 'DELETE'
+                const response = await api(API_BASE + '/' + encodeURIComponent(domain), {
+                    method: 'DELETE',
+                    headers: {
+                        'If-Match': '"' + revision + '"'
+                    }
                 });
                 
                 if (!response.ok) {
-                    const data = await response.json();
-                    throw new Error(data.error || data.message || 'خطا در حذف tenant');
+                    throw new Error(await responseError(response, 'خطا در حذف دامنه'));
+                }
+                
+                showAlert('دامنه با موفقیت حذف شد', 'success');
+                await loadAll();
+            } catch (error) {
+                showAlert('خطا: ' + error.message, 'error');
+            }
+        }
+        
+        // حذف tenant (فقط وقتی دامنه‌ای نداشته باشد)
+        async function deleteTenant(id, revision) {
+            if (!confirm('آیا از حذف این tenant اطمینان دارید؟\n\n' + id)) {
+                return;
+            }
+            
+            try {
+                const response = await api(TENANTS_API + '/' + encodeURIComponent(id), {
+                    method: 'DELETE',
+                    headers: {
+                        'If-Match': '"' + revision + '"'
+                    }
+                });
+                
+                if (!response.ok) {
+                    throw new Error(await responseError(response, 'خطا در حذف tenant'));
                 }
                 
                 showAlert('Tenant با موفقیت حذف شد', 'success');
-                await loadTenants();
+                await loadAll();
             } catch (error) {
                 showAlert('خطا: ' + error.message, 'error');
             }
         }
         
+        // پیام خطای پاسخ؛ 412 یعنی شخص دیگری رکورد را در این فاصله تغییر داده است
+        async function responseError(response, fallback) {
+            if (response.status === 412) {
+                await loadAll();
+                return 'این رکورد در این فاصله توسط شخص دیگری تغییر کرده است. لیست دوباره بارگذاری شد؛ تغییرات را بررسی و دوباره تلاش کنید.';
+            }
+            return await response.text() || fallback;
+        }
+        
         // Escape HTML برای جلوگیری از XSS
         function escapeHtml(text) {
             const div = document.createElement('div');
@@ -416,13 +724,35 @@ func (h *AdminUIHandler) ServeAdminPanel(w http.ResponseWriter, r *http.Request)
         }
         
         // رویدادها
-        document.getElementById('tenantForm').addEventListener('submit', addTenant);
-        
-        // بارگذاری اولیه
-        loadTenants();
+        document.getElementById('tenantForm').addEventListener('submit', addDomain);
+        
+        document.getElementById('loginForm').addEventListener('submit', login);
+        document.getElementById('logoutBtn').addEventListener('click', logout);
+        
+        // بارگذاری اولیه، پس از بررسی نشست
+        (async () => {
+            const ssoError = new URLSearchParams(window.location.search).get('sso_error');
+            if (ssoError) {
+                const errorDiv = document.getElementById('loginError');
+                errorDiv.textContent = 'ورود با SSO ناموفق بود: ' + ssoError;
+                errorDiv.style.display = 'block';
+                history.replaceState(null, '', '/admin');
+            }
+            const response = await fetch('/admin/session', { credentials: 'same-origin' });
+            if (response.ok) {
+                showSession(await response.json());
+                loadAll();
+            } else {
+                showLogin();
+            }
+        })();
         
         // بارگذاری مجدد هر 30 ثانیه
-        setInterval(loadTenants, 30000);
+        setInterval(() => {
+            if (csrfToken) {
+                loadAll();
+            }
+        }, 30000);
     </script>
 </body>
 </html>`
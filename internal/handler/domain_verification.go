@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tenantical/router/internal/database"
+	"github.com/tenantical/router/internal/verify"
+)
+
+// verificationView is a domain's verification with instructions for
+// either way of proving ownership.
+type verificationView struct {
+	*database.DomainVerification
+	DNS struct {
+		Name  string `json:"name"`
+		Type  string `json:"type"`
+		Value string `json:"value"`
+	} `json:"dns"`
+	HTTP struct {
+		URL  string `json:"url"`
+		Body string `json:"body"`
+	} `json:"http"`
+}
+
+func newVerificationView(d *database.Domain, v *database.DomainVerification) verificationView {
+	view := verificationView{DomainVerification: v}
+	view.DNS.Name = verify.RecordName(d.Name)
+	view.DNS.Type = "TXT"
+	view.DNS.Value = verify.RecordValue(v.Token)
+	view.HTTP.URL = "http://" + d.Name + verify.WellKnownPath
+	view.HTTP.Body = v.Token
+	return view
+}
+
+// writeVerification responds with the domain and its verification.
+func (h *AdminHandler) writeVerification(w http.ResponseWriter, status int, name string) {
+	d, err := h.tenantManager.GetDomain(name)
+	if err == nil {
+		var v *database.DomainVerification
+		if v, err = h.tenantManager.GetDomainVerification(name); err == nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("ETag", etag(d.Revision))
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"domain":       d,
+				"verification": newVerificationView(d, v),
+			})
+			return
+		}
+	}
+
+	if errors.Is(err, database.ErrNotFound) {
+		http.Error(w, "domain "+name+" has no verification; only domains claimed through the tenant API are verified", http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// verifyDomain checks a claimed domain's verification now and responds
+// with the outcome; the domain is active once it succeeded.
+func (h *AdminHandler) verifyDomain(w http.ResponseWriter, r *http.Request, name string) {
+	_, err := h.tenantManager.VerifyDomain(changeContext(r), name)
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		http.Error(w, "domain "+name+" has no verification; only domains claimed through the tenant API are verified", http.StatusNotFound)
+		return
+	case errors.Is(err, database.ErrVerificationDisabled):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.writeVerification(w, http.StatusOK, name)
+}
+
+// GetDomainVerification returns a claimed domain's verification and how to
+// complete it.
+func (h *AdminHandler) GetDomainVerification(w http.ResponseWriter, r *http.Request) {
+	h.writeVerification(w, http.StatusOK, chi.URLParam(r, "domain"))
+}
+
+// VerifyDomain checks a claimed domain's verification without waiting for
+// the next background check.
+func (h *AdminHandler) VerifyDomain(w http.ResponseWriter, r *http.Request) {
+	h.verifyDomain(w, r, chi.URLParam(r, "domain"))
+}
+
+// GetDomainVerification returns the verification of one of the tenant's
+// domains and how to complete it.
+func (h *TenantAPIHandler) GetDomainVerification(w http.ResponseWriter, r *http.Request) {
+	d, ok := h.tenantDomain(w, r)
+	if !ok {
+		return
+	}
+	h.admin.writeVerification(w, http.StatusOK, d.Name)
+}
+
+// VerifyDomain checks the verification of one of the tenant's domains
+// right away. The response tells whether the domain is active now, or why
+// verification failed.
+func (h *TenantAPIHandler) VerifyDomain(w http.ResponseWriter, r *http.Request) {
+	d, ok := h.tenantDomain(w, r)
+	if !ok {
+		return
+	}
+	h.admin.verifyDomain(w, r, d.Name)
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etag returns the entity tag of a record revision.
+func etag(revision int64) string {
+	return `"` + strconv.FormatInt(revision, 10) + `"`
+}
+
+// checkPreconditions evaluates If-Match and If-None-Match against the stored
+// record (exists, at revision) before a write. It returns the revision the
+// write must still find in the store, or 0 when it may create the record.
+// Changing or deleting an existing record requires If-Match: without it,
+// it answers 428; when a precondition fails, 412.
+func checkPreconditions(w http.ResponseWriter, r *http.Request, exists bool, revision int64) (int64, bool) {
+	if noneMatch := r.Header.Get("If-None-Match"); noneMatch != "" && exists {
+		if strings.TrimSpace(noneMatch) == "*" || matchesETag(noneMatch, revision) {
+			preconditionFailed(w, revision)
+			return 0, false
+		}
+	}
+
+	match := r.Header.Get("If-Match")
+	if match == "" {
+		if exists {
+			preconditionRequired(w, revision)
+			return 0, false
+		}
+		return 0, true
+	}
+	if !exists {
+		preconditionFailed(w, 0)
+		return 0, false
+	}
+	if strings.TrimSpace(match) != "*" && !matchesETag(match, revision) {
+		preconditionFailed(w, revision)
+		return 0, false
+	}
+	return revision, true
+}
+
+// matchesETag reports whether the comma-separated list of entity tags in
+// header contains the tag of revision. Weak tags never match, as If-Match
+// requires the strong comparison.
+func matchesETag(header string, revision int64) bool {
+	want := etag(revision)
+	for _, tag := range strings.Split(header, ",") {
+		if strings.TrimSpace(tag) == want {
+			return true
+		}
+	}
+	return false
+}
+
+// preconditionFailed answers 412 for a record that was changed (or created
+// or deleted) since the client read it. The current ETag, if any, lets the
+// client fetch the record again and retry.
+func preconditionFailed(w http.ResponseWriter, revision int64) {
+	if revision != 0 {
+		w.Header().Set("ETag", etag(revision))
+	}
+	http.Error(w, "precondition failed: the record was modified by someone else, reload it and try again", http.StatusPreconditionFailed)
+}
+
+// preconditionRequired answers 428 for a change or delete without If-Match,
+// so that clients can't overwrite changes they haven't seen. The current
+// ETag lets the client retry with it.
+func preconditionRequired(w http.ResponseWriter, revision int64) {
+	w.Header().Set("ETag", etag(revision))
+	http.Error(w, `precondition required: send If-Match with the record's ETag (or "*" to change it regardless)`, http.StatusPreconditionRequired)
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/tenantical/router/internal/database"
+)
+
+func TestPatchTenantPreconditions(t *testing.T) {
+	tm := newTenantManager(t)
+	_, h := newAdminRouter(tm)
+	auth := "Bearer " + newAPIKey(t, tm, "test", database.ScopeWrite)
+
+	w := serve(h, http.MethodPost, "/admin/tenants", `{"id": "acme", "name": "Acme", "project_route": "/projects/acme"}`, "Authorization", auth)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /admin/tenants: %d %s", w.Code, w.Body)
+	}
+
+	patch := `{"name": "Renamed"}`
+	tests := []struct {
+		name     string
+		ifMatch  string
+		want     int
+		wantETag string
+	}{
+		{"without If-Match", "", http.StatusPreconditionRequired, `"1"`},
+		{"stale If-Match", `"7"`, http.StatusPreconditionFailed, `"1"`},
+		{"current If-Match", `"1"`, http.StatusOK, `"2"`},
+		{"If-Match of the previous revision", `"1"`, http.StatusPreconditionFailed, `"2"`},
+	}
+	for _, tt := range tests {
+		header := []string{"Authorization", auth}
+		if tt.ifMatch != "" {
+			header = append(header, "If-Match", tt.ifMatch)
+		}
+		w := serve(h, http.MethodPatch, "/admin/tenants/acme", patch, header...)
+		if w.Code != tt.want || w.Header().Get("ETag") != tt.wantETag {
+			t.Errorf("PATCH %s: got %d with ETag %s, want %d with ETag %s (%s)", tt.name, w.Code, w.Header().Get("ETag"), tt.want, tt.wantETag, w.Body)
+		}
+	}
+
+	// Only the patched field changed
+	w = serve(h, http.MethodGet, "/admin/tenants/acme", "", "Authorization", auth)
+	var got database.Tenant
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "Renamed" || got.ProjectRoute != "/projects/acme" || got.Revision != 2 {
+		t.Errorf("GET /admin/tenants/acme: got %+v, want it renamed once and its route kept", got)
+	}
+}
+
+func TestDomainPreconditions(t *testing.T) {
+	tm := newTenantManager(t)
+	_, h := newAdminRouter(tm)
+	auth := "Bearer " + newAPIKey(t, tm, "test", database.ScopeWrite)
+	const path = "/admin/domains/shop.example.com"
+
+	// Creating needs no If-Match, but If-None-Match: * refuses to overwrite
+	if w := serve(h, http.MethodPut, path, `{"tenant_id": "acme"}`, "Authorization", auth, "If-None-Match", "*"); w.Code != http.StatusCreated {
+		t.Fatalf("PUT %s: %d %s", path, w.Code, w.Body)
+	}
+	if w := serve(h, http.MethodPut, path, `{"tenant_id": "acme"}`, "Authorization", auth, "If-None-Match", "*"); w.Code != http.StatusPreconditionFailed {
+		t.Errorf("PUT %s of an existing domain with If-None-Match *: got %d, want 412", path, w.Code)
+	}
+
+	tests := []struct {
+		method  string
+		ifMatch string
+		want    int
+	}{
+		{http.MethodPut, "", http.StatusPreconditionRequired},
+		{http.MethodPut, `"2"`, http.StatusPreconditionFailed},
+		{http.MethodPut, `W/"1"`, http.StatusPreconditionFailed},
+		{http.MethodPut, `"1"`, http.StatusOK},
+		{http.MethodDelete, "", http.StatusPreconditionRequired},
+		{http.MethodDelete, `"1"`, http.StatusPreconditionFailed},
+		{http.MethodDelete, `"2"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		header := []string{"Authorization", auth}
+		if tt.ifMatch != "" {
+			header = append(header, "If-Match", tt.ifMatch)
+		}
+		body := ""
+		if tt.method == http.MethodPut {
+			body = `{"tenant_id": "acme", "project_route": "/projects/shop"}`
+		}
+		if w := serve(h, tt.method, path, body, header...); w.Code != tt.want {
+			t.Errorf("%s %s with If-Match %q: got %d, want %d (%s)", tt.method, path, tt.ifMatch, w.Code, tt.want, w.Body)
+		}
+	}
+
+	if w := serve(h, http.MethodGet, path, "", "Authorization", auth); w.Code != http.StatusNotFound {
+		t.Errorf("GET %s after DELETE: got %d, want 404", path, w.Code)
+	}
+	if w := serve(h, http.MethodDelete, path, "", "Authorization", auth, "If-Match", "*"); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE %s of a missing domain: got %d, want 404", path, w.Code)
+	}
+}
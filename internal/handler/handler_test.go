@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tenantical/router/internal/database"
+)
+
+func TestMain(m *testing.M) {
+	// The handlers log every request
+	log.SetOutput(io.Discard)
+	os.Exit(m.Run())
+}
+
+// newTenantManager returns a TenantManager on an empty SQLite database,
+// closed when the test ends.
+func newTenantManager(t *testing.T) *database.TenantManager {
+	t.Helper()
+	store, err := database.OpenStore(database.DriverSQLite, filepath.Join(t.TempDir(), "tenants.db"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm, err := database.NewTenantManager(store, false)
+	if err != nil {
+		store.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tm.Close() })
+	return tm
+}
+
+// testContext attributes changes made by tests in the history.
+func testContext() context.Context {
+	return database.WithActor(context.Background(), "test")
+}
+
+// newAdminRouter returns the admin routes of an AdminHandler on tm, with
+// password sign-in enabled.
+func newAdminRouter(tm *database.TenantManager) (*AdminHandler, http.Handler) {
+	h := NewAdminHandler(tm, AuthSettings{SessionTTL: time.Hour, PasswordLogin: true})
+	r := chi.NewRouter()
+	h.RegisterRoutes(r)
+	return h, r
+}
+
+// newAPIKey creates an API key with scopes and returns it.
+func newAPIKey(t *testing.T, tm *database.TenantManager, name string, scopes ...string) string {
+	t.Helper()
+	key, _, err := tm.CreateAPIKey(testContext(), name, scopes, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// serve sends a request to h and returns the response. header lists
+// header names and values in turn.
+func serve(h http.Handler, method, target, body string, header ...string) *httptest.ResponseRecorder {
+	var r *http.Request
+	if body != "" {
+		r = httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+	} else {
+		r = httptest.NewRequest(method, target, nil)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
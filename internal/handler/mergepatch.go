@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// mergePatch applies a JSON merge patch (RFC 7386) to the JSON document doc:
+// objects are merged recursively, null removes a member and any other value
+// replaces it.
+func mergePatch(doc, patch []byte) ([]byte, error) {
+	var target, p interface{}
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &p); err != nil {
+		return nil, fmt.Errorf("invalid merge patch: %w", err)
+	}
+	if _, ok := p.(map[string]interface{}); !ok {
+		return nil, fmt.Errorf("invalid merge patch: must be a JSON object")
+	}
+	return json.Marshal(mergeValue(target, p))
+}
+
+func mergeValue(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = make(map[string]interface{})
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergeValue(targetObj[key], value)
+	}
+	return targetObj
+}
+
+// isMergePatch reports whether the request body is declared as a merge
+// patch. Plain application/json is accepted too, for simple clients.
+func isMergePatch(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return r.Header.Get("Content-Type") == ""
+	}
+	return mediaType == "application/merge-patch+json" || mediaType == "application/json"
+}
+
+// patchInto applies the merge patch read from body to the JSON form of
+// current and decodes the result into dst.
+func patchInto(current interface{}, body io.Reader, dst interface{}) error {
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	patch, err := io.ReadAll(body)
+	if err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	merged, err := mergePatch(doc, patch)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(merged, dst); err != nil {
+		return fmt.Errorf("invalid patched document: %w", err)
+	}
+	return nil
+}
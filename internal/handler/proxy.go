@@ -1,9 +1,13 @@
 package handler
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -13,34 +17,160 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/tenantical/router/internal/database"
+	"github.com/tenantical/router/internal/proxyproto"
+	"github.com/tenantical/router/internal/verify"
+	"golang.org/x/net/http2"
 )
 
 type ProxyHandler struct {
-	tenantManager *database.TenantManager
-	backendURL    string
-	client        *http.Client
+	tenantManager    *database.TenantManager
+	backendURL       string
+	upstreamProtocol string // Default upstream protocol for tenants that don't set one
+	client           *http.Client
+	h2Client         *http.Client // HTTP/2 over TLS
+	h2cClient        *http.Client // HTTP/2 over cleartext (prior knowledge)
+	proxyProtoClient *http.Client // HTTP/1.1 without connection reuse, sends PROXY protocol headers
+	statusPages      *StatusPages // Responses for suspended and maintenance tenants
 }
 
-func NewProxyHandler(tm *database.TenantManager, backendURL string, timeout time.Duration, maxIdleConns int, idleConnTimeout time.Duration, disableKeepAlive bool) *ProxyHandler {
+func NewProxyHandler(tm *database.TenantManager, backendURL string, timeout time.Duration, maxIdleConns int, idleConnTimeout time.Duration, disableKeepAlive bool, upstreamProtocol string, tlsSkipVerify bool, statusPages *StatusPages) *ProxyHandler {
+	tlsConfig := &tls.Config{InsecureSkipVerify: tlsSkipVerify}
+
+	// Every upstream transport gives up on unreachable backends and on
+	// backends that don't start answering within the proxy timeout
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
 	transport := &http.Transport{
-		MaxIdleConns:        maxIdleConns,
-		IdleConnTimeout:     idleConnTimeout,
-		DisableKeepAlives:   disableKeepAlive,
-		MaxIdleConnsPerHost: 10,
+		DialContext:           dialer.DialContext,
+		MaxIdleConns:          maxIdleConns,
+		IdleConnTimeout:       idleConnTimeout,
+		DisableKeepAlives:     disableKeepAlive,
+		MaxIdleConnsPerHost:   10,
+		TLSClientConfig:       tlsConfig,
+		ResponseHeaderTimeout: timeout,
+	}
+
+	noRedirect := func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
 	}
 
 	client := &http.Client{
-		Transport: transport,
-		Timeout:   timeout,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			return http.ErrUseLastResponse
+		Transport:     transport,
+		Timeout:       timeout,
+		CheckRedirect: noRedirect,
+	}
+
+	// HTTP/2 clients carry long-lived streams (e.g. gRPC server streaming),
+	// so they have no overall client timeout; the request context bounds
+	// them, which streaming requests get without a deadline (see Timeout).
+	h2Client := &http.Client{
+		Transport: &responseHeaderTimeout{
+			RoundTripper: &http2.Transport{
+				TLSClientConfig: tlsConfig.Clone(),
+				DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+					d := &tls.Dialer{NetDialer: dialer, Config: cfg}
+					return d.DialContext(ctx, network, addr)
+				},
+			},
+			timeout: timeout,
+		},
+		CheckRedirect: noRedirect,
+	}
+
+	h2cClient := &http.Client{
+		Transport: &responseHeaderTimeout{
+			RoundTripper: &http2.Transport{
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					return dialer.DialContext(ctx, network, addr)
+				},
+			},
+			timeout: timeout,
+		},
+		CheckRedirect: noRedirect,
+	}
+
+	// A PROXY protocol header describes one client, so connections can't be shared
+	proxyProtoClient := &http.Client{
+		Transport: &http.Transport{
+			DialContext:           proxyproto.DialContext(dialer),
+			DisableKeepAlives:     true,
+			TLSClientConfig:       tlsConfig.Clone(),
+			ResponseHeaderTimeout: timeout,
 		},
+		Timeout:       timeout,
+		CheckRedirect: noRedirect,
+	}
+
+	if upstreamProtocol == "" {
+		upstreamProtocol = database.UpstreamHTTP1
 	}
 
 	return &ProxyHandler{
-		tenantManager: tm,
-		backendURL:    backendURL,
-		client:        client,
+		tenantManager:    tm,
+		backendURL:       backendURL,
+		upstreamProtocol: upstreamProtocol,
+		client:           client,
+		h2Client:         h2Client,
+		h2cClient:        h2cClient,
+		proxyProtoClient: proxyProtoClient,
+		statusPages:      statusPages,
+	}
+}
+
+// responseHeaderTimeout fails requests whose response headers don't
+// arrive within timeout, like http.Transport's ResponseHeaderTimeout, which
+// http2.Transport lacks. The body may then take as long as it needs.
+type responseHeaderTimeout struct {
+	http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *responseHeaderTimeout) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.timeout <= 0 {
+		return t.RoundTripper.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	timer := time.AfterFunc(t.timeout, cancel)
+	resp, err := t.RoundTripper.RoundTrip(req.WithContext(ctx))
+	expired := !timer.Stop()
+	if err == nil && expired {
+		resp.Body.Close()
+		err = context.Canceled
+	}
+	if err != nil {
+		cancel()
+		if expired {
+			return nil, fmt.Errorf("timeout awaiting response headers after %v", t.timeout)
+		}
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases a request's context once its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// clientFor returns the HTTP client and URL scheme to use for the given upstream protocol.
+func (h *ProxyHandler) clientFor(protocol, scheme string) (*http.Client, string) {
+	switch protocol {
+	case database.UpstreamH2:
+		return h.h2Client, "https"
+	case database.UpstreamH2C:
+		return h.h2cClient, "http"
+	default:
+		return h.client, scheme
 	}
 }
 
@@ -72,19 +202,169 @@ func (h *ProxyHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("[PROXY] Tenant found - ID: %s, Route: %s, Port: %v, BackendDomain: %v",
-		tenantInfo.TenantID, tenantInfo.ProjectRoute,
-		tenantInfo.ProjectPort, tenantInfo.BackendDomain)
+	// Suspended and maintenance tenants are answered by the router
+	if h.statusPages != nil && h.statusPages.Intercept(w, r, host, tenantInfo) {
+		return
+	}
 
-	// Build backend URL
-	baseURL, err := parseBackendURL(h.backendURL)
+	target, err := h.backendFor(tenantInfo, r.URL.Path, r.URL.RawQuery)
 	if err != nil {
 		log.Printf("[PROXY] ERROR: Invalid backend URL configuration: %s", h.backendURL)
 		http.Error(w, "Invalid backend URL configuration", http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("[PROXY] Backend URL parsed: %s", baseURL.String())
+	log.Printf("[PROXY] Tenant found - ID: %s, Route: %s, Port: %v, BackendDomain: %v, Protocol: %s, ProxyProtocol: %s",
+		tenantInfo.TenantID, tenantInfo.ProjectRoute,
+		tenantInfo.ProjectPort, tenantInfo.BackendDomain, target.Protocol, tenantInfo.ProxyProtocol)
+
+	client := target.client
+
+	// Backends expecting PROXY protocol get a dedicated HTTP/1.1 connection per request
+	ctx := r.Context()
+	if tenantInfo.ProxyProtocol != "" {
+		client = h.proxyProtoClient
+		var localAddr *net.TCPAddr
+		if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+			localAddr = proxyproto.TCPAddr(addr.String())
+		}
+		ctx = proxyproto.WithHeader(ctx, tenantInfo.ProxyProtocol, proxyproto.TCPAddr(r.RemoteAddr), localAddr)
+	}
+
+	log.Printf("[PROXY] Final backend URL: %s", target.URL.String())
+
+	// Streams last as long as the client and backend keep them open, so
+	// neither the client timeout nor the server's write timeout applies
+	streaming := isStreamingRequest(r)
+	if streaming {
+		client = withoutTimeout(client)
+		clearWriteDeadline(w)
+	}
+
+	// Create request to backend
+	backendReq, err := http.NewRequestWithContext(ctx, r.Method, target.URL.String(), r.Body)
+	if err != nil {
+		log.Printf("[PROXY] ERROR: Failed to create backend request: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	backendReq.ContentLength = r.ContentLength
+
+	// Request trailers (if any) are filled in by the server once the body is read
+	backendReq.Trailer = r.Trailer
+
+	// Copy headers from original request
+	for key, values := range r.Header {
+		// Skip headers that should not be forwarded
+		switch key {
+		case "Connection", "Keep-Alive", "Proxy-Authenticate",
+			"Proxy-Authorization", "Trailers", "Transfer-Encoding", "Upgrade":
+			continue
+		case "Te":
+			// "TE: trailers" is end-to-end for gRPC and must reach the backend
+			for _, value := range values {
+				if strings.EqualFold(strings.TrimSpace(value), "trailers") {
+					backendReq.Header.Set("Te", "trailers")
+				}
+			}
+			continue
+		}
+		
+		for _, value := range values {
+			backendReq.Header.Add(key, value)
+		}
+	}
+
+	// Request goes to backend without any tenant identification headers
+	// Tenant identification is done via domain-based routing only, but
+	// domains can set headers of their own, replacing the client's
+	for key, values := range target.Header {
+		backendReq.Header[key] = values
+	}
+
+	backendReq.Host = target.Host
+
+	// Forward request
+	log.Printf("[PROXY] Forwarding request to backend: %s %s", backendReq.Method, backendReq.URL.String())
+	resp, err := client.Do(backendReq)
+	if err != nil {
+		log.Printf("[PROXY] ERROR: Backend request failed: %v", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	log.Printf("[PROXY] Backend response: %d %s", resp.StatusCode, resp.Status)
+
+	if !streaming && streamingType(resp.Header.Get("Content-Type")) {
+		clearWriteDeadline(w)
+	}
+
+	// Copy response headers (must be done before WriteHeader)
+	for key, values := range resp.Header {
+		switch key {
+		case "Connection", "Keep-Alive", "Trailer", "Transfer-Encoding", "Upgrade":
+			continue
+		}
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+
+	// Announce trailers declared by the backend so they can be sent after the body
+	announced := make(map[string]bool, len(resp.Trailer))
+	for key := range resp.Trailer {
+		w.Header().Add("Trailer", key)
+		announced[key] = true
+	}
+
+	// Set status code
+	w.WriteHeader(resp.StatusCode)
+
+	// Copy response body, flushing as data arrives for streaming responses
+	// (gRPC streams, server-sent events) so they aren't held back in buffers
+	err = copyResponse(w, resp.Body, isStreaming(resp))
+	if err != nil {
+		log.Printf("[PROXY] ERROR: Failed to copy response body: %v", err)
+		// Response already started, can't change status
+		// Log error in production
+		return
+	}
+
+	// Copy trailers (e.g. grpc-status); undeclared ones are only known after the body is read
+	for key, values := range resp.Trailer {
+		if announced[key] {
+			w.Header()[key] = values
+		} else {
+			w.Header()[http.TrailerPrefix+key] = values
+		}
+	}
+
+	log.Printf("[PROXY] Request completed successfully - forwarded %s %s to backend", r.Method, r.URL.Path)
+}
+
+// backendTarget is where the proxy sends a tenant's request.
+type backendTarget struct {
+	URL      *url.URL
+	Host     string // Host header sent to the backend
+	Protocol string      // Upstream protocol
+	Header   http.Header // The domain's request headers, set on the forwarded request
+	client   *http.Client
+}
+
+// backendFor works out where a request for path and rawQuery goes for
+// the tenant, and with which client.
+func (h *ProxyHandler) backendFor(tenantInfo *database.TenantInfo, path, rawQuery string) (*backendTarget, error) {
+	upstreamProtocol := tenantInfo.UpstreamProtocol
+	if upstreamProtocol == "" {
+		upstreamProtocol = h.upstreamProtocol
+	}
+
+	// Build backend URL
+	baseURL, err := parseBackendURL(h.backendURL)
+	if err != nil {
+		return nil, err
+	}
 
 	// Determine scheme (default to http)
 	scheme := baseURL.Scheme
@@ -92,6 +372,9 @@ func (h *ProxyHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		scheme = "http"
 	}
 
+	// HTTP/2 protocols dictate the scheme: h2 needs TLS, h2c is cleartext
+	client, scheme := h.clientFor(upstreamProtocol, scheme)
+
 	var backendURL *url.URL
 
 	// Override domain if tenant has a specific backend domain
@@ -140,9 +423,7 @@ func (h *ProxyHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		}
 	} else {
 		backendURL = baseURL
-		if backendURL.Scheme == "" {
-			backendURL.Scheme = scheme
-		}
+		backendURL.Scheme = scheme
 	}
 
 	// Construct full backend path with project route
@@ -166,7 +447,7 @@ func (h *ProxyHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	// Construct path: projectRoute + originalPath
-	backendPath := projectRoute + r.URL.Path
+	backendPath := projectRoute + path
 	
 	// Ensure path starts with / for proper URL resolution
 	if !strings.HasPrefix(backendPath, "/") {
@@ -176,36 +457,14 @@ func (h *ProxyHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	// Build the full URL by combining base URL with path and query
 	backendReqURL := backendURL.ResolveReference(&url.URL{
 		Path:     backendPath,
-		RawQuery: r.URL.RawQuery,
+		RawQuery: rawQuery,
 	})
 
-	log.Printf("[PROXY] Final backend URL: %s", backendReqURL.String())
-
-	// Create request to backend
-	backendReq, err := http.NewRequestWithContext(r.Context(), r.Method, backendReqURL.String(), r.Body)
-	if err != nil {
-		log.Printf("[PROXY] ERROR: Failed to create backend request: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	target := &backendTarget{URL: backendReqURL, Protocol: upstreamProtocol, Header: http.Header{}, client: client}
+	for key, value := range tenantInfo.RequestHeaders {
+		target.Header.Set(key, value)
 	}
 
-	// Copy headers from original request
-	for key, values := range r.Header {
-		// Skip headers that should not be forwarded
-		switch key {
-		case "Connection", "Keep-Alive", "Proxy-Authenticate",
-			"Proxy-Authorization", "Te", "Trailers", "Transfer-Encoding", "Upgrade":
-			continue
-		}
-		
-		for _, value := range values {
-			backendReq.Header.Add(key, value)
-		}
-	}
-
-	// Request goes to backend without any tenant identification headers
-	// Tenant identification is done via domain-based routing only
-
 	// Set proper Host header for backend
 	// If backend domain was specified, preserve the original domain in Host header for proper routing
 	// This is important for nginx virtual hosts that route based on Host header
@@ -215,47 +474,110 @@ func (h *ProxyHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		// But connect to host.docker.internal:85 for the actual connection
 		// Don't include port in Host header - nginx routes based on domain name only
 		originalDomain := *tenantInfo.BackendDomain
-		backendReq.Host = originalDomain
+		target.Host = originalDomain
 	} else {
-		backendReq.Host = backendURL.Host
+		target.Host = backendURL.Host
 	}
 
-	// Forward request
-	log.Printf("[PROXY] Forwarding request to backend: %s %s", backendReq.Method, backendReq.URL.String())
-	resp, err := h.client.Do(backendReq)
+	return target, nil
+}
+
+func (h *ProxyHandler) RegisterRoutes(r chi.Router) {
+	r.Get(verify.WellKnownPath, h.ServeVerification)
+	r.HandleFunc("/*", h.Handle)
+}
+
+// ServeVerification answers the well-known verification path of domains
+// claimed through the tenant API with their token, so that a domain
+// pointing at the router verifies. Other domains are proxied as usual.
+func (h *ProxyHandler) ServeVerification(w http.ResponseWriter, r *http.Request) {
+	v, err := h.tenantManager.GetDomainVerification(r.Host)
+	if errors.Is(err, database.ErrNotFound) {
+		h.Handle(w, r)
+		return
+	}
 	if err != nil {
-		log.Printf("[PROXY] ERROR: Backend request failed: %v", err)
-		http.Error(w, err.Error(), http.StatusBadGateway)
+		log.Printf("[PROXY] ERROR: Verification lookup for %s failed: %v", r.Host, err)
+		http.Error(w, "Verification lookup failed", http.StatusInternalServerError)
 		return
 	}
-	defer resp.Body.Close()
 
-	log.Printf("[PROXY] Backend response: %d %s", resp.StatusCode, resp.Status)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprintln(w, v.Token)
+}
 
-	// Copy response headers (must be done before WriteHeader)
-	for key, values := range resp.Header {
-		for _, value := range values {
-			w.Header().Add(key, value)
+// streamingContentTypes are response media types delivered piece by piece.
+var streamingContentTypes = []string{"application/grpc", "text/event-stream", "application/x-ndjson"}
+
+// isStreaming reports whether resp should reach the client as it arrives:
+// a streaming media type, or a body of unknown length.
+func isStreaming(resp *http.Response) bool {
+	return resp.ContentLength < 0 || streamingType(resp.Header.Get("Content-Type"))
+}
+
+// isStreamingRequest reports whether r opens a stream: a gRPC call, or a
+// request that accepts a streaming media type in return, like an
+// EventSource's.
+func isStreamingRequest(r *http.Request) bool {
+	return streamingType(r.Header.Get("Content-Type")) || streamingType(r.Header.Values("Accept")...)
+}
+
+// streamingType reports whether one of the comma-separated media types in
+// values is a streaming one.
+func streamingType(values ...string) bool {
+	for _, value := range values {
+		for _, mediaType := range strings.Split(value, ",") {
+			mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+			for _, prefix := range streamingContentTypes {
+				if strings.HasPrefix(mediaType, prefix) {
+					return true
+				}
+			}
 		}
 	}
+	return false
+}
 
-	// Set status code
-	w.WriteHeader(resp.StatusCode)
+// withoutTimeout returns c without its overall timeout, which would also
+// bound reading a stream; response headers still have to arrive in time.
+func withoutTimeout(c *http.Client) *http.Client {
+	untimed := *c
+	untimed.Timeout = 0
+	return &untimed
+}
 
-	// Copy response body
-	_, err = io.Copy(w, resp.Body)
-	if err != nil {
-		log.Printf("[PROXY] ERROR: Failed to copy response body: %v", err)
-		// Response already started, can't change status
-		// Log error in production
-		return
+// clearWriteDeadline lifts the server's WriteTimeout for this response.
+func clearWriteDeadline(w http.ResponseWriter) {
+	err := http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		log.Printf("[PROXY] WARNING: failed to clear write deadline: %v", err)
 	}
-
-	log.Printf("[PROXY] Request completed successfully - forwarded %s %s to backend", r.Method, r.URL.Path)
 }
 
-func (h *ProxyHandler) RegisterRoutes(r chi.Router) {
-	r.HandleFunc("/*", h.Handle)
+// copyResponse copies src to w, flushing after every write if flush is set.
+func copyResponse(w http.ResponseWriter, src io.Reader, flush bool) error {
+	rc := http.NewResponseController(w)
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := src.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+			if flush {
+				if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+					return err
+				}
+			}
+		}
+		if readErr == io.EOF {
+			return nil
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
 }
 
 func parseBackendURL(rawURL string) (*url.URL, error) {
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tenantical/router/internal/database"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// streamFor is how long the test backends keep their streams open, well
+// past the front server's WriteTimeout and the Timeout middleware.
+const (
+	streamFor    = 600 * time.Millisecond
+	writeTimeout = 200 * time.Millisecond
+	streamEvents = 6
+)
+
+// newStreamingProxy serves the proxy like cmd/server does, with short
+// timeouts, and routes the host stream.test to backend over protocol.
+func newStreamingProxy(t *testing.T, backend *httptest.Server, protocol string, tls bool) *httptest.Server {
+	t.Helper()
+	tm := newTenantManager(t)
+	u, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(u.Port())
+	d := database.Domain{Name: "stream.test", TenantID: "streams", ProjectRoute: "/", ProjectPort: &port, UpstreamProtocol: protocol}
+	if err := tm.CreateDomain(testContext(), d); err != nil {
+		t.Fatal(err)
+	}
+
+	proxy := NewProxyHandler(tm, "http://127.0.0.1", writeTimeout, 10, time.Minute, false, database.UpstreamHTTP1, false, nil)
+	front := httptest.NewUnstartedServer(Timeout(writeTimeout)(http.HandlerFunc(proxy.Handle)))
+	front.Config.WriteTimeout = writeTimeout
+	if tls {
+		front.EnableHTTP2 = true
+		front.StartTLS()
+	} else {
+		front.Start()
+	}
+	t.Cleanup(front.Close)
+	return front
+}
+
+func TestProxyStreamsServerSentEvents(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for i := 0; i < streamEvents; i++ {
+			fmt.Fprintf(w, "data: %d\n\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(streamFor / streamEvents)
+		}
+	}))
+	defer backend.Close()
+	front := newStreamingProxy(t, backend, database.UpstreamHTTP1, false)
+
+	req, _ := http.NewRequest(http.MethodGet, front.URL+"/events", nil)
+	req.Host = "stream.test"
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := front.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	events := 0
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "data: ") {
+			events++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("stream broke after %d events: %v", events, err)
+	}
+	if events != streamEvents {
+		t.Fatalf("got %d events, want %d", events, streamEvents)
+	}
+}
+
+func TestProxyStreamsGRPC(t *testing.T) {
+	// A server-streaming call over h2c, ending with its status in a trailer
+	backend := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		for i := 0; i < streamEvents; i++ {
+			w.Write([]byte{0, 0, 0, 0, 1, byte(i)})
+			w.(http.Flusher).Flush()
+			time.Sleep(streamFor / streamEvents)
+		}
+		w.Header().Set("Grpc-Status", "0")
+	}), &http2.Server{}))
+	defer backend.Close()
+	front := newStreamingProxy(t, backend, database.UpstreamH2C, true)
+
+	req, _ := http.NewRequest(http.MethodPost, front.URL+"/echo.Echo/Stream", strings.NewReader("\x00\x00\x00\x00\x00"))
+	req.Host = "stream.test"
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Te", "trailers")
+	resp, err := front.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("stream broke after %d bytes: %v", len(body), err)
+	}
+	if len(body) != streamEvents*6 {
+		t.Fatalf("got %d bytes, want %d messages of 6", len(body), streamEvents)
+	}
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Fatalf("Grpc-Status trailer = %q, want 0", got)
+	}
+}
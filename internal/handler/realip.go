@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RealIP sets the request's RemoteAddr to the client address that a
+// trusted reverse proxy reports in True-Client-IP, X-Real-IP or
+// X-Forwarded-For. Unlike chi's middleware.RealIP, the headers are only
+// believed when the connection comes from one of the trusted networks, so
+// clients can't claim another address; with PROXY protocol enabled, the
+// connection's address is the one from the PROXY header.
+func RealIP(trusted []*net.IPNet) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := forwardedClient(r, trusted); ip != "" {
+				r.RemoteAddr = ip
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedClient returns the client address reported by the proxy r
+// came from, or "" if the proxy isn't trusted or reports none.
+func forwardedClient(r *http.Request, trusted []*net.IPNet) string {
+	if !containsIP(trusted, net.ParseIP(clientIP(r))) {
+		return ""
+	}
+
+	for _, name := range []string{"True-Client-IP", "X-Real-IP"} {
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get(name))); ip != nil {
+			return ip.String()
+		}
+	}
+
+	// Each proxy appends the address it got the request from, so the
+	// client is the last one not added by a trusted proxy; anything
+	// before it may have been sent by the client itself
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			return ""
+		}
+		if i == 0 || !containsIP(trusted, ip) {
+			return ip.String()
+		}
+	}
+	return ""
+}
+
+// containsIP reports whether ip is in one of nets.
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/tenantical/router/internal/database"
+)
+
+const defaultSuspendedPage = `<!DOCTYPE html>
+<html>
+<head><meta charset="UTF-8"><title>Site suspended</title></head>
+<body style="font-family: sans-serif; text-align: center; padding: 60px;">
+    <h1>This site is currently unavailable</h1>
+    <p>The account for {{.Host}} has been suspended. Please contact the site owner.</p>
+</body>
+</html>
+`
+
+const defaultMaintenancePage = `<!DOCTYPE html>
+<html>
+<head><meta charset="UTF-8"><title>Under maintenance</title></head>
+<body style="font-family: sans-serif; text-align: center; padding: 60px;">
+    <h1>We'll be back soon</h1>
+    <p>{{if .Message}}{{.Message}}{{else}}{{.Host}} is undergoing scheduled maintenance.{{end}}</p>
+</body>
+</html>
+`
+
+// StatusPages renders the responses for tenants that are not active. Page
+// templates receive Host, TenantID, Message and RetryAfter (seconds).
+type StatusPages struct {
+	suspendedCode int
+	suspended     *template.Template
+	maintenance   *template.Template
+	retryAfter    time.Duration
+}
+
+// NewStatusPages loads the page templates. Empty file names select the
+// built-in pages.
+func NewStatusPages(suspendedCode int, suspendedFile, maintenanceFile string, retryAfter time.Duration) (*StatusPages, error) {
+	suspended, err := loadPage("suspended", suspendedFile, defaultSuspendedPage)
+	if err != nil {
+		return nil, err
+	}
+	maintenance, err := loadPage("maintenance", maintenanceFile, defaultMaintenancePage)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StatusPages{
+		suspendedCode: suspendedCode,
+		suspended:     suspended,
+		maintenance:   maintenance,
+		retryAfter:    retryAfter,
+	}, nil
+}
+
+func loadPage(name, file, fallback string) (*template.Template, error) {
+	text := fallback
+	if file != "" {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s page: %w", name, err)
+		}
+		text = string(raw)
+	}
+
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s page template: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// Intercept answers the request itself when the tenant's status or a
+// scheduled maintenance window doesn't allow it through, and reports whether
+// it did.
+func (p *StatusPages) Intercept(w http.ResponseWriter, r *http.Request, host string, info *database.TenantInfo) bool {
+	switch info.Status {
+	case database.TenantSuspended:
+		log.Printf("[PROXY] Tenant %s is suspended", info.TenantID)
+		p.render(w, p.suspended, p.suspendedCode, host, info, "", p.retryAfter)
+		return true
+
+	case database.TenantMaintenance:
+		if clientAllowed(r, info.MaintenanceAllow) {
+			log.Printf("[PROXY] Tenant %s is in maintenance, client %s allowlisted", info.TenantID, clientIP(r))
+			return false
+		}
+		log.Printf("[PROXY] Tenant %s is in maintenance", info.TenantID)
+		p.renderMaintenance(w, host, info, "", p.retryAfter)
+		return true
+	}
+
+	now := time.Now()
+	window := info.MaintenanceAt(now)
+	if window == nil {
+		return false
+	}
+	if clientAllowed(r, window.Bypass) || clientAllowed(r, info.MaintenanceAllow) {
+		log.Printf("[PROXY] Tenant %s is in a maintenance window, client %s bypasses it", info.TenantID, clientIP(r))
+		return false
+	}
+	log.Printf("[PROXY] Tenant %s is in a maintenance window until %s", info.TenantID, window.End.Format(time.RFC3339))
+	// Clients should come back once the window has ended
+	retryAfter := (window.End.Sub(now) + time.Second - 1).Truncate(time.Second)
+	p.renderMaintenance(w, host, info, window.Message, retryAfter)
+	return true
+}
+
+func (p *StatusPages) renderMaintenance(w http.ResponseWriter, host string, info *database.TenantInfo, message string, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	p.render(w, p.maintenance, http.StatusServiceUnavailable, host, info, message, retryAfter)
+}
+
+func (p *StatusPages) render(w http.ResponseWriter, tmpl *template.Template, code int, host string, info *database.TenantInfo, message string, retryAfter time.Duration) {
+	var buf bytes.Buffer
+	err := tmpl.Execute(&buf, map[string]interface{}{
+		"Host":       host,
+		"TenantID":   info.TenantID,
+		"Message":    message,
+		"RetryAfter": int(retryAfter.Seconds()),
+	})
+	if err != nil {
+		log.Printf("[PROXY] ERROR: Failed to render %s page: %v", tmpl.Name(), err)
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	w.Write(buf.Bytes())
+}
+
+// clientAllowed reports whether the request's client address is in nets.
+func clientAllowed(r *http.Request, nets []*net.IPNet) bool {
+	return containsIP(nets, net.ParseIP(clientIP(r)))
+}
+
+// clientIP returns the request's client address without port: the
+// connection's (or its PROXY protocol header's), unless the RealIP
+// middleware replaced it with the one a trusted proxy reported. Headers
+// from anyone else are ignored, so it can decide access.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr // RealIP middleware stores the bare address
+	}
+	return host
+}
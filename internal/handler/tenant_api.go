@@ -0,0 +1,284 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tenantical/router/internal/database"
+)
+
+// TenantAPIHandler serves the tenant API under /api/tenant, with which
+// customers manage their own domains using a tenant token (see
+// POST /admin/tenants/{id}/tokens). A token only ever sees its own tenant.
+type TenantAPIHandler struct {
+	tenantManager *database.TenantManager
+	admin         *AdminHandler // Shares the admin API's audit log
+	domainQuota   int
+}
+
+// NewTenantAPIHandler returns the tenant API. Tenants without a max_domains
+// of their own can add domains until they have domainQuota.
+func NewTenantAPIHandler(tm *database.TenantManager, domainQuota int) *TenantAPIHandler {
+	return &TenantAPIHandler{
+		tenantManager: tm,
+		admin:         &AdminHandler{tenantManager: tm},
+		domainQuota:   domainQuota,
+	}
+}
+
+func (h *TenantAPIHandler) RegisterRoutes(r chi.Router) {
+	r.Route("/api/tenant", func(r chi.Router) {
+		r.Use(h.requireToken, h.admin.audit)
+		r.Get("/", h.GetTenant)
+		r.Get("/domains", h.ListDomains)
+		r.Post("/domains", h.AddDomain)
+		r.Get("/domains/{domain}", h.GetDomain)
+		r.Delete("/domains/{domain}", h.DeleteDomain)
+		r.Get("/domains/{domain}/verification", h.GetDomainVerification)
+		r.Post("/domains/{domain}/verify", h.VerifyDomain)
+	})
+}
+
+// requireToken only lets requests through that carry a valid tenant token
+// of a tenant that isn't archived.
+func (h *TenantAPIHandler) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// CORS preflights carry no credentials
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := requestKey(r)
+		if key == "" {
+			unauthorized(w, "tenant token required")
+			return
+		}
+		tok, err := h.tenantManager.AuthenticateTenantToken(r.Context(), key)
+		switch {
+		case errors.Is(err, database.ErrInvalidTenantToken), errors.Is(err, database.ErrTenantTokenExpired), errors.Is(err, database.ErrTenantTokenRevoked):
+			log.Printf("[TENANT-API] Rejected token from %s for %s %s: %v", clientIP(r), r.Method, r.URL.Path, err)
+			unauthorized(w, err.Error())
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		t, err := h.tenantManager.GetTenant(tok.TenantID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if t.Status == database.TenantArchived {
+			http.Error(w, "tenant "+t.ID+" is archived", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(withIdentity(r.Context(), tok)))
+	})
+}
+
+// requestToken returns the tenant token the request was authenticated with.
+func requestToken(r *http.Request) *database.TenantToken {
+	tok, _ := identityFromRequest(r).(*database.TenantToken)
+	return tok
+}
+
+// GetTenant describes the token's tenant, its domain quota and the token.
+func (h *TenantAPIHandler) GetTenant(w http.ResponseWriter, r *http.Request) {
+	tok := requestToken(r)
+
+	t, err := h.tenantManager.GetTenant(tok.TenantID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	domains, err := h.tenantManager.ListDomains(t.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	quota := h.domainQuota
+	if t.MaxDomains != nil {
+		quota = *t.MaxDomains
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"tenant_id":   t.ID,
+		"name":        t.Name,
+		"status":      t.Status,
+		"domains":     domainNames(domains),
+		"max_domains": quota,
+		"token":       tok,
+	})
+}
+
+// ListDomains lists the tenant's domains.
+func (h *TenantAPIHandler) ListDomains(w http.ResponseWriter, r *http.Request) {
+	domains, err := h.tenantManager.ListDomains(requestToken(r).TenantID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if domains == nil {
+		domains = []database.Domain{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"domains": domains,
+		"count":   len(domains),
+	})
+}
+
+// AddDomain claims a custom domain for the tenant: 201 Created, 409
+// Conflict if the domain or a wildcard covering it is taken, or 403
+// Forbidden once the tenant has as many domains as its quota allows. The
+// domain stays pending until the tenant proves it owns it as the response's
+// verification describes. Routing settings are only accepted from tokens
+// that allow them; otherwise the domain inherits the tenant's.
+func (h *TenantAPIHandler) AddDomain(w http.ResponseWriter, r *http.Request) {
+	tok := requestToken(r)
+
+	var req domainRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.TenantID == "" {
+		req.TenantID = tok.TenantID
+	}
+	if req.TenantID != tok.TenantID {
+		forbiddenTenant(w, req.TenantID)
+		return
+	}
+
+	if msg := req.validate(); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	req.Domain = strings.TrimSuffix(strings.ToLower(req.Domain), ".")
+	if strings.ContainsAny(req.Domain, "*{") {
+		http.Error(w, "wildcard and templated domains can only be added by an administrator", http.StatusForbidden)
+		return
+	}
+	if !validHostname(req.Domain) {
+		http.Error(w, "domain must be a fully qualified host name, e.g. shop.example.com", http.StatusBadRequest)
+		return
+	}
+	if (req.routingSettings != (routingSettings{}) || len(req.RequestHeaders) > 0) && !tok.AllowRouting {
+		http.Error(w, "token "+tok.Name+" may not set routing settings", http.StatusForbidden)
+		return
+	}
+
+	_, err := h.tenantManager.AddTenantDomain(changeContext(r), req.domain(), h.domainQuota)
+	switch {
+	case errors.Is(err, database.ErrConflict):
+		http.Error(w, "domain "+req.Domain+" is already taken", http.StatusConflict)
+		return
+	case errors.Is(err, database.ErrQuotaExceeded):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case errors.Is(err, database.ErrReadOnly):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	h.admin.writeVerification(w, http.StatusCreated, req.Domain)
+}
+
+// GetDomain returns one of the tenant's domains.
+func (h *TenantAPIHandler) GetDomain(w http.ResponseWriter, r *http.Request) {
+	d, ok := h.tenantDomain(w, r)
+	if !ok {
+		return
+	}
+	h.admin.writeDomain(w, http.StatusOK, d.Name)
+}
+
+// DeleteDomain detaches one of the tenant's domains. It requires If-Match
+// with the domain's ETag: 428 without it, 412 when it is stale.
+func (h *TenantAPIHandler) DeleteDomain(w http.ResponseWriter, r *http.Request) {
+	d, ok := h.tenantDomain(w, r)
+	if !ok {
+		return
+	}
+
+	revision, ok := checkPreconditions(w, r, true, d.Revision)
+	if !ok {
+		return
+	}
+
+	err := h.tenantManager.DeleteDomain(changeContext(r), d.Name, revision)
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		http.Error(w, "domain "+d.Name+" not found", http.StatusNotFound)
+		return
+	case errors.Is(err, database.ErrRevisionMismatch):
+		preconditionFailed(w, 0)
+		return
+	case errors.Is(err, database.ErrReadOnly):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Domain deleted successfully",
+		"domain":  d.Name,
+	})
+}
+
+// tenantDomain returns the domain in the route if it belongs to the token's
+// tenant. Other tenants' domains are reported as not found, so tokens can't
+// probe which domains are taken by whom.
+func (h *TenantAPIHandler) tenantDomain(w http.ResponseWriter, r *http.Request) (*database.Domain, bool) {
+	name := chi.URLParam(r, "domain")
+
+	d, err := h.tenantManager.GetDomain(name)
+	if errors.Is(err, database.ErrNotFound) || (err == nil && d.TenantID != requestToken(r).TenantID) {
+		http.Error(w, "domain "+name+" not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return d, true
+}
+
+// validHostname reports whether name is a fully qualified DNS host name:
+// at least two labels of letters, digits and inner hyphens.
+func validHostname(name string) bool {
+	if len(name) > 253 {
+		return false
+	}
+	labels := strings.Split(name, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
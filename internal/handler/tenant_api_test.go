@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/tenantical/router/internal/database"
+)
+
+// newTenantAPI returns the tenant API on tm with domainQuota and a token
+// of tenant acme, which has the domain shop.acme.com, while tenant globex
+// has shop.globex.com.
+func newTenantAPI(t *testing.T, tm *database.TenantManager, domainQuota int) (http.Handler, string) {
+	t.Helper()
+	for _, d := range []database.Domain{{Name: "shop.acme.com", TenantID: "acme"}, {Name: "shop.globex.com", TenantID: "globex"}} {
+		if err := tm.CreateDomain(testContext(), d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	token, _, err := tm.CreateTenantToken(testContext(), "acme", "test", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := chi.NewRouter()
+	NewTenantAPIHandler(tm, domainQuota).RegisterRoutes(r)
+	return r, "Bearer " + token
+}
+
+func TestTenantAPIQuota(t *testing.T) {
+	tm := newTenantManager(t)
+	h, auth := newTenantAPI(t, tm, 2)
+
+	// shop.acme.com counts against the quota of 2
+	if w := serve(h, http.MethodPost, "/api/tenant/domains", `{"domain": "one.acme.com"}`, "Authorization", auth); w.Code != http.StatusCreated {
+		t.Fatalf("POST /api/tenant/domains within the quota: %d %s", w.Code, w.Body)
+	}
+	if w := serve(h, http.MethodPost, "/api/tenant/domains", `{"domain": "two.acme.com"}`, "Authorization", auth); w.Code != http.StatusForbidden {
+		t.Errorf("POST /api/tenant/domains beyond the quota: got %d, want 403 (%s)", w.Code, w.Body)
+	}
+	if _, err := tm.GetDomain("two.acme.com"); err == nil {
+		t.Errorf("two.acme.com was added beyond the quota")
+	}
+
+	// Deleting a domain frees its place
+	if w := serve(h, http.MethodDelete, "/api/tenant/domains/one.acme.com", "", "Authorization", auth, "If-Match", "*"); w.Code != http.StatusOK {
+		t.Fatalf("DELETE /api/tenant/domains/one.acme.com: %d %s", w.Code, w.Body)
+	}
+	if w := serve(h, http.MethodPost, "/api/tenant/domains", `{"domain": "two.acme.com"}`, "Authorization", auth); w.Code != http.StatusCreated {
+		t.Errorf("POST /api/tenant/domains after a delete: got %d, want 201 (%s)", w.Code, w.Body)
+	}
+
+	// The tenant's own max_domains takes precedence
+	tenant, err := tm.GetTenant("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	three := 3
+	tenant.MaxDomains = &three
+	if err := tm.UpdateTenant(testContext(), *tenant); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(h, http.MethodPost, "/api/tenant/domains", `{"domain": "three.acme.com"}`, "Authorization", auth); w.Code != http.StatusCreated {
+		t.Errorf("POST /api/tenant/domains within max_domains: got %d, want 201 (%s)", w.Code, w.Body)
+	}
+	if w := serve(h, http.MethodPost, "/api/tenant/domains", `{"domain": "four.acme.com"}`, "Authorization", auth); w.Code != http.StatusForbidden {
+		t.Errorf("POST /api/tenant/domains beyond max_domains: got %d, want 403 (%s)", w.Code, w.Body)
+	}
+}
+
+func TestTenantAPIAccess(t *testing.T) {
+	tm := newTenantManager(t)
+	h, auth := newTenantAPI(t, tm, 10)
+
+	tests := []struct {
+		name         string
+		auth         string
+		method, path string
+		body         string
+		ifMatch      string
+		want         int
+	}{
+		{"without a token", "", http.MethodGet, "/api/tenant/domains", "", "", http.StatusUnauthorized},
+		{"with an unknown token", "Bearer ttk_unknown", http.MethodGet, "/api/tenant/domains", "", "", http.StatusUnauthorized},
+		{"reading its domain", auth, http.MethodGet, "/api/tenant/domains/shop.acme.com", "", "", http.StatusOK},
+		{"reading another tenant's domain", auth, http.MethodGet, "/api/tenant/domains/shop.globex.com", "", "", http.StatusNotFound},
+		{"deleting another tenant's domain", auth, http.MethodDelete, "/api/tenant/domains/shop.globex.com", "", "*", http.StatusNotFound},
+		{"adding a domain to another tenant", auth, http.MethodPost, "/api/tenant/domains", `{"domain": "new.globex.com", "tenant_id": "globex"}`, "", http.StatusForbidden},
+		{"adding a wildcard", auth, http.MethodPost, "/api/tenant/domains", `{"domain": "*.acme.com"}`, "", http.StatusForbidden},
+		{"adding a taken domain", auth, http.MethodPost, "/api/tenant/domains", `{"domain": "shop.globex.com"}`, "", http.StatusConflict},
+		{"setting routing without permission", auth, http.MethodPost, "/api/tenant/domains", `{"domain": "new.acme.com", "project_port": 8080}`, "", http.StatusForbidden},
+		{"deleting without If-Match", auth, http.MethodDelete, "/api/tenant/domains/shop.acme.com", "", "", http.StatusPreconditionRequired},
+		{"deleting with a stale If-Match", auth, http.MethodDelete, "/api/tenant/domains/shop.acme.com", "", `"2"`, http.StatusPreconditionFailed},
+		{"deleting with the current If-Match", auth, http.MethodDelete, "/api/tenant/domains/shop.acme.com", "", `"1"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		var header []string
+		if tt.auth != "" {
+			header = append(header, "Authorization", tt.auth)
+		}
+		if tt.ifMatch != "" {
+			header = append(header, "If-Match", tt.ifMatch)
+		}
+		if w := serve(h, tt.method, tt.path, tt.body, header...); w.Code != tt.want {
+			t.Errorf("%s: %s %s got %d, want %d (%s)", tt.name, tt.method, tt.path, w.Code, tt.want, w.Body)
+		}
+	}
+
+	if _, err := tm.GetDomain("shop.globex.com"); err != nil {
+		t.Errorf("shop.globex.com after the tenant API requests: %v", err)
+	}
+}
@@ -7,6 +7,7 @@ type Tenant struct {
 	TenantID    string    `json:"tenant_id"`
 	ProjectRoute string   `json:"project_route"` // مثال: /projects/backend
 	ProjectPort  *int     `json:"project_port,omitempty"` // Optional port for project
+	UpstreamProtocol string `json:"upstream_protocol,omitempty"` // Optional upstream protocol: http1, h2, h2c
 	CreatedAt   time.Time `json:"created_at"`
 }
 
@@ -22,5 +23,6 @@ type TenantInfo struct {
 	ProjectRoute string
 	ProjectPort  *int    // Optional port, nil means use default from config
 	BackendDomain *string // Optional backend domain (e.g., localhost, admin.local), nil means use default from BACKEND_URL
+	UpstreamProtocol string // Optional upstream protocol (http1, h2, h2c)
 }
 
@@ -0,0 +1,178 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// jwtHeader is the JOSE header of an ID token.
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+// parseJWT splits a compact JWS into its header, claims, signed part and
+// signature. The signature isn't checked.
+func parseJWT(raw string) (header jwtHeader, claims Claims, signed, sig []byte, err error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return header, nil, nil, nil, errors.New("id token is not a JWS")
+	}
+
+	h, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || json.Unmarshal(h, &header) != nil {
+		return header, nil, nil, nil, errors.New("id token has an invalid header")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || json.Unmarshal(payload, &claims) != nil {
+		return header, nil, nil, nil, errors.New("id token has invalid claims")
+	}
+	sig, err = base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return header, nil, nil, nil, errors.New("id token has an invalid signature")
+	}
+	return header, claims, []byte(parts[0] + "." + parts[1]), sig, nil
+}
+
+// verifySignature checks sig over signed with key. Only the asymmetric
+// algorithms providers sign ID tokens with are accepted; in particular
+// never "none" or HMAC, whose key would be our client secret.
+func verifySignature(alg string, key interface{}, signed, sig []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256", "PS256":
+		hash = crypto.SHA256
+	case "RS384", "ES384", "PS384":
+		hash = crypto.SHA384
+	case "RS512", "ES512", "PS512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("id token signed with unsupported algorithm %q", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		var err error
+		switch alg[0] {
+		case 'R':
+			err = rsa.VerifyPKCS1v15(k, hash, digest, sig)
+		case 'P':
+			err = rsa.VerifyPSS(k, hash, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		default:
+			err = fmt.Errorf("algorithm %s doesn't match the RSA key", alg)
+		}
+		if err != nil {
+			return errors.New("id token signature is invalid")
+		}
+		return nil
+
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if alg[0] != 'E' || len(sig) != 2*size {
+			return errors.New("id token signature is invalid")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("id token signature is invalid")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported key type %T", key)
+}
+
+// jsonWebKeySet is a JWK Set (RFC 7517) as served at the jwks_uri.
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+// keySet maps key IDs to public keys.
+type keySet struct {
+	keys map[string]interface{}
+}
+
+// find returns the key with id kid; tokens without a kid may use the only
+// key of a set with one key.
+func (s *keySet) find(kid string) (interface{}, bool) {
+	if key, ok := s.keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// parse decodes the signing keys of the set, skipping encryption keys and
+// key types we don't use.
+func (jwks jsonWebKeySet) parse() (*keySet, error) {
+	set := &keySet{keys: make(map[string]interface{})}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		switch jwk.KeyType {
+		case "RSA":
+			n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: invalid modulus", jwk.KeyID)
+			}
+			e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+			if err != nil || len(e) > 4 {
+				return nil, fmt.Errorf("key %q: invalid exponent", jwk.KeyID)
+			}
+			set.keys[jwk.KeyID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+
+		case "EC":
+			var curve elliptic.Curve
+			switch jwk.Curve {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+			y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+			if errX != nil || errY != nil {
+				return nil, fmt.Errorf("key %q: invalid coordinates", jwk.KeyID)
+			}
+			key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			if !curve.IsOnCurve(key.X, key.Y) {
+				return nil, fmt.Errorf("key %q: point is not on the curve", jwk.KeyID)
+			}
+			set.keys[jwk.KeyID] = key
+		}
+	}
+	if len(set.keys) == 0 {
+		return nil, errors.New("no signing keys")
+	}
+	return set, nil
+}
@@ -0,0 +1,344 @@
+// Package oidc signs users in with an OpenID Connect provider using the
+// authorization code flow with PKCE, and verifies the ID tokens it returns.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockSkew is how far the provider's clock may be off from ours.
+const clockSkew = time.Minute
+
+// Config identifies the provider and this client to it.
+type Config struct {
+	Issuer       string   // Issuer URL, e.g. https://accounts.example.com
+	ClientID     string   // Client registered with the provider
+	ClientSecret string   // Optional; public clients rely on PKCE alone
+	RedirectURL  string   // Where the provider sends the user back with the code
+	Scopes       []string // Requested scopes; openid is always included
+}
+
+// Provider talks to one OpenID Connect provider. Its metadata is discovered
+// on first use, so the router starts even while the provider is down.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu       sync.Mutex
+	meta     *metadata
+	keys     *keySet
+	keysTime time.Time
+}
+
+// metadata is the part of the provider's discovery document we use.
+type metadata struct {
+	Issuer             string `json:"issuer"`
+	AuthorizationURL   string `json:"authorization_endpoint"`
+	TokenURL           string `json:"token_endpoint"`
+	JWKSURL            string `json:"jwks_uri"`
+	EndSessionEndpoint string `json:"end_session_endpoint"`
+}
+
+// NewProvider returns a provider for cfg.
+func NewProvider(cfg Config) *Provider {
+	return &Provider{cfg: cfg, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// discover returns the provider's metadata, fetching it once.
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	var meta metadata
+	wellKnown := strings.TrimSuffix(p.cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, &meta); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if meta.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("oidc discovery: provider reports issuer %q, want %q", meta.Issuer, p.cfg.Issuer)
+	}
+	if meta.AuthorizationURL == "" || meta.TokenURL == "" || meta.JWKSURL == "" {
+		return nil, fmt.Errorf("oidc discovery: authorization, token or jwks endpoint missing")
+	}
+	p.meta = &meta
+	return p.meta, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// AuthCodeURL returns the provider URL to send the user to. state and
+// nonce tie the response to this request; verifier is the PKCE code
+// verifier, of which only the S256 challenge is sent.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	scopes := []string{"openid"}
+	for _, s := range p.cfg.Scopes {
+		if s != "openid" {
+			scopes = append(scopes, s)
+		}
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {Challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(meta.AuthorizationURL, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationURL + sep + q.Encode(), nil
+}
+
+// Exchange redeems an authorization code for the raw ID token.
+func (p *Provider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("oidc token exchange: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return "", fmt.Errorf("oidc token exchange: %s: %w", resp.Status, err)
+	}
+	if token.Error != "" {
+		return "", fmt.Errorf("oidc token exchange: %s: %s", token.Error, token.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("oidc token exchange: %s", resp.Status)
+	}
+	if token.IDToken == "" {
+		return "", errors.New("oidc token exchange: no id_token in response")
+	}
+	return token.IDToken, nil
+}
+
+// LogoutURL returns the provider URL that ends the user's session there and
+// returns them to postLogoutRedirect, or "" if the provider has none.
+func (p *Provider) LogoutURL(ctx context.Context, postLogoutRedirect string) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil || meta.EndSessionEndpoint == "" {
+		return "", err
+	}
+
+	q := url.Values{"client_id": {p.cfg.ClientID}}
+	if postLogoutRedirect != "" {
+		q.Set("post_logout_redirect_uri", postLogoutRedirect)
+	}
+	sep := "?"
+	if strings.Contains(meta.EndSessionEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.EndSessionEndpoint + sep + q.Encode(), nil
+}
+
+// Verify checks the ID token's signature against the provider's keys and
+// its issuer, audience, expiry and nonce, and returns its claims.
+func (p *Provider) Verify(ctx context.Context, rawIDToken, nonce string) (Claims, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	header, claims, signed, sig, err := parseJWT(rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	key, err := p.key(ctx, meta, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Algorithm, key, signed, sig); err != nil {
+		return nil, err
+	}
+
+	if iss := claims.String("iss"); iss != meta.Issuer {
+		return nil, fmt.Errorf("id token issued by %q, want %q", iss, meta.Issuer)
+	}
+	aud := claims.Strings("aud")
+	if !contains(aud, p.cfg.ClientID) {
+		return nil, fmt.Errorf("id token not issued for client %s", p.cfg.ClientID)
+	}
+	if azp := claims.String("azp"); len(aud) > 1 && azp != p.cfg.ClientID {
+		return nil, fmt.Errorf("id token authorized for %q, want %s", azp, p.cfg.ClientID)
+	}
+	now := time.Now()
+	exp, ok := claims.Time("exp")
+	if !ok || !now.Before(exp.Add(clockSkew)) {
+		return nil, errors.New("id token expired")
+	}
+	if iat, ok := claims.Time("iat"); ok && iat.After(now.Add(clockSkew)) {
+		return nil, errors.New("id token issued in the future")
+	}
+	if claims.String("nonce") != nonce {
+		return nil, errors.New("id token nonce mismatch")
+	}
+	if claims.String("sub") == "" {
+		return nil, errors.New("id token has no subject")
+	}
+	return claims, nil
+}
+
+// key returns the provider's signing key with id kid, refetching the key
+// set when kid is unknown (the provider may have rotated its keys), at
+// most once a minute.
+func (p *Provider) key(ctx context.Context, meta *metadata, kid string) (interface{}, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.keys != nil {
+		if key, ok := p.keys.find(kid); ok {
+			return key, nil
+		}
+		if time.Since(p.keysTime) < time.Minute {
+			return nil, fmt.Errorf("id token signed with unknown key %q", kid)
+		}
+	}
+
+	var jwks jsonWebKeySet
+	if err := p.getJSON(ctx, meta.JWKSURL, &jwks); err != nil {
+		return nil, fmt.Errorf("oidc keys: %w", err)
+	}
+	keys, err := jwks.parse()
+	if err != nil {
+		return nil, fmt.Errorf("oidc keys: %w", err)
+	}
+	p.keys, p.keysTime = keys, time.Now()
+
+	if key, ok := p.keys.find(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("id token signed with unknown key %q", kid)
+}
+
+// Claims are the claims of a verified ID token.
+type Claims map[string]interface{}
+
+// String returns a string claim, or "".
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Strings returns a claim holding a list of strings, or a single string as
+// a list of one.
+func (c Claims) Strings(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// Time returns a NumericDate claim such as exp.
+func (c Claims) Time(name string) (time.Time, bool) {
+	n, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(n), 0), true
+}
+
+// Bool returns a boolean claim and whether it is present.
+func (c Claims) Bool(name string) (value, ok bool) {
+	value, ok = c[name].(bool)
+	return value, ok
+}
+
+// RandomString returns n random bytes encoded as base64url, for states,
+// nonces and code verifiers.
+func RandomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Challenge returns the S256 PKCE code challenge of verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func contains(values []string, want string) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
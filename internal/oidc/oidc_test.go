@@ -0,0 +1,265 @@
+package oidc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tenantical/router/internal/oidc/oidctest"
+)
+
+const (
+	testClientID = "router"
+	testSecret   = "client secret"
+)
+
+var alice = oidctest.User{Email: "alice@example.com", Name: "Alice", Groups: []string{"admins"}}
+
+// newTestProvider starts an oidctest provider that signs in alice, and
+// returns it with a Provider using it.
+func newTestProvider(t *testing.T) (*oidctest.Provider, *Provider) {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(nil)
+	mock, err := oidctest.New("http://"+srv.Listener.Addr().String(), testClientID, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ClientSecret = testSecret
+	srv.Config.Handler = mock
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	return mock, NewProvider(Config{
+		Issuer:       mock.Issuer,
+		ClientID:     testClientID,
+		ClientSecret: testSecret,
+		RedirectURL:  "http://router.test/admin/oidc/callback",
+		Scopes:       []string{"email", "groups"},
+	})
+}
+
+// signWith returns a JWT of claims with header alg and kid and the
+// signature sign returns for the signed part.
+func signWith(t *testing.T, alg, kid string, claims map[string]interface{}, sign func(signed string) []byte) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT", "kid": kid})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign(signed))
+}
+
+// keyID returns the key ID the provider currently signs with.
+func keyID(t *testing.T, mock *oidctest.Provider) string {
+	t.Helper()
+	token, err := mock.IDToken(alice, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, _, _, _, err := parseJWT(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header.KeyID
+}
+
+func TestLoginRoundTrip(t *testing.T) {
+	mock, p := newTestProvider(t)
+	ctx := context.Background()
+	state, nonce, verifier := "the-state", "the-nonce", "the-verifier-of-at-least-43-characters-long"
+
+	authURL, err := p.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authURL, mock.Issuer+"/authorize?") {
+		t.Fatalf("AuthCodeURL: got %s, want the provider's authorization endpoint", authURL)
+	}
+	u, _ := http.NewRequest(http.MethodGet, authURL, nil)
+	q := u.URL.Query()
+	for name, want := range map[string]string{
+		"state":                 state,
+		"nonce":                 nonce,
+		"code_challenge":        Challenge(verifier),
+		"code_challenge_method": "S256",
+		"scope":                 "openid email groups",
+		"client_id":             testClientID,
+	} {
+		if got := q.Get(name); got != want {
+			t.Errorf("AuthCodeURL %s: got %q, want %q", name, got, want)
+		}
+	}
+	if q.Has("code_verifier") {
+		t.Errorf("AuthCodeURL sends the code verifier")
+	}
+
+	// Sign alice in at the provider, which redirects back with a code
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	authorize := func() (code string) {
+		t.Helper()
+		resp, err := client.Get(authURL + "&login_hint=" + alice.Email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		back, err := resp.Location()
+		if err != nil {
+			t.Fatalf("authorize: %s without a redirect", resp.Status)
+		}
+		if got := back.Query().Get("state"); got != state {
+			t.Fatalf("authorize: got state %q, want %q", got, state)
+		}
+		return back.Query().Get("code")
+	}
+
+	// The code can't be redeemed without the matching verifier, nor twice
+	if _, err := p.Exchange(ctx, authorize(), "another-verifier"); err == nil {
+		t.Errorf("Exchange with the wrong code verifier succeeded")
+	}
+	code := authorize()
+	raw, err := p.Exchange(ctx, code, verifier)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if _, err := p.Exchange(ctx, code, verifier); err == nil {
+		t.Errorf("Exchange of a redeemed code succeeded")
+	}
+
+	claims, err := p.Verify(ctx, raw, nonce)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if claims.String("sub") != alice.Email || claims.String("nonce") != nonce {
+		t.Errorf("Verify: got claims %v, want alice's with the nonce", claims)
+	}
+	if _, err := p.Verify(ctx, raw, "another-nonce"); err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Errorf("Verify with another nonce: got %v, want a nonce mismatch", err)
+	}
+}
+
+func TestVerifyClaims(t *testing.T) {
+	mock, p := newTestProvider(t)
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		change func(claims map[string]interface{})
+		want   string // in the error; "" if the token is valid
+	}{
+		{"valid", func(map[string]interface{}) {}, ""},
+		{"another issuer", func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, "issued by"},
+		{"another audience", func(c map[string]interface{}) { c["aud"] = "another-client" }, "not issued for client"},
+		{"several audiences without azp", func(c map[string]interface{}) { c["aud"] = []string{testClientID, "another-client"} }, "authorized for"},
+		{"several audiences for another azp", func(c map[string]interface{}) {
+			c["aud"] = []string{testClientID, "another-client"}
+			c["azp"] = "another-client"
+		}, "authorized for"},
+		{"several audiences for us", func(c map[string]interface{}) {
+			c["aud"] = []string{testClientID, "another-client"}
+			c["azp"] = testClientID
+		}, ""},
+		{"expired", func(c map[string]interface{}) { c["exp"] = now.Add(-2 * time.Minute).Unix() }, "expired"},
+		{"expired within the clock skew", func(c map[string]interface{}) { c["exp"] = now.Add(-30 * time.Second).Unix() }, ""},
+		{"without exp", func(c map[string]interface{}) { delete(c, "exp") }, "expired"},
+		{"issued in the future", func(c map[string]interface{}) { c["iat"] = now.Add(5 * time.Minute).Unix() }, "future"},
+		{"without a nonce", func(c map[string]interface{}) { delete(c, "nonce") }, "nonce mismatch"},
+		{"another nonce", func(c map[string]interface{}) { c["nonce"] = "replayed" }, "nonce mismatch"},
+		{"without a subject", func(c map[string]interface{}) { delete(c, "sub") }, "no subject"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := mock.Claims(alice, "the-nonce")
+			tt.change(claims)
+			raw, err := mock.Sign(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = p.Verify(context.Background(), raw, "the-nonce")
+			if tt.want == "" && err != nil {
+				t.Errorf("Verify: %v", err)
+			}
+			if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+				t.Errorf("Verify: got %v, want an error about %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyAlgorithms(t *testing.T) {
+	mock, p := newTestProvider(t)
+	kid := keyID(t, mock)
+	claims := mock.Claims(alice, "the-nonce")
+
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{"alg none", signWith(t, "none", kid, claims, func(string) []byte { return nil })},
+		// HS256 keyed with the client secret, which the verifier must not
+		// mistake for the provider's key
+		{"alg HS256", signWith(t, "HS256", kid, claims, func(signed string) []byte {
+			mac := hmac.New(sha256.New, []byte(testSecret))
+			mac.Write([]byte(signed))
+			return mac.Sum(nil)
+		})},
+		{"alg ES256 with the RSA key", signWith(t, "ES256", kid, claims, func(string) []byte { return make([]byte, 64) })},
+		{"forged RS256 signature", signWith(t, "RS256", kid, claims, func(string) []byte { return make([]byte, 256) })},
+	}
+	for _, tt := range tests {
+		if _, err := p.Verify(context.Background(), tt.raw, "the-nonce"); err == nil {
+			t.Errorf("Verify of a token with %s succeeded", tt.name)
+		}
+	}
+}
+
+func TestVerifyKeyRotation(t *testing.T) {
+	mock, p := newTestProvider(t)
+	ctx := context.Background()
+
+	raw, err := mock.IDToken(alice, "the-nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Verify(ctx, raw, "the-nonce"); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	// A key ID the provider never had is refused
+	unknown := signWith(t, "RS256", "unknown", mock.Claims(alice, "the-nonce"), func(string) []byte { return make([]byte, 256) })
+	if _, err := p.Verify(ctx, unknown, "the-nonce"); err == nil || !strings.Contains(err.Error(), "unknown key") {
+		t.Errorf("Verify with an unknown key ID: got %v, want an unknown key", err)
+	}
+
+	// After a rotation the new key is unknown until the key set is fetched
+	// again, which happens at most once a minute
+	if err := mock.RotateKey(); err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := mock.IDToken(alice, "the-nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Verify(ctx, rotated, "the-nonce"); err == nil || !strings.Contains(err.Error(), "unknown key") {
+		t.Errorf("Verify right after a refetch: got %v, want an unknown key", err)
+	}
+	p.mu.Lock()
+	p.keysTime = time.Now().Add(-2 * time.Minute)
+	p.mu.Unlock()
+	if _, err := p.Verify(ctx, rotated, "the-nonce"); err != nil {
+		t.Errorf("Verify with the rotated key: %v", err)
+	}
+
+	// The old key is gone from the refetched set
+	if _, err := p.Verify(ctx, raw, "the-nonce"); err == nil {
+		t.Errorf("Verify with the retired key succeeded")
+	}
+}
@@ -0,0 +1,341 @@
+// Package oidctest is a minimal OpenID Connect provider for trying out and
+// checking single sign-on locally. It signs in whichever configured user is
+// picked, without passwords, and must never be exposed publicly.
+package oidctest
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// codeLifetime is how long an authorization code can be redeemed.
+const codeLifetime = time.Minute
+
+// User is a user the provider can sign in.
+type User struct {
+	Subject string   // sub claim; defaults to Email
+	Email   string   // email and preferred_username claims
+	Name    string   // name claim
+	Groups  []string // groups claim
+	Tenants []string // tenants claim, if any
+}
+
+// Provider serves the discovery document, authorization, token, JWKS and
+// end session endpoints of an OpenID Connect provider at Issuer.
+type Provider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // Optional; checked when set
+	Users        []User
+
+	mu    sync.Mutex
+	key   *rsa.PrivateKey
+	keyID string
+	codes map[string]authorization
+}
+
+// authorization is an issued code awaiting redemption.
+type authorization struct {
+	user        User
+	redirectURI string
+	nonce       string
+	challenge   string
+	expires     time.Time
+}
+
+// New returns a provider for issuer, e.g. http://localhost:9999, that
+// accepts clientID and signs in users.
+func New(issuer, clientID string, users ...User) (*Provider, error) {
+	p := &Provider{
+		Issuer:   strings.TrimSuffix(issuer, "/"),
+		ClientID: clientID,
+		Users:    users,
+		codes:    make(map[string]authorization),
+	}
+	if err := p.RotateKey(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// RotateKey replaces the signing key with a new one under a new key ID.
+// The JWKS endpoint only serves the current key.
+func (p *Provider) RotateKey() error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.key, p.keyID = key, "oidctest-"+randomString()[:8]
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"issuer":                                p.Issuer,
+			"authorization_endpoint":                p.Issuer + "/authorize",
+			"token_endpoint":                        p.Issuer + "/token",
+			"jwks_uri":                              p.Issuer + "/jwks",
+			"end_session_endpoint":                  p.Issuer + "/logout",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+			"code_challenge_methods_supported":      []string{"S256"},
+			"scopes_supported":                      []string{"openid", "profile", "email", "groups"},
+		})
+	case "/authorize":
+		p.authorize(w, r)
+	case "/token":
+		p.token(w, r)
+	case "/jwks":
+		p.mu.Lock()
+		key, keyID := p.key, p.keyID
+		p.mu.Unlock()
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"use": "sig",
+				"alg": "RS256",
+				"kid": keyID,
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	case "/logout":
+		if redirect := r.URL.Query().Get("post_logout_redirect_uri"); redirect != "" {
+			http.Redirect(w, r, redirect, http.StatusFound)
+			return
+		}
+		fmt.Fprintln(w, "Signed out of the mock provider")
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+var chooserPage = template.Must(template.New("chooser").Parse(`<!DOCTYPE html>
+<html><head><meta charset="UTF-8"><title>Mock OpenID provider</title></head>
+<body style="font-family: sans-serif; max-width: 480px; margin: 60px auto;">
+<h2>Mock OpenID provider</h2>
+<p>Sign in as:</p>
+<form method="get">
+{{range $k, $v := .Query}}{{if ne $k "login_hint"}}<input type="hidden" name="{{$k}}" value="{{index $v 0}}">{{end}}{{end}}
+{{range .Users}}<p><button name="login_hint" value="{{.Email}}">{{.Email}}{{if .Groups}} ({{range $i, $g := .Groups}}{{if $i}}, {{end}}{{$g}}{{end}}){{end}}</button></p>{{end}}
+</form>
+</body></html>`))
+
+// authorize shows a page to pick a user, or with login_hint signs the user
+// in right away and redirects back with a code.
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirectURI := q.Get("redirect_uri")
+	if q.Get("client_id") != p.ClientID || redirectURI == "" {
+		http.Error(w, "unknown client_id or missing redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	back, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	fail := func(code, description string) {
+		v := back.Query()
+		v.Set("error", code)
+		v.Set("error_description", description)
+		v.Set("state", q.Get("state"))
+		back.RawQuery = v.Encode()
+		http.Redirect(w, r, back.String(), http.StatusFound)
+	}
+	if q.Get("response_type") != "code" {
+		fail("unsupported_response_type", "only the code flow is supported")
+		return
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		fail("invalid_request", "PKCE with S256 is required")
+		return
+	}
+
+	hint := q.Get("login_hint")
+	if hint == "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		chooserPage.Execute(w, map[string]interface{}{"Query": q, "Users": p.Users})
+		return
+	}
+	user, ok := p.user(hint)
+	if !ok {
+		fail("access_denied", "unknown user "+hint)
+		return
+	}
+
+	code := randomString()
+	p.mu.Lock()
+	p.codes[code] = authorization{
+		user:        user,
+		redirectURI: redirectURI,
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		expires:     time.Now().Add(codeLifetime),
+	}
+	p.mu.Unlock()
+
+	v := back.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	back.RawQuery = v.Encode()
+	http.Redirect(w, r, back.String(), http.StatusFound)
+}
+
+func (p *Provider) user(email string) (User, bool) {
+	for _, u := range p.Users {
+		if strings.EqualFold(u.Email, email) {
+			return u, true
+		}
+	}
+	return User{}, false
+}
+
+// token redeems a code, once, for an ID token, checking the client, the
+// redirect URI and the PKCE verifier.
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	tokenError := func(code, description string) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": code, "error_description": description})
+	}
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		tokenError("invalid_request", "POST a form")
+		return
+	}
+
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.ClientID || (p.ClientSecret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(p.ClientSecret)) != 1) {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError("unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+
+	p.mu.Lock()
+	auth, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mu.Unlock()
+
+	switch {
+	case !ok || time.Now().After(auth.expires):
+		tokenError("invalid_grant", "unknown or expired code")
+		return
+	case auth.redirectURI != r.PostForm.Get("redirect_uri"):
+		tokenError("invalid_grant", "redirect_uri doesn't match the authorization request")
+		return
+	case pkceChallenge(r.PostForm.Get("code_verifier")) != auth.challenge:
+		tokenError("invalid_grant", "code_verifier doesn't match the code_challenge")
+		return
+	}
+
+	idToken, err := p.IDToken(auth.user, auth.nonce)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error", "error_description": err.Error()})
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+// IDToken returns an RS256-signed ID token for user with nonce.
+func (p *Provider) IDToken(user User, nonce string) (string, error) {
+	return p.Sign(p.Claims(user, nonce))
+}
+
+// Claims returns the claims of an ID token for user with nonce, valid for
+// five minutes.
+func (p *Provider) Claims(user User, nonce string) map[string]interface{} {
+	now := time.Now()
+	subject := user.Subject
+	if subject == "" {
+		subject = user.Email
+	}
+	claims := map[string]interface{}{
+		"iss":                p.Issuer,
+		"sub":                subject,
+		"aud":                p.ClientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"email":              user.Email,
+		"email_verified":     true,
+		"preferred_username": user.Email,
+		"name":               user.Name,
+		"groups":             user.Groups,
+	}
+	if nonce != "" {
+		claims["nonce"] = nonce
+	}
+	if user.Tenants != nil {
+		claims["tenants"] = user.Tenants
+	}
+	return claims
+}
+
+// Sign returns a JWT of claims signed with RS256 by the current key, so
+// tests can make tokens the provider wouldn't issue.
+func (p *Provider) Sign(claims map[string]interface{}) (string, error) {
+	p.mu.Lock()
+	key, keyID := p.key, p.keyID
+	p.mu.Unlock()
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
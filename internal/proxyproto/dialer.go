@@ -0,0 +1,69 @@
+package proxyproto
+
+import (
+	"context"
+	"net"
+	"strconv"
+)
+
+type headerKey struct{}
+
+type outboundHeader struct {
+	version string
+	src     *net.TCPAddr
+	dst     *net.TCPAddr
+}
+
+// WithHeader returns a context instructing DialContext to send a PROXY
+// protocol header of the given version describing a src -> dst connection.
+func WithHeader(ctx context.Context, version string, src, dst *net.TCPAddr) context.Context {
+	return context.WithValue(ctx, headerKey{}, outboundHeader{version: version, src: src, dst: dst})
+}
+
+// DialContext returns a dial function for http.Transport that writes the
+// PROXY protocol header carried by the context (see WithHeader) as the first
+// bytes of every new connection. The transport must not reuse connections
+// across requests (DisableKeepAlives), since a header describes one client.
+func DialContext(d *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+
+		h, ok := ctx.Value(headerKey{}).(outboundHeader)
+		if !ok {
+			return conn, nil
+		}
+
+		header, err := Format(h.version, h.src, h.dst)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		if _, err := conn.Write(header); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return conn, nil
+	}
+}
+
+// TCPAddr converts an address of the form "ip:port" or a bare "ip" (as left
+// by the RealIP middleware) to a *net.TCPAddr, or returns nil.
+func TCPAddr(addr string) *net.TCPAddr {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		host, portStr = addr, "0"
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+
+	port, _ := strconv.Atoi(portStr)
+	return &net.TCPAddr{IP: ip, Port: port}
+}
@@ -0,0 +1,228 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Protocol versions
+const (
+	V1 = "v1"
+	V2 = "v2"
+)
+
+// v2Signature is the fixed 12-byte prefix of every PROXY protocol v2 header.
+var v2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+// v1MaxLength is the maximum length of a v1 header line, including CRLF.
+const v1MaxLength = 107
+
+var ErrInvalidHeader = errors.New("invalid PROXY protocol header")
+
+// Header is a decoded PROXY protocol header. Source and Destination are nil
+// for LOCAL (v2) and UNKNOWN (v1) headers, meaning the connection's own
+// addresses should be used.
+type Header struct {
+	Version     string
+	Source      *net.TCPAddr
+	Destination *net.TCPAddr
+}
+
+// ValidVersion reports whether v is an accepted version for outbound headers.
+// An empty value is valid and means "don't send a header".
+func ValidVersion(v string) bool {
+	switch v {
+	case "", V1, V2:
+		return true
+	}
+	return false
+}
+
+// ReadHeader reads a PROXY protocol header from r if one is present.
+// It returns (nil, nil) without consuming anything when the stream does not
+// start with a PROXY protocol signature.
+func ReadHeader(r *bufio.Reader) (*Header, error) {
+	// Both signatures are at least 6 bytes long ("PROXY " / first half of v2)
+	prefix, err := r.Peek(6)
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	switch {
+	case string(prefix) == "PROXY ":
+		return readV1(r)
+	case bytes.Equal(prefix, v2Signature[:6]):
+		return readV2(r)
+	}
+
+	return nil, nil
+}
+
+func readV1(r *bufio.Reader) (*Header, error) {
+	var line []byte
+	for len(line) < v1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("%w: v1 header not terminated by CRLF", ErrInvalidHeader)
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, ErrInvalidHeader
+	}
+
+	if fields[1] == "UNKNOWN" {
+		return &Header{Version: V1}, nil
+	}
+
+	if (fields[1] != "TCP4" && fields[1] != "TCP6") || len(fields) != 6 {
+		return nil, fmt.Errorf("%w: unsupported v1 protocol %q", ErrInvalidHeader, fields[1])
+	}
+
+	src, err := parseV1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, err
+	}
+	dst, err := parseV1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Header{Version: V1, Source: src, Destination: dst}, nil
+}
+
+func parseV1Addr(ip, port string) (*net.TCPAddr, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return nil, fmt.Errorf("%w: bad address %q", ErrInvalidHeader, ip)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p < 0 || p > 65535 {
+		return nil, fmt.Errorf("%w: bad port %q", ErrInvalidHeader, port)
+	}
+	return &net.TCPAddr{IP: addr, Port: p}, nil
+}
+
+func readV2(r *bufio.Reader) (*Header, error) {
+	fixed := make([]byte, 16)
+	if _, err := io.ReadFull(r, fixed); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(fixed[:12], v2Signature) {
+		return nil, fmt.Errorf("%w: bad v2 signature", ErrInvalidHeader)
+	}
+
+	verCmd := fixed[12]
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("%w: unsupported v2 version %d", ErrInvalidHeader, verCmd>>4)
+	}
+
+	length := binary.BigEndian.Uint16(fixed[14:16])
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	header := &Header{Version: V2}
+
+	switch verCmd & 0x0F {
+	case 0x0: // LOCAL: health checks from the balancer itself
+		return header, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("%w: unsupported v2 command %d", ErrInvalidHeader, verCmd&0x0F)
+	}
+
+	family := fixed[13]
+	switch family {
+	case 0x11: // TCP over IPv4
+		if len(payload) < 12 {
+			return nil, fmt.Errorf("%w: short v2 IPv4 payload", ErrInvalidHeader)
+		}
+		header.Source = &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}
+		header.Destination = &net.TCPAddr{IP: net.IP(payload[4:8]), Port: int(binary.BigEndian.Uint16(payload[10:12]))}
+	case 0x21: // TCP over IPv6
+		if len(payload) < 36 {
+			return nil, fmt.Errorf("%w: short v2 IPv6 payload", ErrInvalidHeader)
+		}
+		header.Source = &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}
+		header.Destination = &net.TCPAddr{IP: net.IP(payload[16:32]), Port: int(binary.BigEndian.Uint16(payload[34:36]))}
+	default:
+		// UNSPEC, UDP or UNIX: addresses are not usable for HTTP, keep the connection's own
+	}
+
+	return header, nil
+}
+
+// Format encodes a PROXY protocol header of the given version for a
+// connection from src to dst. If either address is missing, an UNKNOWN (v1)
+// or LOCAL (v2) header is produced.
+func Format(version string, src, dst *net.TCPAddr) ([]byte, error) {
+	switch version {
+	case V1:
+		return formatV1(src, dst), nil
+	case V2:
+		return formatV2(src, dst), nil
+	}
+	return nil, fmt.Errorf("unsupported PROXY protocol version %q", version)
+}
+
+func formatV1(src, dst *net.TCPAddr) []byte {
+	if src == nil || dst == nil {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+
+	proto := "TCP4"
+	srcIP, dstIP := src.IP.To4(), dst.IP.To4()
+	if srcIP == nil || dstIP == nil {
+		proto = "TCP6"
+		srcIP, dstIP = src.IP.To16(), dst.IP.To16()
+	}
+
+	return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", proto, srcIP, dstIP, src.Port, dst.Port))
+}
+
+func formatV2(src, dst *net.TCPAddr) []byte {
+	buf := bytes.NewBuffer(make([]byte, 0, 16+36))
+	buf.Write(v2Signature)
+
+	if src == nil || dst == nil {
+		buf.Write([]byte{0x20, 0x00, 0x00, 0x00}) // LOCAL, UNSPEC, no payload
+		return buf.Bytes()
+	}
+
+	srcIP, dstIP := src.IP.To4(), dst.IP.To4()
+	if srcIP != nil && dstIP != nil {
+		buf.Write([]byte{0x21, 0x11, 0x00, 12}) // PROXY, TCP over IPv4
+	} else {
+		srcIP, dstIP = src.IP.To16(), dst.IP.To16()
+		buf.Write([]byte{0x21, 0x21, 0x00, 36}) // PROXY, TCP over IPv6
+	}
+
+	buf.Write(srcIP)
+	buf.Write(dstIP)
+	binary.Write(buf, binary.BigEndian, uint16(src.Port))
+	binary.Write(buf, binary.BigEndian, uint16(dst.Port))
+
+	return buf.Bytes()
+}
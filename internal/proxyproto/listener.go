@@ -0,0 +1,113 @@
+package proxyproto
+
+import (
+	"bufio"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Listener wraps a net.Listener and decodes PROXY protocol (v1 or v2)
+// headers on connections from trusted sources. Connections from any other
+// source are passed through untouched, so a client can't spoof its address
+// by sending a header itself.
+type Listener struct {
+	net.Listener
+	trusted       []*net.IPNet
+	headerTimeout time.Duration
+}
+
+func NewListener(ln net.Listener, trusted []*net.IPNet, headerTimeout time.Duration) *Listener {
+	return &Listener{
+		Listener:      ln,
+		trusted:       trusted,
+		headerTimeout: headerTimeout,
+	}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if !l.isTrusted(c.RemoteAddr()) {
+		return c, nil
+	}
+
+	return &Conn{
+		Conn:          c,
+		reader:        bufio.NewReader(c),
+		headerTimeout: l.headerTimeout,
+	}, nil
+}
+
+func (l *Listener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+
+	for _, n := range l.trusted {
+		if n.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection from a trusted source. The PROXY protocol header is
+// read lazily on first use (from the connection's own goroutine, not from
+// Accept) so a slow balancer can't stall the accept loop.
+type Conn struct {
+	net.Conn
+	reader        *bufio.Reader
+	headerTimeout time.Duration
+
+	once   sync.Once
+	header *Header
+	err    error
+}
+
+func (c *Conn) readHeader() {
+	c.once.Do(func() {
+		if c.headerTimeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.headerTimeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+
+		c.header, c.err = ReadHeader(c.reader)
+		if c.err != nil {
+			log.Printf("[PROXYPROTO] ERROR: bad header from %s: %v", c.Conn.RemoteAddr(), c.err)
+		}
+	})
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the client address from the PROXY protocol header,
+// or the peer address if no header (or a LOCAL/UNKNOWN header) was sent.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.header != nil && c.header.Source != nil {
+		return c.header.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client originally connected to, as
+// reported by the PROXY protocol header, or the socket's local address.
+func (c *Conn) LocalAddr() net.Addr {
+	c.readHeader()
+	if c.header != nil && c.header.Destination != nil {
+		return c.header.Destination
+	}
+	return c.Conn.LocalAddr()
+}
@@ -0,0 +1,92 @@
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Resolver looks up the DNS records verification relies on. *net.Resolver
+// satisfies it.
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// NewResolver returns the system's resolver, or if server (host:port) is
+// set one that sends every query to that server.
+func NewResolver(server string) Resolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{Timeout: 5 * time.Second}
+			return d.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// FileResolver answers lookups from a JSON file instead of DNS, so
+// verification can be tried locally:
+//
+//	{
+//	  "txt":   {"_tenant-router.shop.example.com": ["tenant-router-verification=..."]},
+//	  "hosts": {"shop.example.com": ["127.0.0.1"]}
+//	}
+//
+// The file is read on every lookup, so edits apply right away. Names it
+// doesn't list don't exist.
+type FileResolver struct {
+	Path string
+}
+
+type records struct {
+	TXT   map[string][]string `json:"txt"`
+	Hosts map[string][]string `json:"hosts"`
+}
+
+func (f *FileResolver) load() (*records, error) {
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+	var r records
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("%s: %w", f.Path, err)
+	}
+	return &r, nil
+}
+
+// LookupTXT returns the TXT records the file lists for name.
+func (f *FileResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	r, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	return lookup(r.TXT, name)
+}
+
+// LookupHost returns the addresses the file lists for host.
+func (f *FileResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	r, err := f.load()
+	if err != nil {
+		return nil, err
+	}
+	return lookup(r.Hosts, host)
+}
+
+func lookup(entries map[string][]string, name string) ([]string, error) {
+	name = strings.TrimSuffix(strings.ToLower(name), ".")
+	for key, values := range entries {
+		if strings.TrimSuffix(strings.ToLower(key), ".") == name {
+			return values, nil
+		}
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
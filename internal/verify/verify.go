@@ -0,0 +1,210 @@
+// Package verify checks that tenants own the domains they claim, by
+// finding a verification token either in a DNS TXT record or in a file
+// served on the domain.
+package verify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Verification methods, as reported by Check.
+const (
+	MethodDNS  = "dns"
+	MethodHTTP = "http"
+)
+
+const (
+	// RecordPrefix is prepended to a domain to name its TXT record.
+	RecordPrefix = "_tenant-router."
+
+	// ValuePrefix starts the TXT record's value, followed by the token.
+	ValuePrefix = "tenant-router-verification="
+
+	// WellKnownPath serves the token over HTTP. The router answers it for
+	// claimed domains itself, so pointing a domain at the router proves
+	// ownership too.
+	WellKnownPath = "/.well-known/tenant-router-verification"
+)
+
+// RecordName returns the name of the TXT record that verifies domain.
+func RecordName(domain string) string {
+	return RecordPrefix + domain
+}
+
+// RecordValue returns the TXT record value that verifies with token.
+func RecordValue(token string) string {
+	return ValuePrefix + token
+}
+
+// Checker looks for verification tokens through its resolver.
+type Checker struct {
+	resolver     Resolver
+	httpPort     int
+	allowPrivate bool
+	client       *http.Client
+}
+
+// NewChecker returns a checker that resolves names with resolver and
+// fetches WellKnownPath over plain HTTP on httpPort. Unless allowPrivate is
+// set, domains resolving to loopback, private or link-local addresses
+// aren't fetched, so claims can't be used to probe internal networks.
+func NewChecker(resolver Resolver, httpPort int, allowPrivate bool) *Checker {
+	c := &Checker{resolver: resolver, httpPort: httpPort, allowPrivate: allowPrivate}
+	c.client = &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:       c.dial,
+			DisableKeepAlives: true,
+		},
+		// The token must be served by the domain itself
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return c
+}
+
+// Check looks for token in the domain's TXT record, then on its
+// well-known URL, and returns the method that found it.
+func (c *Checker) Check(ctx context.Context, domain, token string) (string, error) {
+	dnsErr := c.checkDNS(ctx, domain, token)
+	if dnsErr == nil {
+		return MethodDNS, nil
+	}
+	httpErr := c.checkHTTP(ctx, domain, token)
+	if httpErr == nil {
+		return MethodHTTP, nil
+	}
+	return "", fmt.Errorf("%v; %v", dnsErr, httpErr)
+}
+
+func (c *Checker) checkDNS(ctx context.Context, domain, token string) error {
+	name := RecordName(domain)
+	values, err := c.resolver.LookupTXT(ctx, name)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return fmt.Errorf("no TXT record at %s", name)
+	}
+	if err != nil {
+		return fmt.Errorf("TXT lookup of %s failed: %w", name, err)
+	}
+
+	want := RecordValue(token)
+	for _, v := range values {
+		if strings.TrimSpace(v) == want {
+			return nil
+		}
+	}
+	return fmt.Errorf("TXT record at %s doesn't contain the token", name)
+}
+
+func (c *Checker) checkHTTP(ctx context.Context, domain, token string) error {
+	url := "http://" + net.JoinHostPort(domain, strconv.Itoa(c.httpPort)) + WellKnownPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Host = domain
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("GET %s failed: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return fmt.Errorf("GET %s failed: %w", url, err)
+	}
+	if strings.TrimSpace(string(body)) != token {
+		return fmt.Errorf("GET %s doesn't return the token", url)
+	}
+	return nil
+}
+
+// dial connects to the first usable address the resolver returns for the
+// host.
+func (c *Checker) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := c.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fmt.Errorf("%s has no addresses", host)
+	d := net.Dialer{Timeout: 5 * time.Second}
+	for _, a := range addrs {
+		ip := net.ParseIP(a)
+		if ip == nil {
+			continue
+		}
+		if !c.allowPrivate && !publicIP(ip) {
+			err = fmt.Errorf("%s resolves to non-public address %s", host, ip)
+			continue
+		}
+		var conn net.Conn
+		conn, err = d.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// publicIP reports whether ip is routable on the internet.
+func publicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range nonPublic {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// nonPublic lists the special-purpose ranges (RFC 6890) the net.IP methods
+// don't cover: addresses that are shared, reserved or for documentation,
+// and so can't be a domain's public address.
+var nonPublic = parseCIDRs(
+	"0.0.0.0/8",       // "this network"
+	"100.64.0.0/10",   // carrier-grade NAT
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // TEST-NET-1
+	"198.18.0.0/15",   // benchmarking
+	"198.51.100.0/24", // TEST-NET-2
+	"203.0.113.0/24",  // TEST-NET-3
+	"240.0.0.0/4",     // reserved, and broadcast
+	"64:ff9b::/96",    // NAT64, which reaches any IPv4 address
+	"64:ff9b:1::/48",  // local-use NAT64
+	"100::/64",        // discard
+	"2001:db8::/32",   // documentation
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
@@ -1,18 +1,37 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
+	"net/http"
 	"os"
+	"strings"
 
 	"github.com/tenantical/router/internal/database"
+	"github.com/tenantical/router/internal/oidc/oidctest"
 )
 
 func main() {
-	dbPath := flag.String("db", "./tenants.db", "Path to SQLite database file")
+	dbPath := flag.String("db", "./tenants.db", "Path to SQLite database file, or PostgreSQL URL with -driver postgres")
+	driver := flag.String("driver", "sqlite", "Database driver: sqlite or postgres")
+	mockOIDC := flag.String("mock-oidc", "", "Instead, serve a mock OpenID Connect provider for trying out admin single sign-on on this address, e.g. localhost:9999")
+	mockClient := flag.String("mock-oidc-client", "tenant-router", "Client ID accepted by the mock provider")
+	mockUsers := flag.String("mock-oidc-users", "admin@example.com=admins,dev@example.com=developers,guest@example.com=",
+		"Users of the mock provider: comma-separated email=group|group")
 	flag.Parse()
 
-	tm, err := database.NewTenantManager(*dbPath, false)
+	if *mockOIDC != "" {
+		serveMockOIDC(*mockOIDC, *mockClient, *mockUsers)
+		return
+	}
+
+	store, err := database.OpenStore(*driver, *dbPath, true)
+	if err != nil {
+		log.Fatalf("Failed to open tenant store: %v", err)
+	}
+
+	tm, err := database.NewTenantManager(store, false)
 	if err != nil {
 		log.Fatalf("Failed to initialize tenant manager: %v", err)
 	}
@@ -30,14 +49,36 @@ func main() {
 
 	log.Printf("Initializing database at %s", *dbPath)
 
+	ctx := database.WithActor(context.Background(), "init_db")
+
 	for _, tenant := range tenants {
-		if err := tm.AddTenant(tenant.domain, tenant.tenantID, "", nil, nil); err != nil {
-			log.Printf("Failed to add tenant %s: %v", tenant.domain, err)
+		if _, err := tm.PutDomain(ctx, database.Domain{Name: tenant.domain, TenantID: tenant.tenantID}); err != nil {
+			log.Printf("Failed to add domain %s: %v", tenant.domain, err)
 			os.Exit(1)
 		}
-		log.Printf("Added tenant: %s -> %s", tenant.domain, tenant.tenantID)
+		log.Printf("Added domain: %s -> %s", tenant.domain, tenant.tenantID)
 	}
 
 	log.Println("Database initialized successfully!")
 }
 
+// serveMockOIDC runs a mock OpenID Connect provider until interrupted.
+func serveMockOIDC(addr, clientID, users string) {
+	var mockUsers []oidctest.User
+	for _, item := range strings.Split(users, ",") {
+		email, groups, _ := strings.Cut(item, "=")
+		user := oidctest.User{Email: email, Name: email}
+		if groups != "" {
+			user.Groups = strings.Split(groups, "|")
+		}
+		mockUsers = append(mockUsers, user)
+	}
+
+	provider, err := oidctest.New("http://"+addr, clientID, mockUsers...)
+	if err != nil {
+		log.Fatalf("Failed to create mock OpenID provider: %v", err)
+	}
+
+	log.Printf("Mock OpenID provider for client %s at http://%s (OIDC_ISSUER=http://%s)", clientID, addr, addr)
+	log.Fatal(http.ListenAndServe(addr, provider))
+}
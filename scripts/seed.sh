@@ -3,19 +3,29 @@
 # Seed script for initializing tenant database
 
 DB_PATH="${DB_PATH:-./tenants.db}"
+# Admin API key with the write scope (tenant-router apikey create -name seed -scopes write)
+ADMIN_API_KEY="${ADMIN_API_KEY:?ADMIN_API_KEY must be set}"
+# Admin routes are only served on ADMIN_DOMAIN hosts
+ADMIN_DOMAIN="${ADMIN_DOMAIN:-tenantical.iranservat.com}"
 
 echo "Seeding tenant database at $DB_PATH"
 
 # Add sample tenants
 curl -X POST http://localhost:8080/admin/tenants \
+  -H "Host: $ADMIN_DOMAIN" \
+  -H "Authorization: Bearer $ADMIN_API_KEY" \
   -H "Content-Type: application/json" \
   -d '{"domain": "tenant1.example.com", "tenant_id": "tenant-123"}' || true
 
 curl -X POST http://localhost:8080/admin/tenants \
+  -H "Host: $ADMIN_DOMAIN" \
+  -H "Authorization: Bearer $ADMIN_API_KEY" \
   -H "Content-Type: application/json" \
   -d '{"domain": "tenant2.example.com", "tenant_id": "tenant-456"}' || true
 
 curl -X POST http://localhost:8080/admin/tenants \
+  -H "Host: $ADMIN_DOMAIN" \
+  -H "Authorization: Bearer $ADMIN_API_KEY" \
   -H "Content-Type: application/json" \
   -d '{"domain": "*.saas.com", "tenant_id": "tenant-789"}' || true
 